@@ -0,0 +1,62 @@
+package auth
+
+import (
+	"context"
+	"slices"
+)
+
+// Scopes of API keys, weakest first. Each scope includes the ones before it:
+// write can also read, and admin can also manage the API keys.
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+	ScopeAdmin = "admin"
+)
+
+// Scopes lists the scopes, weakest first
+var Scopes = []string{ScopeRead, ScopeWrite, ScopeAdmin}
+
+// Principal is the authenticated caller of a request
+type Principal struct {
+	// Subject identifies the caller, e.g. the name of an API key or the sub claim of a token
+	Subject string
+	// Scopes are what the caller may do
+	Scopes []string
+	// Roles are the roles of the access policy the caller acts in; callers
+	// without any get the default roles of the policy
+	Roles []string
+}
+
+// Allows reports whether the principal has scope or a scope that includes it
+func (p Principal) Allows(scope string) bool {
+	required := slices.Index(Scopes, scope)
+	if required < 0 {
+		return false
+	}
+	for _, granted := range p.Scopes {
+		if slices.Index(Scopes, granted) >= required {
+			return true
+		}
+	}
+	return false
+}
+
+// Anonymous is the principal of requests when authentication is disabled,
+// so it may do everything. With authentication enabled, requests without
+// credentials are rejected before they reach a handler.
+var Anonymous = Principal{Subject: "anonymous", Scopes: []string{ScopeAdmin}}
+
+type principalKey struct{}
+
+// WithPrincipal returns a copy of ctx that carries principal
+func WithPrincipal(ctx context.Context, principal Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// FromContext returns the principal ctx carries, or Anonymous
+func FromContext(ctx context.Context) Principal {
+	if principal, ok := ctx.Value(principalKey{}).(Principal); ok {
+		return principal
+	}
+	return Anonymous
+}
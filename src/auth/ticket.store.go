@@ -0,0 +1,85 @@
+package auth
+
+import (
+	"crypto/rand"
+	"sync"
+	"time"
+)
+
+// DefaultTicketTTL is how long a ticket can be redeemed when no TTL is configured
+const DefaultTicketTTL = 30 * time.Second
+
+// ticketPrefix tells tickets apart from API keys
+const ticketPrefix = "crmt_"
+
+type ticket struct {
+	principal Principal
+	expires   time.Time
+}
+
+// TicketStore issues tickets: short-lived, single-use stand-ins for the
+// credentials of a principal. Browsers cannot set headers on WebSockets and
+// event streams, so those requests carry a ticket in the URL, where an API
+// key or token would end up in access logs, proxies and browser history for
+// as long as it is valid. Tickets live in memory, so they only work on the
+// instance that issued them.
+type TicketStore struct {
+	mu        sync.Mutex
+	ttl       time.Duration
+	now       func() time.Time
+	tickets   map[string]ticket
+	nextSweep time.Time
+}
+
+// NewTicketStore creates a store whose tickets can be redeemed for ttl
+func NewTicketStore(ttl time.Duration) *TicketStore {
+	if ttl <= 0 {
+		ttl = DefaultTicketTTL
+	}
+	return &TicketStore{
+		ttl:     ttl,
+		now:     time.Now,
+		tickets: map[string]ticket{},
+	}
+}
+
+// Issue returns a new ticket for principal and when it expires
+func (s *TicketStore) Issue(principal Principal) (string, time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.sweep(now)
+
+	value := ticketPrefix + rand.Text()
+	expires := now.Add(s.ttl)
+	s.tickets[value] = ticket{principal: principal, expires: expires}
+	return value, expires
+}
+
+// Redeem returns the principal a ticket was issued for and forgets the
+// ticket. It returns false for a ticket that is unknown, used or expired.
+func (s *TicketStore) Redeem(value string) (Principal, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.tickets[value]
+	delete(s.tickets, value)
+	if !ok || !s.now().Before(t.expires) {
+		return Principal{}, false
+	}
+	return t.principal, true
+}
+
+// sweep drops expired tickets at most once per TTL; the caller holds the lock
+func (s *TicketStore) sweep(now time.Time) {
+	if now.Before(s.nextSweep) {
+		return
+	}
+	for value, t := range s.tickets {
+		if !now.Before(t.expires) {
+			delete(s.tickets, value)
+		}
+	}
+	s.nextSweep = now.Add(s.ttl)
+}
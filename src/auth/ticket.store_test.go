@@ -0,0 +1,34 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTicketStore_Redeem(t *testing.T) {
+	store := NewTicketStore(time.Minute)
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	store.now = func() time.Time { return now }
+	principal := Principal{Subject: "reader", Scopes: []string{ScopeRead}, Roles: []string{"viewer"}}
+
+	ticket, expires := store.Issue(principal)
+	if !strings.HasPrefix(ticket, ticketPrefix) || !expires.Equal(now.Add(time.Minute)) {
+		t.Fatalf("Expected a ticket that expires in a minute, but got %q expiring at %s", ticket, expires)
+	}
+	if redeemed, ok := store.Redeem(ticket); !ok || redeemed.Subject != principal.Subject || !redeemed.Allows(ScopeRead) {
+		t.Errorf("Expected the principal of the ticket, but got %v (%t)", redeemed, ok)
+	}
+	if _, ok := store.Redeem(ticket); ok {
+		t.Error("Expected a ticket to be redeemed only once")
+	}
+	if _, ok := store.Redeem("crmt_unknown"); ok {
+		t.Error("Expected an unknown ticket to be refused")
+	}
+
+	expired, _ := store.Issue(principal)
+	now = now.Add(time.Minute)
+	if _, ok := store.Redeem(expired); ok {
+		t.Error("Expected an expired ticket to be refused")
+	}
+}
@@ -0,0 +1,139 @@
+package changefeed
+
+import "sync"
+
+// DefaultCapacity is how many events are kept for resuming when no capacity is configured
+const DefaultCapacity = 1000
+
+// subscriberBuffer is how many events a subscriber may fall behind before it is dropped
+const subscriberBuffer = 256
+
+// Event is one published change. IDs count from 1 in publish order.
+type Event struct {
+	ID   uint64
+	Type string
+	Data []byte
+}
+
+// Broker hands published events to its subscribers and keeps the latest ones
+// in a ring buffer, so a subscriber that reconnects can resume after the last
+// event it saw. It lives in memory: the buffer and the IDs start over on restart.
+type Broker struct {
+	mu sync.Mutex
+	// ring holds the event with ID n at index (n-1) % len(ring)
+	ring        []Event
+	lastID      uint64
+	subscribers map[*Subscription]struct{}
+	closed      bool
+}
+
+// NewBroker creates a broker that keeps the last capacity events
+func NewBroker(capacity int) *Broker {
+	if capacity <= 0 {
+		capacity = DefaultCapacity
+	}
+	return &Broker{
+		ring:        make([]Event, capacity),
+		subscribers: map[*Subscription]struct{}{},
+	}
+}
+
+// Publish numbers an event and hands it to every subscriber. Publish never
+// blocks: a subscriber that has fallen too far behind is dropped instead, and
+// can resume from the buffer.
+func (b *Broker) Publish(eventType string, data []byte) Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.lastID++
+	event := Event{ID: b.lastID, Type: eventType, Data: data}
+	b.ring[(event.ID-1)%uint64(len(b.ring))] = event
+
+	for subscription := range b.subscribers {
+		select {
+		case subscription.events <- event:
+		default:
+			b.drop(subscription)
+		}
+	}
+	return event
+}
+
+// Subscription receives the events published after it was made
+type Subscription struct {
+	broker *Broker
+	events chan Event
+}
+
+// Events is closed when the subscription is closed, falls behind, or the
+// broker closes
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Close stops the subscription
+func (s *Subscription) Close() {
+	s.broker.mu.Lock()
+	defer s.broker.mu.Unlock()
+
+	if _, ok := s.broker.subscribers[s]; ok {
+		s.broker.drop(s)
+	}
+}
+
+// Subscribe subscribes to the events published from now on
+func (b *Broker) Subscribe() *Subscription {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.subscribe()
+}
+
+// Resume subscribes to the events after lastID and returns the ones already
+// published. complete is false when some of them are no longer buffered or
+// lastID was never published, as after a restart; the caller then missed
+// events and gets every buffered one.
+func (b *Broker) Resume(lastID uint64) (subscription *Subscription, missed []Event, complete bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	oldest := uint64(1)
+	if b.lastID > uint64(len(b.ring)) {
+		oldest = b.lastID - uint64(len(b.ring)) + 1
+	}
+	complete = lastID+1 >= oldest && lastID <= b.lastID
+	if !complete {
+		lastID = oldest - 1
+	}
+	for id := lastID + 1; id <= b.lastID; id++ {
+		missed = append(missed, b.ring[(id-1)%uint64(len(b.ring))])
+	}
+	return b.subscribe(), missed, complete
+}
+
+func (b *Broker) subscribe() *Subscription {
+	subscription := &Subscription{broker: b, events: make(chan Event, subscriberBuffer)}
+	if b.closed {
+		close(subscription.events)
+		return subscription
+	}
+	b.subscribers[subscription] = struct{}{}
+	return subscription
+}
+
+func (b *Broker) drop(subscription *Subscription) {
+	delete(b.subscribers, subscription)
+	close(subscription.events)
+}
+
+// Close ends every subscription, and those made later right away, so
+// streaming requests finish and the server can shut down
+func (b *Broker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for subscription := range b.subscribers {
+		b.drop(subscription)
+	}
+}
@@ -0,0 +1,102 @@
+package changefeed
+
+import (
+	"fmt"
+	"testing"
+)
+
+func eventIDs(events []Event) []uint64 {
+	ids := []uint64{}
+	for _, event := range events {
+		ids = append(ids, event.ID)
+	}
+	return ids
+}
+
+func TestBroker_Publish(t *testing.T) {
+	broker := NewBroker(10)
+	subscription := broker.Subscribe()
+	defer subscription.Close()
+
+	broker.Publish("created", []byte("first"))
+	broker.Publish("updated", []byte("second"))
+
+	for _, expected := range []Event{{ID: 1, Type: "created", Data: []byte("first")}, {ID: 2, Type: "updated", Data: []byte("second")}} {
+		event := <-subscription.Events()
+		if event.ID != expected.ID || event.Type != expected.Type || string(event.Data) != string(expected.Data) {
+			t.Errorf("Expected %v, but got %v", expected, event)
+		}
+	}
+
+	subscription.Close()
+	if _, ok := <-subscription.Events(); ok {
+		t.Error("Expected Events to be closed after Close")
+	}
+	subscription.Close()
+}
+
+func TestBroker_Resume(t *testing.T) {
+	broker := NewBroker(3)
+	for i := range 5 {
+		broker.Publish("updated", fmt.Appendf(nil, "%d", i+1))
+	}
+
+	tests := []struct {
+		lastID   uint64
+		missed   []uint64
+		complete bool
+	}{
+		{lastID: 5, missed: []uint64{}, complete: true},
+		{lastID: 3, missed: []uint64{4, 5}, complete: true},
+		{lastID: 2, missed: []uint64{3, 4, 5}, complete: true},
+		// Event 2 is no longer buffered
+		{lastID: 1, missed: []uint64{3, 4, 5}, complete: false},
+		// Event 9 was never published, as after a restart
+		{lastID: 9, missed: []uint64{3, 4, 5}, complete: false},
+	}
+	for _, test := range tests {
+		subscription, missed, complete := broker.Resume(test.lastID)
+		subscription.Close()
+		if fmt.Sprint(eventIDs(missed)) != fmt.Sprint(test.missed) || complete != test.complete {
+			t.Errorf("Expected resuming after %d to return %v (complete %t), but got %v (complete %t)", test.lastID, test.missed, test.complete, eventIDs(missed), complete)
+		}
+	}
+
+	subscription, _, _ := broker.Resume(5)
+	defer subscription.Close()
+	broker.Publish("deleted", nil)
+	if event := <-subscription.Events(); event.ID != 6 {
+		t.Errorf("Expected the next event to be 6, but got %d", event.ID)
+	}
+}
+
+func TestBroker_DropsSlowSubscribers(t *testing.T) {
+	broker := NewBroker(10)
+	slow := broker.Subscribe()
+
+	for range subscriberBuffer + 1 {
+		broker.Publish("updated", nil)
+	}
+
+	received := 0
+	for range slow.Events() {
+		received++
+	}
+	if received != subscriberBuffer {
+		t.Errorf("Expected a slow subscriber to get %d events before it is dropped, but got %d", subscriberBuffer, received)
+	}
+}
+
+func TestBroker_Close(t *testing.T) {
+	broker := NewBroker(10)
+	before := broker.Subscribe()
+
+	broker.Close()
+
+	if _, ok := <-before.Events(); ok {
+		t.Error("Expected Close to end existing subscriptions")
+	}
+	if _, ok := <-broker.Subscribe().Events(); ok {
+		t.Error("Expected subscriptions after Close to end right away")
+	}
+}
@@ -0,0 +1,24 @@
+package clock
+
+import "time"
+
+// Clock tells the current time. Code that stamps records takes a Clock so
+// tests can control the time.
+type Clock interface {
+	Now() time.Time
+}
+
+// Func adapts a function to a Clock
+type Func func() time.Time
+
+func (f Func) Now() time.Time {
+	return f()
+}
+
+// System is the clock of the machine
+var System Clock = Func(time.Now)
+
+// Fixed returns a clock that always tells t
+func Fixed(t time.Time) Clock {
+	return Func(func() time.Time { return t })
+}
@@ -0,0 +1,181 @@
+package config
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Config holds the runtime settings of the CRM server
+type Config struct {
+	// Addr is the address the HTTP server listens on
+	Addr string
+	// Storage selects the customer backend ("json", "events", "sqlite" or "postgres")
+	Storage string
+	// DataFile is the JSON file customers are loaded from and persisted to
+	DataFile string
+	// FlushPolicy controls when mutations are written to DataFile ("always" or "debounce")
+	FlushPolicy string
+	// FlushDelay is how long the debounce policy waits before writing pending changes
+	FlushDelay time.Duration
+	// EventLogFile is the log the events backend appends customer events to
+	EventLogFile string
+	// SnapshotFile is where the events backend writes snapshots of the customers
+	SnapshotFile string
+	// SnapshotEvery is how many events the events backend appends between snapshots
+	SnapshotEvery int
+	// HistoryFile is the file the json and events backends append the customer history to
+	HistoryFile string
+	// WebhookFile is the file the json and events backends keep webhooks and their deliveries in
+	WebhookFile string
+	// APIKeyFile is the file the json and events backends keep the API keys in
+	APIKeyFile string
+	// SQLiteFile is the database file used by the sqlite backend
+	SQLiteFile string
+	// PostgresDSN is the connection string used by the postgres backend
+	PostgresDSN string
+	// PostgresMaxConns caps the number of open connections in the postgres pool
+	PostgresMaxConns int
+	// PostgresConnMaxLifetime recycles pooled connections after this long
+	PostgresConnMaxLifetime time.Duration
+	// ChangeFeedBuffer is how many customer events GET /customers/events keeps for resuming
+	ChangeFeedBuffer int
+	// WebhookPollInterval is how often due webhook deliveries are looked for
+	WebhookPollInterval time.Duration
+	// WebhookTimeout bounds each attempt to deliver a webhook
+	WebhookTimeout time.Duration
+	// WebhookMaxAttempts is how often a delivery is tried before it becomes a dead letter
+	WebhookMaxAttempts int
+	// WebhookRetryDelay is the wait after the first failed attempt, doubled after each further one
+	WebhookRetryDelay time.Duration
+	// WebhookMaxRetryDelay caps the wait between attempts
+	WebhookMaxRetryDelay time.Duration
+	// Auth selects how /api/v1 requests are authenticated: a comma separated
+	// list of "apikey" and "jwt", or "none"
+	Auth string
+	// BootstrapAPIKey is stored as an admin API key on startup, to create the other keys with
+	BootstrapAPIKey string
+	// APIKeyRotationGrace is how long a key replaced by a rotation keeps working
+	APIKeyRotationGrace time.Duration
+	// JWKSURL and JWKSFile are where the keys JWTs are signed with are loaded
+	// from; the URL wins when both are set
+	JWKSURL  string
+	JWKSFile string
+	// JWKSRefreshInterval is how often the JWKS is reloaded
+	JWKSRefreshInterval time.Duration
+	// JWTIssuer and JWTAudience must match the iss and aud claims of a JWT
+	JWTIssuer   string
+	JWTAudience string
+	// JWTClockSkew is how far the clock of the token issuer may drift from ours
+	JWTClockSkew time.Duration
+	// JWTSubjectClaim names the claim that identifies the caller of a JWT
+	JWTSubjectClaim string
+	// JWTRolesClaim names the claim with the access policy roles of the caller of a JWT
+	JWTRolesClaim string
+	// JWTScopeClaim names the claim with the scopes of a JWT; empty reads scope or scp
+	JWTScopeClaim string
+	// JWTScopePrefix is what the values of the scope claim meant for the CRM start with
+	JWTScopePrefix string
+	// JWTDefaultScopes are the space separated scopes of a JWT without any CRM scope
+	JWTDefaultScopes string
+	// PolicyFile is the access policy, mapping roles to the routes they may
+	// use and the customer fields they may not see; empty uses the built-in one
+	PolicyFile string
+	// StreamTicketTTL is how long a ticket for a WebSocket or event stream can be used
+	StreamTicketTTL time.Duration
+	// IdempotencyTTL is how long responses to POST requests with an Idempotency-Key are replayed
+	IdempotencyTTL time.Duration
+	// TrashRetention is how long deleted customers can be restored before they are purged
+	TrashRetention time.Duration
+	// PurgeInterval is how often customers past TrashRetention are purged
+	PurgeInterval time.Duration
+}
+
+// Load reads the configuration from the environment, falling back to defaults
+func Load() Config {
+	return Config{
+		Addr:        getEnv("CRM_ADDR", ":8080"),
+		Storage:     getEnv("CRM_STORAGE", "json"),
+		DataFile:    getEnv("CRM_DATA_FILE", ""),
+		FlushPolicy: getEnv("CRM_FLUSH_POLICY", "always"),
+		FlushDelay:  getDuration("CRM_FLUSH_DELAY", time.Second),
+		HistoryFile: getEnv("CRM_HISTORY_FILE", ""),
+		WebhookFile: getEnv("CRM_WEBHOOK_FILE", ""),
+		APIKeyFile:  getEnv("CRM_API_KEY_FILE", ""),
+
+		EventLogFile:  getEnv("CRM_EVENT_LOG", ""),
+		SnapshotFile:  getEnv("CRM_SNAPSHOT_FILE", ""),
+		SnapshotEvery: getInt("CRM_SNAPSHOT_EVERY", 1000),
+
+		SQLiteFile: getEnv("CRM_SQLITE_FILE", ""),
+
+		PostgresDSN:             getEnv("CRM_POSTGRES_DSN", ""),
+		PostgresMaxConns:        getInt("CRM_POSTGRES_MAX_CONNS", 10),
+		PostgresConnMaxLifetime: getDuration("CRM_POSTGRES_CONN_MAX_LIFETIME", 30*time.Minute),
+
+		Auth:                getEnv("CRM_AUTH", "apikey"),
+		BootstrapAPIKey:     getEnv("CRM_BOOTSTRAP_API_KEY", ""),
+		APIKeyRotationGrace: getDuration("CRM_API_KEY_ROTATION_GRACE", 24*time.Hour),
+
+		JWKSURL:             getEnv("CRM_JWKS_URL", ""),
+		JWKSFile:            getEnv("CRM_JWKS_FILE", ""),
+		JWKSRefreshInterval: getDuration("CRM_JWKS_REFRESH_INTERVAL", time.Hour),
+		JWTIssuer:           getEnv("CRM_JWT_ISSUER", ""),
+		JWTAudience:         getEnv("CRM_JWT_AUDIENCE", ""),
+		JWTClockSkew:        getDuration("CRM_JWT_CLOCK_SKEW", time.Minute),
+		JWTSubjectClaim:     getEnv("CRM_JWT_SUBJECT_CLAIM", "sub"),
+		JWTRolesClaim:       getEnv("CRM_JWT_ROLES_CLAIM", "roles"),
+		JWTScopeClaim:       getEnv("CRM_JWT_SCOPE_CLAIM", ""),
+		JWTScopePrefix:      getEnv("CRM_JWT_SCOPE_PREFIX", ""),
+		JWTDefaultScopes:    getEnv("CRM_JWT_DEFAULT_SCOPES", ""),
+		PolicyFile:          getEnv("CRM_POLICY_FILE", ""),
+		StreamTicketTTL:     getDuration("CRM_STREAM_TICKET_TTL", 30*time.Second),
+
+		IdempotencyTTL: getDuration("CRM_IDEMPOTENCY_TTL", 24*time.Hour),
+
+		ChangeFeedBuffer: getInt("CRM_CHANGE_FEED_BUFFER", 1000),
+
+		WebhookPollInterval:  getDuration("CRM_WEBHOOK_POLL_INTERVAL", 5*time.Second),
+		WebhookTimeout:       getDuration("CRM_WEBHOOK_TIMEOUT", 10*time.Second),
+		WebhookMaxAttempts:   getInt("CRM_WEBHOOK_MAX_ATTEMPTS", 8),
+		WebhookRetryDelay:    getDuration("CRM_WEBHOOK_RETRY_DELAY", 30*time.Second),
+		WebhookMaxRetryDelay: getDuration("CRM_WEBHOOK_MAX_RETRY_DELAY", time.Hour),
+
+		TrashRetention: getDuration("CRM_TRASH_RETENTION", 30*24*time.Hour),
+		PurgeInterval:  getDuration("CRM_PURGE_INTERVAL", time.Hour),
+	}
+}
+
+func getEnv(key string, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok && value != "" {
+		return value
+	}
+	return fallback
+}
+
+func getInt(key string, fallback int) int {
+	value, ok := os.LookupEnv(key)
+	if !ok || value == "" {
+		return fallback
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Invalid number %q for %s, using %d", value, key, fallback)
+		return fallback
+	}
+	return number
+}
+
+func getDuration(key string, fallback time.Duration) time.Duration {
+	value, ok := os.LookupEnv(key)
+	if !ok || value == "" {
+		return fallback
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("Invalid duration %q for %s, using %s", value, key, fallback)
+		return fallback
+	}
+	return duration
+}
@@ -0,0 +1,178 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"congdinh.com/crm/auth"
+	"congdinh.com/crm/services"
+	viewmodels "congdinh.com/crm/view-models"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+type APIKeyController struct {
+	IAPIKeyService services.IAPIKeyService
+}
+
+// NewAPIKeyController creates a new API key controller
+func NewAPIKeyController(apiKeyService services.IAPIKeyService) *APIKeyController {
+	return &APIKeyController{IAPIKeyService: apiKeyService}
+}
+
+// RegisterRoutes registers the routes for the API key controller on the
+// /api/v1 router. They all need the admin scope.
+func (kc *APIKeyController) RegisterRoutes(router *mux.Router) {
+	keys := router.PathPrefix("/api-keys").Subrouter()
+
+	keys.HandleFunc("", requireScope(auth.ScopeAdmin, kc.GetAPIKeys)).Methods("GET").Name("api-keys.list")
+	keys.HandleFunc("", requireScope(auth.ScopeAdmin, kc.CreateAPIKey)).Methods("POST").Name("api-keys.create")
+	// Registered before the /{id} routes: a path mismatch after a method
+	// mismatch makes mux answer 404 instead of 405
+	keys.HandleFunc("/{id}/rotate", requireScope(auth.ScopeAdmin, kc.RotateAPIKey)).Methods("POST").Name("api-keys.rotate")
+	keys.HandleFunc("/{id}", requireScope(auth.ScopeAdmin, kc.GetAPIKey)).Methods("GET").Name("api-keys.get")
+	keys.HandleFunc("/{id}", requireScope(auth.ScopeAdmin, kc.RevokeAPIKey)).Methods("DELETE").Name("api-keys.revoke")
+
+	keys.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeProblem(w, r, http.StatusNotFound, "")
+	})
+	keys.MethodNotAllowedHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeProblem(w, r, http.StatusMethodNotAllowed, "")
+	})
+}
+
+// GetAPIKeys godoc
+// @Summary Show all API keys
+// @Description list every API key in creation order, revoked ones included, with when each was last used. The keys themselves are never listed. Needs the admin scope.
+// @Tags api-keys
+// @Produce  json
+// @Success 200 {array} viewmodels.APIKeyViewModel
+// @Failure 401 {object} viewmodels.ProblemViewModel "No valid API key or token"
+// @Failure 403 {object} viewmodels.ProblemViewModel "The caller lacks the admin scope"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /api-keys [get]
+func (kc *APIKeyController) GetAPIKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := kc.IAPIKeyService.List(r.Context())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, keys)
+}
+
+// GetAPIKey godoc
+// @Summary Show an API key
+// @Description get an API key by ID, without the key itself. Needs the admin scope.
+// @Tags api-keys
+// @Produce  json
+// @Param id path string true "API key ID"
+// @Success 200 {object} viewmodels.APIKeyViewModel
+// @Failure 400 {object} viewmodels.ProblemViewModel "Bad Request"
+// @Failure 401 {object} viewmodels.ProblemViewModel "No valid API key or token"
+// @Failure 403 {object} viewmodels.ProblemViewModel "The caller lacks the admin scope"
+// @Failure 404 {object} viewmodels.ProblemViewModel "API key not found"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /api-keys/{id} [get]
+func (kc *APIKeyController) GetAPIKey(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, "Invalid API key ID")
+		return
+	}
+
+	key, err := kc.IAPIKeyService.Get(r.Context(), id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, key)
+}
+
+// CreateAPIKey godoc
+// @Summary Create an API key
+// @Description create an API key with scopes read (GET requests), write (every customer and webhook request) or admin (also managing API keys). The key is only returned here; store it safely. Needs the admin scope.
+// @Tags api-keys
+// @Accept  json
+// @Produce  json
+// @Param   key  body viewmodels.APIKeyCreateViewModel  true  "Add API key"
+// @Success 201  {object}  viewmodels.APIKeyViewModel  "Successfully created"
+// @Failure 400  {object}  viewmodels.ProblemViewModel  "Bad Request"
+// @Failure 401  {object}  viewmodels.ProblemViewModel  "No valid API key or token"
+// @Failure 403  {object}  viewmodels.ProblemViewModel  "The caller lacks the admin scope"
+// @Failure 422  {object}  viewmodels.ProblemViewModel  "Validation failed"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /api-keys [post]
+func (kc *APIKeyController) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	var key viewmodels.APIKeyCreateViewModel
+	if err := json.NewDecoder(r.Body).Decode(&key); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, "Malformed JSON body: "+err.Error())
+		return
+	}
+
+	result, err := kc.IAPIKeyService.Create(r.Context(), key)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, result)
+}
+
+// RotateAPIKey godoc
+// @Summary Rotate an API key
+// @Description replace the key of an API key with a new one, returned only here. The replaced key keeps working for a grace period (CRM_API_KEY_ROTATION_GRACE), so clients can switch over. Needs the admin scope.
+// @Tags api-keys
+// @Produce  json
+// @Param id path string true "API key ID"
+// @Success 200 {object} viewmodels.APIKeyViewModel
+// @Failure 400 {object} viewmodels.ProblemViewModel "Bad Request"
+// @Failure 401 {object} viewmodels.ProblemViewModel "No valid API key or token"
+// @Failure 403 {object} viewmodels.ProblemViewModel "The caller lacks the admin scope"
+// @Failure 404 {object} viewmodels.ProblemViewModel "API key not found"
+// @Failure 409 {object} viewmodels.ProblemViewModel "The API key is revoked"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /api-keys/{id}/rotate [post]
+func (kc *APIKeyController) RotateAPIKey(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, "Invalid API key ID")
+		return
+	}
+
+	key, err := kc.IAPIKeyService.Rotate(r.Context(), id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, key)
+}
+
+// RevokeAPIKey godoc
+// @Summary Revoke an API key
+// @Description stop an API key from working right away, including the key a rotation replaced. The key stays listed with its RevokedAt. Needs the admin scope.
+// @Tags api-keys
+// @Param id path string true "API key ID"
+// @Success 204 "Successfully revoked"
+// @Failure 400 {object} viewmodels.ProblemViewModel "Bad Request"
+// @Failure 401 {object} viewmodels.ProblemViewModel "No valid API key or token"
+// @Failure 403 {object} viewmodels.ProblemViewModel "The caller lacks the admin scope"
+// @Failure 404 {object} viewmodels.ProblemViewModel "API key not found"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /api-keys/{id} [delete]
+func (kc *APIKeyController) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, "Invalid API key ID")
+		return
+	}
+
+	if err := kc.IAPIKeyService.Revoke(r.Context(), id); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,175 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"congdinh.com/crm/auth"
+	"congdinh.com/crm/repositories"
+	"congdinh.com/crm/services"
+	viewmodels "congdinh.com/crm/view-models"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+)
+
+func TestAPIKeyController_APIKeys(t *testing.T) {
+	customerService := newCustomerService(t)
+	apiKeyService := services.NewAPIKeyService(repositories.NewMemoryAPIKeyRepository())
+	apiKeyService.RotationGrace = 0
+	bootstrap := "bootstrap-0123456789abcdef"
+	if err := apiKeyService.EnsureBootstrapKey(t.Context(), bootstrap); err != nil {
+		t.Fatal(err)
+	}
+	router := mux.NewRouter()
+	api := router.PathPrefix("/api/v1").Subrouter()
+	tickets := auth.NewTicketStore(time.Minute)
+	api.Use(Authenticate(apiKeyService, nil, tickets))
+	customerController := NewCustomerController(customerService)
+	customerController.Tickets = tickets
+	customerController.RegisterRoutes(api)
+	NewAPIKeyController(apiKeyService).RegisterRoutes(api)
+
+	serve := func(method string, path string, header string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if name, value, ok := strings.Cut(header, ": "); ok {
+			req.Header.Set(name, value)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	createKey := func(body string) viewmodels.APIKeyViewModel {
+		rr := serve("POST", "/api/v1/api-keys", "X-API-Key: "+bootstrap, body)
+		var created viewmodels.APIKeyViewModel
+		json.NewDecoder(rr.Body).Decode(&created)
+		if rr.Code != http.StatusCreated || created.Key == "" {
+			t.Fatalf("Expected the created key, but got %d %v", rr.Code, created)
+		}
+		return created
+	}
+
+	rr := serve("GET", "/api/v1/customers", "", "")
+	if rr.Code != http.StatusUnauthorized || rr.Header().Get("WWW-Authenticate") == "" || rr.Header().Get("Content-Type") != "application/problem+json" {
+		t.Errorf("Expected a request without a key to be 401, but got %d %v", rr.Code, rr.Header())
+	}
+	if rr := serve("GET", "/api/v1/customers", "X-API-Key: crm_unknown", ""); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected an unknown key to be 401, but got %d", rr.Code)
+	}
+	if rr := serve("GET", "/api/v1/customers?api_key="+bootstrap, "", ""); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected the api_key parameter to be ignored outside streams, but got %d", rr.Code)
+	}
+
+	reader := createKey(`{"Name": "reporting", "Scopes": ["read"]}`)
+	writer := createKey(`{"Name": "sync", "Scopes": ["write"]}`)
+	if rr := serve("POST", "/api/v1/api-keys", "X-API-Key: "+bootstrap, `{"Name": "root", "Scopes": ["root"]}`); rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected an unknown scope to be 422, but got %d", rr.Code)
+	}
+
+	tests := []struct {
+		method string
+		path   string
+		header string
+		status int
+	}{
+		{method: "GET", path: "/api/v1/customers", header: "X-API-Key: " + reader.Key, status: http.StatusOK},
+		{method: "GET", path: "/api/v1/customers", header: "Authorization: Bearer " + reader.Key, status: http.StatusOK},
+		{method: "POST", path: "/api/v1/customers", header: "X-API-Key: " + reader.Key, status: http.StatusForbidden},
+		{method: "GET", path: "/api/v1/api-keys", header: "X-API-Key: " + reader.Key, status: http.StatusForbidden},
+		{method: "GET", path: "/api/v1/api-keys", header: "X-API-Key: " + writer.Key, status: http.StatusForbidden},
+		{method: "GET", path: "/api/v1/api-keys", header: "X-API-Key: " + bootstrap, status: http.StatusOK},
+	}
+	for _, test := range tests {
+		if rr := serve(test.method, test.path, test.header, `{}`); rr.Code != test.status {
+			t.Errorf("Expected %s %s with %q to be %d, but got %d", test.method, test.path, test.header, test.status, rr.Code)
+		}
+	}
+
+	// The key name is the caller recorded on the customer
+	rr = serve("POST", "/api/v1/customers", "X-API-Key: "+writer.Key, `{"Name": "Synced", "Role": "Sales", "Email": "synced@domain.com", "Phone": "5550009401"}`)
+	var customer viewmodels.CustomerViewModel
+	json.NewDecoder(rr.Body).Decode(&customer)
+	if rr.Code != http.StatusCreated || customer.CreatedBy != "sync" {
+		t.Errorf("Expected the customer to be created by sync, but got %d %v", rr.Code, customer)
+	}
+
+	rr = serve("GET", "/api/v1/api-keys/"+writer.ID.String(), "X-API-Key: "+bootstrap, "")
+	var found viewmodels.APIKeyViewModel
+	json.NewDecoder(rr.Body).Decode(&found)
+	if rr.Code != http.StatusOK || found.Key != "" || found.LastUsedAt == nil || strings.Contains(rr.Body.String(), writer.Key) {
+		t.Errorf("Expected the key with its last use and without the secret, but got %d %s", rr.Code, rr.Body.String())
+	}
+
+	rr = serve("POST", "/api/v1/api-keys/"+writer.ID.String()+"/rotate", "X-API-Key: "+bootstrap, "")
+	var rotated viewmodels.APIKeyViewModel
+	json.NewDecoder(rr.Body).Decode(&rotated)
+	if rr.Code != http.StatusOK || rotated.Key == "" || rotated.Key == writer.Key {
+		t.Fatalf("Expected a new key, but got %d %v", rr.Code, rotated)
+	}
+	if rr := serve("GET", "/api/v1/customers", "X-API-Key: "+writer.Key, ""); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected the replaced key to stop working without a grace period, but got %d", rr.Code)
+	}
+	if rr := serve("GET", "/api/v1/customers", "X-API-Key: "+rotated.Key, ""); rr.Code != http.StatusOK {
+		t.Errorf("Expected the rotated key to work, but got %d", rr.Code)
+	}
+
+	if rr := serve("DELETE", "/api/v1/api-keys/"+writer.ID.String(), "X-API-Key: "+bootstrap, ""); rr.Code != http.StatusNoContent {
+		t.Errorf("Expected the revoke to be 204, but got %d", rr.Code)
+	}
+	if rr := serve("GET", "/api/v1/customers", "X-API-Key: "+rotated.Key, ""); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected a revoked key to be 401, but got %d", rr.Code)
+	}
+	if rr := serve("POST", "/api/v1/api-keys/"+writer.ID.String()+"/rotate", "X-API-Key: "+bootstrap, ""); rr.Code != http.StatusConflict {
+		t.Errorf("Expected rotating a revoked key to be 409, but got %d", rr.Code)
+	}
+	if rr := serve("GET", "/api/v1/api-keys/"+reader.ID.String()[:8], "X-API-Key: "+bootstrap, ""); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected an invalid ID to be 400, but got %d", rr.Code)
+	}
+
+	// A browser cannot set headers on a WebSocket, so it trades its key for
+	// a single-use ticket and passes that as a parameter; a read key may
+	// watch but not edit
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+	live := "ws" + strings.TrimPrefix(server.URL, "http") + "/api/v1/customers/" + customer.ID.String() + "/live"
+	if _, resp, err := websocket.DefaultDialer.Dial(live+"?api_key="+reader.Key, nil); err == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected the api_key parameter to be refused on a WebSocket, but got %v", err)
+	}
+	rr = serve("POST", "/api/v1/customers/stream-tickets", "X-API-Key: "+reader.Key, "")
+	var ticket viewmodels.StreamTicketViewModel
+	json.NewDecoder(rr.Body).Decode(&ticket)
+	if rr.Code != http.StatusCreated || ticket.Ticket == "" || rr.Header().Get("Cache-Control") != "no-store" {
+		t.Fatalf("Expected a stream ticket, but got %d %v", rr.Code, ticket)
+	}
+	conn, _, err := websocket.DefaultDialer.Dial(live+"?ticket="+ticket.Ticket, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, resp, err := websocket.DefaultDialer.Dial(live+"?ticket="+ticket.Ticket, nil); err == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected a used ticket to be refused, but got %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var snapshot viewmodels.CustomerLiveMessageViewModel
+	if conn.ReadJSON(&snapshot); snapshot.Type != "snapshot" || snapshot.Viewers[0].Subject != "reporting" {
+		t.Errorf("Expected a snapshot with the reporting viewer, but got %+v", snapshot)
+	}
+	conn.WriteJSON(viewmodels.CustomerLiveMessageViewModel{Type: "patch", Version: 1, Patch: json.RawMessage(`{"Contacted": true}`)})
+	for {
+		var message viewmodels.CustomerLiveMessageViewModel
+		if err := conn.ReadJSON(&message); err != nil {
+			t.Fatalf("Failed to read a message: %v", err)
+		}
+		if message.Type == "presence" {
+			continue
+		}
+		if message.Type != "error" || message.Problem.Status != http.StatusForbidden {
+			t.Errorf("Expected an edit with a read key to be 403, but got %+v", message)
+		}
+		break
+	}
+}
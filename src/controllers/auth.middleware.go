@@ -0,0 +1,168 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"congdinh.com/crm/auth"
+	"congdinh.com/crm/policy"
+	"congdinh.com/crm/services"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+)
+
+// APIKeyHeader carries the API key of a request
+const APIKeyHeader = "X-API-Key"
+
+// TokenVerifier checks bearer tokens, such as the JWTs of an SSO provider,
+// and returns an error for any token it does not accept
+type TokenVerifier interface {
+	Verify(ctx context.Context, token string) (auth.Principal, error)
+}
+
+// credentialsFrom returns the API key or bearer token of r from the
+// X-API-Key header or an Authorization Bearer header
+func credentialsFrom(r *http.Request) string {
+	if key := r.Header.Get(APIKeyHeader); key != "" {
+		return key
+	}
+	if scheme, credentials, ok := strings.Cut(r.Header.Get("Authorization"), " "); ok && strings.EqualFold(scheme, "Bearer") {
+		return strings.TrimSpace(credentials)
+	}
+	return ""
+}
+
+// ticketFrom returns the ticket parameter of a WebSocket or event stream
+// request. Browsers cannot set headers on those, and a URL is logged and
+// kept in histories, so it may only carry a short-lived, single-use ticket
+// and never an API key or token.
+func ticketFrom(r *http.Request) string {
+	if websocket.IsWebSocketUpgrade(r) || r.Header.Get("Accept") == "text/event-stream" {
+		return r.URL.Query().Get("ticket")
+	}
+	return ""
+}
+
+// isJWT tells a JWT, three dot separated parts, from an API key
+func isJWT(credentials string) bool {
+	return strings.Count(credentials, ".") == 2
+}
+
+// requiredScope is the scope a request needs: reading for the safe methods
+// and for stream tickets, which only open streams, writing for the others.
+// Routes that need more wrap their handler in requireScope.
+func requiredScope(r *http.Request) string {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return auth.ScopeRead
+	}
+	if route := mux.CurrentRoute(r); route != nil && route.GetName() == "customers.stream-tickets" {
+		return auth.ScopeRead
+	}
+	return auth.ScopeWrite
+}
+
+// Authenticate returns the middleware that admits requests with an API key,
+// a bearer token or, for streams, a ticket from tickets that allows their
+// method, and hands its principal to the handlers. JWTs go to tokens and
+// everything else to keys; any of them may be nil to turn that kind of
+// credentials off. Other requests are answered with 401, or 403 when the
+// credentials lack the scope.
+func Authenticate(keys services.IAPIKeyService, tokens TokenVerifier, tickets *auth.TicketStore) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			credentials := credentialsFrom(r)
+			ticket := ticketFrom(r)
+			if credentials == "" && (ticket == "" || tickets == nil) {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="crm"`)
+				writeProblem(w, r, http.StatusUnauthorized, "An API key in the "+APIKeyHeader+" header or a bearer token is required")
+				return
+			}
+
+			var principal auth.Principal
+			var err error
+			switch {
+			case credentials == "":
+				var ok bool
+				if principal, ok = tickets.Redeem(ticket); !ok {
+					err = services.ErrInvalidAPIKey
+				}
+			case tokens != nil && isJWT(credentials):
+				principal, err = tokens.Verify(r.Context(), credentials)
+				if err != nil {
+					// The reason is for the logs, not for whoever sent the token
+					log.Printf("Rejected a bearer token: %v", err)
+					err = services.ErrInvalidAPIKey
+				}
+			case keys != nil:
+				principal, err = keys.Authenticate(r.Context(), credentials)
+			default:
+				err = services.ErrInvalidAPIKey
+			}
+			if errors.Is(err, services.ErrInvalidAPIKey) {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="crm", error="invalid_token"`)
+				writeProblem(w, r, http.StatusUnauthorized, "The API key, bearer token or ticket is unknown, revoked or expired")
+				return
+			}
+			if err != nil {
+				writeError(w, r, err)
+				return
+			}
+			if scope := requiredScope(r); !principal.Allows(scope) {
+				writeProblem(w, r, http.StatusForbidden, "The caller lacks the "+scope+" scope")
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(auth.WithPrincipal(r.Context(), principal)))
+		})
+	}
+}
+
+// Authorize returns the middleware that only admits requests to the routes
+// the roles of the caller allow under p, by route name, and hands the
+// policy.Access of the caller to the handlers. It runs after Authenticate.
+// Unnamed routes are refused, so a new route is not open to every role.
+func Authorize(p *policy.Policy) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			access := p.Access(auth.FromContext(r.Context()))
+			var permission string
+			if route := mux.CurrentRoute(r); route != nil {
+				permission = route.GetName()
+			}
+			if permission == "" {
+				log.Printf("%s %s: the route has no name, so the access policy refuses it", r.Method, r.URL.Path)
+				writeProblem(w, r, http.StatusForbidden, "The access policy does not cover this route")
+				return
+			}
+			if !access.Allows(permission) {
+				writeProblem(w, r, http.StatusForbidden, "The roles of the caller do not allow "+permission)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(policy.WithAccess(r.Context(), access)))
+		})
+	}
+}
+
+// requirePermission writes a 403 problem unless the caller may use the route
+// named permission, for handlers that do the work of other routes
+func requirePermission(w http.ResponseWriter, r *http.Request, permission string) bool {
+	if !policy.FromContext(r.Context()).Allows(permission) {
+		writeProblem(w, r, http.StatusForbidden, "The roles of the caller do not allow "+permission)
+		return false
+	}
+	return true
+}
+
+// requireScope only runs next for callers with scope
+func requireScope(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !auth.FromContext(r.Context()).Allows(scope) {
+			writeProblem(w, r, http.StatusForbidden, "The caller lacks the "+scope+" scope")
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,244 @@
+package controllers
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"congdinh.com/crm/oidc"
+	"congdinh.com/crm/policy"
+	"congdinh.com/crm/repositories"
+	"congdinh.com/crm/services"
+	viewmodels "congdinh.com/crm/view-models"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+func TestAuthenticate_JWT(t *testing.T) {
+	signingKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwk, err := oidc.NewJWK("sso-1", &signingKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(oidc.JWKS{Keys: []oidc.JWK{jwk}})
+	}))
+	defer provider.Close()
+	token := func(subject string, scope string, expiresIn time.Duration) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+			"iss":   provider.URL,
+			"aud":   "crm",
+			"sub":   subject,
+			"scope": scope,
+			"exp":   time.Now().Add(expiresIn).Unix(),
+		})
+		token.Header["kid"] = "sso-1"
+		signed, err := token.SignedString(signingKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+
+	customerService := newCustomerService(t)
+	apiKeyService := services.NewAPIKeyService(repositories.NewMemoryAPIKeyRepository())
+	bootstrap := "bootstrap-0123456789abcdef"
+	apiKeyService.EnsureBootstrapKey(t.Context(), bootstrap)
+	router := mux.NewRouter()
+	api := router.PathPrefix("/api/v1").Subrouter()
+	api.Use(Authenticate(apiKeyService, oidc.NewVerifier(oidc.NewKeySet(provider.URL), provider.URL, "crm"), nil))
+	NewCustomerController(customerService).RegisterRoutes(api)
+
+	serve := func(method string, path string, authorization string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", authorization)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := serve("POST", "/api/v1/customers", "Bearer "+token("alice@example.com", "read write", time.Hour), `{"Name": "Lead", "Role": "Sales", "Email": "lead@domain.com", "Phone": "5550009302"}`)
+	var created viewmodels.CustomerViewModel
+	json.NewDecoder(rr.Body).Decode(&created)
+	if rr.Code != http.StatusCreated || created.CreatedBy != "alice@example.com" {
+		t.Errorf("Expected the customer created by the token subject, but got %d %v", rr.Code, created)
+	}
+
+	tests := []struct {
+		name          string
+		method        string
+		authorization string
+		status        int
+	}{
+		{name: "read token", method: "GET", authorization: "Bearer " + token("bob@example.com", "read", time.Hour), status: http.StatusOK},
+		{name: "read token writing", method: "POST", authorization: "Bearer " + token("bob@example.com", "read", time.Hour), status: http.StatusForbidden},
+		{name: "expired token", method: "GET", authorization: "Bearer " + token("bob@example.com", "read", -time.Hour), status: http.StatusUnauthorized},
+		{name: "forged token", method: "GET", authorization: "Bearer " + token("bob@example.com", "read", time.Hour) + "x", status: http.StatusUnauthorized},
+		{name: "api key", method: "GET", authorization: "Bearer " + bootstrap, status: http.StatusOK},
+	}
+	for _, test := range tests {
+		if rr := serve(test.method, "/api/v1/customers", test.authorization, `{}`); rr.Code != test.status {
+			t.Errorf("Expected status code %d for the %s, but got %d", test.status, test.name, rr.Code)
+		}
+	}
+
+	// Without a verifier JWTs are just unknown API keys
+	router = mux.NewRouter()
+	api = router.PathPrefix("/api/v1").Subrouter()
+	api.Use(Authenticate(apiKeyService, nil, nil))
+	NewCustomerController(customerService).RegisterRoutes(api)
+	if rr := serve("GET", "/api/v1/customers", "Bearer "+token("alice@example.com", "read", time.Hour), ""); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected a JWT to be 401 with jwt authentication off, but got %d", rr.Code)
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	customerService := newCustomerService(t)
+	apiKeyService := services.NewAPIKeyService(repositories.NewMemoryAPIKeyRepository())
+	accessPolicy := &policy.Policy{
+		DefaultRoles: []string{"viewer"},
+		Roles: map[string]policy.Role{
+			"viewer":    {Permissions: []string{"customers.list", "customers.get", "customers.search", "customers.history"}, HiddenFields: []string{"Phone"}},
+			"sales-rep": {Permissions: []string{"customers.get", "customers.update", "customers.patch", "customers.batch"}, HiddenFields: []string{"Phone"}},
+			"clerk":     {Permissions: []string{"customers.create", "customers.import", "customers.batch"}, HiddenFields: []string{"Contacted"}},
+			"manager":   {Permissions: []string{"customers.*"}},
+		},
+	}
+	router := mux.NewRouter()
+	api := router.PathPrefix("/api/v1").Subrouter()
+	api.Use(Authenticate(apiKeyService, nil, nil), Authorize(accessPolicy))
+	NewCustomerController(customerService).RegisterRoutes(api)
+	NewWebhookController(services.NewWebhookService(repositories.NewMemoryWebhookRepository())).RegisterRoutes(api)
+	NewAPIKeyController(apiKeyService).RegisterRoutes(api)
+	api.HandleFunc("/unnamed", func(w http.ResponseWriter, r *http.Request) {}).Methods("GET")
+
+	// Every route of the controllers is named, so the policy covers it
+	router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		path, _ := route.GetPathTemplate()
+		if route.GetName() == "" && route.GetHandler() != nil && path != "/api/v1/unnamed" {
+			t.Errorf("Expected route %s to have a name", path)
+		}
+		return nil
+	})
+
+	key := func(name string, roles ...string) string {
+		created, err := apiKeyService.Create(t.Context(), viewmodels.APIKeyCreateViewModel{Name: name, Scopes: []string{"write"}, Roles: roles})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return created.Key
+	}
+	viewer := key("viewer")
+	salesRep := key("sales-rep", "sales-rep")
+	manager := key("manager", "manager")
+	clerk := key("clerk", "clerk")
+	serve := func(method string, path string, key string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		switch {
+		case method == "PATCH" && strings.HasPrefix(body, "["):
+			req.Header.Set("Content-Type", services.JSONPatchContentType)
+		case method == "PATCH":
+			req.Header.Set("Content-Type", services.MergePatchContentType)
+		case strings.HasSuffix(path, "/import"):
+			req.Header.Set("Content-Type", "text/csv")
+		default:
+			req.Header.Set("Content-Type", "application/json")
+		}
+		req.Header.Set(APIKeyHeader, key)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	all, _ := customerService.GetAll(t.Context())
+	customer := all[0]
+	customerPath := "/api/v1/customers/" + customer.ID.String()
+
+	rr := serve("GET", customerPath, viewer, "")
+	var seen viewmodels.CustomerViewModel
+	json.NewDecoder(rr.Body).Decode(&seen)
+	if rr.Code != http.StatusOK || seen.Phone != "" || seen.Email != customer.Email {
+		t.Errorf("Expected the customer without the phone, but got %d %v", rr.Code, seen)
+	}
+	if full := serve("GET", customerPath, manager, ""); full.Header().Get("ETag") == rr.Header().Get("ETag") {
+		t.Errorf("Expected the customer with and without the phone to have different ETags, but both got %q", rr.Header().Get("ETag"))
+	}
+	rr = serve("GET", "/api/v1/customers", viewer, "")
+	var page []viewmodels.CustomerViewModel
+	json.NewDecoder(rr.Body).Decode(&page)
+	if rr.Code != http.StatusOK || len(page) == 0 || page[0].Phone != "" {
+		t.Errorf("Expected the customers without phones, but got %d %v", rr.Code, page)
+	}
+	rr = serve("GET", "/api/v1/customers/search?q="+customer.Phone, viewer, "")
+	var results []viewmodels.CustomerSearchResultViewModel
+	json.NewDecoder(rr.Body).Decode(&results)
+	if rr.Code != http.StatusOK || len(results) != 0 {
+		t.Errorf("Expected no results for a search by a hidden phone, but got %d %v", rr.Code, results)
+	}
+
+	// PUT without the hidden phone keeps it
+	rr = serve("PUT", customerPath, salesRep, `{"Name": "Renamed", "Role": "`+customer.Role+`", "Email": "`+customer.Email+`"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected the update to succeed, but got %d %s", rr.Code, rr.Body.String())
+	}
+	if stored, _ := customerService.GetById(t.Context(), customer.ID); stored.Name != "Renamed" || stored.Phone != customer.Phone {
+		t.Errorf("Expected the phone to be kept, but got %v", stored)
+	}
+	rr = serve("GET", customerPath+"/history", viewer, "")
+	if rr.Code != http.StatusOK || strings.Contains(rr.Body.String(), "Phone") || !strings.Contains(rr.Body.String(), "Renamed") {
+		t.Errorf("Expected the history without phone changes, but got %d %s", rr.Code, rr.Body.String())
+	}
+
+	// A conflict does not name the hidden phone
+	rr = serve("PUT", customerPath, salesRep, `{"Name": "Renamed", "Role": "`+customer.Role+`", "Email": "`+all[1].Email+`"}`)
+	if rr.Code != http.StatusConflict || strings.Contains(strings.ToLower(rr.Body.String()), "phone") {
+		t.Errorf("Expected a conflict over the email only, but got %d %s", rr.Code, rr.Body.String())
+	}
+
+	create := `{"Name": "Lead", "Role": "Sales", "Email": "lead@domain.com", "Phone": "5550009303"`
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		key    string
+		body   string
+		status int
+	}{
+		{name: "viewer deleting", method: "DELETE", path: customerPath, key: viewer, status: http.StatusForbidden},
+		{name: "viewer sorting by phone", method: "GET", path: "/api/v1/customers?sort=phone", key: viewer, status: http.StatusForbidden},
+		{name: "viewer managing webhooks", method: "GET", path: "/api/v1/webhooks", key: viewer, status: http.StatusForbidden},
+		{name: "unnamed route", method: "GET", path: "/api/v1/unnamed", key: manager, status: http.StatusForbidden},
+		{name: "sales rep deleting in a batch", method: "POST", path: "/api/v1/customers:batch", key: salesRep, body: `{"Operations": [{"Op": "delete", "ID": "` + customer.ID.String() + `"}]}`, status: http.StatusForbidden},
+		{name: "sales rep copying a hidden field", method: "PATCH", path: customerPath, key: salesRep, body: `[{"op": "copy", "from": "/Phone", "path": "/Name"}]`, status: http.StatusForbidden},
+		{name: "sales rep testing a hidden field", method: "PATCH", path: customerPath, key: salesRep, body: `[{"op": "test", "path": "/Phone", "value": "` + customer.Phone + `"}]`, status: http.StatusForbidden},
+		{name: "sales rep replacing the whole customer", method: "PATCH", path: customerPath, key: salesRep, body: `[{"op": "replace", "path": "", "value": {}}]`, status: http.StatusForbidden},
+		{name: "sales rep merging a hidden field", method: "PATCH", path: customerPath, key: salesRep, body: `{"Phone": "5550009302"}`, status: http.StatusForbidden},
+		{name: "sales rep patching a visible field", method: "PATCH", path: customerPath, key: salesRep, body: `[{"op": "replace", "path": "/Contacted", "value": true}]`, status: http.StatusOK},
+		{name: "sales rep listing", method: "GET", path: "/api/v1/customers", key: salesRep, status: http.StatusForbidden},
+		{name: "sales rep updating a hidden field", method: "PUT", path: customerPath, key: salesRep, body: `{"Name": "Renamed", "Role": "` + customer.Role + `", "Email": "` + customer.Email + `", "Phone": "5550009302"}`, status: http.StatusForbidden},
+		{name: "sales rep updating a hidden field in a batch", method: "POST", path: "/api/v1/customers:batch", key: salesRep, body: `{"Operations": [{"Op": "update", "ID": "` + customer.ID.String() + `", "Customer": {"Name": "Renamed", "Role": "` + customer.Role + `", "Email": "` + customer.Email + `", "Phone": "5550009302"}}]}`, status: http.StatusForbidden},
+		{name: "clerk creating with a hidden field", method: "POST", path: "/api/v1/customers", key: clerk, body: create + `, "Contacted": true}`, status: http.StatusForbidden},
+		{name: "clerk creating with a hidden field in a batch", method: "POST", path: "/api/v1/customers:batch", key: clerk, body: `{"Operations": [{"Op": "create", "Customer": ` + create + `, "Contacted": true}}]}`, status: http.StatusForbidden},
+		{name: "clerk importing a hidden field", method: "POST", path: "/api/v1/customers/import", key: clerk, body: "Name,Role,Email,Phone,Contacted\nLead,Sales,lead@domain.com,5550009303,true\n", status: http.StatusForbidden},
+		{name: "clerk creating", method: "POST", path: "/api/v1/customers", key: clerk, body: create + `}`, status: http.StatusCreated},
+		{name: "manager deleting", method: "DELETE", path: customerPath, key: manager, status: http.StatusNoContent},
+		{name: "manager restoring", method: "POST", path: customerPath + "/restore", key: manager, status: http.StatusOK},
+		{name: "unknown customer", method: "GET", path: "/api/v1/customers/" + uuid.NewString(), key: viewer, status: http.StatusNotFound},
+	}
+	for _, test := range tests {
+		if rr := serve(test.method, test.path, test.key, test.body); rr.Code != test.status {
+			t.Errorf("Expected status code %d for the %s, but got %d %s", test.status, test.name, rr.Code, rr.Body.String())
+		}
+	}
+}
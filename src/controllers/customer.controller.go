@@ -2,9 +2,22 @@ package controllers
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
 	"net/http"
-
+	"strconv"
+	"strings"
+	"time"
+
+	"congdinh.com/crm/auth"
+	"congdinh.com/crm/changefeed"
+	"congdinh.com/crm/idempotency"
+	"congdinh.com/crm/live"
+	"congdinh.com/crm/repositories"
 	"congdinh.com/crm/services"
+	"congdinh.com/crm/validation"
 	viewmodels "congdinh.com/crm/view-models"
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
@@ -12,70 +25,375 @@ import (
 
 type CustomerController struct {
 	ICustomerService services.ICustomerService
+	// IdempotencyKeys stores the responses replayed for Idempotency-Key retries of POST
+	IdempotencyKeys *idempotency.ResponseStore
+	// Events buffers the customer changes streamed by GET /customers/events
+	Events *changefeed.Broker
+	// Heartbeat is how often an idle event stream sends a comment
+	Heartbeat time.Duration
+	// Live tracks who has a customer open on GET /customers/{id}/live
+	Live *live.Hub
+	// Tickets issues the tickets that authenticate the streams; Authenticate
+	// must redeem them from the same store
+	Tickets *auth.TicketStore
 }
 
 // NewCustomerController creates a new customer controller
 func NewCustomerController(customerService services.ICustomerService) *CustomerController {
-	return &CustomerController{
-		ICustomerService: customerService,
+	cc := &CustomerController{
+		ICustomerService: visibleCustomerService{customerService},
+		IdempotencyKeys:  idempotency.NewResponseStore(idempotency.DefaultTTL),
+		Events:           changefeed.NewBroker(changefeed.DefaultCapacity),
+		Heartbeat:        defaultHeartbeat,
+		Live:             live.NewHub(),
+		Tickets:          auth.NewTicketStore(auth.DefaultTicketTTL),
 	}
+	cc.Live.Presence = livePresenceMessage
+	customerService.Subscribe(cc.publishEvent)
+	customerService.Subscribe(cc.publishLiveChange)
+	return cc
 }
 
-// RegisterRoutes registers the routes for the customer controller
+// RegisterRoutes registers the routes for the customer controller on the
+// /api/v1 router. The route names are the permissions of the access policy.
 func (cc *CustomerController) RegisterRoutes(router *mux.Router) {
-	customers := router.PathPrefix("/api/v1/customers").Subrouter()
+	// Subrouter paths must start with a slash, and the subrouter answers every
+	// path under its prefix, so the batch route goes on the router first
+	router.HandleFunc("/customers:batch", idempotent(cc.IdempotencyKeys, cc.BatchCustomers)).Methods("POST").Name("customers.batch")
+
+	customers := router.PathPrefix("/customers").Subrouter()
+
+	customers.HandleFunc("", cc.GetCustomers).Methods("GET").Name("customers.list")
+	customers.HandleFunc("/search", cc.SearchCustomers).Methods("GET").Name("customers.search")
+	customers.HandleFunc("/export.csv", cc.ExportCustomers).Methods("GET").Name("customers.export")
+	customers.HandleFunc("/import", cc.ImportCustomers).Methods("POST").Name("customers.import")
+	customers.HandleFunc("/trash", cc.GetTrash).Methods("GET").Name("customers.trash")
+	customers.HandleFunc("/events", cc.StreamCustomerEvents).Methods("GET").Name("customers.events")
+	customers.HandleFunc("/stream-tickets", cc.CreateStreamTicket).Methods("POST").Name("customers.stream-tickets")
+	// Registered before the /{id} routes: a path mismatch after a method
+	// mismatch makes mux answer 404 instead of 405
+	customers.HandleFunc("/{id}/restore", cc.RestoreCustomer).Methods("POST").Name("customers.restore")
+	customers.HandleFunc("/{id}/history", cc.GetCustomerHistory).Methods("GET").Name("customers.history")
+	customers.HandleFunc("/{id}/live", cc.LiveCustomer).Methods("GET").Name("customers.live")
+	customers.HandleFunc("/{id}", cc.GetCustomer).Methods("GET").Name("customers.get")
+	customers.HandleFunc("", idempotent(cc.IdempotencyKeys, cc.CreateCustomer)).Methods("POST").Name("customers.create")
+	customers.HandleFunc("/{id}", cc.UpdateCustomer).Methods("PUT").Name("customers.update")
+	customers.HandleFunc("/{id}", cc.PatchCustomer).Methods("PATCH").Name("customers.patch")
+	customers.HandleFunc("/{id}", cc.DeleteCustomer).Methods("DELETE").Name("customers.delete")
+
+	customers.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeProblem(w, r, http.StatusNotFound, "")
+	})
+	customers.MethodNotAllowedHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeProblem(w, r, http.StatusMethodNotAllowed, "")
+	})
+}
+
+const (
+	// defaultPageSize is used when GET /customers has no limit parameter
+	defaultPageSize = 100
+	// maxPageSize caps the limit parameter
+	maxPageSize = 1000
+	// maxBatchSize caps the number of operations in POST /customers:batch
+	maxBatchSize = 1000
+)
+
+// parseCustomerQuery reads the pagination, sort and filter parameters of GET /customers
+func parseCustomerQuery(r *http.Request) (services.CustomerQueryOptions, error) {
+	params := r.URL.Query()
+	options := services.CustomerQueryOptions{
+		CustomerQuery: repositories.CustomerQuery{
+			Limit:       defaultPageSize,
+			Role:        params.Get("role"),
+			NamePrefix:  params.Get("name_prefix"),
+			EmailPrefix: params.Get("email_prefix"),
+		},
+		Cursor: params.Get("cursor"),
+	}
 
-	customers.HandleFunc("", cc.GetCustomers).Methods("GET")
-	customers.HandleFunc("/{id}", cc.GetCustomer).Methods("GET")
-	customers.HandleFunc("", cc.CreateCustomer).Methods("POST")
-	customers.HandleFunc("/{id}", cc.UpdateCustomer).Methods("PUT")
-	customers.HandleFunc("/{id}", cc.DeleteCustomer).Methods("DELETE")
+	if value := params.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxPageSize {
+			return options, fmt.Errorf("limit must be a number between 1 and %d", maxPageSize)
+		}
+		options.Limit = limit
+	}
+	if value := params.Get("offset"); value != "" {
+		offset, err := strconv.Atoi(value)
+		if err != nil || offset < 0 {
+			return options, errors.New("offset must be a non-negative number")
+		}
+		if options.Cursor != "" {
+			return options, errors.New("offset and cursor cannot be combined")
+		}
+		options.Offset = offset
+	}
+	if value := params.Get("contacted"); value != "" {
+		contacted, err := strconv.ParseBool(value)
+		if err != nil {
+			return options, errors.New("contacted must be true or false")
+		}
+		options.Contacted = &contacted
+	}
+	if value := params.Get("sort"); value != "" {
+		sort, err := repositories.ParseSort(value)
+		if err != nil {
+			return options, err
+		}
+		options.Sort = sort
+	}
+	if value := params.Get("updated_since"); value != "" {
+		updatedSince, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			return options, errors.New("updated_since must be an RFC 3339 timestamp")
+		}
+		options.UpdatedSince = updatedSince
+	}
+
+	return options, nil
+}
+
+// writePageHeaders adds the total count and the Link header for the next and
+// previous pages. The next page continues from nextCursor when there is one
+// and from the next offset otherwise.
+func writePageHeaders(w http.ResponseWriter, r *http.Request, total int, offset int, limit int, nextCursor string) {
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+
+	links := []string{}
+	if nextCursor != "" {
+		next := r.URL.Query()
+		next.Del("offset")
+		next.Set("cursor", nextCursor)
+		links = append(links, fmt.Sprintf(`<%s?%s>; rel="next"`, r.URL.Path, next.Encode()))
+	} else if offset+limit < total {
+		next := r.URL.Query()
+		next.Set("offset", strconv.Itoa(offset+limit))
+		links = append(links, fmt.Sprintf(`<%s?%s>; rel="next"`, r.URL.Path, next.Encode()))
+	}
+	if offset > 0 && r.URL.Query().Get("cursor") == "" {
+		prev := r.URL.Query()
+		prev.Set("offset", strconv.Itoa(max(offset-limit, 0)))
+		links = append(links, fmt.Sprintf(`<%s?%s>; rel="prev"`, r.URL.Path, prev.Encode()))
+	}
+	if len(links) > 0 {
+		w.Header().Set("Link", strings.Join(links, ", "))
+	}
 }
 
 // GetCustomers godoc
 // @Summary Show a list of customers
-// @Description get customers
+// @Description get customers, optionally filtered, sorted and paginated. The total number of matches is returned in X-Total-Count and the next page in the Link header. The Accept header selects JSON (default), NDJSON streamed one customer per line, CSV or XML.
 // @Tags customers
 // @Accept  json
-// @Produce  json
+// @Produce  json,application/x-ndjson,text/csv,application/xml
+// @Param limit query int false "Page size (1-1000, default 100)"
+// @Param offset query int false "Number of customers to skip"
+// @Param cursor query string false "Opaque cursor from a previous Link header"
+// @Param sort query string false "Comma separated fields, prefix with - for descending, e.g. name,-email"
+// @Param role query string false "Filter by role"
+// @Param contacted query bool false "Filter by contacted"
+// @Param name_prefix query string false "Filter by name prefix"
+// @Param email_prefix query string false "Filter by email prefix"
+// @Param updated_since query string false "Only customers changed at or after this RFC 3339 time"
+// @Param If-None-Match header string false "ETag of a cached page"
 // @Success 200 {array} viewmodels.CustomerViewModel
+// @Success 304 "Not Modified"
+// @Header 200 {integer} X-Total-Count "Number of customers matching the filters"
+// @Header 200 {string} Link "Links to the next and previous pages"
+// @Header 200 {string} ETag "Weak ETag of the page"
+// @Failure 400 {object} viewmodels.ProblemViewModel "Bad Request"
+// @Failure 406 {object} viewmodels.ProblemViewModel "None of the accepted media types is supported"
+// @Security ApiKeyAuth
+// @Security BearerAuth
 // @Router /customers [get]
 func (cc *CustomerController) GetCustomers(w http.ResponseWriter, r *http.Request) {
-	customers := cc.ICustomerService.GetAll()
+	cc.listCustomers(w, r, false)
+}
+
+// GetTrash godoc
+// @Summary Show the deleted customers
+// @Description get the customers in the trash, with their DeletedAt. Accepts the same filters, sorting, pagination and formats as GET /customers.
+// @Tags customers
+// @Accept  json
+// @Produce  json,application/x-ndjson,text/csv,application/xml
+// @Param limit query int false "Page size (1-1000, default 100)"
+// @Param offset query int false "Number of customers to skip"
+// @Param cursor query string false "Opaque cursor from a previous Link header"
+// @Param sort query string false "Comma separated fields, prefix with - for descending, e.g. name,-email"
+// @Param role query string false "Filter by role"
+// @Param contacted query bool false "Filter by contacted"
+// @Param name_prefix query string false "Filter by name prefix"
+// @Param email_prefix query string false "Filter by email prefix"
+// @Param updated_since query string false "Only customers changed at or after this RFC 3339 time"
+// @Param If-None-Match header string false "ETag of a cached page"
+// @Success 200 {array} viewmodels.CustomerViewModel
+// @Success 304 "Not Modified"
+// @Header 200 {integer} X-Total-Count "Number of deleted customers matching the filters"
+// @Header 200 {string} Link "Links to the next and previous pages"
+// @Header 200 {string} ETag "Weak ETag of the page"
+// @Failure 400 {object} viewmodels.ProblemViewModel "Bad Request"
+// @Failure 406 {object} viewmodels.ProblemViewModel "None of the accepted media types is supported"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /customers/trash [get]
+func (cc *CustomerController) GetTrash(w http.ResponseWriter, r *http.Request) {
+	cc.listCustomers(w, r, true)
+}
+
+// listCustomers serves a page of the live customers, or of the deleted ones
+func (cc *CustomerController) listCustomers(w http.ResponseWriter, r *http.Request, deleted bool) {
+	encoder, ok := negotiateCustomerEncoder(r)
+	if !ok {
+		writeNotAcceptable(w, r)
+		return
+	}
+
+	options, err := parseCustomerQuery(r)
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	options.Deleted = deleted
+
+	page, err := cc.ICustomerService.Query(r.Context(), options)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// The ETag is derived from the JSON form of the page whatever the representation
+	body, err := json.Marshal(page.Items)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writePageHeaders(w, r, page.Total, page.Offset, page.Limit, page.NextCursor)
+	w.Header().Set("Vary", varyHeader)
+	etag := contentETag(encoder.mediaType, body)
+	w.Header().Set("ETag", etag)
+	if noneMatch(r, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", encoder.contentType)
+	w.WriteHeader(http.StatusOK)
+	encoder.list(w, page.Items)
+}
+
+// SearchCustomers godoc
+// @Summary Search customers
+// @Description full-text search over name, email, phone and role. Matching ignores case and accents, accepts prefixes, phone number fragments and small typos. Matched text is wrapped in <em> tags in Highlights.
+// @Tags customers
+// @Accept  json
+// @Produce  json
+// @Param q query string true "Search text"
+// @Param limit query int false "Maximum number of results (1-100, default 20)"
+// @Success 200 {array} viewmodels.CustomerSearchResultViewModel
+// @Failure 400 {object} viewmodels.ProblemViewModel "Bad Request"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /customers/search [get]
+func (cc *CustomerController) SearchCustomers(w http.ResponseWriter, r *http.Request) {
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if query == "" {
+		writeProblem(w, r, http.StatusBadRequest, "q is required")
+		return
+	}
+
+	limit := 20
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > 100 {
+			writeProblem(w, r, http.StatusBadRequest, "limit must be a number between 1 and 100")
+			return
+		}
+		limit = parsed
+	}
+
+	results, err := cc.ICustomerService.Search(r.Context(), query, limit)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(customers)
+	json.NewEncoder(w).Encode(results)
 }
 
 // GetCustomer godoc
 // @Summary Show a customer
-// @Description get customer by ID
+// @Description get customer by ID, or as it was at the as_of time. The Accept header selects JSON (default), NDJSON, CSV or XML.
 // @Tags customers
 // @Accept  json
-// @Produce  json
+// @Produce  json,application/x-ndjson,text/csv,application/xml
 // @Param id path string true "Customer ID"
+// @Param as_of query string false "RFC 3339 time to reconstruct the customer at"
+// @Param If-None-Match header string false "ETag of the cached customer"
 // @Success 200 {object} viewmodels.CustomerViewModel
+// @Success 304 "Not Modified"
+// @Header 200 {string} ETag "Weak ETag of the representation"
+// @Failure 400 {object} viewmodels.ProblemViewModel "Bad Request"
+// @Failure 404 {object} viewmodels.ProblemViewModel "Customer not found"
+// @Failure 406 {object} viewmodels.ProblemViewModel "None of the accepted media types is supported"
+// @Security ApiKeyAuth
+// @Security BearerAuth
 // @Router /customers/{id} [get]
 func (cc *CustomerController) GetCustomer(w http.ResponseWriter, r *http.Request) {
+	encoder, ok := negotiateCustomerEncoder(r)
+	if !ok {
+		writeNotAcceptable(w, r)
+		return
+	}
+
 	// Get the ID from the request and convert it to an integer
 	id, err := uuid.Parse(mux.Vars(r)["id"])
 
 	if err != nil {
-		http.Error(w, "Invalid customer ID", http.StatusBadRequest)
+		writeProblem(w, r, http.StatusBadRequest, "Invalid customer ID")
 		return
 	}
 
-	customer := cc.ICustomerService.GetById(id)
+	var customer *viewmodels.CustomerViewModel
+	if value := r.URL.Query().Get("as_of"); value != "" {
+		asOf, parseErr := time.Parse(time.RFC3339Nano, value)
+		if parseErr != nil {
+			writeProblem(w, r, http.StatusBadRequest, "as_of must be an RFC 3339 timestamp")
+			return
+		}
+		customer, err = cc.ICustomerService.GetAsOf(r.Context(), id, asOf)
+	} else {
+		customer, err = cc.ICustomerService.GetById(r.Context(), id)
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
 
 	if customer == nil {
-		http.Error(w, "Customer not found", http.StatusNotFound)
+		writeError(w, r, &services.NotFoundError{ID: id})
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
+	// The version alone is not enough: the representation, the fields the
+	// caller may see and as_of all change the body
+	body, err := json.Marshal(customer)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Vary", varyHeader)
+	etag := contentETag(encoder.mediaType, body)
+	w.Header().Set("ETag", etag)
+	if noneMatch(r, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", encoder.contentType)
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(customer)
+	encoder.one(w, *customer)
 }
 
 // CreateCustomer godoc
@@ -85,38 +403,133 @@ func (cc *CustomerController) GetCustomer(w http.ResponseWriter, r *http.Request
 // @Accept  json
 // @Produce  json
 // @Param   customer  body viewmodels.CustomerCreateViewModel  true  "Add Customer"
+// @Param   Idempotency-Key  header  string  false  "Unique key that makes retries return the original response"
 // @Success 201  {object}  viewmodels.CustomerViewModel  "Successfully created"
-// @Failure 400  {object}  nil  "Bad Request"
+// @Header  201  {string}  ETag  "Strong ETag of the new customer"
+// @Failure 400  {object}  viewmodels.ProblemViewModel  "Bad Request"
+// @Failure 409  {object}  viewmodels.ProblemViewModel  "Email or phone already exists, or a request with the same Idempotency-Key is in progress"
+// @Failure 422  {object}  viewmodels.ProblemViewModel  "Validation failed, or Idempotency-Key reused with a different body"
+// @Security ApiKeyAuth
+// @Security BearerAuth
 // @Router /customers [post]
 func (cc *CustomerController) CreateCustomer(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-
 	var newCustomer viewmodels.CustomerCreateViewModel
 
 	// Decode the request body into newCustomer
 	err := json.NewDecoder(r.Body).Decode(&newCustomer)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeProblem(w, r, http.StatusBadRequest, "Malformed JSON body: "+err.Error())
 		return
 	}
 
 	// Add the new customer to the slice
-	result, err := cc.ICustomerService.Create(newCustomer)
+	result, err := cc.ICustomerService.Create(r.Context(), newCustomer)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
-	}
-
-	if result.ID == uuid.Nil {
-		http.Error(w, "Failed to create the customer", http.StatusBadRequest)
+		writeError(w, r, err)
 		return
 	}
 
 	// Respond to the client
+	w.Header().Set("ETag", versionETag(result.Version))
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated) // HTTP 201
 	json.NewEncoder(w).Encode(result)
 }
 
+// batchPermissions are the permissions the operations of a batch need
+var batchPermissions = map[services.OperationKind]string{
+	services.OperationCreate: "customers.create",
+	services.OperationUpdate: "customers.update",
+	services.OperationDelete: "customers.delete",
+}
+
+// BatchCustomers godoc
+// @Summary Create, update and delete customers in one request
+// @Description perform up to 1000 operations in order. Op is create, update or delete; update and delete take the customer ID and optionally the Version they are based on, create and update take the Customer fields (its ID is ignored). In atomic mode (the default) either every operation is stored or none is, and the operations that were rolled back report 424. In best-effort mode every operation that can be stored is. Each result carries the status the single-customer endpoint would have returned.
+// @Tags customers
+// @Accept  json
+// @Produce  json
+// @Param   batch  body  viewmodels.CustomerBatchViewModel  true  "Operations"
+// @Param   Idempotency-Key  header  string  false  "Unique key that makes retries return the original response"
+// @Success 200  {object}  viewmodels.CustomerBatchResultViewModel  "Per-operation results"
+// @Failure 400  {object}  viewmodels.ProblemViewModel  "Bad Request"
+// @Failure 409  {object}  viewmodels.ProblemViewModel  "A request with the same Idempotency-Key is in progress"
+// @Failure 422  {object}  viewmodels.ProblemViewModel  "Invalid mode or too many operations, or Idempotency-Key reused with a different body"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /customers:batch [post]
+func (cc *CustomerController) BatchCustomers(w http.ResponseWriter, r *http.Request) {
+	var batch viewmodels.CustomerBatchViewModel
+	if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, "Malformed JSON body: "+err.Error())
+		return
+	}
+
+	var atomic bool
+	switch batch.Mode {
+	case "", "atomic":
+		atomic = true
+	case "best-effort":
+	default:
+		writeError(w, r, &services.ValidationError{
+			Errors: validation.Errors{{Field: "Mode", Reason: "must be one of atomic|best-effort"}},
+		})
+		return
+	}
+	if len(batch.Operations) > maxBatchSize {
+		writeError(w, r, &services.ValidationError{
+			Errors: validation.Errors{{Field: "Operations", Reason: "must hold at most " + strconv.Itoa(maxBatchSize) + " operations"}},
+		})
+		return
+	}
+
+	operations := []services.CustomerOperation{}
+	for _, operation := range batch.Operations {
+		// Each operation needs the permission of its own route, so a batch
+		// cannot delete for a role that may not
+		if permission, ok := batchPermissions[services.OperationKind(operation.Op)]; ok && !requirePermission(w, r, permission) {
+			return
+		}
+		operations = append(operations, services.CustomerOperation{
+			Kind:     services.OperationKind(operation.Op),
+			ID:       operation.ID,
+			Version:  operation.Version,
+			Customer: operation.Customer,
+		})
+	}
+
+	results, err := cc.ICustomerService.Batch(r.Context(), operations, atomic)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	response := viewmodels.CustomerBatchResultViewModel{Results: []viewmodels.CustomerOperationResultViewModel{}}
+	for i, result := range results {
+		item := viewmodels.CustomerOperationResultViewModel{Customer: result.Customer}
+		switch {
+		case result.Err != nil:
+			problem := problemFor(r, result.Err)
+			item.Status = problem.Status
+			item.Error = &problem
+		case operations[i].Kind == services.OperationCreate:
+			item.Status = http.StatusCreated
+		case operations[i].Kind == services.OperationDelete:
+			item.Status = http.StatusNoContent
+		default:
+			item.Status = http.StatusOK
+		}
+		if result.Err == nil {
+			response.Applied++
+		}
+		response.Results = append(response.Results, item)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK) // HTTP 200
+	json.NewEncoder(w).Encode(response)
+}
+
 // UpdateCustomer godoc
 // @Summary Update an existing customer
 // @Description update by json customer
@@ -125,17 +538,23 @@ func (cc *CustomerController) CreateCustomer(w http.ResponseWriter, r *http.Requ
 // @Produce  json
 // @Param   id   path      string  true  "Customer ID"
 // @Param   customer  body      viewmodels.CustomerEditViewModel  true  "Update Customer"
+// @Param   If-Match  header    string  false  "ETag the update is based on"
 // @Success 200  {object}  viewmodels.CustomerViewModel  "Successfully updated"
-// @Failure 400  {object}  nil  "Bad Request"
+// @Header  200  {string}  ETag  "Strong ETag of the new version"
+// @Failure 400  {object}  viewmodels.ProblemViewModel  "Bad Request"
+// @Failure 404  {object}  viewmodels.ProblemViewModel  "Customer not found"
+// @Failure 409  {object}  viewmodels.ProblemViewModel  "Email or phone already exists"
+// @Failure 412  {object}  viewmodels.ProblemViewModel  "If-Match does not match"
+// @Failure 422  {object}  viewmodels.ProblemViewModel  "Validation failed"
+// @Security ApiKeyAuth
+// @Security BearerAuth
 // @Router /customers/{id} [put]
 func (cc *CustomerController) UpdateCustomer(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-
 	// Get the ID from the request and convert it to an uuid
 	id, error := uuid.Parse(mux.Vars(r)["id"])
 
 	if error != nil {
-		http.Error(w, "Invalid customer ID", http.StatusBadRequest)
+		writeProblem(w, r, http.StatusBadRequest, "Invalid customer ID")
 		return
 	}
 
@@ -145,62 +564,165 @@ func (cc *CustomerController) UpdateCustomer(w http.ResponseWriter, r *http.Requ
 	err := json.NewDecoder(r.Body).Decode(&updatedCustomer)
 
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeProblem(w, r, http.StatusBadRequest, "Malformed JSON body: "+err.Error())
 		return
 	}
 
-	// Update the customer in the slice
-	result, err := cc.ICustomerService.Update(id, updatedCustomer)
+	version, err := cc.expectedVersion(r, id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, err)
 		return
 	}
 
-	if result.ID == uuid.Nil {
-		http.Error(w, "Failed to update the customer", http.StatusBadRequest)
+	// Update the customer in the slice
+	result, err := cc.ICustomerService.Update(r.Context(), id, version, updatedCustomer)
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
 
 	// Respond to the client
+	w.Header().Set("ETag", versionETag(result.Version))
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK) // HTTP 200
-	json.NewEncoder(w).Encode(updatedCustomer)
+	json.NewEncoder(w).Encode(result)
+}
+
+// PatchCustomer godoc
+// @Summary Partially update a customer
+// @Description apply a JSON Merge Patch (RFC 7396) or a JSON Patch (RFC 6902) to a customer. Members and paths use the field names of CustomerEditViewModel, e.g. {"Contacted": true} or [{"op": "replace", "path": "/Contacted", "value": true}]. The patched customer is validated like PUT.
+// @Tags customers
+// @Accept  application/merge-patch+json,application/json-patch+json
+// @Produce  json
+// @Param   id   path      string  true  "Customer ID"
+// @Param   patch  body      object  true  "Patch document"
+// @Param   If-Match  header    string  false  "ETag the patch is based on"
+// @Success 200  {object}  viewmodels.CustomerViewModel  "Successfully updated"
+// @Header  200  {string}  ETag  "Strong ETag of the new version"
+// @Failure 400  {object}  viewmodels.ProblemViewModel  "Bad Request"
+// @Failure 404  {object}  viewmodels.ProblemViewModel  "Customer not found"
+// @Failure 409  {object}  viewmodels.ProblemViewModel  "Email or phone already exists"
+// @Failure 412  {object}  viewmodels.ProblemViewModel  "If-Match does not match"
+// @Failure 415  {object}  viewmodels.ProblemViewModel  "Unsupported patch format"
+// @Failure 422  {object}  viewmodels.ProblemViewModel  "Invalid patch or validation failed"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /customers/{id} [patch]
+func (cc *CustomerController) PatchCustomer(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, "Invalid customer ID")
+		return
+	}
+
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if contentType != services.MergePatchContentType && contentType != services.JSONPatchContentType {
+		w.Header().Set("Accept-Patch", services.MergePatchContentType+", "+services.JSONPatchContentType)
+		writeProblem(w, r, http.StatusUnsupportedMediaType, "Content-Type must be "+services.MergePatchContentType+" or "+services.JSONPatchContentType)
+		return
+	}
+
+	document, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	version, err := cc.expectedVersion(r, id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	result, err := cc.ICustomerService.Patch(r.Context(), id, version, services.CustomerPatch{ContentType: contentType, Document: document})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", versionETag(result.Version))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(result)
 }
 
 // DeleteCustomer godoc
 // @Summary Delete a customer
-// @Description delete by customer ID
+// @Description move a customer to the trash by ID. It can be restored until it is purged after the retention period.
 // @Tags customers
 // @Accept  json
 // @Produce  json
 // @Param   id   path      string  true  "Customer ID"
+// @Param   If-Match  header    string  false  "ETag the delete is based on"
 // @Success 204  "Successfully deleted"
-// @Failure 400  {object}  nil  "Bad Request"
+// @Failure 400  {object}  viewmodels.ProblemViewModel  "Bad Request"
+// @Failure 404  {object}  viewmodels.ProblemViewModel  "Customer not found"
+// @Failure 412  {object}  viewmodels.ProblemViewModel  "If-Match does not match"
+// @Security ApiKeyAuth
+// @Security BearerAuth
 // @Router /customers/{id} [delete]
 func (cc *CustomerController) DeleteCustomer(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-
 	// Get the ID from the request and convert it to an uuid
 	id, err := uuid.Parse(mux.Vars(r)["id"])
 
 	if err != nil {
-		http.Error(w, "Invalid customer ID", http.StatusBadRequest)
+		writeProblem(w, r, http.StatusBadRequest, "Invalid customer ID")
 		return
 	}
 
-	needDelete := cc.ICustomerService.GetById(id)
-
-	if needDelete == nil {
-		http.Error(w, "Customer not found", http.StatusNotFound)
+	version, err := cc.expectedVersion(r, id)
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
 
-	// Delete the customer from the slice
-	result := cc.ICustomerService.Delete(id)
-	if !result {
-		http.Error(w, "Failed to delete the customer", http.StatusBadRequest)
+	// Move the customer to the trash
+	if err := cc.ICustomerService.Delete(r.Context(), id, version); err != nil {
+		writeError(w, r, err)
 		return
 	}
 
 	// Respond to the client
 	w.WriteHeader(http.StatusNoContent) // HTTP 204
 }
+
+// RestoreCustomer godoc
+// @Summary Restore a deleted customer
+// @Description move a customer out of the trash by ID
+// @Tags customers
+// @Accept  json
+// @Produce  json
+// @Param   id   path      string  true  "Customer ID"
+// @Param   If-Match  header    string  false  "ETag the restore is based on"
+// @Success 200  {object}  viewmodels.CustomerViewModel  "Successfully restored"
+// @Header  200  {string}  ETag  "Strong ETag of the new version"
+// @Failure 400  {object}  viewmodels.ProblemViewModel  "Bad Request"
+// @Failure 404  {object}  viewmodels.ProblemViewModel  "Customer not found in the trash"
+// @Failure 412  {object}  viewmodels.ProblemViewModel  "If-Match does not match"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /customers/{id}/restore [post]
+func (cc *CustomerController) RestoreCustomer(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, "Invalid customer ID")
+		return
+	}
+
+	version, err := cc.expectedVersion(r, id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	result, err := cc.ICustomerService.Restore(r.Context(), id, version)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", versionETag(result.Version))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK) // HTTP 200
+	json.NewEncoder(w).Encode(result)
+}
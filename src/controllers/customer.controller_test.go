@@ -1,23 +1,46 @@
 package controllers
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"encoding/csv"
 	"encoding/json"
+	"encoding/xml"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"congdinh.com/crm/clock"
 	"congdinh.com/crm/models"
+	"congdinh.com/crm/repositories"
 	"congdinh.com/crm/services"
 	viewmodels "congdinh.com/crm/view-models"
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
 )
 
+// newCustomerService creates a service over an in-memory copy of data/customers.json
+func newCustomerService(t *testing.T) *services.CustomerService {
+	t.Helper()
+
+	customers, err := repositories.ReadCustomersFile(repositories.DefaultDataFile())
+	if err != nil {
+		t.Fatalf("Failed to read customers: %s", err.Error())
+	}
+	return services.NewCustomerService(repositories.NewMemoryCustomerRepository(customers))
+}
+
 func TestCustomerController_GetCustomers(t *testing.T) {
 	// Create a new customer service
-	customerService := services.NewCustomerService()
+	customerService := newCustomerService(t)
 	// Create a new customer controller
 	customerController := NewCustomerController(customerService)
 	// Create a new request
@@ -29,7 +52,7 @@ func TestCustomerController_GetCustomers(t *testing.T) {
 	rr := httptest.NewRecorder()
 	// Serve the request
 	router := mux.NewRouter()
-	customerController.RegisterRoutes(router)
+	customerController.RegisterRoutes(router.PathPrefix("/api/v1").Subrouter())
 	router.ServeHTTP(rr, req)
 	// Check the response status code
 	if rr.Code != http.StatusOK {
@@ -38,7 +61,7 @@ func TestCustomerController_GetCustomers(t *testing.T) {
 	// Check the response body
 	expectedResponse := []viewmodels.CustomerViewModel{}
 	json.Unmarshal(rr.Body.Bytes(), &expectedResponse)
-	actualResponse := customerService.GetAll()
+	actualResponse, _ := customerService.GetAll(t.Context())
 	if !reflect.DeepEqual(actualResponse, expectedResponse) {
 		t.Errorf("Expected response body %v, but got %v", expectedResponse, actualResponse)
 	}
@@ -46,7 +69,7 @@ func TestCustomerController_GetCustomers(t *testing.T) {
 
 func TestCustomerController_GetCustomer(t *testing.T) {
 	// Create a new customer service
-	customerService := services.NewCustomerService()
+	customerService := newCustomerService(t)
 	// Create a new customer controller
 	customerController := NewCustomerController(customerService)
 
@@ -59,7 +82,7 @@ func TestCustomerController_GetCustomer(t *testing.T) {
 		Contacted: false,
 	}
 	// Add the new customer to the service
-	result, err := customerService.Create(newCustomer)
+	result, err := customerService.Create(t.Context(), newCustomer)
 
 	if err != nil {
 		t.Errorf("Expected Create to return nil error, but got %s", err.Error())
@@ -81,7 +104,7 @@ func TestCustomerController_GetCustomer(t *testing.T) {
 
 	// Serve the request
 	router := mux.NewRouter()
-	customerController.RegisterRoutes(router)
+	customerController.RegisterRoutes(router.PathPrefix("/api/v1").Subrouter())
 	router.ServeHTTP(rr, req)
 
 	// Check the response status code
@@ -99,7 +122,7 @@ func TestCustomerController_GetCustomer(t *testing.T) {
 	}
 
 	// Check if the customer was created
-	createdCustomer := customerService.GetById(result.ID)
+	createdCustomer, _ := customerService.GetById(t.Context(), result.ID)
 	if createdCustomer == nil {
 		t.Errorf("Expected customer with ID %s to be created, but got nil", result.ID.String())
 	} else {
@@ -117,7 +140,7 @@ func TestCustomerController_GetCustomer(t *testing.T) {
 
 func TestCustomerController_CreateCustomer(t *testing.T) {
 	// Create a new customer service
-	customerService := services.NewCustomerService()
+	customerService := newCustomerService(t)
 	// Create a new customer controller
 	customerController := NewCustomerController(customerService)
 
@@ -142,7 +165,7 @@ func TestCustomerController_CreateCustomer(t *testing.T) {
 
 	// Serve the request
 	router := mux.NewRouter()
-	customerController.RegisterRoutes(router)
+	customerController.RegisterRoutes(router.PathPrefix("/api/v1").Subrouter())
 	router.ServeHTTP(rr, req)
 
 	// Check the response status code
@@ -159,7 +182,7 @@ func TestCustomerController_CreateCustomer(t *testing.T) {
 	}
 
 	// Check if the customer was created
-	createdCustomer := customerService.GetById(actualResponse.ID)
+	createdCustomer, _ := customerService.GetById(t.Context(), actualResponse.ID)
 	if createdCustomer == nil {
 		t.Errorf("Expected customer with ID %s to be created, but got nil", actualResponse.ID.String())
 	} else {
@@ -177,7 +200,7 @@ func TestCustomerController_CreateCustomer(t *testing.T) {
 
 func TestCustomerController_UpdateCustomer(t *testing.T) {
 	// Create a new customer service
-	customerService := services.NewCustomerService()
+	customerService := newCustomerService(t)
 	// Create a new customer controller
 	customerController := NewCustomerController(customerService)
 
@@ -190,7 +213,7 @@ func TestCustomerController_UpdateCustomer(t *testing.T) {
 		Contacted: false,
 	}
 	// Add the new customer to the service
-	result, err := customerService.Create(newCustomer)
+	result, err := customerService.Create(t.Context(), newCustomer)
 
 	if err != nil {
 		t.Errorf("Expected Create to return nil error, but got %s", err.Error())
@@ -206,7 +229,7 @@ func TestCustomerController_UpdateCustomer(t *testing.T) {
 		Name:      "Dinh Van Vinh",
 		Role:      "Product Owner",
 		Email:     "vinhdinh@example.com",
-		Phone:     "0987654321",
+		Phone:     "0987654322",
 		Contacted: true,
 	}
 	reqBody, _ := json.Marshal(updatedCustomer)
@@ -222,7 +245,7 @@ func TestCustomerController_UpdateCustomer(t *testing.T) {
 
 	// Serve the request
 	router := mux.NewRouter()
-	customerController.RegisterRoutes(router)
+	customerController.RegisterRoutes(router.PathPrefix("/api/v1").Subrouter())
 	router.ServeHTTP(rr, req)
 
 	// Check the response status code
@@ -231,7 +254,7 @@ func TestCustomerController_UpdateCustomer(t *testing.T) {
 	}
 
 	// Check if the customer was updated
-	updatedCustomerEntity := *customerService.GetById(updatedCustomer.ID)
+	updatedCustomerEntity, _ := customerService.GetById(t.Context(), updatedCustomer.ID)
 
 	if updatedCustomer.Name != updatedCustomerEntity.Name {
 		t.Errorf("Expected customer with ID %s to have name '%s', but got '%s'", updatedCustomer.ID.String(), updatedCustomer.Name, updatedCustomerEntity.Name)
@@ -246,7 +269,7 @@ func TestCustomerController_UpdateCustomer(t *testing.T) {
 
 func TestCustomerController_DeleteCustomer(t *testing.T) {
 	// Create a new customer service
-	customerService := services.NewCustomerService()
+	customerService := newCustomerService(t)
 
 	// Create a new customer controller
 	customerController := NewCustomerController(customerService)
@@ -268,7 +291,7 @@ func TestCustomerController_DeleteCustomer(t *testing.T) {
 
 	// Serve the request
 	router := mux.NewRouter()
-	customerController.RegisterRoutes(router)
+	customerController.RegisterRoutes(router.PathPrefix("/api/v1").Subrouter())
 	router.ServeHTTP(rr, req)
 
 	// Check the response status code
@@ -277,8 +300,1245 @@ func TestCustomerController_DeleteCustomer(t *testing.T) {
 	}
 
 	// Check if the customer was deleted
-	deletedCustomer := customerService.GetById(existingCustomerId)
+	deletedCustomer, _ := customerService.GetById(t.Context(), existingCustomerId)
 	if deletedCustomer != nil {
 		t.Errorf("Expected customer with ID %s to be deleted, but got customer with ID %d", existingCustomerId.String(), deletedCustomer.ID)
 	}
 }
+
+func TestCustomerController_ConcurrentRequests(t *testing.T) {
+	// Run with -race: every endpoint is hit from many goroutines at once
+	customerService := newCustomerService(t)
+	customerController := NewCustomerController(customerService)
+	router := mux.NewRouter()
+	customerController.RegisterRoutes(router.PathPrefix("/api/v1").Subrouter())
+
+	serve := func(method string, url string, body any) *httptest.ResponseRecorder {
+		var reqBody bytes.Buffer
+		if body != nil {
+			json.NewEncoder(&reqBody).Encode(body)
+		}
+		req := httptest.NewRequest(method, url, &reqBody)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	const workers = 20
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			created := viewmodels.CustomerViewModel{}
+			rr := serve("POST", "/api/v1/customers", viewmodels.CustomerCreateViewModel{
+				Name:  fmt.Sprintf("Worker %d", i),
+				Role:  "Sales",
+				Email: fmt.Sprintf("worker%d@example.com", i),
+				Phone: fmt.Sprintf("555000%04d", i),
+			})
+			if rr.Code != http.StatusCreated {
+				t.Errorf("Expected status code %d for create, but got %d", http.StatusCreated, rr.Code)
+				return
+			}
+			json.Unmarshal(rr.Body.Bytes(), &created)
+
+			if rr := serve("GET", "/api/v1/customers", nil); rr.Code != http.StatusOK {
+				t.Errorf("Expected status code %d for list, but got %d", http.StatusOK, rr.Code)
+			}
+			if rr := serve("GET", "/api/v1/customers/"+created.ID.String(), nil); rr.Code != http.StatusOK {
+				t.Errorf("Expected status code %d for get, but got %d", http.StatusOK, rr.Code)
+			}
+
+			edit := viewmodels.CustomerEditViewModel{
+				ID:        created.ID,
+				Name:      created.Name,
+				Role:      "Developer",
+				Email:     created.Email,
+				Phone:     created.Phone,
+				Contacted: true,
+			}
+			if rr := serve("PUT", "/api/v1/customers/"+created.ID.String(), edit); rr.Code != http.StatusOK {
+				t.Errorf("Expected status code %d for update, but got %d", http.StatusOK, rr.Code)
+			}
+
+			// Even workers delete their customer
+			if i%2 == 0 {
+				if rr := serve("DELETE", "/api/v1/customers/"+created.ID.String(), nil); rr.Code != http.StatusNoContent {
+					t.Errorf("Expected status code %d for delete, but got %d", http.StatusNoContent, rr.Code)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	customers, err := customerService.GetAll(t.Context())
+	if err != nil {
+		t.Fatalf("Expected GetAll to return nil error, but got %s", err.Error())
+	}
+	if len(customers) != 5+workers/2 {
+		t.Errorf("Expected %d customers, but got %d", 5+workers/2, len(customers))
+	}
+	for _, customer := range customers {
+		if customer.Role == "Sales" {
+			t.Errorf("Expected customer %s to have been updated", customer.ID.String())
+		}
+	}
+}
+
+func TestCustomerController_GetCustomersPagination(t *testing.T) {
+	customerService := newCustomerService(t)
+	customerController := NewCustomerController(customerService)
+	router := mux.NewRouter()
+	customerController.RegisterRoutes(router.PathPrefix("/api/v1").Subrouter())
+
+	req := httptest.NewRequest("GET", "/api/v1/customers?limit=2&sort=-name&role=developer", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, but got %d", http.StatusOK, rr.Code)
+	}
+	if rr.Header().Get("X-Total-Count") != "2" {
+		t.Errorf("Expected X-Total-Count 2, but got '%s'", rr.Header().Get("X-Total-Count"))
+	}
+
+	firstPage := []viewmodels.CustomerViewModel{}
+	json.Unmarshal(rr.Body.Bytes(), &firstPage)
+	if len(firstPage) != 2 || firstPage[0].Name != "Cong Dinh" || firstPage[1].Name != "An Dinh" {
+		t.Errorf("Expected Cong Dinh and An Dinh, but got %v", firstPage)
+	}
+	if link := rr.Header().Get("Link"); link != "" {
+		t.Errorf("Expected no Link header on the last page, but got '%s'", link)
+	}
+
+	// Walk all customers one page at a time by following rel="next"
+	next := "/api/v1/customers?limit=2"
+	names := []string{}
+	for next != "" {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", next, nil))
+		page := []viewmodels.CustomerViewModel{}
+		json.Unmarshal(rr.Body.Bytes(), &page)
+		for _, customer := range page {
+			names = append(names, customer.Name)
+		}
+
+		next = ""
+		link := rr.Header().Get("Link")
+		if start, end := strings.Index(link, "<"), strings.Index(link, `>; rel="next"`); start >= 0 && end > start {
+			next = link[start+1 : end]
+		}
+	}
+	if len(names) != 5 {
+		t.Errorf("Expected to walk 5 customers, but got %v", names)
+	}
+}
+
+func TestCustomerController_GetCustomersInvalidQuery(t *testing.T) {
+	customerController := NewCustomerController(newCustomerService(t))
+	router := mux.NewRouter()
+	customerController.RegisterRoutes(router.PathPrefix("/api/v1").Subrouter())
+
+	for _, query := range []string{"limit=0", "limit=abc", "offset=-1", "sort=password", "contacted=maybe", "cursor=bogus", "cursor=x&offset=1", "updated_since=yesterday"} {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/v1/customers?"+query, nil))
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status code %d for %s, but got %d", http.StatusBadRequest, query, rr.Code)
+		}
+	}
+}
+
+func TestCustomerController_SearchCustomers(t *testing.T) {
+	customerController := NewCustomerController(newCustomerService(t))
+	router := mux.NewRouter()
+	customerController.RegisterRoutes(router.PathPrefix("/api/v1").Subrouter())
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/v1/customers/search?q=dinh", nil))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, but got %d", http.StatusOK, rr.Code)
+	}
+	results := []viewmodels.CustomerSearchResultViewModel{}
+	json.Unmarshal(rr.Body.Bytes(), &results)
+	if len(results) != 3 {
+		t.Errorf("Expected 3 customers named Dinh, but got %d", len(results))
+	}
+	for _, result := range results {
+		if !strings.Contains(result.Highlights["Name"], "<em>Dinh</em>") {
+			t.Errorf("Expected Dinh to be highlighted, but got '%s'", result.Highlights["Name"])
+		}
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/v1/customers/search", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d without q, but got %d", http.StatusBadRequest, rr.Code)
+	}
+}
+
+func TestCustomerController_CreateCustomerValidation(t *testing.T) {
+	customerController := NewCustomerController(newCustomerService(t))
+	router := mux.NewRouter()
+	customerController.RegisterRoutes(router.PathPrefix("/api/v1").Subrouter())
+
+	invalidCustomer := viewmodels.CustomerCreateViewModel{
+		Name:  "",
+		Role:  "Developer",
+		Email: "vinhdinh@",
+		Phone: "123",
+	}
+	reqBody, _ := json.Marshal(invalidCustomer)
+	req := httptest.NewRequest("POST", "/api/v1/customers", bytes.NewBuffer(reqBody))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("Expected status code %d, but got %d", http.StatusUnprocessableEntity, rr.Code)
+	}
+
+	if contentType := rr.Header().Get("Content-Type"); contentType != "application/problem+json" {
+		t.Errorf("Expected Content-Type application/problem+json, but got %s", contentType)
+	}
+	actualResponse := viewmodels.ProblemViewModel{}
+	json.Unmarshal(rr.Body.Bytes(), &actualResponse)
+	if actualResponse.Status != http.StatusUnprocessableEntity || actualResponse.Type != "/problems/validation" {
+		t.Errorf("Expected a validation problem, but got %+v", actualResponse)
+	}
+
+	fields := []string{}
+	for _, fieldError := range actualResponse.Errors {
+		if fieldError.Reason == "" {
+			t.Errorf("Expected a reason for field %s", fieldError.Field)
+		}
+		fields = append(fields, fieldError.Field)
+	}
+	if !reflect.DeepEqual(fields, []string{"Name", "Email", "Phone"}) {
+		t.Errorf("Expected Name, Email and Phone to fail validation, but got %v", fields)
+	}
+}
+
+func TestCustomerController_UpdateCustomerValidation(t *testing.T) {
+	customerController := NewCustomerController(newCustomerService(t))
+	router := mux.NewRouter()
+	customerController.RegisterRoutes(router.PathPrefix("/api/v1").Subrouter())
+
+	id := "4405071c-2adc-499d-966f-3cfdfa1deedc"
+	updatedCustomer := viewmodels.CustomerEditViewModel{
+		ID:    uuid.MustParse(id),
+		Name:  "Cong Dinh",
+		Role:  "Developer",
+		Email: "congdinh@example.com",
+		Phone: "+84 987 654 321 000 000",
+	}
+	reqBody, _ := json.Marshal(updatedCustomer)
+	req := httptest.NewRequest("PUT", "/api/v1/customers/"+id, bytes.NewBuffer(reqBody))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("Expected status code %d, but got %d", http.StatusUnprocessableEntity, rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), `"field":"Phone"`) {
+		t.Errorf("Expected the response to report the Phone field, but got %s", rr.Body.String())
+	}
+}
+
+func TestCustomerController_ProblemResponses(t *testing.T) {
+	customerController := NewCustomerController(newCustomerService(t))
+	router := mux.NewRouter()
+	customerController.RegisterRoutes(router.PathPrefix("/api/v1").Subrouter())
+
+	unknownId := "00000000-0000-0000-0000-000000000001"
+	tests := []struct {
+		name        string
+		method      string
+		url         string
+		body        string
+		status      int
+		problemType string
+	}{
+		{"invalid id", "GET", "/api/v1/customers/not-a-uuid", "", http.StatusBadRequest, "about:blank"},
+		{"get unknown", "GET", "/api/v1/customers/" + unknownId, "", http.StatusNotFound, "/problems/not-found"},
+		{"delete unknown", "DELETE", "/api/v1/customers/" + unknownId, "", http.StatusNotFound, "/problems/not-found"},
+		{"update unknown", "PUT", "/api/v1/customers/" + unknownId,
+			`{"Name":"Nobody","Role":"Sales","Email":"nobody@example.com","Phone":"0900000999"}`,
+			http.StatusNotFound, "/problems/not-found"},
+		{"duplicate email", "POST", "/api/v1/customers",
+			`{"Name":"Copy","Role":"Developer","Email":"cong@domain.com","Phone":"0900000999"}`,
+			http.StatusConflict, "/problems/conflict"},
+		{"malformed body", "POST", "/api/v1/customers", `{"Name":`, http.StatusBadRequest, "about:blank"},
+		{"invalid cursor", "GET", "/api/v1/customers?cursor=bogus", "", http.StatusBadRequest, "about:blank"},
+		{"method not allowed", "POST", "/api/v1/customers/" + unknownId, "", http.StatusMethodNotAllowed, "about:blank"},
+		{"unknown route", "GET", "/api/v1/customers/" + unknownId + "/orders", "", http.StatusNotFound, "about:blank"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(test.method, test.url, strings.NewReader(test.body))
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			if rr.Code != test.status {
+				t.Fatalf("Expected status code %d, but got %d", test.status, rr.Code)
+			}
+			if contentType := rr.Header().Get("Content-Type"); contentType != "application/problem+json" {
+				t.Errorf("Expected Content-Type application/problem+json, but got %s", contentType)
+			}
+
+			problem := viewmodels.ProblemViewModel{}
+			if err := json.Unmarshal(rr.Body.Bytes(), &problem); err != nil {
+				t.Fatalf("Expected a JSON problem body, but got %s", rr.Body.String())
+			}
+			if problem.Type != test.problemType {
+				t.Errorf("Expected type %s, but got %s", test.problemType, problem.Type)
+			}
+			if problem.Status != test.status {
+				t.Errorf("Expected status %d in the body, but got %d", test.status, problem.Status)
+			}
+			if problem.Title == "" {
+				t.Error("Expected the problem to have a title")
+			}
+			if problem.Instance != req.URL.Path {
+				t.Errorf("Expected instance %s, but got %s", req.URL.Path, problem.Instance)
+			}
+		})
+	}
+}
+
+func TestCustomerController_PatchCustomer(t *testing.T) {
+	customerService := newCustomerService(t)
+	customerController := NewCustomerController(customerService)
+	router := mux.NewRouter()
+	customerController.RegisterRoutes(router.PathPrefix("/api/v1").Subrouter())
+
+	id := "4405071c-2adc-499d-966f-3cfdfa1deedc"
+	patch := func(contentType string, document string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PATCH", "/api/v1/customers/"+id, strings.NewReader(document))
+		req.Header.Set("Content-Type", contentType)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := patch("application/merge-patch+json; charset=utf-8", `{"Contacted": true}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, but got %d", http.StatusOK, rr.Code)
+	}
+	actualResponse := viewmodels.CustomerViewModel{}
+	json.Unmarshal(rr.Body.Bytes(), &actualResponse)
+	if !actualResponse.Contacted || actualResponse.Name != "Cong Dinh" {
+		t.Errorf("Expected only Contacted to change, but got %v", actualResponse)
+	}
+
+	rr = patch("application/json-patch+json", `[{"op": "replace", "path": "/Role", "value": "Manager"}]`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, but got %d", http.StatusOK, rr.Code)
+	}
+	customer, _ := customerService.GetById(t.Context(), uuid.MustParse(id))
+	if customer.Role != "Manager" || !customer.Contacted {
+		t.Errorf("Expected both patches to be applied, but got %v", *customer)
+	}
+
+	rr = patch("application/json", `{"Contacted": false}`)
+	if rr.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("Expected status code %d, but got %d", http.StatusUnsupportedMediaType, rr.Code)
+	}
+	if accept := rr.Header().Get("Accept-Patch"); !strings.Contains(accept, "application/merge-patch+json") {
+		t.Errorf("Expected an Accept-Patch header, but got %q", accept)
+	}
+
+	rr = patch("application/json-patch+json", `[{"op": "test", "path": "/Role", "value": "Developer"}]`)
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected status code %d, but got %d", http.StatusUnprocessableEntity, rr.Code)
+	}
+
+	rr = patch("application/merge-patch+json", `{"Email": "not-an-email"}`)
+	if rr.Code != http.StatusUnprocessableEntity || !strings.Contains(rr.Body.String(), `"field":"Email"`) {
+		t.Errorf("Expected a validation problem for Email, but got %d %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestCustomerController_ETags(t *testing.T) {
+	customerController := NewCustomerController(newCustomerService(t))
+	router := mux.NewRouter()
+	customerController.RegisterRoutes(router.PathPrefix("/api/v1").Subrouter())
+
+	url := "/api/v1/customers/4405071c-2adc-499d-966f-3cfdfa1deedc"
+	serve := func(method string, body string, header string, value string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		if method == "PATCH" {
+			req.Header.Set("Content-Type", "application/merge-patch+json")
+		}
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := serve("GET", "", "", "")
+	etag := rr.Header().Get("ETag")
+	if !strings.HasPrefix(etag, `W/"`) {
+		t.Fatalf("Expected a weak ETag, but got %q", etag)
+	}
+	if rr := serve("GET", "", "If-None-Match", etag); rr.Code != http.StatusNotModified || rr.Body.Len() != 0 {
+		t.Errorf("Expected status code %d without a body, but got %d", http.StatusNotModified, rr.Code)
+	}
+	if rr := serve("GET", "", "If-None-Match", `"0", `+strings.TrimPrefix(etag, "W/")); rr.Code != http.StatusNotModified {
+		t.Errorf("Expected If-None-Match to use the weak comparison and return %d, but got %d", http.StatusNotModified, rr.Code)
+	}
+	// The version ETag is for If-Match only, and each representation has its own ETag
+	if rr := serve("GET", "", "If-None-Match", `"1"`); rr.Code != http.StatusOK {
+		t.Errorf("Expected the version ETag not to match the representation, but got %d", rr.Code)
+	}
+	if csv := serve("GET", "", "Accept", "text/csv").Header().Get("ETag"); csv == etag {
+		t.Errorf("Expected JSON and CSV to have different ETags, but both got %q", etag)
+	}
+
+	update := `{"Name":"Cong Dinh","Role":"Developer","Email":"cong@domain.com","Phone":"1234567890","Contacted":true}`
+	rr = serve("PUT", update, "If-Match", `"1"`)
+	if rr.Code != http.StatusOK || rr.Header().Get("ETag") != `"2"` {
+		t.Fatalf("Expected status code %d with ETag %q, but got %d %q", http.StatusOK, `"2"`, rr.Code, rr.Header().Get("ETag"))
+	}
+
+	stale := []struct {
+		method string
+		body   string
+		value  string
+	}{
+		{"PUT", update, `"1"`},
+		{"PATCH", `{"Role":"Manager"}`, `"1"`},
+		{"PATCH", `{"Role":"Manager"}`, `W/"2"`},
+		{"DELETE", "", `"1", "3"`},
+	}
+	for _, test := range stale {
+		rr := serve(test.method, test.body, "If-Match", test.value)
+		if rr.Code != http.StatusPreconditionFailed {
+			t.Errorf("Expected %s with If-Match %s to return %d, but got %d", test.method, test.value, http.StatusPreconditionFailed, rr.Code)
+		}
+		if !strings.Contains(rr.Body.String(), "/problems/precondition-failed") {
+			t.Errorf("Expected a precondition-failed problem, but got %s", rr.Body.String())
+		}
+	}
+
+	rr = serve("PATCH", `{"Role":"Manager"}`, "If-Match", "*")
+	if rr.Code != http.StatusOK || rr.Header().Get("ETag") != `"3"` {
+		t.Errorf("Expected If-Match * to update to ETag %q, but got %d %q", `"3"`, rr.Code, rr.Header().Get("ETag"))
+	}
+	if rr := serve("DELETE", "", "If-Match", `"1", "3"`); rr.Code != http.StatusNoContent {
+		t.Errorf("Expected DELETE with a matching If-Match to return %d, but got %d", http.StatusNoContent, rr.Code)
+	}
+}
+
+func TestCustomerController_GetCustomersNotModified(t *testing.T) {
+	customerController := NewCustomerController(newCustomerService(t))
+	router := mux.NewRouter()
+	customerController.RegisterRoutes(router.PathPrefix("/api/v1").Subrouter())
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/v1/customers?limit=2", nil))
+	etag := rr.Header().Get("ETag")
+	if !strings.HasPrefix(etag, `W/"`) {
+		t.Fatalf("Expected a weak ETag, but got %q", etag)
+	}
+
+	req := httptest.NewRequest("GET", "/api/v1/customers?limit=2", nil)
+	req.Header.Set("If-None-Match", etag)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotModified {
+		t.Errorf("Expected status code %d, but got %d", http.StatusNotModified, rr.Code)
+	}
+
+	req = httptest.NewRequest("GET", "/api/v1/customers?limit=3", nil)
+	req.Header.Set("If-None-Match", etag)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected a different page to return %d, but got %d", http.StatusOK, rr.Code)
+	}
+}
+
+func TestCustomerController_CreateCustomerIdempotencyKey(t *testing.T) {
+	customerService := newCustomerService(t)
+	customerController := NewCustomerController(customerService)
+	router := mux.NewRouter()
+	customerController.RegisterRoutes(router.PathPrefix("/api/v1").Subrouter())
+
+	post := func(key string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/v1/customers", strings.NewReader(body))
+		req.Header.Set("Idempotency-Key", key)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	body := `{"Name":"Retry Customer","Role":"Sales","Email":"retry@example.com","Phone":"0900000777"}`
+	first := post("key-1", body)
+	if first.Code != http.StatusCreated {
+		t.Fatalf("Expected status code %d, but got %d", http.StatusCreated, first.Code)
+	}
+
+	// A retry may re-serialize the same document
+	retry := post("key-1", `{"Phone":"0900000777", "Email":"retry@example.com", "Role":"Sales", "Name":"Retry Customer"}`)
+	if retry.Code != http.StatusCreated {
+		t.Errorf("Expected the retry to replay status code %d, but got %d", http.StatusCreated, retry.Code)
+	}
+	if retry.Body.String() != first.Body.String() {
+		t.Errorf("Expected the retry to replay %s, but got %s", first.Body.String(), retry.Body.String())
+	}
+	if retry.Header().Get("Idempotent-Replayed") != "true" || retry.Header().Get("ETag") != first.Header().Get("ETag") {
+		t.Errorf("Expected the replayed headers, but got %v", retry.Header())
+	}
+
+	customers, _ := customerService.GetAll(t.Context())
+	if len(customers) != 6 {
+		t.Errorf("Expected one customer to be created, but got %d customers", len(customers))
+	}
+
+	reused := post("key-1", `{"Name":"Other Customer","Role":"Sales","Email":"other@example.com","Phone":"0900000888"}`)
+	if reused.Code != http.StatusUnprocessableEntity || !strings.Contains(reused.Body.String(), "/problems/idempotency-key") {
+		t.Errorf("Expected key reuse with another body to return %d, but got %d %s", http.StatusUnprocessableEntity, reused.Code, reused.Body.String())
+	}
+
+	// Without a key the same body is a duplicate
+	req := httptest.NewRequest("POST", "/api/v1/customers", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusConflict {
+		t.Errorf("Expected status code %d without a key, but got %d", http.StatusConflict, rr.Code)
+	}
+
+	if rr := post(strings.Repeat("k", 256), body); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected an overlong key to return %d, but got %d", http.StatusBadRequest, rr.Code)
+	}
+}
+
+func TestCustomerController_CreateCustomerConcurrentRetries(t *testing.T) {
+	customerService := newCustomerService(t)
+	customerController := NewCustomerController(customerService)
+	router := mux.NewRouter()
+	customerController.RegisterRoutes(router.PathPrefix("/api/v1").Subrouter())
+
+	body := `{"Name":"Burst Customer","Role":"Sales","Email":"burst@example.com","Phone":"0900000999"}`
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest("POST", "/api/v1/customers", strings.NewReader(body))
+			req.Header.Set("Idempotency-Key", "burst")
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+			if rr.Code != http.StatusCreated && rr.Code != http.StatusConflict {
+				t.Errorf("Expected status code %d or %d, but got %d", http.StatusCreated, http.StatusConflict, rr.Code)
+			}
+		}()
+	}
+	wg.Wait()
+
+	customers, _ := customerService.GetAll(t.Context())
+	if len(customers) != 6 {
+		t.Errorf("Expected exactly one customer to be created, but got %d customers", len(customers))
+	}
+}
+
+func TestCustomerController_BatchCustomers(t *testing.T) {
+	customerService := newCustomerService(t)
+	router := mux.NewRouter()
+	NewCustomerController(customerService).RegisterRoutes(router.PathPrefix("/api/v1").Subrouter())
+
+	batch := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/v1/customers:batch", strings.NewReader(body))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	statuses := func(rr *httptest.ResponseRecorder) (viewmodels.CustomerBatchResultViewModel, []int) {
+		var response viewmodels.CustomerBatchResultViewModel
+		if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+			t.Fatalf("Failed to decode response: %s", err.Error())
+		}
+		codes := []int{}
+		for _, result := range response.Results {
+			codes = append(codes, result.Status)
+		}
+		return response, codes
+	}
+
+	rr := batch(`{"Mode":"best-effort","Operations":[
+		{"Op":"create","Customer":{"Name":"Batch","Role":"Sales","Email":"batch@example.com","Phone":"0900000222"}},
+		{"Op":"create","Customer":{"Name":"Batch","Role":"Sales","Email":"invalid","Phone":"0900000333"}},
+		{"Op":"update","ID":"4405071c-2adc-499d-966f-3cfdfa1deedc","Version":1,"Customer":{"Name":"Cong Dinh","Role":"Developer","Email":"cong@domain.com","Phone":"1234567890","Contacted":true}},
+		{"Op":"delete","ID":"4405071c-2adc-499d-966f-3cfdfa1deedc","Version":1},
+		{"Op":"delete","ID":"00000000-0000-0000-0000-000000000001"}
+	]}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, but got %d %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	response, codes := statuses(rr)
+	expected := []int{http.StatusCreated, http.StatusUnprocessableEntity, http.StatusOK, http.StatusPreconditionFailed, http.StatusNotFound}
+	if !reflect.DeepEqual(codes, expected) {
+		t.Errorf("Expected statuses %v, but got %v", expected, codes)
+	}
+	if response.Applied != 2 || response.Results[0].Customer == nil || response.Results[2].Customer.Version != 2 {
+		t.Errorf("Expected two applied operations with their customers, but got %+v", response)
+	}
+	if problem := response.Results[1].Error; problem == nil || problem.Type != "/problems/validation" || problem.Errors[0].Field != "Email" {
+		t.Errorf("Expected a validation problem for the invalid create, but got %+v", problem)
+	}
+
+	// An update chained on a failed one reports the operation it depends on
+	rr = batch(`{"Mode":"best-effort","Operations":[
+		{"Op":"update","ID":"4405071c-2adc-499d-966f-3cfdfa1deedc","Customer":{"Name":"Cong Dinh","Role":"Developer","Email":"van@domain.com","Phone":"1234567890"}},
+		{"Op":"update","ID":"4405071c-2adc-499d-966f-3cfdfa1deedc","Customer":{"Name":"Cong Dinh","Role":"Developer","Email":"cong@domain.com","Phone":"1234567890"}}
+	]}`)
+	response, codes = statuses(rr)
+	if expected := []int{http.StatusConflict, http.StatusFailedDependency}; !reflect.DeepEqual(codes, expected) {
+		t.Errorf("Expected statuses %v, but got %v", expected, codes)
+	}
+	if problem := response.Results[1].Error; problem == nil || problem.Type != "/problems/dependency-failed" || problem.Detail != "operation 0 on the same customer failed" {
+		t.Errorf("Expected a dependency problem naming operation 0, but got %+v", problem)
+	}
+
+	// Atomic is the default mode
+	rr = batch(`{"Operations":[
+		{"Op":"create","Customer":{"Name":"Atomic","Role":"Sales","Email":"atomic@example.com","Phone":"0900000444"}},
+		{"Op":"delete","ID":"00000000-0000-0000-0000-000000000001"}
+	]}`)
+	response, codes = statuses(rr)
+	if expected := []int{http.StatusFailedDependency, http.StatusNotFound}; !reflect.DeepEqual(codes, expected) || response.Applied != 0 {
+		t.Errorf("Expected statuses %v and nothing applied, but got %v (%d applied)", expected, codes, response.Applied)
+	}
+	if matches, _ := customerService.Search(t.Context(), "atomic@example.com", 1); len(matches) != 0 {
+		t.Errorf("Expected the rolled back customer to be absent, but got %v", matches)
+	}
+
+	for _, body := range []string{`{"Mode":"sometimes","Operations":[]}`, `{"Operations":[` + strings.Repeat(`{"Op":"delete"},`, maxBatchSize) + `{"Op":"delete"}]}`} {
+		if rr := batch(body); rr.Code != http.StatusUnprocessableEntity {
+			t.Errorf("Expected status code %d, but got %d", http.StatusUnprocessableEntity, rr.Code)
+		}
+	}
+	if rr := batch(`[`); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d for malformed JSON, but got %d", http.StatusBadRequest, rr.Code)
+	}
+}
+
+func TestCustomerController_ExportCustomers(t *testing.T) {
+	router := mux.NewRouter()
+	NewCustomerController(newCustomerService(t)).RegisterRoutes(router.PathPrefix("/api/v1").Subrouter())
+
+	req := httptest.NewRequest("GET", "/api/v1/customers/export.csv?role=Developer&sort=name", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK || !strings.HasPrefix(rr.Header().Get("Content-Type"), "text/csv") {
+		t.Fatalf("Expected a CSV with status code %d, but got %d %s", http.StatusOK, rr.Code, rr.Header().Get("Content-Type"))
+	}
+	records, err := csv.NewReader(rr.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("Failed to read CSV: %s", err.Error())
+	}
+	if !reflect.DeepEqual(records[0], csvColumns) {
+		t.Errorf("Expected the header %v, but got %v", csvColumns, records[0])
+	}
+	for _, record := range records[1:] {
+		if record[2] != "Developer" {
+			t.Errorf("Expected only developers, but got %v", record)
+		}
+	}
+	expected := []string{"4405071c-2adc-499d-966f-3cfdfa1deedc", "Cong Dinh", "Developer", "cong@domain.com", "1234567890", "false", "1", "", "", "", ""}
+	if !slices.ContainsFunc(records, func(record []string) bool { return slices.Equal(record, expected) }) {
+		t.Errorf("Expected the row %v, but got %v", expected, records)
+	}
+
+	req = httptest.NewRequest("GET", "/api/v1/customers/export.csv?sort=unknown", nil)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, but got %d", http.StatusBadRequest, rr.Code)
+	}
+}
+
+// deletingCustomerService deletes the customers of every page it returns,
+// like a writer racing an export
+type deletingCustomerService struct {
+	services.ICustomerService
+}
+
+func (s deletingCustomerService) Query(ctx context.Context, options services.CustomerQueryOptions) (viewmodels.CustomerPageViewModel, error) {
+	page, err := s.ICustomerService.Query(ctx, options)
+	for _, customer := range page.Items {
+		s.Delete(ctx, customer.ID, 0)
+	}
+	return page, err
+}
+
+func TestCustomerController_ExportCustomersStreamsEveryPage(t *testing.T) {
+	customers := []models.Customer{}
+	for i := range 2*maxPageSize + 1 {
+		customers = append(customers, models.Customer{
+			ID:    uuid.New(),
+			Name:  fmt.Sprintf("Customer %d", i),
+			Email: fmt.Sprintf("customer%d@example.com", i),
+			Phone: fmt.Sprintf("09%08d", i),
+		})
+	}
+	router := mux.NewRouter()
+	customerService := services.NewCustomerService(repositories.NewMemoryCustomerRepository(customers))
+	NewCustomerController(deletingCustomerService{customerService}).RegisterRoutes(router.PathPrefix("/api/v1").Subrouter())
+
+	// Deleting the exported pages would make offsets skip a page
+	req := httptest.NewRequest("GET", "/api/v1/customers/export.csv", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	records, err := csv.NewReader(rr.Body).ReadAll()
+	if err != nil || len(records) != len(customers)+1 {
+		t.Fatalf("Expected %d rows, but got %d (%v)", len(customers)+1, len(records), err)
+	}
+	ids := map[string]bool{}
+	for _, record := range records[1:] {
+		ids[record[0]] = true
+	}
+	if len(ids) != len(customers) {
+		t.Errorf("Expected every customer once, but got %d distinct of %d rows", len(ids), len(records)-1)
+	}
+}
+
+func TestCustomerController_ImportCustomers(t *testing.T) {
+	customerService := newCustomerService(t)
+	router := mux.NewRouter()
+	NewCustomerController(customerService).RegisterRoutes(router.PathPrefix("/api/v1").Subrouter())
+
+	importCSV := func(query string, body string) (*httptest.ResponseRecorder, viewmodels.CustomerImportResultViewModel) {
+		req := httptest.NewRequest("POST", "/api/v1/customers/import"+query, strings.NewReader(body))
+		req.Header.Set("Content-Type", "text/csv")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		var response viewmodels.CustomerImportResultViewModel
+		if rr.Code == http.StatusOK {
+			json.NewDecoder(bytes.NewReader(rr.Body.Bytes())).Decode(&response)
+		}
+		return rr, response
+	}
+
+	file := "\ufeffFull Name,ROLE,E-mail,Phone,Contacted,Notes\n" +
+		"Ana,Sales,ana@example.com,0900000111,yes,first\n" +
+		"Ana Again,Sales,ana@example.com,0900000222,false,valid once Ana is skipped\n" +
+		"Van Again,Sales,other@example.com,0987654321,,stored phone\n" +
+		"Bao,Sales,bao@example.com,0900000333,true,\n"
+	mapping := "?map=Full+Name:Name&map=E-mail:Email"
+
+	rr, response := importCSV(mapping+"&dry_run=true", file)
+	if rr.Code != http.StatusOK || !response.DryRun || response.Created != 2 || response.Failed != 2 {
+		t.Fatalf("Expected a dry run with 2 created and 2 failed rows, but got %d %s", rr.Code, rr.Body.String())
+	}
+	lines, statuses := []int{}, []int{}
+	for _, row := range response.Rows {
+		lines = append(lines, row.Line)
+		statuses = append(statuses, row.Status)
+	}
+	if expected := []int{2, 3, 4, 5}; !reflect.DeepEqual(lines, expected) {
+		t.Errorf("Expected lines %v, but got %v", expected, lines)
+	}
+	if expected := []int{http.StatusUnprocessableEntity, http.StatusCreated, http.StatusConflict, http.StatusCreated}; !reflect.DeepEqual(statuses, expected) {
+		t.Errorf("Expected statuses %v, but got %v", expected, statuses)
+	}
+	if field := response.Rows[0].Error.Errors[0].Field; field != "Contacted" {
+		t.Errorf("Expected the first row to fail on Contacted, but got %s", field)
+	}
+	if customers, _ := customerService.GetAll(t.Context()); len(customers) != 5 {
+		t.Errorf("Expected a dry run to store nothing, but got %d customers", len(customers))
+	}
+
+	_, response = importCSV(mapping, file)
+	if response.DryRun || response.Created != 2 || response.Rows[3].Customer == nil || response.Rows[3].Customer.Email != "bao@example.com" {
+		t.Errorf("Expected the import to create 2 customers, but got %+v", response)
+	}
+	if customers, _ := customerService.GetAll(t.Context()); len(customers) != 7 {
+		t.Errorf("Expected 7 customers after the import, but got %d", len(customers))
+	}
+
+	tests := []struct {
+		name        string
+		query       string
+		contentType string
+		body        string
+		status      int
+	}{
+		{"not CSV", "", "application/json", file, http.StatusUnsupportedMediaType},
+		{"unknown field", "?map=Notes:Comment", "text/csv", file, http.StatusUnprocessableEntity},
+		{"mapping without column", "?map=Email", "text/csv", file, http.StatusUnprocessableEntity},
+		{"invalid dry_run", "?dry_run=maybe", "text/csv", file, http.StatusBadRequest},
+		{"empty file", "", "text/csv", "", http.StatusUnprocessableEntity},
+		{"malformed", "", "text/csv", "Name,Email\n\"unterminated,x\n", http.StatusBadRequest},
+	}
+	for _, tc := range tests {
+		req := httptest.NewRequest("POST", "/api/v1/customers/import"+tc.query, strings.NewReader(tc.body))
+		req.Header.Set("Content-Type", tc.contentType)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != tc.status {
+			t.Errorf("%s: Expected status code %d, but got %d %s", tc.name, tc.status, rr.Code, rr.Body.String())
+		}
+	}
+}
+
+func TestCustomerController_ContentNegotiation(t *testing.T) {
+	router := mux.NewRouter()
+	NewCustomerController(newCustomerService(t)).RegisterRoutes(router.PathPrefix("/api/v1").Subrouter())
+
+	get := func(path string, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	tests := []struct {
+		accept      string
+		contentType string
+	}{
+		{"", "application/json"},
+		{"*/*", "application/json"},
+		{"application/x-ndjson", "application/x-ndjson"},
+		{"application/ndjson", "application/x-ndjson"},
+		{"text/*", "text/csv; charset=utf-8"},
+		{"text/html, application/xml;q=0.9, */*;q=0.1", "application/xml; charset=utf-8"},
+		{"application/json;q=0.5, text/csv", "text/csv; charset=utf-8"},
+		{"application/json;q=0, */*", "application/x-ndjson"},
+	}
+	for _, tc := range tests {
+		rr := get("/api/v1/customers?sort=name", tc.accept)
+		if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != tc.contentType {
+			t.Errorf("Accept %q: Expected %s, but got %d %s", tc.accept, tc.contentType, rr.Code, rr.Header().Get("Content-Type"))
+		}
+		if rr.Header().Get("Vary") != varyHeader {
+			t.Errorf("Accept %q: Expected Vary: %s, but got %q", tc.accept, varyHeader, rr.Header().Get("Vary"))
+		}
+	}
+
+	lines := strings.Split(strings.TrimSpace(get("/api/v1/customers?sort=name", "application/x-ndjson").Body.String()), "\n")
+	var first viewmodels.CustomerViewModel
+	if len(lines) != 5 || json.Unmarshal([]byte(lines[0]), &first) != nil || first.Name != "An Dinh" {
+		t.Errorf("Expected 5 NDJSON lines starting with An Dinh, but got %v", lines)
+	}
+
+	records, err := csv.NewReader(get("/api/v1/customers", "text/csv").Body).ReadAll()
+	if err != nil || len(records) != 6 || !reflect.DeepEqual(records[0], csvColumns) {
+		t.Errorf("Expected a CSV header and 5 rows, but got %v (%v)", records, err)
+	}
+
+	var list struct {
+		Customers []struct {
+			ID   string
+			Name string
+		} `xml:"Customer"`
+	}
+	if err := xml.Unmarshal(get("/api/v1/customers?sort=name", "application/xml").Body.Bytes(), &list); err != nil || len(list.Customers) != 5 || list.Customers[0].ID != "80fa51f8-0f4d-4662-b2a1-3ba45bacb2fa" {
+		t.Errorf("Expected 5 XML customers starting with An Dinh, but got %+v (%v)", list, err)
+	}
+
+	rr := get("/api/v1/customers/4405071c-2adc-499d-966f-3cfdfa1deedc", "application/xml")
+	var customer struct {
+		XMLName xml.Name
+		Email   string
+		Version int64
+	}
+	if err := xml.Unmarshal(rr.Body.Bytes(), &customer); err != nil || customer.XMLName.Local != "Customer" || customer.Email != "cong@domain.com" || customer.Version != 1 {
+		t.Errorf("Expected the customer as XML, but got %s (%v)", rr.Body.String(), err)
+	}
+	rr = get("/api/v1/customers/4405071c-2adc-499d-966f-3cfdfa1deedc", "text/csv")
+	if records, _ := csv.NewReader(rr.Body).ReadAll(); len(records) != 2 || records[1][1] != "Cong Dinh" {
+		t.Errorf("Expected the customer as CSV, but got %v", records)
+	}
+
+	// Each representation of a page has its own ETag
+	if get("/api/v1/customers", "application/json").Header().Get("ETag") == get("/api/v1/customers", "text/csv").Header().Get("ETag") {
+		t.Error("Expected JSON and CSV pages to have different ETags")
+	}
+
+	for _, path := range []string{"/api/v1/customers", "/api/v1/customers/4405071c-2adc-499d-966f-3cfdfa1deedc"} {
+		rr := get(path, "text/html, application/json;q=0")
+		if rr.Code != http.StatusNotAcceptable || rr.Header().Get("Content-Type") != "application/problem+json" {
+			t.Errorf("%s: Expected status code %d, but got %d", path, http.StatusNotAcceptable, rr.Code)
+		}
+	}
+}
+
+func TestCustomerController_TrashAndRestore(t *testing.T) {
+	router := mux.NewRouter()
+	NewCustomerController(newCustomerService(t)).RegisterRoutes(router.PathPrefix("/api/v1").Subrouter())
+
+	serve := func(method string, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	customerPath := "/api/v1/customers/4405071c-2adc-499d-966f-3cfdfa1deedc"
+
+	if rr := serve("DELETE", customerPath); rr.Code != http.StatusNoContent {
+		t.Fatalf("Expected status code %d, but got %d", http.StatusNoContent, rr.Code)
+	}
+	if rr := serve("GET", customerPath); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected the deleted customer to return %d, but got %d", http.StatusNotFound, rr.Code)
+	}
+
+	rr := serve("GET", "/api/v1/customers/trash")
+	var trash []viewmodels.CustomerViewModel
+	json.NewDecoder(rr.Body).Decode(&trash)
+	if rr.Code != http.StatusOK || rr.Header().Get("X-Total-Count") != "1" || len(trash) != 1 || trash[0].DeletedAt == nil {
+		t.Fatalf("Expected the trash to list the deleted customer, but got %d %v", rr.Code, trash)
+	}
+	if rr := serve("GET", "/api/v1/customers"); rr.Header().Get("X-Total-Count") != "4" || strings.Contains(rr.Body.String(), "DeletedAt") {
+		t.Errorf("Expected the list to hold the 4 live customers, but got %s", rr.Body.String())
+	}
+
+	rr = serve("POST", customerPath+"/restore")
+	var restored viewmodels.CustomerViewModel
+	json.NewDecoder(rr.Body).Decode(&restored)
+	if rr.Code != http.StatusOK || restored.DeletedAt != nil || rr.Header().Get("ETag") != `"3"` {
+		t.Fatalf("Expected the restored customer at version 3, but got %d %v", rr.Code, restored)
+	}
+	if rr := serve("POST", customerPath+"/restore"); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected restoring a live customer to return %d, but got %d", http.StatusNotFound, rr.Code)
+	}
+	if rr := serve("GET", customerPath); rr.Code != http.StatusOK {
+		t.Errorf("Expected the restored customer to return %d, but got %d", http.StatusOK, rr.Code)
+	}
+}
+
+func TestCustomerController_GetCustomersUpdatedSince(t *testing.T) {
+	customerService := newCustomerService(t)
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	customerService.Clock = clock.Fixed(now)
+	router := mux.NewRouter()
+	NewCustomerController(customerService).RegisterRoutes(router.PathPrefix("/api/v1").Subrouter())
+
+	req := httptest.NewRequest("PATCH", "/api/v1/customers/4405071c-2adc-499d-966f-3cfdfa1deedc", strings.NewReader(`{"Contacted": true}`))
+	req.Header.Set("Content-Type", services.MergePatchContentType)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, but got %d", http.StatusOK, rr.Code)
+	}
+
+	req = httptest.NewRequest("GET", "/api/v1/customers?updated_since=2026-03-01T13:00:00%2B01:00", nil)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	var customers []viewmodels.CustomerViewModel
+	json.NewDecoder(rr.Body).Decode(&customers)
+	if rr.Code != http.StatusOK || len(customers) != 1 || customers[0].Name != "Cong Dinh" {
+		t.Fatalf("Expected only the patched customer, but got %d %v", rr.Code, customers)
+	}
+	if !customers[0].UpdatedAt.Equal(now) || customers[0].UpdatedBy != "anonymous" {
+		t.Errorf("Expected the customer to be updated by anonymous at %s, but got %v", now, customers[0])
+	}
+
+	req = httptest.NewRequest("GET", "/api/v1/customers?updated_since=2026-03-01T12:00:01Z", nil)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Header().Get("X-Total-Count") != "0" {
+		t.Errorf("Expected no customers updated after the patch, but got %s", rr.Body.String())
+	}
+}
+
+func TestCustomerController_GetCustomerHistory(t *testing.T) {
+	customerService := newCustomerService(t)
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	customerService.Clock = clock.Func(func() time.Time { return now })
+	router := mux.NewRouter()
+	NewCustomerController(customerService).RegisterRoutes(router.PathPrefix("/api/v1").Subrouter())
+
+	serve := func(method string, path string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := serve("POST", "/api/v1/customers", `{"Name": "Lead", "Role": "Sales", "Email": "lead@domain.com", "Phone": "5550006001"}`)
+	var created viewmodels.CustomerViewModel
+	json.NewDecoder(rr.Body).Decode(&created)
+	customerPath := "/api/v1/customers/" + created.ID.String()
+	for _, phone := range []string{"5550006002", "5550006003"} {
+		now = now.Add(time.Hour)
+		serve("PUT", customerPath, fmt.Sprintf(`{"ID": %q, "Name": "Lead", "Role": "Sales", "Email": "lead@domain.com", "Phone": %q}`, created.ID, phone))
+	}
+
+	rr = serve("GET", customerPath+"/history?limit=2", "")
+	var history []viewmodels.CustomerHistoryEntryViewModel
+	json.NewDecoder(rr.Body).Decode(&history)
+	if rr.Code != http.StatusOK || rr.Header().Get("X-Total-Count") != "3" || len(history) != 2 || history[0].Version != 3 {
+		t.Fatalf("Expected the 2 newest of 3 entries, but got %d %v", rr.Code, history)
+	}
+	if change := history[0].Changes[0]; change.Field != "Phone" || change.Before != "5550006002" || change.After != "5550006003" {
+		t.Errorf("Expected the phone change, but got %v", history[0].Changes)
+	}
+	if link := rr.Header().Get("Link"); !strings.Contains(link, "offset=2") || !strings.Contains(link, `rel="next"`) {
+		t.Errorf("Expected a Link to the next page, but got %q", link)
+	}
+
+	rr = serve("GET", customerPath+"?as_of=2026-03-01T13:30:00Z", "")
+	var asOf viewmodels.CustomerViewModel
+	json.NewDecoder(rr.Body).Decode(&asOf)
+	if rr.Code != http.StatusOK || asOf.Phone != "5550006002" || asOf.Version != 2 {
+		t.Errorf("Expected the customer at version 2, but got %d %v", rr.Code, asOf)
+	}
+	if current := serve("GET", customerPath, ""); rr.Header().Get("ETag") == current.Header().Get("ETag") {
+		t.Errorf("Expected the as_of read and the current customer to have different ETags, but both got %q", rr.Header().Get("ETag"))
+	}
+	if rr := serve("GET", customerPath+"?as_of=2026-03-01T11:00:00Z", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d before the customer existed, but got %d", http.StatusNotFound, rr.Code)
+	}
+
+	for _, path := range []string{customerPath + "?as_of=yesterday", customerPath + "/history?limit=0", "/api/v1/customers/abc/history"} {
+		if rr := serve("GET", path, ""); rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status code %d for %s, but got %d", http.StatusBadRequest, path, rr.Code)
+		}
+	}
+	if rr := serve("GET", "/api/v1/customers/"+uuid.NewString()+"/history", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d for an unknown customer, but got %d", http.StatusNotFound, rr.Code)
+	}
+}
+
+// sseEvent is one server-sent event, or a comment when only Comment is set
+type sseEvent struct {
+	ID, Type, Data, Comment string
+}
+
+// readSSEEvent reads the lines of the next event from stream
+func readSSEEvent(t *testing.T, stream *bufio.Reader) sseEvent {
+	t.Helper()
+
+	event := sseEvent{}
+	for {
+		line, err := stream.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Failed to read the event stream: %s", err.Error())
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			return event
+		}
+		field, value, _ := strings.Cut(line, ": ")
+		switch field {
+		case "id":
+			event.ID = value
+		case "event":
+			event.Type = value
+		case "data":
+			event.Data = value
+		case "":
+			event.Comment = value
+		}
+	}
+}
+
+func TestCustomerController_StreamCustomerEvents(t *testing.T) {
+	customerService := newCustomerService(t)
+	customerController := NewCustomerController(customerService)
+	router := mux.NewRouter()
+	customerController.RegisterRoutes(router.PathPrefix("/api/v1").Subrouter())
+	// Cleanups run last first, so the streams are cancelled before the
+	// servers wait for them to close
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+
+	open := func(server *httptest.Server, query string, lastEventID string) *bufio.Reader {
+		ctx, cancel := context.WithCancel(t.Context())
+		t.Cleanup(cancel)
+		req, _ := http.NewRequestWithContext(ctx, "GET", server.URL+"/api/v1/customers/events"+query, nil)
+		if lastEventID != "" {
+			req.Header.Set("Last-Event-ID", lastEventID)
+		}
+		resp, err := server.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+			t.Fatalf("Expected an event stream, but got %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+		}
+		return bufio.NewReader(resp.Body)
+	}
+
+	filtered := open(server, "?type=created,deleted", "")
+
+	created, _ := customerService.Create(t.Context(), viewmodels.CustomerCreateViewModel{Name: "Streamed", Role: "Sales", Email: "streamed@domain.com", Phone: "5550007001"})
+	customerService.Patch(t.Context(), created.ID, 0, services.CustomerPatch{ContentType: services.MergePatchContentType, Document: []byte(`{"Contacted": true}`)})
+	customerService.Delete(t.Context(), created.ID, 0)
+
+	event := readSSEEvent(t, filtered)
+	var data viewmodels.CustomerEventViewModel
+	json.Unmarshal([]byte(event.Data), &data)
+	if event.ID != "1" || event.Type != "created" || data.Customer.ID != created.ID || data.Actor != "anonymous" {
+		t.Errorf("Expected the created event, but got %v", event)
+	}
+	if event := readSSEEvent(t, filtered); event.ID != "3" || event.Type != "deleted" {
+		t.Errorf("Expected the filter to skip to the deleted event, but got %v", event)
+	}
+
+	resumed := open(server, "", "1")
+	event = readSSEEvent(t, resumed)
+	json.Unmarshal([]byte(event.Data), &data)
+	if event.ID != "2" || event.Type != "updated" || len(data.Changes) != 1 || data.Changes[0].Field != "Contacted" || data.Customer.Version != 2 {
+		t.Errorf("Expected to resume with the update, but got %v", event)
+	}
+	if event := readSSEEvent(t, resumed); event.ID != "3" {
+		t.Errorf("Expected to resume with the delete next, but got %v", event)
+	}
+
+	// An id from before a restart cannot be resumed
+	restarted := open(server, "", "42")
+	if event := readSSEEvent(t, restarted); event.Type != "reset" || event.ID != "" {
+		t.Errorf("Expected a reset event for an unknown id, but got %v", event)
+	}
+	if event := readSSEEvent(t, restarted); event.ID != "1" {
+		t.Errorf("Expected every buffered event after the reset, but got %v", event)
+	}
+
+	idleController := NewCustomerController(newCustomerService(t))
+	idleController.Heartbeat = 10 * time.Millisecond
+	idleRouter := mux.NewRouter()
+	idleController.RegisterRoutes(idleRouter.PathPrefix("/api/v1").Subrouter())
+	idleServer := httptest.NewServer(idleRouter)
+	t.Cleanup(idleServer.Close)
+	if event := readSSEEvent(t, open(idleServer, "", "")); event.Comment != "heartbeat" {
+		t.Errorf("Expected a heartbeat comment, but got %v", event)
+	}
+
+	for _, query := range []string{"?type=purged", ""} {
+		req := httptest.NewRequest("GET", "/api/v1/customers/events"+query, nil)
+		req.Header.Set("Last-Event-ID", "abc")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status code %d for %s, but got %d", http.StatusBadRequest, query, rr.Code)
+		}
+	}
+}
+
+func TestCustomerController_LiveCustomer(t *testing.T) {
+	customerService := newCustomerService(t)
+	customerController := NewCustomerController(customerService)
+	router := mux.NewRouter()
+	customerController.RegisterRoutes(router.PathPrefix("/api/v1").Subrouter())
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+
+	customer, _ := customerService.Create(t.Context(), viewmodels.CustomerCreateViewModel{Name: "Shared", Role: "Sales", Email: "shared@domain.com", Phone: "5550008001"})
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/api/v1/customers/" + customer.ID.String() + "/live"
+
+	dial := func() *websocket.Conn {
+		conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+	read := func(conn *websocket.Conn) viewmodels.CustomerLiveMessageViewModel {
+		t.Helper()
+		var message viewmodels.CustomerLiveMessageViewModel
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if err := conn.ReadJSON(&message); err != nil {
+			t.Fatalf("Failed to read a message: %v", err)
+		}
+		return message
+	}
+
+	alice := dial()
+	snapshot := read(alice)
+	if snapshot.Type != "snapshot" || snapshot.Customer == nil || snapshot.Customer.Version != 1 || len(snapshot.Viewers) != 1 || snapshot.Viewers[0].ID != snapshot.ViewerID || snapshot.Viewers[0].Subject != "anonymous" {
+		t.Errorf("Expected a snapshot with the customer and its only viewer, but got %+v", snapshot)
+	}
+	if message := read(alice); message.Type != "presence" || len(message.Viewers) != 1 {
+		t.Errorf("Expected alice to see their own join, but got %+v", message)
+	}
+
+	bob := dial()
+	if message := read(bob); message.Type != "snapshot" || len(message.Viewers) != 2 {
+		t.Errorf("Expected bob's snapshot to list both viewers, but got %+v", message)
+	}
+	read(bob)
+	if message := read(alice); message.Type != "presence" || len(message.Viewers) != 2 {
+		t.Errorf("Expected alice to see bob join, but got %+v", message)
+	}
+
+	alice.WriteJSON(viewmodels.CustomerLiveMessageViewModel{Type: "patch", RequestID: "1", Version: 1, Patch: json.RawMessage(`{"Contacted": true}`)})
+	for _, conn := range []*websocket.Conn{alice, bob} {
+		message := read(conn)
+		if message.Type != "change" || message.Event != "updated" || len(message.Changes) != 1 || message.Changes[0].Field != "Contacted" || message.Customer.Version != 2 {
+			t.Errorf("Expected everyone to get the field-level change, but got %+v", message)
+		}
+	}
+	if message := read(alice); message.Type != "ack" || message.RequestID != "1" || message.Customer.Version != 2 {
+		t.Errorf("Expected alice's patch to be acknowledged, but got %+v", message)
+	}
+
+	// Bob still edits version 1
+	bob.WriteJSON(viewmodels.CustomerLiveMessageViewModel{Type: "update", RequestID: "7", Version: 1, Edit: &viewmodels.CustomerEditViewModel{Name: "Overwritten", Role: "Sales", Email: "shared@domain.com", Phone: "5550008001"}})
+	message := read(bob)
+	if message.Type != "error" || message.RequestID != "7" || message.Problem.Status != http.StatusPreconditionFailed || message.Customer == nil || message.Customer.Version != 2 {
+		t.Errorf("Expected the stale update to be rejected with the current customer, but got %+v", message)
+	}
+	if current, _ := customerService.GetById(t.Context(), customer.ID); current.Name != "Shared" {
+		t.Errorf("Expected the stale update not to be stored, but got %v", current.Name)
+	}
+
+	tests := []struct {
+		message string
+		status  int
+	}{
+		{message: `{"Type": "patch", "Patch": {"Name": "No version"}}`, status: http.StatusBadRequest},
+		{message: `{"Type": "rename", "Version": 2}`, status: http.StatusBadRequest},
+		{message: `{"Type": "update", "Version": 2}`, status: http.StatusBadRequest},
+		{message: `not json`, status: http.StatusBadRequest},
+		{message: `{"Type": "patch", "Version": 2, "Patch": {"Email": "invalid"}}`, status: http.StatusUnprocessableEntity},
+	}
+	for _, test := range tests {
+		bob.WriteMessage(websocket.TextMessage, []byte(test.message))
+		if message := read(bob); message.Type != "error" || message.Problem.Status != test.status {
+			t.Errorf("Expected %s to be rejected with %d, but got %+v", test.message, test.status, message)
+		}
+	}
+
+	// Changes made over REST reach the viewers too
+	customerService.Delete(t.Context(), customer.ID, 0)
+	if message := read(alice); message.Type != "change" || message.Event != "deleted" || message.Customer.DeletedAt == nil {
+		t.Errorf("Expected the delete to reach the viewers, but got %+v", message)
+	}
+	read(bob)
+
+	bob.Close()
+	if message := read(alice); message.Type != "presence" || len(message.Viewers) != 1 {
+		t.Errorf("Expected alice to see bob leave, but got %+v", message)
+	}
+
+	for _, path := range []string{"/api/v1/customers/" + customer.ID.String() + "/live", "/api/v1/customers/" + uuid.NewString() + "/live"} {
+		_, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+path, nil)
+		if err == nil || resp == nil || resp.StatusCode != http.StatusNotFound {
+			t.Errorf("Expected %s to be 404 for a missing or deleted customer, but got %v", path, err)
+		}
+	}
+	resp, err := http.Get(server.URL + "/api/v1/customers/" + uuid.NewString() + "/live")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected a request without an upgrade to be 400, but got %d", resp.StatusCode)
+	}
+}
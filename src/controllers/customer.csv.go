@@ -0,0 +1,295 @@
+package controllers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"congdinh.com/crm/services"
+	"congdinh.com/crm/validation"
+	viewmodels "congdinh.com/crm/view-models"
+)
+
+const (
+	// maxImportSize caps the body of POST /customers/import
+	maxImportSize = 10 << 20
+	// maxImportRows caps the number of data rows of an import
+	maxImportRows = 10000
+)
+
+// csvColumns are the columns of an export, in order
+var csvColumns = []string{"ID", "Name", "Role", "Email", "Phone", "Contacted", "Version", "CreatedAt", "UpdatedAt", "CreatedBy", "UpdatedBy"}
+
+// importFields are the fields an import column can be mapped to
+var importFields = []string{"Name", "Role", "Email", "Phone", "Contacted"}
+
+// ExportCustomers godoc
+// @Summary Export customers as CSV
+// @Description stream every customer matching the filters as CSV with a header row. Accepts the filters and sort of GET /customers; limit, offset and cursor are ignored.
+// @Tags customers
+// @Produce  text/csv
+// @Param sort query string false "Comma separated fields, prefix with - for descending, e.g. name,-email"
+// @Param role query string false "Filter by role"
+// @Param contacted query bool false "Filter by contacted"
+// @Param name_prefix query string false "Filter by name prefix"
+// @Param email_prefix query string false "Filter by email prefix"
+// @Param updated_since query string false "Only customers changed at or after this RFC 3339 time"
+// @Success 200 {string} string "CSV with the columns ID, Name, Role, Email, Phone, Contacted and Version"
+// @Failure 400 {object} viewmodels.ProblemViewModel "Bad Request"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /customers/export.csv [get]
+func (cc *CustomerController) ExportCustomers(w http.ResponseWriter, r *http.Request) {
+	options, err := parseCustomerQuery(r)
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	options.Limit = maxPageSize
+	options.Offset = 0
+	options.Cursor = ""
+
+	// Read the first page before writing, so early failures still get a problem response
+	page, err := cc.ICustomerService.Query(r.Context(), options)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="customers.csv"`)
+	w.WriteHeader(http.StatusOK)
+
+	writer := csv.NewWriter(w)
+	writer.Write(csvColumns)
+	for {
+		for _, customer := range page.Items {
+			writer.Write(csvRecord(customer))
+		}
+		// Flush each page so large exports stream instead of buffering
+		writer.Flush()
+		if page.NextCursor == "" || writer.Error() != nil {
+			return
+		}
+
+		// The cursor continues after the last exported customer, so changes
+		// to the pages already written do not shift the rest of the export
+		options.Cursor = page.NextCursor
+		if page, err = cc.ICustomerService.Query(r.Context(), options); err != nil {
+			// The status is already sent; cut the stream short so the client sees a truncated file
+			panic(http.ErrAbortHandler)
+		}
+	}
+}
+
+// ImportCustomers godoc
+// @Summary Import customers from CSV
+// @Description create a customer per row of a CSV file with a header row. Columns are matched to the fields Name, Role, Email, Phone and Contacted by name, ignoring case; other columns are ignored. map=Column:Field maps a column with another name, e.g. map=E-mail address:Email. Rows are validated and checked for duplicate emails and phones like POST /customers, against the stored customers and the rows above them. Valid rows are created even if others fail. With dry_run=true nothing is stored.
+// @Tags customers
+// @Accept  text/csv
+// @Produce  json
+// @Param   file  body  string  true  "CSV file"
+// @Param   map  query  []string  false  "Column mappings as Column:Field" collectionFormat(multi)
+// @Param   dry_run  query  bool  false  "Check the rows without storing them"
+// @Success 200  {object}  viewmodels.CustomerImportResultViewModel  "Per-row results"
+// @Failure 400  {object}  viewmodels.ProblemViewModel  "Malformed CSV or parameters"
+// @Failure 413  {object}  viewmodels.ProblemViewModel  "File too large"
+// @Failure 415  {object}  viewmodels.ProblemViewModel  "Not a CSV file"
+// @Failure 422  {object}  viewmodels.ProblemViewModel  "Invalid column mapping or too many rows"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /customers/import [post]
+func (cc *CustomerController) ImportCustomers(w http.ResponseWriter, r *http.Request) {
+	if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || (mediaType != "text/csv" && mediaType != "application/csv") {
+		writeProblem(w, r, http.StatusUnsupportedMediaType, "Content-Type must be text/csv")
+		return
+	}
+	dryRun := false
+	if value := r.URL.Query().Get("dry_run"); value != "" {
+		var err error
+		if dryRun, err = strconv.ParseBool(value); err != nil {
+			writeProblem(w, r, http.StatusBadRequest, "dry_run must be true or false")
+			return
+		}
+	}
+	mapping, err := parseColumnMapping(r.URL.Query()["map"])
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	rows, err := readCustomerRows(http.MaxBytesReader(w, r.Body, maxImportSize), mapping)
+	var (
+		tooLarge  *http.MaxBytesError
+		malformed *csv.ParseError
+	)
+	switch {
+	case errors.As(err, &tooLarge):
+		writeProblem(w, r, http.StatusRequestEntityTooLarge, fmt.Sprintf("the file must be at most %d bytes", maxImportSize))
+		return
+	case errors.As(err, &malformed):
+		writeProblem(w, r, http.StatusBadRequest, "Malformed CSV: "+err.Error())
+		return
+	case err != nil:
+		writeError(w, r, err)
+		return
+	}
+
+	// Rows that could not be read are reported as they are; the rest go to the service
+	customers := []viewmodels.CustomerCreateViewModel{}
+	positions := []int{}
+	for i, row := range rows {
+		if row.err == nil {
+			customers = append(customers, row.customer)
+			positions = append(positions, i)
+		}
+	}
+	results, err := cc.ICustomerService.Import(r.Context(), customers, dryRun)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	for j, result := range results {
+		rows[positions[j]].result = result
+	}
+
+	response := viewmodels.CustomerImportResultViewModel{DryRun: dryRun, Rows: []viewmodels.CustomerImportRowViewModel{}}
+	for _, row := range rows {
+		item := viewmodels.CustomerImportRowViewModel{Line: row.line, Customer: row.result.Customer}
+		if err := errors.Join(row.err, row.result.Err); err != nil {
+			problem := problemFor(r, err)
+			item.Status = problem.Status
+			item.Error = &problem
+			response.Failed++
+		} else {
+			item.Status = http.StatusCreated
+			response.Created++
+		}
+		response.Rows = append(response.Rows, item)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK) // HTTP 200
+	json.NewEncoder(w).Encode(response)
+}
+
+// customerRow is a data row of an import
+type customerRow struct {
+	// line is the line of the row in the file, counting the header as line 1
+	line     int
+	customer viewmodels.CustomerCreateViewModel
+	// err is set when the row could not be read into a customer
+	err    error
+	result services.CustomerOperationResult
+}
+
+// parseColumnMapping reads map=Column:Field parameters into a map from the
+// lower-cased column name to the field
+func parseColumnMapping(values []string) (map[string]string, error) {
+	mapping := map[string]string{}
+	for _, value := range values {
+		index := strings.LastIndex(value, ":")
+		if index <= 0 {
+			return nil, mappingError("must be Column:Field")
+		}
+		column, field := strings.TrimSpace(value[:index]), strings.TrimSpace(value[index+1:])
+		if !slices.Contains(importFields, field) {
+			return nil, mappingError(field + " is not one of " + strings.Join(importFields, "|"))
+		}
+		mapping[strings.ToLower(column)] = field
+	}
+	return mapping, nil
+}
+
+func mappingError(reason string) error {
+	return &services.ValidationError{Errors: validation.Errors{{Field: "map", Reason: reason}}}
+}
+
+// readCustomerRows reads a CSV file with a header row into customers. Columns
+// are matched to fields through mapping first, then by name ignoring case.
+func readCustomerRows(body io.Reader, mapping map[string]string) ([]customerRow, error) {
+	reader := csv.NewReader(body)
+	// Rows with missing or extra cells are reported per row rather than failing the file
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, &services.ValidationError{Errors: validation.Errors{{Field: "file", Reason: "must have a header row"}}}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// columns maps each field to the index of its column
+	columns := map[string]int{}
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		field, ok := mapping[name]
+		if !ok {
+			for _, f := range importFields {
+				if strings.ToLower(f) == name {
+					field, ok = f, true
+				}
+			}
+		}
+		if _, taken := columns[field]; ok && !taken {
+			columns[field] = i
+		}
+	}
+
+	rows := []customerRow{}
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return rows, nil
+		}
+		if err != nil {
+			// A quoting error leaves the reader unable to find the following rows
+			return nil, err
+		}
+		if len(rows) == maxImportRows {
+			return nil, &services.ValidationError{
+				Errors: validation.Errors{{Field: "file", Reason: "must have at most " + strconv.Itoa(maxImportRows) + " rows"}},
+			}
+		}
+
+		line, _ := reader.FieldPos(0)
+		rows = append(rows, readCustomerRow(line, record, columns))
+	}
+}
+
+// readCustomerRow reads the cells of a record into a customer
+func readCustomerRow(line int, record []string, columns map[string]int) customerRow {
+	cell := func(field string) string {
+		if i, ok := columns[field]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	row := customerRow{
+		line: line,
+		customer: viewmodels.CustomerCreateViewModel{
+			Name:  cell("Name"),
+			Role:  cell("Role"),
+			Email: cell("Email"),
+			Phone: cell("Phone"),
+		},
+	}
+	if value := cell("Contacted"); value != "" {
+		contacted, err := strconv.ParseBool(value)
+		if err != nil {
+			row.err = &services.ValidationError{Errors: validation.Errors{{Field: "Contacted", Reason: "must be true or false"}}}
+		}
+		row.customer.Contacted = contacted
+	}
+	return row
+}
@@ -0,0 +1,186 @@
+package controllers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	viewmodels "congdinh.com/crm/view-models"
+)
+
+// customerEncoder renders customers in one media type
+type customerEncoder struct {
+	// mediaType is the type the encoder is negotiated by
+	mediaType string
+	// aliases are other types in Accept that select the encoder
+	aliases     []string
+	contentType string
+	list        func(w http.ResponseWriter, customers []viewmodels.CustomerViewModel)
+	one         func(w http.ResponseWriter, customer viewmodels.CustomerViewModel)
+}
+
+// customerEncoders are the supported representations, the preferred one first
+var customerEncoders = []customerEncoder{
+	{
+		mediaType:   "application/json",
+		contentType: "application/json",
+		list: func(w http.ResponseWriter, customers []viewmodels.CustomerViewModel) {
+			json.NewEncoder(w).Encode(customers)
+		},
+		one: func(w http.ResponseWriter, customer viewmodels.CustomerViewModel) {
+			json.NewEncoder(w).Encode(customer)
+		},
+	},
+	{
+		mediaType:   "application/x-ndjson",
+		aliases:     []string{"application/ndjson", "application/jsonl"},
+		contentType: "application/x-ndjson",
+		list: func(w http.ResponseWriter, customers []viewmodels.CustomerViewModel) {
+			encoder := json.NewEncoder(w)
+			flusher := http.NewResponseController(w)
+			for _, customer := range customers {
+				encoder.Encode(customer)
+				// Send every line as soon as it is encoded so clients can process large sets incrementally
+				flusher.Flush()
+			}
+		},
+		one: func(w http.ResponseWriter, customer viewmodels.CustomerViewModel) {
+			json.NewEncoder(w).Encode(customer)
+		},
+	},
+	{
+		mediaType:   "text/csv",
+		aliases:     []string{"application/csv"},
+		contentType: "text/csv; charset=utf-8",
+		list: func(w http.ResponseWriter, customers []viewmodels.CustomerViewModel) {
+			writer := csv.NewWriter(w)
+			writer.Write(csvColumns)
+			for _, customer := range customers {
+				writer.Write(csvRecord(customer))
+			}
+			writer.Flush()
+		},
+		one: func(w http.ResponseWriter, customer viewmodels.CustomerViewModel) {
+			writer := csv.NewWriter(w)
+			writer.Write(csvColumns)
+			writer.Write(csvRecord(customer))
+			writer.Flush()
+		},
+	},
+	{
+		mediaType:   "application/xml",
+		aliases:     []string{"text/xml"},
+		contentType: "application/xml; charset=utf-8",
+		list: func(w http.ResponseWriter, customers []viewmodels.CustomerViewModel) {
+			w.Write([]byte(xml.Header))
+			xml.NewEncoder(w).Encode(struct {
+				XMLName   xml.Name                       `xml:"Customers"`
+				Customers []viewmodels.CustomerViewModel `xml:"Customer"`
+			}{Customers: customers})
+		},
+		one: func(w http.ResponseWriter, customer viewmodels.CustomerViewModel) {
+			w.Write([]byte(xml.Header))
+			xml.NewEncoder(w).EncodeElement(customer, xml.StartElement{Name: xml.Name{Local: "Customer"}})
+		},
+	},
+}
+
+// csvRecord is the row of customer under csvColumns
+func csvRecord(customer viewmodels.CustomerViewModel) []string {
+	return []string{
+		customer.ID.String(),
+		customer.Name,
+		customer.Role,
+		customer.Email,
+		customer.Phone,
+		strconv.FormatBool(customer.Contacted),
+		strconv.FormatInt(customer.Version, 10),
+		csvTime(customer.CreatedAt),
+		csvTime(customer.UpdatedAt),
+		customer.CreatedBy,
+		customer.UpdatedBy,
+	}
+}
+
+// csvTime formats t as RFC 3339, leaving unknown times empty
+func csvTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339Nano)
+}
+
+// negotiateCustomerEncoder picks the encoder the Accept header prefers. Each
+// encoder gets the quality of the most specific media range that matches it,
+// and ties go to the earlier encoder, so a missing header or */* means JSON.
+// It returns false when the header rules out every encoder.
+func negotiateCustomerEncoder(r *http.Request) (customerEncoder, bool) {
+	header := r.Header.Get("Accept")
+	if strings.TrimSpace(header) == "" {
+		return customerEncoders[0], true
+	}
+
+	type mediaRange struct {
+		mediaType string
+		quality   float64
+	}
+	ranges := []mediaRange{}
+	for _, value := range strings.Split(header, ",") {
+		mediaType, params, err := mime.ParseMediaType(value)
+		if err != nil {
+			continue
+		}
+		quality := 1.0
+		if q, ok := params["q"]; ok {
+			if quality, err = strconv.ParseFloat(q, 64); err != nil {
+				continue
+			}
+		}
+		ranges = append(ranges, mediaRange{mediaType, quality})
+	}
+
+	best, bestQuality := customerEncoder{}, 0.0
+	for _, encoder := range customerEncoders {
+		quality, specificity := 0.0, 0
+		for _, accepted := range ranges {
+			if s := encoder.matches(accepted.mediaType); s > specificity {
+				quality, specificity = accepted.quality, s
+			}
+		}
+		if quality > bestQuality {
+			best, bestQuality = encoder, quality
+		}
+	}
+	return best, bestQuality > 0
+}
+
+// matches reports how specifically mediaRange selects the encoder: 3 for its
+// type or an alias, 2 for type/*, 1 for */* and 0 for no match
+func (e customerEncoder) matches(mediaRange string) int {
+	if mediaRange == "*/*" {
+		return 1
+	}
+	for _, mediaType := range append([]string{e.mediaType}, e.aliases...) {
+		if mediaRange == mediaType {
+			return 3
+		}
+		if prefix, ok := strings.CutSuffix(mediaRange, "/*"); ok && strings.HasPrefix(mediaType, prefix+"/") {
+			return 2
+		}
+	}
+	return 0
+}
+
+// writeNotAcceptable responds with 406 and the supported media types
+func writeNotAcceptable(w http.ResponseWriter, r *http.Request) {
+	mediaTypes := []string{}
+	for _, encoder := range customerEncoders {
+		mediaTypes = append(mediaTypes, encoder.mediaType)
+	}
+	writeProblem(w, r, http.StatusNotAcceptable, "Supported media types are "+strings.Join(mediaTypes, ", "))
+}
@@ -0,0 +1,155 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"congdinh.com/crm/auth"
+	"congdinh.com/crm/changefeed"
+	"congdinh.com/crm/policy"
+	"congdinh.com/crm/services"
+	viewmodels "congdinh.com/crm/view-models"
+)
+
+// defaultHeartbeat is how often an idle event stream sends a comment, so
+// proxies keep the connection open and clients notice a dead one
+const defaultHeartbeat = 15 * time.Second
+
+// customerEventTypes are the values of the type parameter of GET /customers/events
+var customerEventTypes = []string{services.EventCreated, services.EventUpdated, services.EventDeleted, services.EventRestored}
+
+// publishEvent hands a stored change to the event stream
+func (cc *CustomerController) publishEvent(event viewmodels.CustomerEventViewModel) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Failed to marshal the %s event of customer %s: %v", event.Type, event.Customer.ID, err)
+		return
+	}
+	cc.Events.Publish(event.Type, data)
+}
+
+// CreateStreamTicket godoc
+// @Summary Get a ticket for a stream
+// @Description issue a short-lived, single-use ticket that authenticates one GET /customers/events or GET /customers/{id}/live request as the caller, for browsers, which cannot set headers on those. Pass it as the ticket parameter instead of an API key or token, which must not appear in URLs.
+// @Tags customers
+// @Produce  json
+// @Success 201 {object} viewmodels.StreamTicketViewModel
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /customers/stream-tickets [post]
+func (cc *CustomerController) CreateStreamTicket(w http.ResponseWriter, r *http.Request) {
+	ticket, expiresAt := cc.Tickets.Issue(auth.FromContext(r.Context()))
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(viewmodels.StreamTicketViewModel{Ticket: ticket, ExpiresAt: expiresAt.UTC()})
+}
+
+// StreamCustomerEvents godoc
+// @Summary Stream customer changes
+// @Description stream every created, updated, deleted and restored customer as server-sent events. Each event has an id, the type as event name and a CustomerEventViewModel as data. A client that reconnects with the Last-Event-ID header gets the events it missed while they are still buffered; otherwise it first gets a reset event and should reload the customers. Idle streams send a heartbeat comment.
+// @Tags customers
+// @Produce  text/event-stream
+// @Param type query string false "Comma-separated event types to stream (created, updated, deleted, restored), default all"
+// @Param ticket query string false "Ticket from POST /customers/stream-tickets, for clients that cannot set headers"
+// @Param Last-Event-ID header string false "ID of the last event received, to resume after it"
+// @Success 200 {object} viewmodels.CustomerEventViewModel
+// @Failure 400 {object} viewmodels.ProblemViewModel "Bad Request"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /customers/events [get]
+func (cc *CustomerController) StreamCustomerEvents(w http.ResponseWriter, r *http.Request) {
+	types := customerEventTypes
+	if value := r.URL.Query().Get("type"); value != "" {
+		types = strings.Split(value, ",")
+		for _, eventType := range types {
+			if !slices.Contains(customerEventTypes, eventType) {
+				writeProblem(w, r, http.StatusBadRequest, "type must be a comma-separated list of "+strings.Join(customerEventTypes, ", "))
+				return
+			}
+		}
+	}
+
+	var subscription *changefeed.Subscription
+	var missed []changefeed.Event
+	complete := true
+	if value := r.Header.Get("Last-Event-ID"); value != "" {
+		lastID, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			writeProblem(w, r, http.StatusBadRequest, "Last-Event-ID must be the id of an event")
+			return
+		}
+		subscription, missed, complete = cc.Events.Resume(lastID)
+	} else {
+		subscription = cc.Events.Subscribe()
+	}
+	defer subscription.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// Keeps nginx from buffering the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	access := policy.FromContext(r.Context())
+	response := http.NewResponseController(w)
+	if !complete {
+		// Without an id, so the client still resumes after the last event it got
+		fmt.Fprint(w, "event: reset\ndata: {}\n\n")
+	}
+	for _, event := range missed {
+		writeEvent(w, event, types, access)
+	}
+	if err := response.Flush(); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(cc.Heartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-subscription.Events():
+			// A closed subscription fell behind or the server is shutting
+			// down; the client reconnects and resumes from the buffer
+			if !ok {
+				return
+			}
+			writeEvent(w, event, types, access)
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+		}
+		if err := response.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// writeEvent writes event in the server-sent events format if its type is
+// one of types, clearing what access hides
+func writeEvent(w http.ResponseWriter, event changefeed.Event, types []string, access policy.Access) {
+	if !slices.Contains(types, event.Type) {
+		return
+	}
+	data := event.Data
+	if access.Restricted() {
+		var change viewmodels.CustomerEventViewModel
+		if err := json.Unmarshal(data, &change); err != nil {
+			log.Printf("Failed to parse event %d: %v", event.ID, err)
+			return
+		}
+		var err error
+		if data, err = json.Marshal(visibleEvent(access, change)); err != nil {
+			log.Printf("Failed to marshal event %d: %v", event.ID, err)
+			return
+		}
+	}
+	fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+}
@@ -0,0 +1,63 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// GetCustomerHistory godoc
+// @Summary Show the history of a customer
+// @Description list every recorded change of a customer, newest first, with the actor, time, operation and the changed fields before and after. The history is kept after the customer is deleted or purged.
+// @Tags customers
+// @Produce  json
+// @Param id path string true "Customer ID"
+// @Param limit query int false "Page size (1-1000, default 100)"
+// @Param offset query int false "Number of entries to skip"
+// @Success 200 {array} viewmodels.CustomerHistoryEntryViewModel
+// @Header 200 {integer} X-Total-Count "Number of entries of the customer"
+// @Header 200 {string} Link "Links to the next and previous pages"
+// @Failure 400 {object} viewmodels.ProblemViewModel "Bad Request"
+// @Failure 404 {object} viewmodels.ProblemViewModel "Customer not found"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /customers/{id}/history [get]
+func (cc *CustomerController) GetCustomerHistory(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, "Invalid customer ID")
+		return
+	}
+
+	limit := defaultPageSize
+	if value := r.URL.Query().Get("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxPageSize {
+			writeProblem(w, r, http.StatusBadRequest, fmt.Sprintf("limit must be a number between 1 and %d", maxPageSize))
+			return
+		}
+	}
+	offset := 0
+	if value := r.URL.Query().Get("offset"); value != "" {
+		offset, err = strconv.Atoi(value)
+		if err != nil || offset < 0 {
+			writeProblem(w, r, http.StatusBadRequest, "offset must be a non-negative number")
+			return
+		}
+	}
+
+	page, err := cc.ICustomerService.GetHistory(r.Context(), id, offset, limit)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writePageHeaders(w, r, page.Total, page.Offset, page.Limit, "")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(page.Items)
+}
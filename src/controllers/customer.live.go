@@ -0,0 +1,307 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"congdinh.com/crm/auth"
+	"congdinh.com/crm/live"
+	"congdinh.com/crm/policy"
+	"congdinh.com/crm/services"
+	viewmodels "congdinh.com/crm/view-models"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// liveWriteWait is how long a message may take to write
+	liveWriteWait = 10 * time.Second
+	// livePongWait is how long a connection may stay silent before it is
+	// considered dead; it is pinged well before that
+	livePongWait   = 60 * time.Second
+	livePingPeriod = livePongWait * 9 / 10
+	// liveMaxMessage caps the size of a message from a client
+	liveMaxMessage = 64 << 10
+)
+
+// Live message types
+const (
+	liveSnapshot = "snapshot"
+	livePresence = "presence"
+	liveChange   = "change"
+	liveAck      = "ack"
+	liveError    = "error"
+	liveUpdate   = "update"
+	livePatch    = "patch"
+)
+
+// livePermissions are the permissions the edits need
+var livePermissions = map[string]string{
+	liveUpdate: "customers.update",
+	livePatch:  "customers.patch",
+}
+
+// liveUpgrader accepts WebSocket connections from the origin serving the API only
+var liveUpgrader = websocket.Upgrader{}
+
+// publishLiveChange sends a stored change to everyone viewing the customer
+func (cc *CustomerController) publishLiveChange(event viewmodels.CustomerEventViewModel) {
+	if !cc.Live.Watched(event.Customer.ID) {
+		return
+	}
+	customer := event.Customer
+	message, err := json.Marshal(viewmodels.CustomerLiveMessageViewModel{
+		Type:     liveChange,
+		Event:    event.Type,
+		Actor:    event.Actor,
+		Changes:  event.Changes,
+		Customer: &customer,
+	})
+	if err != nil {
+		log.Printf("Failed to marshal the %s change of customer %s: %v", event.Type, event.Customer.ID, err)
+		return
+	}
+	cc.Live.Broadcast(event.Customer.ID, message)
+}
+
+// livePresenceMessage is the message the hub sends when someone joins or leaves
+func livePresenceMessage(customerID uuid.UUID, viewers []live.Viewer) []byte {
+	message, err := json.Marshal(viewmodels.CustomerLiveMessageViewModel{Type: livePresence, Viewers: toViewerViewModels(viewers)})
+	if err != nil {
+		log.Printf("Failed to marshal the viewers of customer %s: %v", customerID, err)
+	}
+	return message
+}
+
+func toViewerViewModels(viewers []live.Viewer) []viewmodels.CustomerViewerViewModel {
+	result := make([]viewmodels.CustomerViewerViewModel, 0, len(viewers))
+	for _, viewer := range viewers {
+		result = append(result, viewmodels.CustomerViewerViewModel{ID: viewer.ID, Subject: viewer.Subject, JoinedAt: viewer.JoinedAt})
+	}
+	return result
+}
+
+// LiveCustomer godoc
+// @Summary Edit a customer together
+// @Description upgrade to a WebSocket that shows who else has the customer open and every change made to it, and takes edits. Messages are CustomerLiveMessageViewModel JSON. The server first sends a snapshot with the customer, the viewers and the ViewerID of the connection, then presence whenever someone joins or leaves and change for every stored change, however it was made. A client edits with update (Edit holds the whole customer, like PUT) or patch (Patch holds a JSON Merge Patch, like PATCH), always with the Version it is based on and optionally a RequestID. The reply is ack with the stored customer, or error with the problem; an edit based on a stale version fails with 412 and carries the current customer to rebase on. Changes can overtake the snapshot, so clients should ignore those with a Version they already have.
+// @Tags customers
+// @Produce  json
+// @Param   id   path      string  true  "Customer ID"
+// @Param   ticket  query     string  false  "Ticket from POST /customers/stream-tickets, for clients that cannot set headers"
+// @Success 101  {object}  viewmodels.CustomerLiveMessageViewModel  "Switching Protocols"
+// @Failure 400  {object}  viewmodels.ProblemViewModel  "Bad Request"
+// @Failure 404  {object}  viewmodels.ProblemViewModel  "Customer not found"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /customers/{id}/live [get]
+func (cc *CustomerController) LiveCustomer(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, "Invalid customer ID")
+		return
+	}
+	if !websocket.IsWebSocketUpgrade(r) {
+		writeProblem(w, r, http.StatusBadRequest, "Expected a WebSocket upgrade")
+		return
+	}
+	if _, err := cc.liveCustomer(r, id); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	conn, err := liveUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already responded
+		return
+	}
+
+	client := cc.Live.Join(id, live.Viewer{ID: uuid.NewString(), Subject: auth.FromContext(r.Context()).Subject, JoinedAt: time.Now()})
+	written := make(chan struct{})
+	defer func() { <-written }()
+	defer client.Leave()
+
+	// Read again after joining, so no change between the two reads is missed
+	snapshot := viewmodels.CustomerLiveMessageViewModel{Type: liveSnapshot, ViewerID: client.Viewer().ID}
+	customer, err := cc.liveCustomer(r, id)
+	if err != nil {
+		problem := problemFor(r, err)
+		problem.Instance = r.URL.Path
+		snapshot = viewmodels.CustomerLiveMessageViewModel{Type: liveError, Problem: &problem}
+	} else {
+		snapshot.Customer = customer
+		snapshot.Viewers = toViewerViewModels(cc.Live.Viewers(id))
+	}
+	go writeLive(conn, client, policy.FromContext(r.Context()), snapshot, written)
+	if err != nil {
+		return
+	}
+
+	conn.SetReadLimit(liveMaxMessage)
+	conn.SetReadDeadline(time.Now().Add(livePongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(livePongWait))
+	})
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var message viewmodels.CustomerLiveMessageViewModel
+		var reply viewmodels.CustomerLiveMessageViewModel
+		if err := json.Unmarshal(data, &message); err != nil {
+			reply = liveErrorReply(r, message, &liveRequestError{http.StatusBadRequest, "Malformed JSON message: " + err.Error()})
+		} else {
+			reply = cc.applyLiveEdit(r, id, message)
+		}
+		data, err = json.Marshal(reply)
+		if err != nil {
+			log.Printf("Failed to marshal the %s reply for customer %s: %v", reply.Type, id, err)
+			return
+		}
+		if !client.Send(data) {
+			return
+		}
+	}
+}
+
+// liveCustomer returns the live customer id, or *services.NotFoundError
+func (cc *CustomerController) liveCustomer(r *http.Request, id uuid.UUID) (*viewmodels.CustomerViewModel, error) {
+	customer, err := cc.ICustomerService.GetById(r.Context(), id)
+	if err == nil && customer == nil {
+		err = &services.NotFoundError{ID: id}
+	}
+	return customer, err
+}
+
+// liveRequestError is a message the server will not act on
+type liveRequestError struct {
+	status int
+	detail string
+}
+
+func (e *liveRequestError) Error() string {
+	return e.detail
+}
+
+// applyLiveEdit stores an edit sent over the live WebSocket and returns the reply
+func (cc *CustomerController) applyLiveEdit(r *http.Request, id uuid.UUID, message viewmodels.CustomerLiveMessageViewModel) viewmodels.CustomerLiveMessageViewModel {
+	// Connecting only needs the read scope
+	if !auth.FromContext(r.Context()).Allows(auth.ScopeWrite) {
+		return liveErrorReply(r, message, &liveRequestError{http.StatusForbidden, "The caller lacks the " + auth.ScopeWrite + " scope"})
+	}
+	// A live edit always names its version: the point is not to overwrite
+	// what someone else just saved
+	if message.Version < 1 {
+		return liveErrorReply(r, message, &liveRequestError{http.StatusBadRequest, "Version is required"})
+	}
+
+	// Edits need the permission of the route that makes them
+	if permission, ok := livePermissions[message.Type]; ok && !policy.FromContext(r.Context()).Allows(permission) {
+		return liveErrorReply(r, message, &liveRequestError{http.StatusForbidden, "The roles of the caller do not allow " + permission})
+	}
+
+	var result viewmodels.CustomerViewModel
+	var err error
+	switch message.Type {
+	case liveUpdate:
+		if message.Edit == nil {
+			return liveErrorReply(r, message, &liveRequestError{http.StatusBadRequest, "Edit is required"})
+		}
+		result, err = cc.ICustomerService.Update(r.Context(), id, message.Version, *message.Edit)
+	case livePatch:
+		if len(message.Patch) == 0 {
+			return liveErrorReply(r, message, &liveRequestError{http.StatusBadRequest, "Patch is required"})
+		}
+		result, err = cc.ICustomerService.Patch(r.Context(), id, message.Version, services.CustomerPatch{ContentType: services.MergePatchContentType, Document: message.Patch})
+	default:
+		return liveErrorReply(r, message, &liveRequestError{http.StatusBadRequest, "Type must be " + liveUpdate + " or " + livePatch})
+	}
+	if err != nil {
+		reply := liveErrorReply(r, message, err)
+		var stale *services.PreconditionFailedError
+		if errors.As(err, &stale) {
+			reply.Customer, _ = cc.ICustomerService.GetById(r.Context(), id)
+		}
+		return reply
+	}
+	return viewmodels.CustomerLiveMessageViewModel{Type: liveAck, RequestID: message.RequestID, Customer: &result}
+}
+
+// liveErrorReply is the error reply to message
+func liveErrorReply(r *http.Request, message viewmodels.CustomerLiveMessageViewModel, err error) viewmodels.CustomerLiveMessageViewModel {
+	var problem viewmodels.ProblemViewModel
+	var requestErr *liveRequestError
+	if errors.As(err, &requestErr) {
+		problem = genericProblem(requestErr.status, requestErr.detail)
+	} else {
+		problem = problemFor(r, err)
+	}
+	problem.Instance = r.URL.Path
+	return viewmodels.CustomerLiveMessageViewModel{Type: liveError, RequestID: message.RequestID, Problem: &problem}
+}
+
+// visibleLiveMessage clears what access hides from a message the hub sent,
+// which is the same for every viewer. It returns nil for a message it cannot
+// clear, which is not sent.
+func visibleLiveMessage(access policy.Access, data []byte) []byte {
+	if !access.Restricted() {
+		return data
+	}
+	var message viewmodels.CustomerLiveMessageViewModel
+	if err := json.Unmarshal(data, &message); err != nil {
+		log.Printf("Failed to parse a live message: %v", err)
+		return nil
+	}
+	if message.Customer != nil {
+		customer := access.Customer(*message.Customer)
+		message.Customer = &customer
+	}
+	message.Changes = access.Changes(message.Changes)
+	visible, err := json.Marshal(message)
+	if err != nil {
+		log.Printf("Failed to marshal a live message: %v", err)
+		return nil
+	}
+	return visible
+}
+
+// writeLive writes the snapshot and then the messages of client, pinging
+// while it is idle, clearing what access hides. It is the only writer of conn
+// and closes it when the client leaves or the connection fails.
+func writeLive(conn *websocket.Conn, client *live.Client, access policy.Access, snapshot viewmodels.CustomerLiveMessageViewModel, written chan<- struct{}) {
+	defer close(written)
+	defer conn.Close()
+
+	conn.SetWriteDeadline(time.Now().Add(liveWriteWait))
+	if err := conn.WriteJSON(snapshot); err != nil || snapshot.Type == liveError {
+		return
+	}
+
+	ping := time.NewTicker(livePingPeriod)
+	defer ping.Stop()
+	for {
+		select {
+		case message, ok := <-client.Messages():
+			conn.SetWriteDeadline(time.Now().Add(liveWriteWait))
+			if !ok {
+				// Left, fell behind, or the server is shutting down
+				conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, ""))
+				return
+			}
+			if message = visibleLiveMessage(access, message); message == nil {
+				continue
+			}
+			if err := conn.WriteMessage(websocket.TextMessage, message); err != nil {
+				return
+			}
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(liveWriteWait)); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,257 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"congdinh.com/crm/policy"
+	"congdinh.com/crm/repositories"
+	"congdinh.com/crm/services"
+	viewmodels "congdinh.com/crm/view-models"
+	"github.com/google/uuid"
+)
+
+// visibleCustomerService clears the customer fields the caller may not see,
+// under the policy.Access of the context, from everything the customer
+// service returns, so no handler can leak them, and refuses writes that set
+// them. Changes pushed to event streams and live viewers are cleared when
+// they are written.
+type visibleCustomerService struct {
+	services.ICustomerService
+}
+
+func visibleCustomer(ctx context.Context, customer viewmodels.CustomerViewModel) viewmodels.CustomerViewModel {
+	return policy.FromContext(ctx).Customer(customer)
+}
+
+func visibleCustomerPointer(ctx context.Context, customer *viewmodels.CustomerViewModel) *viewmodels.CustomerViewModel {
+	if customer == nil {
+		return nil
+	}
+	visible := visibleCustomer(ctx, *customer)
+	return &visible
+}
+
+func visibleCustomers(ctx context.Context, customers []viewmodels.CustomerViewModel) []viewmodels.CustomerViewModel {
+	result := make([]viewmodels.CustomerViewModel, 0, len(customers))
+	for _, customer := range customers {
+		result = append(result, visibleCustomer(ctx, customer))
+	}
+	return result
+}
+
+func visibleResults(ctx context.Context, results []services.CustomerOperationResult) []services.CustomerOperationResult {
+	for i := range results {
+		results[i].Customer = visibleCustomerPointer(ctx, results[i].Customer)
+		results[i].Err = visibleError(ctx, results[i].Err)
+	}
+	return results
+}
+
+// visibleError keeps a conflict over a taken email or phone from naming a
+// field the caller may not see
+func visibleError(ctx context.Context, err error) error {
+	access := policy.FromContext(ctx)
+	var conflict *services.ConflictError
+	if !errors.As(err, &conflict) || !errors.Is(err, repositories.ErrCustomerExists) || !access.Hides("Email") && !access.Hides("Phone") {
+		return err
+	}
+	fields := []string{}
+	for _, field := range []string{"Email", "Phone"} {
+		if !access.Hides(field) {
+			fields = append(fields, strings.ToLower(field))
+		}
+	}
+	if len(fields) == 0 {
+		return &services.ConflictError{Reason: "the customer clashes with another customer", Err: conflict.Err}
+	}
+	return &services.ConflictError{Reason: "a customer with this " + strings.Join(fields, " or ") + " already exists", Err: conflict.Err}
+}
+
+// createEdit is customer as the edit that CheckWrite takes
+func createEdit(customer viewmodels.CustomerCreateViewModel) viewmodels.CustomerEditViewModel {
+	return viewmodels.CustomerEditViewModel{Name: customer.Name, Role: customer.Role, Email: customer.Email, Phone: customer.Phone, Contacted: customer.Contacted}
+}
+
+// visibleEvent clears what access hides from a change
+func visibleEvent(access policy.Access, event viewmodels.CustomerEventViewModel) viewmodels.CustomerEventViewModel {
+	event.Customer = access.Customer(event.Customer)
+	event.Changes = access.Changes(event.Changes)
+	return event
+}
+
+// sortFieldNames maps the sort fields of queries to the customer fields
+var sortFieldNames = map[string]string{
+	"name":      "Name",
+	"role":      "Role",
+	"email":     "Email",
+	"phone":     "Phone",
+	"contacted": "Contacted",
+}
+
+func (s visibleCustomerService) GetAll(ctx context.Context) ([]viewmodels.CustomerViewModel, error) {
+	customers, err := s.ICustomerService.GetAll(ctx)
+	return visibleCustomers(ctx, customers), err
+}
+
+// Query also refuses to filter or sort by hidden fields, which would reveal them
+func (s visibleCustomerService) Query(ctx context.Context, options services.CustomerQueryOptions) (viewmodels.CustomerPageViewModel, error) {
+	access := policy.FromContext(ctx)
+	filters := map[string]bool{
+		"Role":      options.Role != "",
+		"Contacted": options.Contacted != nil,
+		"Name":      options.NamePrefix != "",
+		"Email":     options.EmailPrefix != "",
+	}
+	for field, used := range filters {
+		if used && access.Hides(field) {
+			return viewmodels.CustomerPageViewModel{}, &policy.HiddenFieldError{Field: field}
+		}
+	}
+	for _, sort := range options.Sort {
+		if field := sortFieldNames[strings.ToLower(sort.Field)]; access.Hides(field) {
+			return viewmodels.CustomerPageViewModel{}, &policy.HiddenFieldError{Field: field}
+		}
+	}
+
+	page, err := s.ICustomerService.Query(ctx, options)
+	page.Items = visibleCustomers(ctx, page.Items)
+	return page, err
+}
+
+// Search leaves out the customers that only matched in hidden fields
+func (s visibleCustomerService) Search(ctx context.Context, query string, limit int) ([]viewmodels.CustomerSearchResultViewModel, error) {
+	results, err := s.ICustomerService.Search(ctx, query, limit)
+	access := policy.FromContext(ctx)
+	if err != nil || !access.Restricted() {
+		return results, err
+	}
+
+	visible := []viewmodels.CustomerSearchResultViewModel{}
+	for _, result := range results {
+		highlights := map[string]string{}
+		for field, highlight := range result.Highlights {
+			if !access.Hides(field) {
+				highlights[field] = highlight
+			}
+		}
+		if len(highlights) == 0 && len(result.Highlights) > 0 {
+			continue
+		}
+		result.Customer = access.Customer(result.Customer)
+		result.Highlights = highlights
+		visible = append(visible, result)
+	}
+	return visible, nil
+}
+
+func (s visibleCustomerService) GetById(ctx context.Context, id uuid.UUID) (*viewmodels.CustomerViewModel, error) {
+	customer, err := s.ICustomerService.GetById(ctx, id)
+	return visibleCustomerPointer(ctx, customer), err
+}
+
+// Create refuses customers that set fields the caller may not see
+func (s visibleCustomerService) Create(ctx context.Context, customer viewmodels.CustomerCreateViewModel) (viewmodels.CustomerViewModel, error) {
+	if err := policy.FromContext(ctx).CheckWrite(createEdit(customer)); err != nil {
+		return viewmodels.CustomerViewModel{}, err
+	}
+	result, err := s.ICustomerService.Create(ctx, customer)
+	return visibleCustomer(ctx, result), visibleError(ctx, err)
+}
+
+// Update keeps the fields the caller may not see, which it must send empty
+func (s visibleCustomerService) Update(ctx context.Context, id uuid.UUID, version int64, customer viewmodels.CustomerEditViewModel) (viewmodels.CustomerViewModel, error) {
+	customer, err := s.keepHidden(ctx, id, customer)
+	if err != nil {
+		return viewmodels.CustomerViewModel{}, err
+	}
+	result, err := s.ICustomerService.Update(ctx, id, version, customer)
+	return visibleCustomer(ctx, result), visibleError(ctx, err)
+}
+
+// keepHidden refuses an edit that sets fields the caller may not see, and
+// fills them in from the stored customer id
+func (s visibleCustomerService) keepHidden(ctx context.Context, id uuid.UUID, customer viewmodels.CustomerEditViewModel) (viewmodels.CustomerEditViewModel, error) {
+	access := policy.FromContext(ctx)
+	if !access.Restricted() {
+		return customer, nil
+	}
+	if err := access.CheckWrite(customer); err != nil {
+		return customer, err
+	}
+	current, err := s.ICustomerService.GetById(ctx, id)
+	if err != nil || current == nil {
+		// The update reports the missing customer
+		return customer, err
+	}
+	return access.KeepHidden(customer, *current), nil
+}
+
+// Patch refuses patches that read or write hidden fields. Copying, moving or
+// testing one would reveal it, and writing one would change what the caller
+// cannot see.
+func (s visibleCustomerService) Patch(ctx context.Context, id uuid.UUID, version int64, patch services.CustomerPatch) (viewmodels.CustomerViewModel, error) {
+	access := policy.FromContext(ctx)
+	for _, field := range patch.Fields() {
+		if access.Hides(field) {
+			return viewmodels.CustomerViewModel{}, &policy.HiddenFieldError{Field: field}
+		}
+	}
+	result, err := s.ICustomerService.Patch(ctx, id, version, patch)
+	return visibleCustomer(ctx, result), visibleError(ctx, err)
+}
+
+func (s visibleCustomerService) Restore(ctx context.Context, id uuid.UUID, version int64) (viewmodels.CustomerViewModel, error) {
+	result, err := s.ICustomerService.Restore(ctx, id, version)
+	return visibleCustomer(ctx, result), visibleError(ctx, err)
+}
+
+// Batch refuses creates and updates that set fields the caller may not see,
+// and keeps those fields on updates like Update
+func (s visibleCustomerService) Batch(ctx context.Context, operations []services.CustomerOperation, atomic bool) ([]services.CustomerOperationResult, error) {
+	operations = slices.Clone(operations)
+	for i, operation := range operations {
+		switch operation.Kind {
+		case services.OperationCreate:
+			if err := policy.FromContext(ctx).CheckWrite(operation.Customer); err != nil {
+				return nil, err
+			}
+		case services.OperationUpdate:
+			customer, err := s.keepHidden(ctx, operation.ID, operation.Customer)
+			if err != nil {
+				return nil, err
+			}
+			operations[i].Customer = customer
+		}
+	}
+	results, err := s.ICustomerService.Batch(ctx, operations, atomic)
+	return visibleResults(ctx, results), err
+}
+
+// Import refuses files whose customers set fields the caller may not see
+func (s visibleCustomerService) Import(ctx context.Context, customers []viewmodels.CustomerCreateViewModel, dryRun bool) ([]services.CustomerOperationResult, error) {
+	for _, customer := range customers {
+		if err := policy.FromContext(ctx).CheckWrite(createEdit(customer)); err != nil {
+			return nil, err
+		}
+	}
+	results, err := s.ICustomerService.Import(ctx, customers, dryRun)
+	return visibleResults(ctx, results), err
+}
+
+func (s visibleCustomerService) GetHistory(ctx context.Context, id uuid.UUID, offset int, limit int) (viewmodels.CustomerHistoryPageViewModel, error) {
+	page, err := s.ICustomerService.GetHistory(ctx, id, offset, limit)
+	access := policy.FromContext(ctx)
+	for i := range page.Items {
+		page.Items[i].Changes = access.Changes(page.Items[i].Changes)
+	}
+	return page, err
+}
+
+func (s visibleCustomerService) GetAsOf(ctx context.Context, id uuid.UUID, at time.Time) (*viewmodels.CustomerViewModel, error) {
+	customer, err := s.ICustomerService.GetAsOf(ctx, id, at)
+	return visibleCustomerPointer(ctx, customer), err
+}
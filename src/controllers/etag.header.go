@@ -0,0 +1,95 @@
+package controllers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"congdinh.com/crm/services"
+	"github.com/google/uuid"
+)
+
+// errETagMismatch is returned when none of the If-Match tags is the current ETag
+var errETagMismatch = errors.New("If-Match does not match the current ETag")
+
+// varyHeader lists the request headers a customer response depends on: the
+// media type, and the credentials that decide which fields the caller sees
+const varyHeader = "Accept, Authorization, " + APIKeyHeader
+
+// versionETag is the strong ETag of a customer at version, which If-Match
+// compares against
+func versionETag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// contentETag is a weak ETag derived from a JSON body and the media type it
+// is sent as, so every representation has its own tag
+func contentETag(mediaType string, body []byte) string {
+	sum := sha256.Sum256(append([]byte(mediaType+"\n"), body...))
+	return `W/"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// splitETags splits an If-Match or If-None-Match header into its entity tags
+func splitETags(header string) []string {
+	tags := []string{}
+	for _, tag := range strings.Split(header, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// noneMatch reports whether the If-None-Match header rules out etag, i.e. the
+// client already has this representation. It uses the weak comparison.
+func noneMatch(r *http.Request, etag string) bool {
+	current := strings.TrimPrefix(etag, "W/")
+	for _, tag := range splitETags(r.Header.Get("If-None-Match")) {
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == current {
+			return true
+		}
+	}
+	return false
+}
+
+// expectedVersion returns the customer version required by the If-Match
+// header, or 0 when the header is absent or "*". If-Match uses the strong
+// comparison, so weak tags never match. A list of several tags is resolved
+// against the current version of the customer.
+func (cc *CustomerController) expectedVersion(r *http.Request, id uuid.UUID) (int64, error) {
+	tags := splitETags(r.Header.Get("If-Match"))
+	if len(tags) == 0 || slices.Contains(tags, "*") {
+		return 0, nil
+	}
+
+	versions := []int64{}
+	for _, tag := range tags {
+		value, ok := strings.CutPrefix(tag, `"`)
+		value, ok2 := strings.CutSuffix(value, `"`)
+		if !ok || !ok2 {
+			continue
+		}
+		if version, err := strconv.ParseInt(value, 10, 64); err == nil && version > 0 {
+			versions = append(versions, version)
+		}
+	}
+	if len(versions) == 1 {
+		return versions[0], nil
+	}
+
+	customer, err := cc.ICustomerService.GetById(r.Context(), id)
+	if err != nil {
+		return 0, err
+	}
+	if customer == nil {
+		return 0, &services.NotFoundError{ID: id}
+	}
+	if !slices.Contains(versions, customer.Version) {
+		return 0, errETagMismatch
+	}
+	return customer.Version, nil
+}
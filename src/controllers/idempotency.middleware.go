@@ -0,0 +1,116 @@
+package controllers
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"congdinh.com/crm/auth"
+	"congdinh.com/crm/idempotency"
+	viewmodels "congdinh.com/crm/view-models"
+)
+
+// maxIdempotencyKeyLength bounds the Idempotency-Key header
+const maxIdempotencyKeyLength = 255
+
+// responseRecorder passes a response through while keeping a copy for replay
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rr *responseRecorder) WriteHeader(status int) {
+	rr.status = status
+	rr.ResponseWriter.WriteHeader(status)
+}
+
+func (rr *responseRecorder) Write(b []byte) (int, error) {
+	if rr.status == 0 {
+		rr.status = http.StatusOK
+	}
+	rr.body.Write(b)
+	return rr.ResponseWriter.Write(b)
+}
+
+// idempotent makes next safe to retry with the same Idempotency-Key header.
+// The first response below 500 is stored and replayed to later requests with
+// the key and the same body; reusing the key with another body returns 422.
+// Requests without the header run as usual.
+func idempotent(store *idempotency.ResponseStore, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" || store == nil {
+			next(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			writeProblem(w, r, http.StatusBadRequest, "Idempotency-Key must be at most "+strconv.Itoa(maxIdempotencyKeyLength)+" characters")
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeProblem(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		// Keys are per caller, so one cannot replay another's response
+		scope := auth.FromContext(r.Context()).Subject + " " + r.Method + " " + r.URL.Path + " " + key
+		stored, err := store.Begin(scope, idempotency.Fingerprint(r.Method, r.URL.Path, body))
+		switch {
+		case errors.Is(err, idempotency.ErrFingerprintMismatch):
+			writeProblemResponse(w, r, viewmodels.ProblemViewModel{
+				Type:   problemTypeIdempotency,
+				Title:  "Idempotency key reused",
+				Status: http.StatusUnprocessableEntity,
+				Detail: err.Error(),
+			})
+			return
+		case errors.Is(err, idempotency.ErrInProgress):
+			w.Header().Set("Retry-After", "1")
+			writeProblemResponse(w, r, viewmodels.ProblemViewModel{
+				Type:   problemTypeIdempotency,
+				Title:  "Request in progress",
+				Status: http.StatusConflict,
+				Detail: err.Error(),
+			})
+			return
+		case stored != nil:
+			for name, values := range stored.Header {
+				w.Header()[name] = values
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(stored.Status)
+			w.Write(stored.Body)
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: w}
+		completed := false
+		defer func() {
+			// Server errors and panics leave the key free for a retry
+			if !completed {
+				store.Release(scope)
+			}
+		}()
+
+		next(recorder, r)
+
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+		if recorder.status >= http.StatusInternalServerError {
+			return
+		}
+		store.Complete(scope, idempotency.Response{
+			Status: recorder.status,
+			Header: w.Header().Clone(),
+			Body:   recorder.body.Bytes(),
+		})
+		completed = true
+	}
+}
@@ -0,0 +1,151 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"congdinh.com/crm/policy"
+	"congdinh.com/crm/services"
+	viewmodels "congdinh.com/crm/view-models"
+)
+
+// Problem types of the domain errors. Other failures use about:blank,
+// whose title is the HTTP status text.
+const (
+	problemTypeNotFound    = "/problems/not-found"
+	problemTypeConflict    = "/problems/conflict"
+	problemTypeValidation  = "/problems/validation"
+	problemTypeBadPatch    = "/problems/invalid-patch"
+	problemTypeStale       = "/problems/precondition-failed"
+	problemTypeIdempotency = "/problems/idempotency-key"
+	problemTypeNotApplied  = "/problems/not-applied"
+	problemTypeDependency  = "/problems/dependency-failed"
+)
+
+// writeProblemResponse writes problem as application/problem+json
+func writeProblemResponse(w http.ResponseWriter, r *http.Request, problem viewmodels.ProblemViewModel) {
+	if problem.Type == "" {
+		problem.Type = "about:blank"
+	}
+	if problem.Title == "" {
+		problem.Title = http.StatusText(problem.Status)
+	}
+	problem.Instance = r.URL.Path
+
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(problem.Status)
+	json.NewEncoder(w).Encode(problem)
+}
+
+// writeProblem responds with a generic problem for status
+func writeProblem(w http.ResponseWriter, r *http.Request, status int, detail string) {
+	writeProblemResponse(w, r, genericProblem(status, detail))
+}
+
+// writeError maps err to its problem response
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	writeProblemResponse(w, r, problemFor(r, err))
+}
+
+// problemFor maps err to its problem. Errors the API does not know are
+// logged and reported as 500 without leaking their text.
+func problemFor(r *http.Request, err error) viewmodels.ProblemViewModel {
+	var (
+		notFound   *services.NotFoundError
+		conflict   *services.ConflictError
+		validation *services.ValidationError
+		badPatch   *services.PatchError
+		stale      *services.PreconditionFailedError
+		notApplied *services.NotAppliedError
+		dependency *services.DependencyFailedError
+		noWebhook  *services.WebhookNotFoundError
+		noAPIKey   *services.APIKeyNotFoundError
+		hidden     *policy.HiddenFieldError
+	)
+
+	switch {
+	case errors.As(err, &validation):
+		return viewmodels.ProblemViewModel{
+			Type:   problemTypeValidation,
+			Title:  "Validation failed",
+			Status: http.StatusUnprocessableEntity,
+			Detail: "One or more fields are invalid",
+			Errors: validation.Errors,
+		}
+	case errors.As(err, &notFound):
+		return viewmodels.ProblemViewModel{
+			Type:   problemTypeNotFound,
+			Title:  "Customer not found",
+			Status: http.StatusNotFound,
+			Detail: notFound.Error(),
+		}
+	case errors.As(err, &noWebhook):
+		return viewmodels.ProblemViewModel{
+			Type:   problemTypeNotFound,
+			Title:  "Webhook not found",
+			Status: http.StatusNotFound,
+			Detail: noWebhook.Error(),
+		}
+	case errors.As(err, &noAPIKey):
+		return viewmodels.ProblemViewModel{
+			Type:   problemTypeNotFound,
+			Title:  "API key not found",
+			Status: http.StatusNotFound,
+			Detail: noAPIKey.Error(),
+		}
+	case errors.As(err, &conflict):
+		return viewmodels.ProblemViewModel{
+			Type:   problemTypeConflict,
+			Title:  "Conflict",
+			Status: http.StatusConflict,
+			Detail: conflict.Error(),
+		}
+	case errors.As(err, &badPatch):
+		return viewmodels.ProblemViewModel{
+			Type:   problemTypeBadPatch,
+			Title:  "Patch could not be applied",
+			Status: http.StatusUnprocessableEntity,
+			Detail: badPatch.Error(),
+		}
+	case errors.As(err, &stale), errors.Is(err, errETagMismatch):
+		return viewmodels.ProblemViewModel{
+			Type:   problemTypeStale,
+			Title:  "Precondition failed",
+			Status: http.StatusPreconditionFailed,
+			Detail: err.Error(),
+		}
+	case errors.As(err, &notApplied):
+		return viewmodels.ProblemViewModel{
+			Type:   problemTypeNotApplied,
+			Title:  "Not applied",
+			Status: http.StatusFailedDependency,
+			Detail: notApplied.Error(),
+		}
+	case errors.As(err, &dependency):
+		return viewmodels.ProblemViewModel{
+			Type:   problemTypeDependency,
+			Title:  "Dependency failed",
+			Status: http.StatusFailedDependency,
+			Detail: dependency.Error(),
+		}
+	case errors.As(err, &hidden):
+		return genericProblem(http.StatusForbidden, "The roles of the caller hide the "+hidden.Field+" field")
+	case errors.Is(err, services.ErrInvalidCursor):
+		return genericProblem(http.StatusBadRequest, err.Error())
+	}
+
+	log.Printf("%s %s: %v", r.Method, r.URL.Path, err)
+	return genericProblem(http.StatusInternalServerError, "")
+}
+
+// genericProblem is the about:blank problem for status
+func genericProblem(status int, detail string) viewmodels.ProblemViewModel {
+	return viewmodels.ProblemViewModel{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	}
+}
@@ -0,0 +1,267 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"congdinh.com/crm/models"
+	"congdinh.com/crm/services"
+	viewmodels "congdinh.com/crm/view-models"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+type WebhookController struct {
+	IWebhookService services.IWebhookService
+}
+
+// NewWebhookController creates a new webhook controller
+func NewWebhookController(webhookService services.IWebhookService) *WebhookController {
+	return &WebhookController{IWebhookService: webhookService}
+}
+
+// RegisterRoutes registers the routes for the webhook controller on the
+// /api/v1 router
+func (wc *WebhookController) RegisterRoutes(router *mux.Router) {
+	webhooks := router.PathPrefix("/webhooks").Subrouter()
+
+	webhooks.HandleFunc("", wc.GetWebhooks).Methods("GET").Name("webhooks.list")
+	webhooks.HandleFunc("", wc.CreateWebhook).Methods("POST").Name("webhooks.create")
+	webhooks.HandleFunc("/dead-letters", wc.GetDeadLetters).Methods("GET").Name("webhooks.dead-letters")
+	// Registered before the /{id} routes: a path mismatch after a method
+	// mismatch makes mux answer 404 instead of 405
+	webhooks.HandleFunc("/{id}/deliveries", wc.GetWebhookDeliveries).Methods("GET").Name("webhooks.deliveries")
+	webhooks.HandleFunc("/{id}", wc.GetWebhook).Methods("GET").Name("webhooks.get")
+	webhooks.HandleFunc("/{id}", wc.UpdateWebhook).Methods("PUT").Name("webhooks.update")
+	webhooks.HandleFunc("/{id}", wc.DeleteWebhook).Methods("DELETE").Name("webhooks.delete")
+
+	webhooks.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeProblem(w, r, http.StatusNotFound, "")
+	})
+	webhooks.MethodNotAllowedHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeProblem(w, r, http.StatusMethodNotAllowed, "")
+	})
+}
+
+// writeJSON responds with status and value as JSON
+func writeJSON(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+// parseLimit reads the limit parameter, defaulting to defaultPageSize
+func parseLimit(r *http.Request) (int, error) {
+	value := r.URL.Query().Get("limit")
+	if value == "" {
+		return defaultPageSize, nil
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 1 || limit > maxPageSize {
+		return 0, fmt.Errorf("limit must be a number between 1 and %d", maxPageSize)
+	}
+	return limit, nil
+}
+
+// GetWebhooks godoc
+// @Summary Show all webhooks
+// @Description list every webhook in creation order. Secrets are never listed.
+// @Tags webhooks
+// @Produce  json
+// @Success 200 {array} viewmodels.WebhookViewModel
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /webhooks [get]
+func (wc *WebhookController) GetWebhooks(w http.ResponseWriter, r *http.Request) {
+	webhooks, err := wc.IWebhookService.List(r.Context())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, webhooks)
+}
+
+// GetWebhook godoc
+// @Summary Show a webhook
+// @Description get a webhook by ID, without its secret
+// @Tags webhooks
+// @Produce  json
+// @Param id path string true "Webhook ID"
+// @Success 200 {object} viewmodels.WebhookViewModel
+// @Failure 400 {object} viewmodels.ProblemViewModel "Bad Request"
+// @Failure 404 {object} viewmodels.ProblemViewModel "Webhook not found"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /webhooks/{id} [get]
+func (wc *WebhookController) GetWebhook(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, "Invalid webhook ID")
+		return
+	}
+
+	webhook, err := wc.IWebhookService.Get(r.Context(), id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, webhook)
+}
+
+// CreateWebhook godoc
+// @Summary Create a webhook
+// @Description subscribe a URL to customer events: customer.created, customer.updated, customer.contacted, customer.deleted and customer.restored. Deliveries are signed with the secret, which is generated when none is given and only returned here.
+// @Tags webhooks
+// @Accept  json
+// @Produce  json
+// @Param   webhook  body viewmodels.WebhookEditViewModel  true  "Add Webhook"
+// @Success 201  {object}  viewmodels.WebhookViewModel  "Successfully created"
+// @Failure 400  {object}  viewmodels.ProblemViewModel  "Bad Request"
+// @Failure 422  {object}  viewmodels.ProblemViewModel  "Validation failed"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /webhooks [post]
+func (wc *WebhookController) CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	var webhook viewmodels.WebhookEditViewModel
+	if err := json.NewDecoder(r.Body).Decode(&webhook); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, "Malformed JSON body: "+err.Error())
+		return
+	}
+
+	result, err := wc.IWebhookService.Create(r.Context(), webhook)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, result)
+}
+
+// UpdateWebhook godoc
+// @Summary Update a webhook
+// @Description replace the URL, events and active flag of a webhook. A new secret is used from the next delivery on; without one the secret is kept. An inactive webhook gets no new deliveries.
+// @Tags webhooks
+// @Accept  json
+// @Produce  json
+// @Param   id       path  string                           true  "Webhook ID"
+// @Param   webhook  body  viewmodels.WebhookEditViewModel  true  "Update Webhook"
+// @Success 200  {object}  viewmodels.WebhookViewModel  "Successfully updated"
+// @Failure 400  {object}  viewmodels.ProblemViewModel  "Bad Request"
+// @Failure 404  {object}  viewmodels.ProblemViewModel  "Webhook not found"
+// @Failure 422  {object}  viewmodels.ProblemViewModel  "Validation failed"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /webhooks/{id} [put]
+func (wc *WebhookController) UpdateWebhook(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, "Invalid webhook ID")
+		return
+	}
+
+	var webhook viewmodels.WebhookEditViewModel
+	if err := json.NewDecoder(r.Body).Decode(&webhook); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, "Malformed JSON body: "+err.Error())
+		return
+	}
+
+	result, err := wc.IWebhookService.Update(r.Context(), id, webhook)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// DeleteWebhook godoc
+// @Summary Delete a webhook
+// @Description delete a webhook by ID together with its deliveries, including the pending ones
+// @Tags webhooks
+// @Param   id   path      string  true  "Webhook ID"
+// @Success 204  "Successfully deleted"
+// @Failure 400  {object}  viewmodels.ProblemViewModel  "Bad Request"
+// @Failure 404  {object}  viewmodels.ProblemViewModel  "Webhook not found"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /webhooks/{id} [delete]
+func (wc *WebhookController) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, "Invalid webhook ID")
+		return
+	}
+
+	if err := wc.IWebhookService.Delete(r.Context(), id); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetWebhookDeliveries godoc
+// @Summary Show the deliveries of a webhook
+// @Description list the deliveries of a webhook, newest first, with the payload and every attempt: its time, response status, error and duration
+// @Tags webhooks
+// @Produce  json
+// @Param id path string true "Webhook ID"
+// @Param status query string false "Only deliveries with this status: pending, delivered or dead"
+// @Param limit query int false "Number of deliveries (1-1000, default 100)"
+// @Success 200 {array} viewmodels.WebhookDeliveryViewModel
+// @Failure 400 {object} viewmodels.ProblemViewModel "Bad Request"
+// @Failure 404 {object} viewmodels.ProblemViewModel "Webhook not found"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /webhooks/{id}/deliveries [get]
+func (wc *WebhookController) GetWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, "Invalid webhook ID")
+		return
+	}
+	limit, err := parseLimit(r)
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	status := models.DeliveryStatus(r.URL.Query().Get("status"))
+	switch status {
+	case "", models.DeliveryPending, models.DeliveryDelivered, models.DeliveryDead:
+	default:
+		writeProblem(w, r, http.StatusBadRequest, "status must be pending, delivered or dead")
+		return
+	}
+
+	deliveries, err := wc.IWebhookService.Deliveries(r.Context(), id, status, limit)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, deliveries)
+}
+
+// GetDeadLetters godoc
+// @Summary Show the dead letters
+// @Description list the deliveries of every webhook that failed on every attempt, newest first, with their attempts
+// @Tags webhooks
+// @Produce  json
+// @Param limit query int false "Number of deliveries (1-1000, default 100)"
+// @Success 200 {array} viewmodels.WebhookDeliveryViewModel
+// @Failure 400 {object} viewmodels.ProblemViewModel "Bad Request"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /webhooks/dead-letters [get]
+func (wc *WebhookController) GetDeadLetters(w http.ResponseWriter, r *http.Request) {
+	limit, err := parseLimit(r)
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	deliveries, err := wc.IWebhookService.DeadLetters(r.Context(), limit)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, deliveries)
+}
@@ -0,0 +1,105 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"congdinh.com/crm/repositories"
+	"congdinh.com/crm/services"
+	viewmodels "congdinh.com/crm/view-models"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+func TestWebhookController_Webhooks(t *testing.T) {
+	customerService := newCustomerService(t)
+	webhookService := services.NewWebhookService(repositories.NewMemoryWebhookRepository())
+	webhookService.MaxAttempts = 1
+	webhookService.History = customerService.History.(repositories.ICustomerHistoryLog)
+	customerService.Subscribe(webhookService.NotifyCustomerEvent)
+	router := mux.NewRouter()
+	NewWebhookController(webhookService).RegisterRoutes(router.PathPrefix("/api/v1").Subrouter())
+
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusGone)
+	}))
+	defer receiver.Close()
+
+	serve := func(method string, path string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := serve("POST", "/api/v1/webhooks", `{"URL": "`+receiver.URL+`", "Events": ["customer.created"]}`)
+	var created viewmodels.WebhookViewModel
+	json.NewDecoder(rr.Body).Decode(&created)
+	if rr.Code != http.StatusCreated || created.Secret == "" || !created.Active {
+		t.Fatalf("Expected the created webhook with its secret, but got %d %v", rr.Code, created)
+	}
+	webhookPath := "/api/v1/webhooks/" + created.ID.String()
+
+	rr = serve("GET", webhookPath, "")
+	if rr.Code != http.StatusOK || strings.Contains(rr.Body.String(), created.Secret) {
+		t.Errorf("Expected the webhook without its secret, but got %d %s", rr.Code, rr.Body.String())
+	}
+	rr = serve("PUT", webhookPath, `{"URL": "`+receiver.URL+`/v2", "Events": ["customer.created", "customer.deleted"]}`)
+	var updated viewmodels.WebhookViewModel
+	json.NewDecoder(rr.Body).Decode(&updated)
+	if rr.Code != http.StatusOK || len(updated.Events) != 2 || updated.Secret != "" {
+		t.Errorf("Expected the updated webhook, but got %d %v", rr.Code, updated)
+	}
+	rr = serve("GET", "/api/v1/webhooks", "")
+	var webhooks []viewmodels.WebhookViewModel
+	json.NewDecoder(rr.Body).Decode(&webhooks)
+	if rr.Code != http.StatusOK || len(webhooks) != 1 {
+		t.Errorf("Expected the list of one webhook, but got %d %v", rr.Code, webhooks)
+	}
+
+	customerService.Create(t.Context(), viewmodels.CustomerCreateViewModel{Name: "Lead", Role: "Sales", Email: "lead@domain.com", Phone: "5550009301"})
+	webhookService.DeliverDue(t.Context())
+
+	rr = serve("GET", webhookPath+"/deliveries?status=dead", "")
+	var deliveries []viewmodels.WebhookDeliveryViewModel
+	json.NewDecoder(rr.Body).Decode(&deliveries)
+	if rr.Code != http.StatusOK || len(deliveries) != 1 || deliveries[0].Event != "customer.created" || len(deliveries[0].Attempts) != 1 || deliveries[0].Attempts[0].StatusCode != http.StatusGone {
+		t.Fatalf("Expected the failed delivery with its attempt, but got %d %v", rr.Code, deliveries)
+	}
+	rr = serve("GET", "/api/v1/webhooks/dead-letters", "")
+	var deadLetters []viewmodels.WebhookDeliveryViewModel
+	json.NewDecoder(rr.Body).Decode(&deadLetters)
+	if rr.Code != http.StatusOK || len(deadLetters) != 1 || deadLetters[0].ID != deliveries[0].ID {
+		t.Errorf("Expected the dead letter, but got %d %v", rr.Code, deadLetters)
+	}
+
+	problems := []struct {
+		method, path, body string
+		status             int
+	}{
+		{"POST", "/api/v1/webhooks", `{"URL": "not a url", "Events": ["customer.created"]}`, http.StatusUnprocessableEntity},
+		{"POST", "/api/v1/webhooks", `{"URL":`, http.StatusBadRequest},
+		{"GET", "/api/v1/webhooks/abc", "", http.StatusBadRequest},
+		{"GET", webhookPath + "/deliveries?status=lost", "", http.StatusBadRequest},
+		{"GET", "/api/v1/webhooks/dead-letters?limit=0", "", http.StatusBadRequest},
+		{"GET", "/api/v1/webhooks/" + uuid.NewString(), "", http.StatusNotFound},
+		{"GET", "/api/v1/webhooks/" + uuid.NewString() + "/deliveries", "", http.StatusNotFound},
+		{"PATCH", webhookPath, "{}", http.StatusMethodNotAllowed},
+	}
+	for _, problem := range problems {
+		if rr := serve(problem.method, problem.path, problem.body); rr.Code != problem.status || rr.Header().Get("Content-Type") != "application/problem+json" {
+			t.Errorf("Expected status code %d for %s %s, but got %d", problem.status, problem.method, problem.path, rr.Code)
+		}
+	}
+
+	if rr := serve("DELETE", webhookPath, ""); rr.Code != http.StatusNoContent {
+		t.Errorf("Expected status code %d, but got %d", http.StatusNoContent, rr.Code)
+	}
+	if rr := serve("GET", webhookPath, ""); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d after the delete, but got %d", http.StatusNotFound, rr.Code)
+	}
+}
@@ -15,33 +15,58 @@ const docTemplate = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
-        "/customers": {
+        "/api-keys": {
             "get": {
-                "description": "get customers",
-                "consumes": [
-                    "application/json"
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
                 ],
+                "description": "list every API key in creation order, revoked ones included, with when each was last used. The keys themselves are never listed. Needs the admin scope.",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "customers"
+                    "api-keys"
                 ],
-                "summary": "Show a list of customers",
+                "summary": "Show all API keys",
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
                             "type": "array",
                             "items": {
-                                "$ref": "#/definitions/viewmodels.CustomerViewModel"
+                                "$ref": "#/definitions/viewmodels.APIKeyViewModel"
                             }
                         }
+                    },
+                    "401": {
+                        "description": "No valid API key or token",
+                        "schema": {
+                            "$ref": "#/definitions/viewmodels.ProblemViewModel"
+                        }
+                    },
+                    "403": {
+                        "description": "The caller lacks the admin scope",
+                        "schema": {
+                            "$ref": "#/definitions/viewmodels.ProblemViewModel"
+                        }
                     }
                 }
             },
             "post": {
-                "description": "add by json customer",
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "create an API key with scopes read (GET requests), write (every customer and webhook request) or admin (also managing API keys). The key is only returned here; store it safely. Needs the admin scope.",
                 "consumes": [
                     "application/json"
                 ],
@@ -49,17 +74,17 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "customers"
+                    "api-keys"
                 ],
-                "summary": "Create a new customer",
+                "summary": "Create an API key",
                 "parameters": [
                     {
-                        "description": "Add Customer",
-                        "name": "customer",
+                        "description": "Add API key",
+                        "name": "key",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/viewmodels.CustomerCreateViewModel"
+                            "$ref": "#/definitions/viewmodels.APIKeyCreateViewModel"
                         }
                     }
                 ],
@@ -67,32 +92,58 @@ const docTemplate = `{
                     "201": {
                         "description": "Successfully created",
                         "schema": {
-                            "$ref": "#/definitions/viewmodels.CustomerViewModel"
+                            "$ref": "#/definitions/viewmodels.APIKeyViewModel"
                         }
                     },
                     "400": {
-                        "description": "Bad Request"
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/viewmodels.ProblemViewModel"
+                        }
+                    },
+                    "401": {
+                        "description": "No valid API key or token",
+                        "schema": {
+                            "$ref": "#/definitions/viewmodels.ProblemViewModel"
+                        }
+                    },
+                    "403": {
+                        "description": "The caller lacks the admin scope",
+                        "schema": {
+                            "$ref": "#/definitions/viewmodels.ProblemViewModel"
+                        }
+                    },
+                    "422": {
+                        "description": "Validation failed",
+                        "schema": {
+                            "$ref": "#/definitions/viewmodels.ProblemViewModel"
+                        }
                     }
                 }
             }
         },
-        "/customers/{id}": {
+        "/api-keys/{id}": {
             "get": {
-                "description": "get customer by ID",
-                "consumes": [
-                    "application/json"
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
                 ],
+                "description": "get an API key by ID, without the key itself. Needs the admin scope.",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "customers"
+                    "api-keys"
                 ],
-                "summary": "Show a customer",
+                "summary": "Show an API key",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Customer ID",
+                        "description": "API key ID",
                         "name": "id",
                         "in": "path",
                         "required": true
@@ -102,55 +153,293 @@ const docTemplate = `{
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/viewmodels.CustomerViewModel"
+                            "$ref": "#/definitions/viewmodels.APIKeyViewModel"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/viewmodels.ProblemViewModel"
+                        }
+                    },
+                    "401": {
+                        "description": "No valid API key or token",
+                        "schema": {
+                            "$ref": "#/definitions/viewmodels.ProblemViewModel"
+                        }
+                    },
+                    "403": {
+                        "description": "The caller lacks the admin scope",
+                        "schema": {
+                            "$ref": "#/definitions/viewmodels.ProblemViewModel"
+                        }
+                    },
+                    "404": {
+                        "description": "API key not found",
+                        "schema": {
+                            "$ref": "#/definitions/viewmodels.ProblemViewModel"
                         }
                     }
                 }
             },
-            "put": {
-                "description": "update by json customer",
-                "consumes": [
-                    "application/json"
+            "delete": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "stop an API key from working right away, including the key a rotation replaced. The key stays listed with its RevokedAt. Needs the admin scope.",
+                "tags": [
+                    "api-keys"
+                ],
+                "summary": "Revoke an API key",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "API key ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "Successfully revoked"
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/viewmodels.ProblemViewModel"
+                        }
+                    },
+                    "401": {
+                        "description": "No valid API key or token",
+                        "schema": {
+                            "$ref": "#/definitions/viewmodels.ProblemViewModel"
+                        }
+                    },
+                    "403": {
+                        "description": "The caller lacks the admin scope",
+                        "schema": {
+                            "$ref": "#/definitions/viewmodels.ProblemViewModel"
+                        }
+                    },
+                    "404": {
+                        "description": "API key not found",
+                        "schema": {
+                            "$ref": "#/definitions/viewmodels.ProblemViewModel"
+                        }
+                    }
+                }
+            }
+        },
+        "/api-keys/{id}/rotate": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
                 ],
+                "description": "replace the key of an API key with a new one, returned only here. The replaced key keeps working for a grace period (CRM_API_KEY_ROTATION_GRACE), so clients can switch over. Needs the admin scope.",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "customers"
+                    "api-keys"
                 ],
-                "summary": "Update an existing customer",
+                "summary": "Rotate an API key",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Customer ID",
+                        "description": "API key ID",
                         "name": "id",
                         "in": "path",
                         "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/viewmodels.APIKeyViewModel"
+                        }
                     },
-                    {
-                        "description": "Update Customer",
-                        "name": "customer",
-                        "in": "body",
-                        "required": true,
+                    "400": {
+                        "description": "Bad Request",
                         "schema": {
-                            "$ref": "#/definitions/viewmodels.CustomerEditViewModel"
+                            "$ref": "#/definitions/viewmodels.ProblemViewModel"
+                        }
+                    },
+                    "401": {
+                        "description": "No valid API key or token",
+                        "schema": {
+                            "$ref": "#/definitions/viewmodels.ProblemViewModel"
+                        }
+                    },
+                    "403": {
+                        "description": "The caller lacks the admin scope",
+                        "schema": {
+                            "$ref": "#/definitions/viewmodels.ProblemViewModel"
+                        }
+                    },
+                    "404": {
+                        "description": "API key not found",
+                        "schema": {
+                            "$ref": "#/definitions/viewmodels.ProblemViewModel"
+                        }
+                    },
+                    "409": {
+                        "description": "The API key is revoked",
+                        "schema": {
+                            "$ref": "#/definitions/viewmodels.ProblemViewModel"
                         }
                     }
+                }
+            }
+        },
+        "/customers": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "get customers, optionally filtered, sorted and paginated. The total number of matches is returned in X-Total-Count and the next page in the Link header. The Accept header selects JSON (default), NDJSON streamed one customer per line, CSV or XML.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json",
+                    "application/x-ndjson",
+                    "text/csv",
+                    "application/xml"
+                ],
+                "tags": [
+                    "customers"
+                ],
+                "summary": "Show a list of customers",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Page size (1-1000, default 100)",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Number of customers to skip",
+                        "name": "offset",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Opaque cursor from a previous Link header",
+                        "name": "cursor",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Comma separated fields, prefix with - for descending, e.g. name,-email",
+                        "name": "sort",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Filter by role",
+                        "name": "role",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Filter by contacted",
+                        "name": "contacted",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Filter by name prefix",
+                        "name": "name_prefix",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Filter by email prefix",
+                        "name": "email_prefix",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only customers changed at or after this RFC 3339 time",
+                        "name": "updated_since",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "ETag of a cached page",
+                        "name": "If-None-Match",
+                        "in": "header"
+                    }
                 ],
                 "responses": {
                     "200": {
-                        "description": "Successfully updated",
+                        "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/viewmodels.CustomerViewModel"
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/viewmodels.CustomerViewModel"
+                            }
+                        },
+                        "headers": {
+                            "ETag": {
+                                "type": "string",
+                                "description": "Weak ETag of the page"
+                            },
+                            "Link": {
+                                "type": "string",
+                                "description": "Links to the next and previous pages"
+                            },
+                            "X-Total-Count": {
+                                "type": "integer",
+                                "description": "Number of customers matching the filters"
+                            }
                         }
                     },
+                    "304": {
+                        "description": "Not Modified"
+                    },
                     "400": {
-                        "description": "Bad Request"
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/viewmodels.ProblemViewModel"
+                        }
+                    },
+                    "406": {
+                        "description": "None of the accepted media types is supported",
+                        "schema": {
+                            "$ref": "#/definitions/viewmodels.ProblemViewModel"
+                        }
                     }
                 }
             },
-            "delete": {
-                "description": "delete by customer ID",
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "add by json customer",
                 "consumes": [
                     "application/json"
                 ],
@@ -160,68 +449,1795 @@ const docTemplate = `{
                 "tags": [
                     "customers"
                 ],
-                "summary": "Delete a customer",
+                "summary": "Create a new customer",
                 "parameters": [
+                    {
+                        "description": "Add Customer",
+                        "name": "customer",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/viewmodels.CustomerCreateViewModel"
+                        }
+                    },
                     {
                         "type": "string",
-                        "description": "Customer ID",
-                        "name": "id",
-                        "in": "path",
-                        "required": true
+                        "description": "Unique key that makes retries return the original response",
+                        "name": "Idempotency-Key",
+                        "in": "header"
                     }
                 ],
                 "responses": {
-                    "204": {
-                        "description": "Successfully deleted"
+                    "201": {
+                        "description": "Successfully created",
+                        "schema": {
+                            "$ref": "#/definitions/viewmodels.CustomerViewModel"
+                        },
+                        "headers": {
+                            "ETag": {
+                                "type": "string",
+                                "description": "Strong ETag of the new customer"
+                            }
+                        }
                     },
                     "400": {
-                        "description": "Bad Request"
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/viewmodels.ProblemViewModel"
+                        }
+                    },
+                    "409": {
+                        "description": "Email or phone already exists, or a request with the same Idempotency-Key is in progress",
+                        "schema": {
+                            "$ref": "#/definitions/viewmodels.ProblemViewModel"
+                        }
+                    },
+                    "422": {
+                        "description": "Validation failed, or Idempotency-Key reused with a different body",
+                        "schema": {
+                            "$ref": "#/definitions/viewmodels.ProblemViewModel"
+                        }
                     }
                 }
             }
-        }
-    },
-    "definitions": {
-        "viewmodels.CustomerCreateViewModel": {
-            "type": "object",
-            "properties": {
-                "contacted": {
-                    "type": "boolean"
-                },
-                "email": {
-                    "type": "string"
-                },
-                "name": {
-                    "type": "string"
-                },
-                "phone": {
-                    "type": "string"
-                },
-                "role": {
-                    "type": "string"
+        },
+        "/customers/events": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "stream every created, updated, deleted and restored customer as server-sent events. Each event has an id, the type as event name and a CustomerEventViewModel as data. A client that reconnects with the Last-Event-ID header gets the events it missed while they are still buffered; otherwise it first gets a reset event and should reload the customers. Idle streams send a heartbeat comment.",
+                "produces": [
+                    "text/event-stream"
+                ],
+                "tags": [
+                    "customers"
+                ],
+                "summary": "Stream customer changes",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Comma-separated event types to stream (created, updated, deleted, restored), default all",
+                        "name": "type",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Ticket from POST /customers/stream-tickets, for clients that cannot set headers",
+                        "name": "ticket",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "ID of the last event received, to resume after it",
+                        "name": "Last-Event-ID",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/viewmodels.CustomerEventViewModel"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/viewmodels.ProblemViewModel"
+                        }
+                    }
+                }
+            }
+        },
+        "/customers/export.csv": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "stream every customer matching the filters as CSV with a header row. Accepts the filters and sort of GET /customers; limit, offset and cursor are ignored.",
+                "produces": [
+                    "text/csv"
+                ],
+                "tags": [
+                    "customers"
+                ],
+                "summary": "Export customers as CSV",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Comma separated fields, prefix with - for descending, e.g. name,-email",
+                        "name": "sort",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Filter by role",
+                        "name": "role",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Filter by contacted",
+                        "name": "contacted",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Filter by name prefix",
+                        "name": "name_prefix",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Filter by email prefix",
+                        "name": "email_prefix",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only customers changed at or after this RFC 3339 time",
+                        "name": "updated_since",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "CSV with the columns ID, Name, Role, Email, Phone, Contacted and Version",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/viewmodels.ProblemViewModel"
+                        }
+                    }
+                }
+            }
+        },
+        "/customers/import": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "create a customer per row of a CSV file with a header row. Columns are matched to the fields Name, Role, Email, Phone and Contacted by name, ignoring case; other columns are ignored. map=Column:Field maps a column with another name, e.g. map=E-mail address:Email. Rows are validated and checked for duplicate emails and phones like POST /customers, against the stored customers and the rows above them. Valid rows are created even if others fail. With dry_run=true nothing is stored.",
+                "consumes": [
+                    "text/csv"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "customers"
+                ],
+                "summary": "Import customers from CSV",
+                "parameters": [
+                    {
+                        "description": "CSV file",
+                        "name": "file",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        },
+                        "collectionFormat": "multi",
+                        "description": "Column mappings as Column:Field",
+                        "name": "map",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Check the rows without storing them",
+                        "name": "dry_run",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Per-row results",
+                        "schema": {
+                            "$ref": "#/definitions/viewmodels.CustomerImportResultViewModel"
+                        }
+                    },
+                    "400": {
+                        "description": "Malformed CSV or parameters",
+                        "schema": {
+                            "$ref": "#/definitions/viewmodels.ProblemViewModel"
+                        }
+                    },
+                    "413": {
+                        "description": "File too large",
+                        "schema": {
+                            "$ref": "#/definitions/viewmodels.ProblemViewModel"
+                        }
+                    },
+                    "415": {
+                        "description": "Not a CSV file",
+                        "schema": {
+                            "$ref": "#/definitions/viewmodels.ProblemViewModel"
+                        }
+                    },
+                    "422": {
+                        "description": "Invalid column mapping or too many rows",
+                        "schema": {
+                            "$ref": "#/definitions/viewmodels.ProblemViewModel"
+                        }
+                    }
+                }
+            }
+        },
+        "/customers/search": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "full-text search over name, email, phone and role. Matching ignores case and accents, accepts prefixes, phone number fragments and small typos. Matched text is wrapped in \u003cem\u003e tags in Highlights.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "customers"
+                ],
+                "summary": "Search customers",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Search text",
+                        "name": "q",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Maximum number of results (1-100, default 20)",
+                        "name": "limit",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/viewmodels.CustomerSearchResultViewModel"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/viewmodels.ProblemViewModel"
+                        }
+                    }
+                }
+            }
+        },
+        "/customers/stream-tickets": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "issue a short-lived, single-use ticket that authenticates one GET /customers/events or GET /customers/{id}/live request as the caller, for browsers, which cannot set headers on those. Pass it as the ticket parameter instead of an API key or token, which must not appear in URLs.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "customers"
+                ],
+                "summary": "Get a ticket for a stream",
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/viewmodels.StreamTicketViewModel"
+                        }
+                    }
+                }
+            }
+        },
+        "/customers/trash": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "get the customers in the trash, with their DeletedAt. Accepts the same filters, sorting, pagination and formats as GET /customers.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json",
+                    "application/x-ndjson",
+                    "text/csv",
+                    "application/xml"
+                ],
+                "tags": [
+                    "customers"
+                ],
+                "summary": "Show the deleted customers",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Page size (1-1000, default 100)",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Number of customers to skip",
+                        "name": "offset",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Opaque cursor from a previous Link header",
+                        "name": "cursor",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Comma separated fields, prefix with - for descending, e.g. name,-email",
+                        "name": "sort",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Filter by role",
+                        "name": "role",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Filter by contacted",
+                        "name": "contacted",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Filter by name prefix",
+                        "name": "name_prefix",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Filter by email prefix",
+                        "name": "email_prefix",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only customers changed at or after this RFC 3339 time",
+                        "name": "updated_since",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "ETag of a cached page",
+                        "name": "If-None-Match",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/viewmodels.CustomerViewModel"
+                            }
+                        },
+                        "headers": {
+                            "ETag": {
+                                "type": "string",
+                                "description": "Weak ETag of the page"
+                            },
+                            "Link": {
+                                "type": "string",
+                                "description": "Links to the next and previous pages"
+                            },
+                            "X-Total-Count": {
+                                "type": "integer",
+                                "description": "Number of deleted customers matching the filters"
+                            }
+                        }
+                    },
+                    "304": {
+                        "description": "Not Modified"
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/viewmodels.ProblemViewModel"
+                        }
+                    },
+                    "406": {
+                        "description": "None of the accepted media types is supported",
+                        "schema": {
+                            "$ref": "#/definitions/viewmodels.ProblemViewModel"
+                        }
+                    }
+                }
+            }
+        },
+        "/customers/{id}": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "get customer by ID, or as it was at the as_of time. The Accept header selects JSON (default), NDJSON, CSV or XML.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json",
+                    "application/x-ndjson",
+                    "text/csv",
+                    "application/xml"
+                ],
+                "tags": [
+                    "customers"
+                ],
+                "summary": "Show a customer",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Customer ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "RFC 3339 time to reconstruct the customer at",
+                        "name": "as_of",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "ETag of the cached customer",
+                        "name": "If-None-Match",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/viewmodels.CustomerViewModel"
+                        },
+                        "headers": {
+                            "ETag": {
+                                "type": "string",
+                                "description": "Weak ETag of the representation"
+                            }
+                        }
+                    },
+                    "304": {
+                        "description": "Not Modified"
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/viewmodels.ProblemViewModel"
+                        }
+                    },
+                    "404": {
+                        "description": "Customer not found",
+                        "schema": {
+                            "$ref": "#/definitions/viewmodels.ProblemViewModel"
+                        }
+                    },
+                    "406": {
+                        "description": "None of the accepted media types is supported",
+                        "schema": {
+                            "$ref": "#/definitions/viewmodels.ProblemViewModel"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "update by json customer",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "customers"
+                ],
+                "summary": "Update an existing customer",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Customer ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Update Customer",
+                        "name": "customer",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/viewmodels.CustomerEditViewModel"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "ETag the update is based on",
+                        "name": "If-Match",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Successfully updated",
+                        "schema": {
+                            "$ref": "#/definitions/viewmodels.CustomerViewModel"
+                        },
+                        "headers": {
+                            "ETag": {
+                                "type": "string",
+                                "description": "Strong ETag of the new version"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/viewmodels.ProblemViewModel"
+                        }
+                    },
+                    "404": {
+                        "description": "Customer not found",
+                        "schema": {
+                            "$ref": "#/definitions/viewmodels.ProblemViewModel"
+                        }
+                    },
+                    "409": {
+                        "description": "Email or phone already exists",
+                        "schema": {
+                            "$ref": "#/definitions/viewmodels.ProblemViewModel"
+                        }
+                    },
+                    "412": {
+                        "description": "If-Match does not match",
+                        "schema": {
+                            "$ref": "#/definitions/viewmodels.ProblemViewModel"
+                        }
+                    },
+                    "422": {
+                        "description": "Validation failed",
+                        "schema": {
+                            "$ref": "#/definitions/viewmodels.ProblemViewModel"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "move a customer to the trash by ID. It can be restored until it is purged after the retention period.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "customers"
+                ],
+                "summary": "Delete a customer",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Customer ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "ETag the delete is based on",
+                        "name": "If-Match",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "Successfully deleted"
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/viewmodels.ProblemViewModel"
+                        }
+                    },
+                    "404": {
+                        "description": "Customer not found",
+                        "schema": {
+                            "$ref": "#/definitions/viewmodels.ProblemViewModel"
+                        }
+                    },
+                    "412": {
+                        "description": "If-Match does not match",
+                        "schema": {
+                            "$ref": "#/definitions/viewmodels.ProblemViewModel"
+                        }
+                    }
+                }
+            },
+            "patch": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "apply a JSON Merge Patch (RFC 7396) or a JSON Patch (RFC 6902) to a customer. Members and paths use the field names of CustomerEditViewModel, e.g. {\"Contacted\": true} or [{\"op\": \"replace\", \"path\": \"/Contacted\", \"value\": true}]. The patched customer is validated like PUT.",
+                "consumes": [
+                    "application/merge-patch+json",
+                    "application/json-patch+json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "customers"
+                ],
+                "summary": "Partially update a customer",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Customer ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Patch document",
+                        "name": "patch",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "object"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "ETag the patch is based on",
+                        "name": "If-Match",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Successfully updated",
+                        "schema": {
+                            "$ref": "#/definitions/viewmodels.CustomerViewModel"
+                        },
+                        "headers": {
+                            "ETag": {
+                                "type": "string",
+                                "description": "Strong ETag of the new version"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/viewmodels.ProblemViewModel"
+                        }
+                    },
+                    "404": {
+                        "description": "Customer not found",
+                        "schema": {
+                            "$ref": "#/definitions/viewmodels.ProblemViewModel"
+                        }
+                    },
+                    "409": {
+                        "description": "Email or phone already exists",
+                        "schema": {
+                            "$ref": "#/definitions/viewmodels.ProblemViewModel"
+                        }
+                    },
+                    "412": {
+                        "description": "If-Match does not match",
+                        "schema": {
+                            "$ref": "#/definitions/viewmodels.ProblemViewModel"
+                        }
+                    },
+                    "415": {
+                        "description": "Unsupported patch format",
+                        "schema": {
+                            "$ref": "#/definitions/viewmodels.ProblemViewModel"
+                        }
+                    },
+                    "422": {
+                        "description": "Invalid patch or validation failed",
+                        "schema": {
+                            "$ref": "#/definitions/viewmodels.ProblemViewModel"
+                        }
+                    }
+                }
+            }
+        },
+        "/customers/{id}/history": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "list every recorded change of a customer, newest first, with the actor, time, operation and the changed fields before and after. The history is kept after the customer is deleted or purged.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "customers"
+                ],
+                "summary": "Show the history of a customer",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Customer ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Page size (1-1000, default 100)",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Number of entries to skip",
+                        "name": "offset",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/viewmodels.CustomerHistoryEntryViewModel"
+                            }
+                        },
+                        "headers": {
+                            "Link": {
+                                "type": "string",
+                                "description": "Links to the next and previous pages"
+                            },
+                            "X-Total-Count": {
+                                "type": "integer",
+                                "description": "Number of entries of the customer"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/viewmodels.ProblemViewModel"
+                        }
+                    },
+                    "404": {
+                        "description": "Customer not found",
+                        "schema": {
+                            "$ref": "#/definitions/viewmodels.ProblemViewModel"
+                        }
+                    }
+                }
+            }
+        },
+        "/customers/{id}/live": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "upgrade to a WebSocket that shows who else has the customer open and every change made to it, and takes edits. Messages are CustomerLiveMessageViewModel JSON. The server first sends a snapshot with the customer, the viewers and the ViewerID of the connection, then presence whenever someone joins or leaves and change for every stored change, however it was made. A client edits with update (Edit holds the whole customer, like PUT) or patch (Patch holds a JSON Merge Patch, like PATCH), always with the Version it is based on and optionally a RequestID. The reply is ack with the stored customer, or error with the problem; an edit based on a stale version fails with 412 and carries the current customer to rebase on. Changes can overtake the snapshot, so clients should ignore those with a Version they already have.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "customers"
+                ],
+                "summary": "Edit a customer together",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Customer ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Ticket from POST /customers/stream-tickets, for clients that cannot set headers",
+                        "name": "ticket",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "101": {
+                        "description": "Switching Protocols",
+                        "schema": {
+                            "$ref": "#/definitions/viewmodels.CustomerLiveMessageViewModel"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/viewmodels.ProblemViewModel"
+                        }
+                    },
+                    "404": {
+                        "description": "Customer not found",
+                        "schema": {
+                            "$ref": "#/definitions/viewmodels.ProblemViewModel"
+                        }
+                    }
+                }
+            }
+        },
+        "/customers/{id}/restore": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "move a customer out of the trash by ID",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "customers"
+                ],
+                "summary": "Restore a deleted customer",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Customer ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "ETag the restore is based on",
+                        "name": "If-Match",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Successfully restored",
+                        "schema": {
+                            "$ref": "#/definitions/viewmodels.CustomerViewModel"
+                        },
+                        "headers": {
+                            "ETag": {
+                                "type": "string",
+                                "description": "Strong ETag of the new version"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/viewmodels.ProblemViewModel"
+                        }
+                    },
+                    "404": {
+                        "description": "Customer not found in the trash",
+                        "schema": {
+                            "$ref": "#/definitions/viewmodels.ProblemViewModel"
+                        }
+                    },
+                    "412": {
+                        "description": "If-Match does not match",
+                        "schema": {
+                            "$ref": "#/definitions/viewmodels.ProblemViewModel"
+                        }
+                    }
+                }
+            }
+        },
+        "/customers:batch": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "perform up to 1000 operations in order. Op is create, update or delete; update and delete take the customer ID and optionally the Version they are based on, create and update take the Customer fields (its ID is ignored). In atomic mode (the default) either every operation is stored or none is, and the operations that were rolled back report 424. In best-effort mode every operation that can be stored is. Each result carries the status the single-customer endpoint would have returned.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "customers"
+                ],
+                "summary": "Create, update and delete customers in one request",
+                "parameters": [
+                    {
+                        "description": "Operations",
+                        "name": "batch",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/viewmodels.CustomerBatchViewModel"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Unique key that makes retries return the original response",
+                        "name": "Idempotency-Key",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Per-operation results",
+                        "schema": {
+                            "$ref": "#/definitions/viewmodels.CustomerBatchResultViewModel"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/viewmodels.ProblemViewModel"
+                        }
+                    },
+                    "409": {
+                        "description": "A request with the same Idempotency-Key is in progress",
+                        "schema": {
+                            "$ref": "#/definitions/viewmodels.ProblemViewModel"
+                        }
+                    },
+                    "422": {
+                        "description": "Invalid mode or too many operations, or Idempotency-Key reused with a different body",
+                        "schema": {
+                            "$ref": "#/definitions/viewmodels.ProblemViewModel"
+                        }
+                    }
+                }
+            }
+        },
+        "/webhooks": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "list every webhook in creation order. Secrets are never listed.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "webhooks"
+                ],
+                "summary": "Show all webhooks",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/viewmodels.WebhookViewModel"
+                            }
+                        }
+                    }
+                }
+            },
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "subscribe a URL to customer events: customer.created, customer.updated, customer.contacted, customer.deleted and customer.restored. Deliveries are signed with the secret, which is generated when none is given and only returned here.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "webhooks"
+                ],
+                "summary": "Create a webhook",
+                "parameters": [
+                    {
+                        "description": "Add Webhook",
+                        "name": "webhook",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/viewmodels.WebhookEditViewModel"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Successfully created",
+                        "schema": {
+                            "$ref": "#/definitions/viewmodels.WebhookViewModel"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/viewmodels.ProblemViewModel"
+                        }
+                    },
+                    "422": {
+                        "description": "Validation failed",
+                        "schema": {
+                            "$ref": "#/definitions/viewmodels.ProblemViewModel"
+                        }
+                    }
+                }
+            }
+        },
+        "/webhooks/dead-letters": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "list the deliveries of every webhook that failed on every attempt, newest first, with their attempts",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "webhooks"
+                ],
+                "summary": "Show the dead letters",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Number of deliveries (1-1000, default 100)",
+                        "name": "limit",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/viewmodels.WebhookDeliveryViewModel"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/viewmodels.ProblemViewModel"
+                        }
+                    }
+                }
+            }
+        },
+        "/webhooks/{id}": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "get a webhook by ID, without its secret",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "webhooks"
+                ],
+                "summary": "Show a webhook",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Webhook ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/viewmodels.WebhookViewModel"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/viewmodels.ProblemViewModel"
+                        }
+                    },
+                    "404": {
+                        "description": "Webhook not found",
+                        "schema": {
+                            "$ref": "#/definitions/viewmodels.ProblemViewModel"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "replace the URL, events and active flag of a webhook. A new secret is used from the next delivery on; without one the secret is kept. An inactive webhook gets no new deliveries.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "webhooks"
+                ],
+                "summary": "Update a webhook",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Webhook ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Update Webhook",
+                        "name": "webhook",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/viewmodels.WebhookEditViewModel"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Successfully updated",
+                        "schema": {
+                            "$ref": "#/definitions/viewmodels.WebhookViewModel"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/viewmodels.ProblemViewModel"
+                        }
+                    },
+                    "404": {
+                        "description": "Webhook not found",
+                        "schema": {
+                            "$ref": "#/definitions/viewmodels.ProblemViewModel"
+                        }
+                    },
+                    "422": {
+                        "description": "Validation failed",
+                        "schema": {
+                            "$ref": "#/definitions/viewmodels.ProblemViewModel"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "delete a webhook by ID together with its deliveries, including the pending ones",
+                "tags": [
+                    "webhooks"
+                ],
+                "summary": "Delete a webhook",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Webhook ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "Successfully deleted"
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/viewmodels.ProblemViewModel"
+                        }
+                    },
+                    "404": {
+                        "description": "Webhook not found",
+                        "schema": {
+                            "$ref": "#/definitions/viewmodels.ProblemViewModel"
+                        }
+                    }
+                }
+            }
+        },
+        "/webhooks/{id}/deliveries": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "list the deliveries of a webhook, newest first, with the payload and every attempt: its time, response status, error and duration",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "webhooks"
+                ],
+                "summary": "Show the deliveries of a webhook",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Webhook ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only deliveries with this status: pending, delivered or dead",
+                        "name": "status",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Number of deliveries (1-1000, default 100)",
+                        "name": "limit",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/viewmodels.WebhookDeliveryViewModel"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/viewmodels.ProblemViewModel"
+                        }
+                    },
+                    "404": {
+                        "description": "Webhook not found",
+                        "schema": {
+                            "$ref": "#/definitions/viewmodels.ProblemViewModel"
+                        }
+                    }
+                }
+            }
+        }
+    },
+    "definitions": {
+        "validation.FieldError": {
+            "type": "object",
+            "properties": {
+                "field": {
+                    "type": "string"
+                },
+                "reason": {
+                    "type": "string"
+                }
+            }
+        },
+        "viewmodels.APIKeyCreateViewModel": {
+            "type": "object",
+            "required": [
+                "name"
+            ],
+            "properties": {
+                "expiresAt": {
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string",
+                    "maxLength": 100
+                },
+                "roles": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "scopes": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "viewmodels.APIKeyViewModel": {
+            "type": "object",
+            "properties": {
+                "createdAt": {
+                    "type": "string"
+                },
+                "expiresAt": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "key": {
+                    "description": "Key is only returned when the key is created or rotated",
+                    "type": "string"
+                },
+                "lastUsedAt": {
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "prefix": {
+                    "description": "Prefix is the start of the key, to tell keys apart",
+                    "type": "string"
+                },
+                "previousExpiresAt": {
+                    "description": "PreviousExpiresAt is when the key replaced by the last rotation stops working",
+                    "type": "string"
+                },
+                "revokedAt": {
+                    "type": "string"
+                },
+                "roles": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "rotatedAt": {
+                    "type": "string"
+                },
+                "scopes": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "viewmodels.CustomerBatchResultViewModel": {
+            "type": "object",
+            "properties": {
+                "applied": {
+                    "type": "integer"
+                },
+                "results": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/viewmodels.CustomerOperationResultViewModel"
+                    }
+                }
+            }
+        },
+        "viewmodels.CustomerBatchViewModel": {
+            "type": "object",
+            "properties": {
+                "mode": {
+                    "description": "Mode is \"atomic\" (the default) or \"best-effort\"",
+                    "type": "string"
+                },
+                "operations": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/viewmodels.CustomerOperationViewModel"
+                    }
+                }
+            }
+        },
+        "viewmodels.CustomerCreateViewModel": {
+            "type": "object",
+            "required": [
+                "email",
+                "name",
+                "phone",
+                "role"
+            ],
+            "properties": {
+                "contacted": {
+                    "type": "boolean"
+                },
+                "email": {
+                    "type": "string",
+                    "maxLength": 254
+                },
+                "name": {
+                    "type": "string",
+                    "maxLength": 100
+                },
+                "phone": {
+                    "type": "string",
+                    "maxLength": 20
+                },
+                "role": {
+                    "type": "string",
+                    "enum": [
+                        "Developer|Designer|Manager|Tester|Product",
+                        "Owner|Sales"
+                    ]
                 }
             }
         },
         "viewmodels.CustomerEditViewModel": {
             "type": "object",
+            "required": [
+                "email",
+                "name",
+                "phone",
+                "role"
+            ],
             "properties": {
                 "contacted": {
                     "type": "boolean"
                 },
                 "email": {
-                    "type": "string"
+                    "type": "string",
+                    "maxLength": 254
                 },
                 "id": {
                     "type": "string"
                 },
                 "name": {
-                    "type": "string"
+                    "type": "string",
+                    "maxLength": 100
                 },
                 "phone": {
-                    "type": "string"
+                    "type": "string",
+                    "maxLength": 20
                 },
                 "role": {
+                    "type": "string",
+                    "enum": [
+                        "Developer|Designer|Manager|Tester|Product",
+                        "Owner|Sales"
+                    ]
+                }
+            }
+        },
+        "viewmodels.CustomerEventViewModel": {
+            "type": "object",
+            "properties": {
+                "actor": {
+                    "type": "string"
+                },
+                "changes": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/viewmodels.FieldChangeViewModel"
+                    }
+                },
+                "customer": {
+                    "description": "Customer is the customer after the change; a deleted one has DeletedAt set",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/viewmodels.CustomerViewModel"
+                        }
+                    ]
+                },
+                "timestamp": {
+                    "type": "string"
+                },
+                "type": {
+                    "type": "string"
+                }
+            }
+        },
+        "viewmodels.CustomerHistoryEntryViewModel": {
+            "type": "object",
+            "properties": {
+                "actor": {
+                    "type": "string"
+                },
+                "changes": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/viewmodels.FieldChangeViewModel"
+                    }
+                },
+                "operation": {
+                    "type": "string"
+                },
+                "timestamp": {
+                    "type": "string"
+                },
+                "version": {
+                    "description": "Version is the version of the customer the change created",
+                    "type": "integer"
+                }
+            }
+        },
+        "viewmodels.CustomerImportResultViewModel": {
+            "type": "object",
+            "properties": {
+                "created": {
+                    "type": "integer"
+                },
+                "dryRun": {
+                    "type": "boolean"
+                },
+                "failed": {
+                    "type": "integer"
+                },
+                "rows": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/viewmodels.CustomerImportRowViewModel"
+                    }
+                }
+            }
+        },
+        "viewmodels.CustomerImportRowViewModel": {
+            "type": "object",
+            "properties": {
+                "customer": {
+                    "$ref": "#/definitions/viewmodels.CustomerViewModel"
+                },
+                "error": {
+                    "$ref": "#/definitions/viewmodels.ProblemViewModel"
+                },
+                "line": {
+                    "description": "Line is the line of the row in the file, counting the header as line 1",
+                    "type": "integer"
+                },
+                "status": {
+                    "type": "integer"
+                }
+            }
+        },
+        "viewmodels.CustomerLiveMessageViewModel": {
+            "type": "object",
+            "properties": {
+                "actor": {
+                    "type": "string"
+                },
+                "changes": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/viewmodels.FieldChangeViewModel"
+                    }
+                },
+                "customer": {
+                    "description": "Customer is the current customer of a snapshot, change or ack, and of an\nerror for a stale edit",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/viewmodels.CustomerViewModel"
+                        }
+                    ]
+                },
+                "edit": {
+                    "$ref": "#/definitions/viewmodels.CustomerEditViewModel"
+                },
+                "event": {
+                    "description": "Event is the type of a change: \"updated\", \"deleted\" or \"restored\"",
+                    "type": "string"
+                },
+                "patch": {
+                    "type": "object"
+                },
+                "problem": {
+                    "$ref": "#/definitions/viewmodels.ProblemViewModel"
+                },
+                "requestID": {
+                    "description": "RequestID is chosen by the client and repeated in the reply to its edit",
+                    "type": "string"
+                },
+                "type": {
+                    "type": "string"
+                },
+                "version": {
+                    "description": "Version is the version an edit is based on",
+                    "type": "integer"
+                },
+                "viewerID": {
+                    "description": "ViewerID identifies this connection among the Viewers of a snapshot",
+                    "type": "string"
+                },
+                "viewers": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/viewmodels.CustomerViewerViewModel"
+                    }
+                }
+            }
+        },
+        "viewmodels.CustomerOperationResultViewModel": {
+            "type": "object",
+            "properties": {
+                "customer": {
+                    "$ref": "#/definitions/viewmodels.CustomerViewModel"
+                },
+                "error": {
+                    "$ref": "#/definitions/viewmodels.ProblemViewModel"
+                },
+                "status": {
+                    "type": "integer"
+                }
+            }
+        },
+        "viewmodels.CustomerOperationViewModel": {
+            "type": "object",
+            "properties": {
+                "customer": {
+                    "$ref": "#/definitions/viewmodels.CustomerEditViewModel"
+                },
+                "id": {
                     "type": "string"
+                },
+                "op": {
+                    "description": "Op is \"create\", \"update\" or \"delete\"",
+                    "type": "string"
+                },
+                "version": {
+                    "type": "integer"
+                }
+            }
+        },
+        "viewmodels.CustomerSearchResultViewModel": {
+            "type": "object",
+            "properties": {
+                "customer": {
+                    "$ref": "#/definitions/viewmodels.CustomerViewModel"
+                },
+                "highlights": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
+                "score": {
+                    "type": "number"
                 }
             }
         },
@@ -231,6 +2247,17 @@ const docTemplate = `{
                 "contacted": {
                     "type": "boolean"
                 },
+                "createdAt": {
+                    "description": "CreatedAt, UpdatedAt, CreatedBy and UpdatedBy record when and by whom\nthe customer was created and last changed, if known",
+                    "type": "string"
+                },
+                "createdBy": {
+                    "type": "string"
+                },
+                "deletedAt": {
+                    "description": "DeletedAt is set for customers in the trash",
+                    "type": "string"
+                },
                 "email": {
                     "type": "string"
                 },
@@ -245,8 +2272,200 @@ const docTemplate = `{
                 },
                 "role": {
                     "type": "string"
+                },
+                "updatedAt": {
+                    "type": "string"
+                },
+                "updatedBy": {
+                    "type": "string"
+                },
+                "version": {
+                    "type": "integer"
+                }
+            }
+        },
+        "viewmodels.CustomerViewerViewModel": {
+            "type": "object",
+            "properties": {
+                "id": {
+                    "type": "string"
+                },
+                "joinedAt": {
+                    "type": "string"
+                },
+                "subject": {
+                    "type": "string"
+                }
+            }
+        },
+        "viewmodels.FieldChangeViewModel": {
+            "type": "object",
+            "properties": {
+                "after": {},
+                "before": {},
+                "field": {
+                    "type": "string"
+                }
+            }
+        },
+        "viewmodels.ProblemViewModel": {
+            "type": "object",
+            "properties": {
+                "detail": {
+                    "type": "string"
+                },
+                "errors": {
+                    "description": "Errors lists the failing fields of a validation problem",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/validation.FieldError"
+                    }
+                },
+                "instance": {
+                    "type": "string"
+                },
+                "status": {
+                    "type": "integer"
+                },
+                "title": {
+                    "type": "string"
+                },
+                "type": {
+                    "type": "string"
+                }
+            }
+        },
+        "viewmodels.StreamTicketViewModel": {
+            "type": "object",
+            "properties": {
+                "expiresAt": {
+                    "type": "string"
+                },
+                "ticket": {
+                    "type": "string"
+                }
+            }
+        },
+        "viewmodels.WebhookAttemptViewModel": {
+            "type": "object",
+            "properties": {
+                "durationMs": {
+                    "description": "DurationMs is how long the receiver took to answer, in milliseconds",
+                    "type": "integer"
+                },
+                "error": {
+                    "type": "string"
+                },
+                "statusCode": {
+                    "type": "integer"
+                },
+                "timestamp": {
+                    "type": "string"
+                }
+            }
+        },
+        "viewmodels.WebhookDeliveryViewModel": {
+            "type": "object",
+            "properties": {
+                "attempts": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/viewmodels.WebhookAttemptViewModel"
+                    }
+                },
+                "createdAt": {
+                    "type": "string"
+                },
+                "event": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "nextAttemptAt": {
+                    "description": "NextAttemptAt is when a pending delivery is tried next",
+                    "type": "string"
+                },
+                "payload": {
+                    "type": "object"
+                },
+                "status": {
+                    "description": "Status is \"pending\", \"delivered\" or \"dead\"",
+                    "type": "string"
+                },
+                "webhookID": {
+                    "type": "string"
                 }
             }
+        },
+        "viewmodels.WebhookEditViewModel": {
+            "type": "object",
+            "required": [
+                "url"
+            ],
+            "properties": {
+                "active": {
+                    "type": "boolean"
+                },
+                "events": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "secret": {
+                    "type": "string",
+                    "maxLength": 256
+                },
+                "url": {
+                    "type": "string",
+                    "maxLength": 2048
+                }
+            }
+        },
+        "viewmodels.WebhookViewModel": {
+            "type": "object",
+            "properties": {
+                "active": {
+                    "type": "boolean"
+                },
+                "createdAt": {
+                    "type": "string"
+                },
+                "events": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "id": {
+                    "type": "string"
+                },
+                "secret": {
+                    "description": "Secret is only returned when the webhook is created or its secret changed",
+                    "type": "string"
+                },
+                "updatedAt": {
+                    "type": "string"
+                },
+                "url": {
+                    "type": "string"
+                }
+            }
+        }
+    },
+    "securityDefinitions": {
+        "ApiKeyAuth": {
+            "description": "An API key with the read, write or admin scope. It can also be sent as \"Authorization: Bearer \u003ckey\u003e\".",
+            "type": "apiKey",
+            "name": "X-API-Key",
+            "in": "header"
+        },
+        "BearerAuth": {
+            "description": "\"Bearer \u003cjwt\u003e\", with a JWT from the company SSO. Its scope claim grants the read, write or admin scope.",
+            "type": "apiKey",
+            "name": "Authorization",
+            "in": "header"
         }
     }
 }`
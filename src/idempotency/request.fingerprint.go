@@ -0,0 +1,24 @@
+package idempotency
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+)
+
+// Fingerprint identifies a request by method, path and body. JSON bodies are
+// compared by value, so a retry that re-serializes the same document with
+// different whitespace or member order still matches.
+func Fingerprint(method string, path string, body []byte) string {
+	var document any
+	if err := json.Unmarshal(body, &document); err == nil {
+		if canonical, err := json.Marshal(document); err == nil {
+			body = canonical
+		}
+	}
+
+	hash := sha256.New()
+	hash.Write([]byte(method + " " + path + "\n"))
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
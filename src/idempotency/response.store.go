@@ -0,0 +1,112 @@
+package idempotency
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrFingerprintMismatch is returned when a key is reused for a different request
+	ErrFingerprintMismatch = errors.New("idempotency key was already used for a different request")
+	// ErrInProgress is returned while the first request with a key is still running
+	ErrInProgress = errors.New("a request with this idempotency key is still in progress")
+)
+
+// DefaultTTL is how long responses are kept when no TTL is configured
+const DefaultTTL = 24 * time.Hour
+
+// Response is a recorded HTTP response that can be replayed
+type Response struct {
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+type entry struct {
+	fingerprint string
+	// response is nil while the first request is in progress
+	response *Response
+	expires  time.Time
+}
+
+// ResponseStore remembers the response to each idempotency key for a TTL.
+// It lives in memory, so keys are only honored by the instance that saw them
+// first and are forgotten on restart.
+type ResponseStore struct {
+	mu        sync.Mutex
+	ttl       time.Duration
+	now       func() time.Time
+	entries   map[string]*entry
+	nextSweep time.Time
+}
+
+// NewResponseStore creates a store that keeps responses for ttl
+func NewResponseStore(ttl time.Duration) *ResponseStore {
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	return &ResponseStore{
+		ttl:     ttl,
+		now:     time.Now,
+		entries: map[string]*entry{},
+	}
+}
+
+// Begin claims key for a request with the given fingerprint. If the key
+// already has a response for the same fingerprint, that response is returned
+// and the request must not run again. Otherwise the caller owns the key and
+// must call Complete or Release when the request finishes.
+func (s *ResponseStore) Begin(key string, fingerprint string) (*Response, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.sweep(now)
+
+	if e, ok := s.entries[key]; ok && now.Before(e.expires) {
+		if e.fingerprint != fingerprint {
+			return nil, ErrFingerprintMismatch
+		}
+		if e.response == nil {
+			return nil, ErrInProgress
+		}
+		return e.response, nil
+	}
+
+	s.entries[key] = &entry{fingerprint: fingerprint, expires: now.Add(s.ttl)}
+	return nil, nil
+}
+
+// Complete stores the response of the request that claimed key
+func (s *ResponseStore) Complete(key string, response Response) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e, ok := s.entries[key]; ok {
+		e.response = &response
+		e.expires = s.now().Add(s.ttl)
+	}
+}
+
+// Release forgets key so the request can be retried, e.g. after a server error
+func (s *ResponseStore) Release(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, key)
+}
+
+// sweep drops expired entries at most once per TTL; the caller holds the lock
+func (s *ResponseStore) sweep(now time.Time) {
+	if now.Before(s.nextSweep) {
+		return
+	}
+	for key, e := range s.entries {
+		if !now.Before(e.expires) {
+			delete(s.entries, key)
+		}
+	}
+	s.nextSweep = now.Add(s.ttl)
+}
@@ -0,0 +1,82 @@
+package idempotency
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestResponseStore_Replay(t *testing.T) {
+	store := NewResponseStore(time.Hour)
+
+	if response, err := store.Begin("key", "a"); response != nil || err != nil {
+		t.Fatalf("Expected the first Begin to claim the key, but got %v (%v)", response, err)
+	}
+	if _, err := store.Begin("key", "a"); !errors.Is(err, ErrInProgress) {
+		t.Errorf("Expected ErrInProgress while the first request runs, but got %v", err)
+	}
+
+	store.Complete("key", Response{Status: http.StatusCreated, Body: []byte("created")})
+
+	response, err := store.Begin("key", "a")
+	if err != nil || response == nil || response.Status != http.StatusCreated || string(response.Body) != "created" {
+		t.Errorf("Expected the stored response, but got %v (%v)", response, err)
+	}
+	if _, err := store.Begin("key", "b"); !errors.Is(err, ErrFingerprintMismatch) {
+		t.Errorf("Expected ErrFingerprintMismatch for a different request, but got %v", err)
+	}
+	if response, err := store.Begin("other", "b"); response != nil || err != nil {
+		t.Errorf("Expected another key to be independent, but got %v (%v)", response, err)
+	}
+}
+
+func TestResponseStore_Release(t *testing.T) {
+	store := NewResponseStore(time.Hour)
+
+	store.Begin("key", "a")
+	store.Release("key")
+
+	if response, err := store.Begin("key", "b"); response != nil || err != nil {
+		t.Errorf("Expected a released key to be claimable again, but got %v (%v)", response, err)
+	}
+}
+
+func TestResponseStore_Expiry(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	store := NewResponseStore(time.Hour)
+	store.now = func() time.Time { return now }
+
+	store.Begin("key", "a")
+	store.Complete("key", Response{Status: http.StatusCreated})
+
+	now = now.Add(59 * time.Minute)
+	if response, _ := store.Begin("key", "a"); response == nil {
+		t.Error("Expected the response to be kept within the TTL")
+	}
+
+	now = now.Add(2 * time.Minute)
+	if response, err := store.Begin("key", "b"); response != nil || err != nil {
+		t.Errorf("Expected an expired key to be claimable again, but got %v (%v)", response, err)
+	}
+
+	now = now.Add(2 * time.Hour)
+	store.Begin("fresh", "a")
+	if _, ok := store.entries["key"]; ok {
+		t.Error("Expected expired entries to be swept")
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	base := Fingerprint("POST", "/api/v1/customers", []byte(`{"Name":"A","Phone":"1"}`))
+
+	if Fingerprint("POST", "/api/v1/customers", []byte("{\n  \"Phone\": \"1\",\n  \"Name\": \"A\"\n}")) != base {
+		t.Error("Expected equivalent JSON documents to share a fingerprint")
+	}
+	if Fingerprint("POST", "/api/v1/customers", []byte(`{"Name":"B","Phone":"1"}`)) == base {
+		t.Error("Expected a different body to change the fingerprint")
+	}
+	if Fingerprint("POST", "/api/v1/other", []byte(`{"Name":"A","Phone":"1"}`)) == base {
+		t.Error("Expected a different path to change the fingerprint")
+	}
+}
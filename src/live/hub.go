@@ -0,0 +1,198 @@
+package live
+
+import (
+	"cmp"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// clientBuffer is how many messages a client may fall behind before it is dropped
+const clientBuffer = 64
+
+// Viewer is someone who has a customer open
+type Viewer struct {
+	// ID tells apart the connections of one subject
+	ID       string
+	Subject  string
+	JoinedAt time.Time
+}
+
+// Hub keeps a room per customer with the clients viewing it, so changes and
+// presence can be sent to everyone who has the customer open. It lives in
+// memory: clients connected to another instance are not seen.
+type Hub struct {
+	mu     sync.Mutex
+	rooms  map[uuid.UUID]map[*Client]struct{}
+	closed bool
+	// Presence builds the message sent to a room whenever someone joins or
+	// leaves it. It is called with the hub locked, so it must not use the hub.
+	Presence func(customerID uuid.UUID, viewers []Viewer) []byte
+}
+
+// NewHub creates a hub without rooms
+func NewHub() *Hub {
+	return &Hub{rooms: map[uuid.UUID]map[*Client]struct{}{}}
+}
+
+// Client is one connection in the room of a customer
+type Client struct {
+	hub        *Hub
+	customerID uuid.UUID
+	viewer     Viewer
+	messages   chan []byte
+}
+
+// Viewer returns who the client is
+func (c *Client) Viewer() Viewer {
+	return c.viewer
+}
+
+// Messages is closed when the client leaves or falls behind
+func (c *Client) Messages() <-chan []byte {
+	return c.messages
+}
+
+// Send queues message for this client only. It reports false when the
+// client has left or fell behind and was dropped.
+func (c *Client) Send(message []byte) bool {
+	c.hub.mu.Lock()
+	defer c.hub.mu.Unlock()
+
+	if _, ok := c.hub.rooms[c.customerID][c]; !ok {
+		return false
+	}
+	if !c.hub.send(c, message) {
+		c.hub.announce(c.customerID)
+		return false
+	}
+	return true
+}
+
+// Leave removes the client from its room and tells the others
+func (c *Client) Leave() {
+	c.hub.mu.Lock()
+	defer c.hub.mu.Unlock()
+
+	if _, ok := c.hub.rooms[c.customerID][c]; ok {
+		c.hub.drop(c)
+		c.hub.announce(c.customerID)
+	}
+}
+
+// Join adds viewer to the room of customerID and tells everyone in it,
+// the new client included
+func (h *Hub) Join(customerID uuid.UUID, viewer Viewer) *Client {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	client := &Client{hub: h, customerID: customerID, viewer: viewer, messages: make(chan []byte, clientBuffer)}
+	if h.closed {
+		close(client.messages)
+		return client
+	}
+	if h.rooms[customerID] == nil {
+		h.rooms[customerID] = map[*Client]struct{}{}
+	}
+	h.rooms[customerID][client] = struct{}{}
+	h.announce(customerID)
+	return client
+}
+
+// Viewers returns who has customerID open, the longest first
+func (h *Hub) Viewers(customerID uuid.UUID) []Viewer {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.viewers(customerID)
+}
+
+// Watched reports whether anybody has customerID open
+func (h *Hub) Watched(customerID uuid.UUID) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return len(h.rooms[customerID]) > 0
+}
+
+// Broadcast queues message for every client viewing customerID. Broadcast
+// never blocks: a client that has fallen too far behind is dropped instead.
+func (h *Hub) Broadcast(customerID uuid.UUID, message []byte) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.broadcast(customerID, message) {
+		h.announce(customerID)
+	}
+}
+
+// Close ends every client, and those joining later right away, so the
+// connections are closed when the server shuts down
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.closed = true
+	for _, room := range h.rooms {
+		for client := range room {
+			h.drop(client)
+		}
+	}
+}
+
+// broadcast reports whether it dropped anybody
+func (h *Hub) broadcast(customerID uuid.UUID, message []byte) (dropped bool) {
+	for client := range h.rooms[customerID] {
+		if !h.send(client, message) {
+			dropped = true
+		}
+	}
+	return dropped
+}
+
+func (h *Hub) send(client *Client, message []byte) bool {
+	select {
+	case client.messages <- message:
+		return true
+	default:
+		h.drop(client)
+		return false
+	}
+}
+
+func (h *Hub) drop(client *Client) {
+	delete(h.rooms[client.customerID], client)
+	if len(h.rooms[client.customerID]) == 0 {
+		delete(h.rooms, client.customerID)
+	}
+	close(client.messages)
+}
+
+func (h *Hub) viewers(customerID uuid.UUID) []Viewer {
+	viewers := []Viewer{}
+	for client := range h.rooms[customerID] {
+		viewers = append(viewers, client.viewer)
+	}
+	slices.SortFunc(viewers, func(a, b Viewer) int {
+		if c := a.JoinedAt.Compare(b.JoinedAt); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.ID, b.ID)
+	})
+	return viewers
+}
+
+// announce sends the viewers to the room of customerID, until nobody else
+// falls behind on the way
+func (h *Hub) announce(customerID uuid.UUID) {
+	if h.Presence == nil {
+		return
+	}
+	for len(h.rooms[customerID]) > 0 {
+		if !h.broadcast(customerID, h.Presence(customerID, h.viewers(customerID))) {
+			return
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package live
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func viewerIDs(viewers []Viewer) []string {
+	ids := []string{}
+	for _, viewer := range viewers {
+		ids = append(ids, viewer.ID)
+	}
+	return ids
+}
+
+func newPresenceHub() *Hub {
+	hub := NewHub()
+	hub.Presence = func(customerID uuid.UUID, viewers []Viewer) []byte {
+		return fmt.Appendf(nil, "presence %v", viewerIDs(viewers))
+	}
+	return hub
+}
+
+func TestHub_Join(t *testing.T) {
+	hub := newPresenceHub()
+	customerID := uuid.New()
+	joinedAt := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+
+	first := hub.Join(customerID, Viewer{ID: "a", Subject: "alice", JoinedAt: joinedAt})
+	if message := string(<-first.Messages()); message != "presence [a]" {
+		t.Errorf("Expected the joining client to get the presence, but got %q", message)
+	}
+	second := hub.Join(customerID, Viewer{ID: "b", Subject: "bob", JoinedAt: joinedAt.Add(time.Minute)})
+	for _, client := range []*Client{first, second} {
+		if message := string(<-client.Messages()); message != "presence [a b]" {
+			t.Errorf("Expected %s to see both viewers, but got %q", client.Viewer().ID, message)
+		}
+	}
+
+	other := hub.Join(uuid.New(), Viewer{ID: "c"})
+	<-other.Messages()
+	hub.Broadcast(customerID, []byte("change"))
+	for _, client := range []*Client{first, second} {
+		if message := string(<-client.Messages()); message != "change" {
+			t.Errorf("Expected %s to get the change, but got %q", client.Viewer().ID, message)
+		}
+	}
+	if len(other.Messages()) != 0 {
+		t.Error("Expected a client of another customer not to get the change")
+	}
+
+	second.Leave()
+	if _, ok := <-second.Messages(); ok {
+		t.Error("Expected Messages to be closed after Leave")
+	}
+	if message := string(<-first.Messages()); message != "presence [a]" {
+		t.Errorf("Expected the others to see the viewer leave, but got %q", message)
+	}
+	second.Leave()
+	if second.Send([]byte("late")) {
+		t.Error("Expected Send to fail after Leave")
+	}
+
+	first.Leave()
+	if hub.Watched(customerID) || len(hub.Viewers(customerID)) != 0 {
+		t.Error("Expected nobody to watch the customer after everyone left")
+	}
+}
+
+func TestHub_DropsSlowClients(t *testing.T) {
+	hub := newPresenceHub()
+	customerID := uuid.New()
+	slow := hub.Join(customerID, Viewer{ID: "slow"})
+	fast := hub.Join(customerID, Viewer{ID: "fast"})
+
+	for range clientBuffer {
+		hub.Broadcast(customerID, []byte("change"))
+		<-fast.Messages()
+	}
+
+	received := 0
+	for range slow.Messages() {
+		received++
+	}
+	if received != clientBuffer {
+		t.Errorf("Expected a slow client to get %d messages before it is dropped, but got %d", clientBuffer, received)
+	}
+	if message := string(<-fast.Messages()); message != "presence [fast]" {
+		t.Errorf("Expected the others to see the slow client go, but got %q", message)
+	}
+}
+
+func TestHub_Close(t *testing.T) {
+	hub := NewHub()
+	before := hub.Join(uuid.New(), Viewer{ID: "a"})
+
+	hub.Close()
+
+	if _, ok := <-before.Messages(); ok {
+		t.Error("Expected Close to end existing clients")
+	}
+	if _, ok := <-hub.Join(uuid.New(), Viewer{ID: "b"}).Messages(); ok {
+		t.Error("Expected clients joining after Close to end right away")
+	}
+}
@@ -1,13 +1,30 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"os"
 	"os/exec"
+	"os/signal"
 	"runtime"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
 
+	"congdinh.com/crm/auth"
+	"congdinh.com/crm/changefeed"
+	"congdinh.com/crm/config"
 	"congdinh.com/crm/controllers"
 	"congdinh.com/crm/docs" // Updated import path
+	"congdinh.com/crm/idempotency"
+	"congdinh.com/crm/oidc"
+	"congdinh.com/crm/policy"
+	"congdinh.com/crm/repositories"
 	"congdinh.com/crm/services"
 	"github.com/gorilla/mux"
 	httpSwagger "github.com/swaggo/http-swagger"
@@ -30,11 +47,266 @@ func openBrowser(url string) error {
 	return exec.Command(cmd, args...).Start()
 }
 
+// closableRepository is a customer repository that holds resources until closed
+type closableRepository interface {
+	repositories.ICustomerRepository
+	io.Closer
+}
+
+// newCustomerRepository opens the storage backend selected in the configuration
+func newCustomerRepository(ctx context.Context, cfg config.Config) (closableRepository, error) {
+	switch cfg.Storage {
+	case "json":
+		flushPolicy, err := repositories.ParseFlushPolicy(cfg.FlushPolicy)
+		if err != nil {
+			return nil, err
+		}
+		dataFile := cfg.DataFile
+		if dataFile == "" {
+			dataFile = repositories.DefaultDataFile()
+		}
+		store := repositories.NewCustomerFileStore(dataFile, flushPolicy, cfg.FlushDelay)
+		return repositories.NewJSONCustomerRepository(store)
+	case "events":
+		eventLogFile := cfg.EventLogFile
+		if eventLogFile == "" {
+			eventLogFile = repositories.DefaultEventLogFile()
+		}
+		snapshotFile := cfg.SnapshotFile
+		if snapshotFile == "" {
+			snapshotFile = repositories.DefaultSnapshotFile()
+		}
+		store, err := repositories.OpenCustomerEventStore(eventLogFile, snapshotFile, cfg.SnapshotEvery)
+		if err != nil {
+			return nil, err
+		}
+		return repositories.NewEventCustomerRepository(store)
+	case "sqlite":
+		sqliteFile := cfg.SQLiteFile
+		if sqliteFile == "" {
+			sqliteFile = repositories.DefaultSQLiteFile()
+		}
+		return repositories.NewSQLiteCustomerRepository(ctx, sqliteFile)
+	case "postgres":
+		if cfg.PostgresDSN == "" {
+			return nil, errors.New("CRM_POSTGRES_DSN is required for postgres storage")
+		}
+		return repositories.NewPostgresCustomerRepository(ctx, cfg.PostgresDSN, repositories.PostgresOptions{
+			MaxOpenConns:    cfg.PostgresMaxConns,
+			MaxIdleConns:    cfg.PostgresMaxConns,
+			ConnMaxLifetime: cfg.PostgresConnMaxLifetime,
+		})
+	}
+	return nil, fmt.Errorf("unknown storage %q", cfg.Storage)
+}
+
+// authMethods are the ways of authenticating CRM_AUTH turns on
+type authMethods struct {
+	apiKeys bool
+	jwts    bool
+}
+
+// parseAuth parses CRM_AUTH: "none", or a comma separated list of "apikey" and "jwt"
+func parseAuth(value string) (authMethods, error) {
+	var methods authMethods
+	if value == "none" {
+		return methods, nil
+	}
+	for method := range strings.SplitSeq(value, ",") {
+		switch strings.TrimSpace(method) {
+		case "apikey":
+			methods.apiKeys = true
+		case "jwt":
+			methods.jwts = true
+		default:
+			return methods, fmt.Errorf("unknown CRM_AUTH %q, expected none or a list of apikey and jwt", value)
+		}
+	}
+	return methods, nil
+}
+
+// newTokenVerifier creates the verifier of the JWTs issued by the SSO provider
+func newTokenVerifier(cfg config.Config) (*oidc.Verifier, error) {
+	source := cfg.JWKSURL
+	if source == "" {
+		source = cfg.JWKSFile
+	}
+	if source == "" {
+		return nil, errors.New("CRM_JWKS_URL or CRM_JWKS_FILE is required for jwt authentication")
+	}
+	if cfg.JWTIssuer == "" || cfg.JWTAudience == "" {
+		return nil, errors.New("CRM_JWT_ISSUER and CRM_JWT_AUDIENCE are required for jwt authentication")
+	}
+	keys := oidc.NewKeySet(source)
+	keys.RefreshInterval = cfg.JWKSRefreshInterval
+	verifier := oidc.NewVerifier(keys, cfg.JWTIssuer, cfg.JWTAudience)
+	verifier.ClockSkew = cfg.JWTClockSkew
+	verifier.SubjectClaim = cfg.JWTSubjectClaim
+	verifier.RolesClaim = cfg.JWTRolesClaim
+	verifier.ScopeClaim = cfg.JWTScopeClaim
+	verifier.ScopePrefix = cfg.JWTScopePrefix
+	verifier.DefaultScopes = strings.Fields(cfg.JWTDefaultScopes)
+	if i := slices.IndexFunc(verifier.DefaultScopes, func(scope string) bool { return !slices.Contains(auth.Scopes, scope) }); i >= 0 {
+		return nil, fmt.Errorf("unknown scope %q in CRM_JWT_DEFAULT_SCOPES, expected %s", verifier.DefaultScopes[i], strings.Join(auth.Scopes, ", "))
+	}
+	return verifier, nil
+}
+
+// routeNames returns the names of the routes of router, which are the
+// permissions of the access policy
+func routeNames(router *mux.Router) []string {
+	names := []string{}
+	router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		if name := route.GetName(); name != "" {
+			names = append(names, name)
+		}
+		return nil
+	})
+	return names
+}
+
+// @securityDefinitions.apikey ApiKeyAuth
+// @in header
+// @name X-API-Key
+// @description An API key with the read, write or admin scope. It can also be sent as "Authorization: Bearer <key>".
+
+// @securityDefinitions.apikey BearerAuth
+// @in header
+// @name Authorization
+// @description "Bearer <jwt>", with a JWT from the company SSO. Its scope claim grants the read, write or admin scope.
 func main() {
+	cfg := config.Load()
+	authentication, err := parseAuth(cfg.Auth)
+	if err != nil {
+		log.Fatal(err)
+	}
+	accessPolicy := policy.Default()
+	if cfg.PolicyFile != "" {
+		if accessPolicy, err = policy.Load(cfg.PolicyFile); err != nil {
+			log.Fatalf("Failed to load the access policy: %v", err)
+		}
+	}
+
+	startupCtx, cancelStartup := context.WithTimeout(context.Background(), 30*time.Second)
+	customerRepository, err := newCustomerRepository(startupCtx, cfg)
+	cancelStartup()
+	if err != nil {
+		log.Fatalf("Failed to open customer storage: %v", err)
+	}
+
+	// The SQL backends keep the history in the customer database; the json
+	// and events backends append it to a file of their own
+	var historyFile *repositories.JSONCustomerHistoryRepository
+	if fileRepository, ok := customerRepository.(*repositories.JSONCustomerRepository); ok {
+		file := cfg.HistoryFile
+		if file == "" {
+			file = repositories.DefaultHistoryFile()
+		}
+		historyFile, err = repositories.NewJSONCustomerHistoryRepository(file)
+		if err != nil {
+			log.Fatalf("Failed to open customer history: %v", err)
+		}
+		fileRepository.History = historyFile
+	}
+	customerService := services.NewCustomerService(customerRepository)
+
+	// Like the history, webhooks live in the customer database of the SQL
+	// backends and in a file of their own otherwise
+	var webhookRepository repositories.IWebhookRepository
+	if webhooks, ok := customerRepository.(repositories.IWebhookRepository); ok {
+		webhookRepository = webhooks
+	} else {
+		file := cfg.WebhookFile
+		if file == "" {
+			file = repositories.DefaultWebhookFile()
+		}
+		webhookRepository, err = repositories.NewJSONWebhookRepository(file)
+		if err != nil {
+			log.Fatalf("Failed to open webhooks: %v", err)
+		}
+	}
+	// API keys are stored like the webhooks
+	var apiKeyRepository repositories.IAPIKeyRepository
+	if keys, ok := customerRepository.(repositories.IAPIKeyRepository); ok {
+		apiKeyRepository = keys
+	} else {
+		file := cfg.APIKeyFile
+		if file == "" {
+			file = repositories.DefaultAPIKeyFile()
+		}
+		apiKeyRepository, err = repositories.NewJSONAPIKeyRepository(file)
+		if err != nil {
+			log.Fatalf("Failed to open API keys: %v", err)
+		}
+	}
+	apiKeyService := services.NewAPIKeyService(apiKeyRepository)
+	apiKeyService.RotationGrace = cfg.APIKeyRotationGrace
+	apiKeyService.Roles = accessPolicy.RoleNames()
+	if cfg.BootstrapAPIKey != "" {
+		if err := apiKeyService.EnsureBootstrapKey(context.Background(), cfg.BootstrapAPIKey); err != nil {
+			log.Fatalf("Failed to store the bootstrap API key: %v", err)
+		}
+	}
+
+	webhookService := services.NewWebhookService(webhookRepository)
+	webhookService.Client.Timeout = cfg.WebhookTimeout
+	webhookService.MaxAttempts = cfg.WebhookMaxAttempts
+	webhookService.RetryDelay = cfg.WebhookRetryDelay
+	webhookService.MaxRetryDelay = cfg.WebhookMaxRetryDelay
+	// The webhook outbox: the SQL backends store the deliveries in the
+	// transaction of the customer change, the others derive them from the
+	// history file
+	if historyFile != nil {
+		webhookService.History = historyFile
+	} else {
+		customerService.Outbox = webhookService.CustomerDeliveries
+	}
+	customerService.Subscribe(webhookService.NotifyCustomerEvent)
+
+	deliveryCtx, stopDeliveries := context.WithCancel(context.Background())
+	defer stopDeliveries()
+	go webhookService.RunDeliveries(deliveryCtx, cfg.WebhookPollInterval)
+
+	purgeCtx, stopPurge := context.WithCancel(context.Background())
+	defer stopPurge()
+	go customerService.RunPurge(purgeCtx, cfg.PurgeInterval, cfg.TrashRetention)
+
 	router := mux.NewRouter()
-	services := services.NewCustomerService()
-	customerController := controllers.NewCustomerController(services)
-	customerController.RegisterRoutes(router)
+	api := router.PathPrefix("/api/v1").Subrouter()
+	tickets := auth.NewTicketStore(cfg.StreamTicketTTL)
+	if authentication.apiKeys || authentication.jwts {
+		// Typed nils would not read as turned off
+		var keys services.IAPIKeyService
+		var tokens controllers.TokenVerifier
+		if authentication.apiKeys {
+			keys = apiKeyService
+		}
+		if authentication.jwts {
+			verifier, err := newTokenVerifier(cfg)
+			if err != nil {
+				log.Fatalf("Failed to set up jwt authentication: %v", err)
+			}
+			// A JWKS that cannot be loaded yet is retried when tokens arrive,
+			// so the provider being down does not keep the CRM from starting
+			if err := verifier.Keys.Refresh(context.Background()); err != nil {
+				log.Printf("JWTs are rejected until the JWKS loads: %v", err)
+			}
+			tokens = verifier
+		}
+		api.Use(controllers.Authenticate(keys, tokens, tickets), controllers.Authorize(accessPolicy))
+	} else {
+		log.Print("Authentication is disabled: anyone who can reach the server can use the API")
+	}
+	customerController := controllers.NewCustomerController(customerService)
+	customerController.IdempotencyKeys = idempotency.NewResponseStore(cfg.IdempotencyTTL)
+	customerController.Events = changefeed.NewBroker(cfg.ChangeFeedBuffer)
+	customerController.Tickets = tickets
+	customerController.RegisterRoutes(api)
+	controllers.NewWebhookController(webhookService).RegisterRoutes(api)
+	controllers.NewAPIKeyController(apiKeyService).RegisterRoutes(api)
+	if err := accessPolicy.CheckPermissions(routeNames(api)); err != nil {
+		log.Fatalf("Invalid access policy: %v", err)
+	}
 
 	router.PathPrefix("/swagger").Handler(httpSwagger.WrapHandler)
 
@@ -51,11 +323,41 @@ func main() {
 	docs.SwaggerInfo.BasePath = "/api/v1"
 	docs.SwaggerInfo.Schemes = []string{"http", "https"}
 
-	log.Println("Server is running on port 8080")
+	server := &http.Server{Addr: cfg.Addr, Handler: router}
+	// Shutdown waits for open requests, so the event streams have to end.
+	// It does not wait for WebSockets, but they should be closed too.
+	server.RegisterOnShutdown(customerController.Events.Close)
+	server.RegisterOnShutdown(customerController.Live.Close)
+
+	go func() {
+		log.Printf("Server is running on %s", cfg.Addr)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
 
 	// Open http://localhost:8080/swagger/index.html in the default browser
 	url := "http://localhost:8080/swagger/index.html"
 	openBrowser(url)
 
-	log.Fatal(http.ListenAndServe(":8080", router))
+	// Wait for an interrupt, then drain requests and flush pending writes
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	<-stop
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("Failed to shut down server: %v", err)
+	}
+	stopPurge()
+	stopDeliveries()
+	if err := customerRepository.Close(); err != nil {
+		log.Printf("Failed to close customer storage: %v", err)
+	}
+	if historyFile != nil {
+		if err := historyFile.Close(); err != nil {
+			log.Printf("Failed to close customer history: %v", err)
+		}
+	}
 }
@@ -0,0 +1,31 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// APIKey grants access to the API. Only the hash of the key is stored, so a
+// lost key cannot be shown again, only rotated.
+type APIKey struct {
+	ID   uuid.UUID
+	Name string
+	// Prefix is the start of the key, to tell keys apart without the secret
+	Prefix string
+	// Hash is the hex SHA-256 of the key
+	Hash string
+	// PreviousHash is the hash of the key a rotation replaced, which keeps
+	// working until PreviousExpiresAt
+	PreviousHash      string
+	PreviousExpiresAt *time.Time
+	// Scopes are what the key may do: "read", "write" or "admin"
+	Scopes []string
+	// Roles are the roles of the access policy the key acts in
+	Roles      []string
+	CreatedAt  time.Time
+	ExpiresAt  *time.Time
+	RotatedAt  *time.Time
+	RevokedAt  *time.Time
+	LastUsedAt *time.Time
+}
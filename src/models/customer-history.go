@@ -0,0 +1,40 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// HistoryOperation is the kind of change a history entry records
+type HistoryOperation string
+
+const (
+	HistoryCreate  HistoryOperation = "create"
+	HistoryUpdate  HistoryOperation = "update"
+	HistoryDelete  HistoryOperation = "delete"
+	HistoryRestore HistoryOperation = "restore"
+)
+
+// FieldChange is the value of one customer field before and after a change.
+// Before is nil when the customer was created.
+type FieldChange struct {
+	Field  string
+	Before any
+	After  any
+}
+
+// CustomerHistoryEntry records one change of a customer. Entries are only
+// ever appended, one per version.
+type CustomerHistoryEntry struct {
+	CustomerID uuid.UUID
+	// Version is the version the change created
+	Version   int64
+	Operation HistoryOperation
+	// Actor is the subject of the principal who made the change
+	Actor     string
+	Timestamp time.Time
+	Changes   []FieldChange
+	// Customer is the state the change left the customer in
+	Customer Customer
+}
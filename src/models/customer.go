@@ -1,6 +1,10 @@
 package models
 
-import "github.com/google/uuid"
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
 
 type Customer struct {
 	ID        uuid.UUID
@@ -9,4 +13,15 @@ type Customer struct {
 	Email     string
 	Phone     string
 	Contacted bool
+	// Version starts at 1 and increases with every update
+	Version int64
+	// CreatedAt and UpdatedAt are when the customer was created and last
+	// changed, CreatedBy and UpdatedBy the subjects of the principals who did
+	// so. Customers stored before these were recorded leave them zero.
+	CreatedAt time.Time `json:",omitzero"`
+	UpdatedAt time.Time `json:",omitzero"`
+	CreatedBy string    `json:",omitempty"`
+	UpdatedBy string    `json:",omitempty"`
+	// DeletedAt is set while the customer is in the trash
+	DeletedAt *time.Time `json:",omitempty"`
 }
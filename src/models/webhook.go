@@ -0,0 +1,56 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Webhook is a subscription of an external URL to customer events
+type Webhook struct {
+	ID  uuid.UUID
+	URL string
+	// Secret is the key deliveries are signed with
+	Secret string
+	// Events are the event types delivered, e.g. "customer.created"
+	Events []string
+	// Active is false for a webhook whose deliveries are paused
+	Active    bool
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// DeliveryStatus is where a webhook delivery is in the outbox
+type DeliveryStatus string
+
+const (
+	// DeliveryPending waits for its next attempt
+	DeliveryPending DeliveryStatus = "pending"
+	// DeliveryDelivered was accepted by the receiver
+	DeliveryDelivered DeliveryStatus = "delivered"
+	// DeliveryDead ran out of attempts and is kept as a dead letter
+	DeliveryDead DeliveryStatus = "dead"
+)
+
+// WebhookDelivery is one event to send to one webhook
+type WebhookDelivery struct {
+	ID        uuid.UUID
+	WebhookID uuid.UUID
+	Event     string
+	// Payload is the JSON body sent to the receiver
+	Payload       json.RawMessage
+	Status        DeliveryStatus
+	CreatedAt     time.Time
+	NextAttemptAt time.Time
+	Attempts      []WebhookAttempt
+}
+
+// WebhookAttempt records one try to send a delivery
+type WebhookAttempt struct {
+	Timestamp time.Time
+	// StatusCode is the status of the response, 0 when there was none
+	StatusCode int
+	Error      string
+	Duration   time.Duration
+}
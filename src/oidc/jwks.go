@@ -0,0 +1,281 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"congdinh.com/crm/clock"
+	"golang.org/x/sync/singleflight"
+)
+
+// maxJWKSSize bounds a JWKS document
+const maxJWKSSize = 1 << 20
+
+// ErrUnknownKey is returned for a key ID the key set does not have, even
+// after reloading it
+var ErrUnknownKey = errors.New("unknown signing key")
+
+// JWK is a public key in the JSON Web Key format (RFC 7517). Only RSA keys
+// and EC keys on P-256 are supported.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	// N and E are the modulus and exponent of an RSA key
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// Crv, X and Y are the curve and point of an EC key
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set, as served at the jwks_uri of an OIDC provider
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// NewJWK returns key as a signing JWK with the given key ID
+func NewJWK(kid string, key crypto.PublicKey) (JWK, error) {
+	encode := base64.RawURLEncoding.EncodeToString
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		return JWK{Kty: "RSA", Kid: kid, Use: "sig", Alg: "RS256", N: encode(key.N.Bytes()), E: encode(big.NewInt(int64(key.E)).Bytes())}, nil
+	case *ecdsa.PublicKey:
+		if key.Curve != elliptic.P256() {
+			return JWK{}, errors.New("only P-256 EC keys are supported")
+		}
+		point, err := key.Bytes()
+		if err != nil {
+			return JWK{}, err
+		}
+		// point is 0x04 followed by X and Y
+		size := (len(point) - 1) / 2
+		return JWK{Kty: "EC", Kid: kid, Use: "sig", Alg: "ES256", Crv: "P-256", X: encode(point[1 : 1+size]), Y: encode(point[1+size:])}, nil
+	}
+	return JWK{}, fmt.Errorf("unsupported key type %T", key)
+}
+
+// PublicKey decodes the key
+func (k JWK) PublicKey() (crypto.PublicKey, error) {
+	decode := base64.RawURLEncoding.DecodeString
+	switch k.Kty {
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modulus: %w", err)
+		}
+		e, err := decode(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exponent: %w", err)
+		}
+		exponent := new(big.Int).SetBytes(e)
+		if !exponent.IsInt64() || exponent.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid exponent")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("invalid x: %w", err)
+		}
+		y, err := decode(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("invalid y: %w", err)
+		}
+		if len(x) != 32 || len(y) != 32 {
+			return nil, errors.New("invalid point size")
+		}
+		return ecdsa.ParseUncompressedPublicKey(elliptic.P256(), append(append([]byte{4}, x...), y...))
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+// KeySet holds the signing keys of a JWKS loaded from a file or a URL. The
+// keys are reloaded once they are older than RefreshInterval, and when a
+// token names a key the set does not have, as after the provider rotated its
+// keys, though not more often than MinRefreshInterval. A failed reload keeps
+// the keys already loaded.
+type KeySet struct {
+	source string
+	// Client fetches a URL source
+	Client *http.Client
+	// Clock decides when the keys are reloaded
+	Clock              clock.Clock
+	RefreshInterval    time.Duration
+	MinRefreshInterval time.Duration
+
+	// reloads lets concurrent callers share one reload
+	reloads     singleflight.Group
+	mu          sync.Mutex
+	keys        map[string]crypto.PublicKey
+	loadedAt    time.Time
+	attemptedAt time.Time
+	// err is why the last load failed
+	err error
+}
+
+// NewKeySet creates a key set that loads source, an http(s) URL or a file
+// path. Nothing is loaded until the first key is needed.
+func NewKeySet(source string) *KeySet {
+	return &KeySet{
+		source:             source,
+		Client:             &http.Client{Timeout: 10 * time.Second},
+		Clock:              clock.System,
+		RefreshInterval:    time.Hour,
+		MinRefreshInterval: time.Minute,
+	}
+}
+
+// Key returns the key with the given ID. An empty kid names the only key
+// of a set that has one.
+func (ks *KeySet) Key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	ks.mu.Lock()
+	stale := ks.keys == nil || ks.Clock.Now().Sub(ks.loadedAt) >= ks.RefreshInterval
+	ks.mu.Unlock()
+
+	if stale {
+		ks.refresh(ctx, false)
+	}
+	if key, ok := ks.find(kid); ok {
+		return key, nil
+	}
+	// The provider may have rotated its keys
+	err := ks.refresh(ctx, false)
+	if key, ok := ks.find(kid); ok {
+		return key, nil
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	if ks.keys == nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("%w %q", ErrUnknownKey, kid)
+}
+
+// Refresh reloads the keys right away
+func (ks *KeySet) Refresh(ctx context.Context) error {
+	return ks.refresh(ctx, true)
+}
+
+func (ks *KeySet) find(kid string) (crypto.PublicKey, bool) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	if kid == "" && len(ks.keys) == 1 {
+		for _, key := range ks.keys {
+			return key, true
+		}
+	}
+	key, ok := ks.keys[kid]
+	return key, ok
+}
+
+// refresh reloads the keys, unless force is false and that was tried less
+// than MinRefreshInterval ago, so a burst of bad tokens or a provider that is
+// down does not turn into a burst of requests for the JWKS. Callers that
+// arrive during a reload wait for it instead of starting another. The reload
+// runs without the lock, and a caller that gives up on it does not cancel it
+// for the others.
+func (ks *KeySet) refresh(ctx context.Context, force bool) error {
+	reload := ks.reloads.DoChan("", func() (any, error) {
+		ks.mu.Lock()
+		now := ks.Clock.Now()
+		if !force && !ks.attemptedAt.IsZero() && now.Sub(ks.attemptedAt) < ks.MinRefreshInterval {
+			defer ks.mu.Unlock()
+			return nil, ks.err
+		}
+		ks.attemptedAt = now
+		ks.mu.Unlock()
+
+		keys, err := ks.load(context.WithoutCancel(ctx))
+
+		ks.mu.Lock()
+		defer ks.mu.Unlock()
+		ks.err = err
+		if err != nil {
+			if ks.keys != nil {
+				log.Printf("Keeping the keys loaded before: %v", err)
+			}
+			return nil, err
+		}
+		ks.keys = keys
+		ks.loadedAt = now
+		return nil, nil
+	})
+
+	select {
+	case result := <-reload:
+		return result.Err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// load returns the keys of the source
+func (ks *KeySet) load(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	data, err := ks.read(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the JWKS from %s: %w", ks.source, err)
+	}
+	var set JWKS
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("failed to parse the JWKS from %s: %w", ks.source, err)
+	}
+
+	keys := map[string]crypto.PublicKey{}
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.PublicKey()
+		if err != nil {
+			// One odd key must not take down the others
+			log.Printf("Skipping key %q of the JWKS from %s: %v", jwk.Kid, ks.source, err)
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+	return keys, nil
+}
+
+func (ks *KeySet) read(ctx context.Context) ([]byte, error) {
+	if !strings.HasPrefix(ks.source, "http://") && !strings.HasPrefix(ks.source, "https://") {
+		return os.ReadFile(ks.source)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ks.source, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := ks.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxJWKSSize))
+}
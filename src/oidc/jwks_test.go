@@ -0,0 +1,191 @@
+package oidc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"congdinh.com/crm/clock"
+)
+
+func newRSAKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate an RSA key: %v", err)
+	}
+	return key
+}
+
+func newECKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate an EC key: %v", err)
+	}
+	return key
+}
+
+// newJWKS returns the JWKS of the public halves of keys, by key ID
+func newJWKS(t *testing.T, keys map[string]any) []byte {
+	t.Helper()
+	set := JWKS{Keys: []JWK{}}
+	for kid, key := range keys {
+		var jwk JWK
+		var err error
+		switch key := key.(type) {
+		case *rsa.PrivateKey:
+			jwk, err = NewJWK(kid, &key.PublicKey)
+		case *ecdsa.PrivateKey:
+			jwk, err = NewJWK(kid, &key.PublicKey)
+		}
+		if err != nil {
+			t.Fatalf("Failed to encode key %s: %v", kid, err)
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	data, err := json.Marshal(set)
+	if err != nil {
+		t.Fatalf("Failed to marshal the JWKS: %v", err)
+	}
+	return data
+}
+
+func TestKeySet_File(t *testing.T) {
+	rsaKey := newRSAKey(t)
+	ecKey := newECKey(t)
+	encryption, _ := NewJWK("enc", &newRSAKey(t).PublicKey)
+	encryption.Use = "enc"
+
+	var set JWKS
+	json.Unmarshal(newJWKS(t, map[string]any{"rsa": rsaKey, "ec": ecKey}), &set)
+	set.Keys = append(set.Keys, encryption, JWK{Kty: "oct", Kid: "secret"})
+	data, _ := json.Marshal(set)
+	file := filepath.Join(t.TempDir(), "jwks.json")
+	os.WriteFile(file, data, 0o600)
+
+	keys := NewKeySet(file)
+	if key, err := keys.Key(t.Context(), "rsa"); err != nil || !rsaKey.PublicKey.Equal(key) {
+		t.Errorf("Expected the RSA key, but got %v, %v", key, err)
+	}
+	if key, err := keys.Key(t.Context(), "ec"); err != nil || !ecKey.PublicKey.Equal(key) {
+		t.Errorf("Expected the EC key, but got %v, %v", key, err)
+	}
+	for _, kid := range []string{"enc", "secret", "missing", ""} {
+		if _, err := keys.Key(t.Context(), kid); !errors.Is(err, ErrUnknownKey) {
+			t.Errorf("Expected ErrUnknownKey for %q, but got %v", kid, err)
+		}
+	}
+
+	if _, err := NewKeySet(filepath.Join(t.TempDir(), "missing.json")).Key(t.Context(), "rsa"); err == nil || errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Expected the error loading a missing file, but got %v", err)
+	}
+}
+
+func TestKeySet_URL(t *testing.T) {
+	first := newRSAKey(t)
+	second := newECKey(t)
+	var jwks atomic.Value
+	jwks.Store(newJWKS(t, map[string]any{"first": first}))
+	var failing atomic.Bool
+	var fetches atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		if failing.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write(jwks.Load().([]byte))
+	}))
+	defer server.Close()
+
+	now := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	keys := NewKeySet(server.URL)
+	keys.Clock = clock.Func(func() time.Time { return now })
+
+	// A set of one key serves tokens without a kid
+	if key, err := keys.Key(t.Context(), ""); err != nil || !first.PublicKey.Equal(key) {
+		t.Fatalf("Expected the only key, but got %v, %v", key, err)
+	}
+	keys.Key(t.Context(), "first")
+	if fetches.Load() != 1 {
+		t.Errorf("Expected the keys to be cached, but got %d fetches", fetches.Load())
+	}
+
+	// The provider rotates its keys: an unknown kid reloads them, though not
+	// more often than MinRefreshInterval
+	jwks.Store(newJWKS(t, map[string]any{"first": first, "second": second}))
+	if _, err := keys.Key(t.Context(), "second"); !errors.Is(err, ErrUnknownKey) || fetches.Load() != 1 {
+		t.Errorf("Expected no reload right after loading, but got %v after %d fetches", err, fetches.Load())
+	}
+	now = now.Add(keys.MinRefreshInterval)
+	if key, err := keys.Key(t.Context(), "second"); err != nil || !second.PublicKey.Equal(key) || fetches.Load() != 2 {
+		t.Errorf("Expected the rotated key, but got %v, %v after %d fetches", key, err, fetches.Load())
+	}
+
+	// Stale keys are reloaded, and kept when that fails
+	failing.Store(true)
+	now = now.Add(keys.RefreshInterval)
+	if key, err := keys.Key(t.Context(), "first"); err != nil || !first.PublicKey.Equal(key) || fetches.Load() != 3 {
+		t.Errorf("Expected the keys loaded before, but got %v, %v after %d fetches", key, err, fetches.Load())
+	}
+	if err := keys.Refresh(t.Context()); err == nil {
+		t.Errorf("Expected the error of the failing provider, but got nil")
+	}
+}
+
+func TestKeySet_ConcurrentRefresh(t *testing.T) {
+	key := newRSAKey(t)
+	jwks := newJWKS(t, map[string]any{"key": key})
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var fetches atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fetches.Add(1) == 1 {
+			close(started)
+		}
+		<-release
+		w.Write(jwks)
+	}))
+	defer server.Close()
+	keys := NewKeySet(server.URL)
+
+	// A request that gives up neither waits for the fetch nor cancels it
+	ctx, cancel := context.WithCancel(t.Context())
+	canceled := make(chan error, 1)
+	go func() {
+		_, err := keys.Key(ctx, "key")
+		canceled <- err
+	}()
+	<-started
+	cancel()
+	if err := <-canceled; !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the canceled request to give up, but got %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Go(func() {
+			if found, err := keys.Key(t.Context(), "key"); err != nil || !key.PublicKey.Equal(found) {
+				t.Errorf("Expected the key, but got %v, %v", found, err)
+			}
+		})
+	}
+	close(release)
+	wg.Wait()
+	if fetches.Load() != 1 {
+		t.Errorf("Expected the requests to share one fetch, but got %d", fetches.Load())
+	}
+}
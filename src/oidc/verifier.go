@@ -0,0 +1,133 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"congdinh.com/crm/auth"
+	"congdinh.com/crm/clock"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ErrInvalidToken is returned for a token that is malformed, badly signed,
+// expired, or not meant for this API
+var ErrInvalidToken = errors.New("invalid token")
+
+// Verifier checks the JWTs an OIDC provider issues and turns them into
+// principals. Tokens must be signed with RS256 or ES256 by a key of Keys,
+// issued by Issuer for Audience, when those are set, and carry an expiry.
+type Verifier struct {
+	Keys     *KeySet
+	Issuer   string
+	Audience string
+	// ClockSkew is how far the clocks of the provider and the API may drift
+	// apart when checking the expiry and not-before times
+	ClockSkew time.Duration
+	// SubjectClaim names the claim that identifies the caller
+	SubjectClaim string
+	// RolesClaim names the claim with the roles of the caller in the access policy
+	RolesClaim string
+	// ScopeClaim names the claim with the scopes of the caller; empty reads
+	// scope, or scp as some providers name it
+	ScopeClaim string
+	// ScopePrefix is what the values of the scope claim meant for this API
+	// start with, like "crm." in "openid profile crm.write". Other values are
+	// ignored and the prefix is cut off the rest.
+	ScopePrefix string
+	// DefaultScopes are granted to tokens without any scope of this API, so
+	// the roles of the access policy alone can decide what SSO users may do
+	DefaultScopes []string
+	// Clock decides whether a token has expired
+	Clock clock.Clock
+}
+
+// NewVerifier creates a verifier of tokens issued by issuer for audience
+func NewVerifier(keys *KeySet, issuer string, audience string) *Verifier {
+	return &Verifier{
+		Keys:         keys,
+		Issuer:       issuer,
+		Audience:     audience,
+		ClockSkew:    time.Minute,
+		SubjectClaim: "sub",
+		RolesClaim:   "roles",
+		Clock:        clock.System,
+	}
+}
+
+// Verify returns the principal of token: its subject, the scopes of its
+// scope claim and its roles
+func (v *Verifier) Verify(ctx context.Context, token string) (auth.Principal, error) {
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodES256.Alg()}),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(v.ClockSkew),
+		jwt.WithTimeFunc(v.Clock.Now),
+	}
+	if v.Issuer != "" {
+		options = append(options, jwt.WithIssuer(v.Issuer))
+	}
+	if v.Audience != "" {
+		options = append(options, jwt.WithAudience(v.Audience))
+	}
+
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (any, error) {
+		kid, _ := token.Header["kid"].(string)
+		return v.Keys.Key(ctx, kid)
+	}, options...)
+	if err != nil {
+		return auth.Principal{}, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	subject, _ := claims[v.SubjectClaim].(string)
+	if subject == "" {
+		return auth.Principal{}, fmt.Errorf("%w: the %s claim is missing", ErrInvalidToken, v.SubjectClaim)
+	}
+	return auth.Principal{Subject: subject, Scopes: v.scopes(claims), Roles: claimList(claims[v.RolesClaim])}, nil
+}
+
+// scopes returns the scopes of this API that claims grant, or DefaultScopes
+// when they grant none. Values that are not scopes of the API, like the
+// openid and profile scopes of OIDC, are ignored.
+func (v *Verifier) scopes(claims jwt.MapClaims) []string {
+	claim, ok := claims[v.ScopeClaim]
+	if v.ScopeClaim == "" {
+		if claim, ok = claims["scope"]; !ok {
+			claim = claims["scp"]
+		}
+	}
+
+	var scopes []string
+	for _, value := range claimList(claim) {
+		scope, ok := strings.CutPrefix(value, v.ScopePrefix)
+		if ok && slices.Contains(auth.Scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	if len(scopes) == 0 {
+		return slices.Clone(v.DefaultScopes)
+	}
+	return scopes
+}
+
+// claimList returns the values of a claim that is a space separated string
+// or a list of strings
+func claimList(claim any) []string {
+	switch claim := claim.(type) {
+	case string:
+		return strings.Fields(claim)
+	case []any:
+		result := []string{}
+		for _, scope := range claim {
+			if scope, ok := scope.(string); ok {
+				result = append(result, scope)
+			}
+		}
+		return result
+	}
+	return nil
+}
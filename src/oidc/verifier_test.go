@@ -0,0 +1,157 @@
+package oidc
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"congdinh.com/crm/clock"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	testIssuer   = "https://sso.example.com"
+	testAudience = "crm"
+)
+
+// signToken returns a JWT of claims signed with key
+func signToken(t *testing.T, method jwt.SigningMethod, kid string, key any, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("Failed to sign the token: %v", err)
+	}
+	return signed
+}
+
+func TestVerifier_Verify(t *testing.T) {
+	rsaKey := newRSAKey(t)
+	ecKey := newECKey(t)
+	file := filepath.Join(t.TempDir(), "jwks.json")
+	os.WriteFile(file, newJWKS(t, map[string]any{"rsa": rsaKey, "ec": ecKey}), 0o600)
+
+	now := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	verifier := NewVerifier(NewKeySet(file), testIssuer, testAudience)
+	verifier.Clock = clock.Fixed(now)
+	claims := func(changes jwt.MapClaims) jwt.MapClaims {
+		claims := jwt.MapClaims{
+			"iss":   testIssuer,
+			"aud":   testAudience,
+			"sub":   "alice@example.com",
+			"scope": "read write",
+			"roles": []any{"sales-rep"},
+			"iat":   now.Add(-time.Minute).Unix(),
+			"exp":   now.Add(time.Hour).Unix(),
+		}
+		for claim, value := range changes {
+			if value == nil {
+				delete(claims, claim)
+			} else {
+				claims[claim] = value
+			}
+		}
+		return claims
+	}
+
+	principal, err := verifier.Verify(t.Context(), signToken(t, jwt.SigningMethodRS256, "rsa", rsaKey, claims(nil)))
+	if err != nil || principal.Subject != "alice@example.com" || !slices.Equal(principal.Scopes, []string{"read", "write"}) || !slices.Equal(principal.Roles, []string{"sales-rep"}) {
+		t.Errorf("Expected the principal of the RS256 token, but got %v, %v", principal, err)
+	}
+	principal, err = verifier.Verify(t.Context(), signToken(t, jwt.SigningMethodES256, "ec", ecKey, claims(jwt.MapClaims{"scope": nil, "scp": []any{"admin"}, "aud": []any{"other", testAudience}})))
+	if err != nil || !slices.Equal(principal.Scopes, []string{"admin"}) {
+		t.Errorf("Expected the principal of the ES256 token, but got %v, %v", principal, err)
+	}
+	// Clocks that drift less than ClockSkew apart are tolerated
+	skewed := claims(jwt.MapClaims{"exp": now.Add(-30 * time.Second).Unix(), "nbf": now.Add(30 * time.Second).Unix()})
+	if _, err := verifier.Verify(t.Context(), signToken(t, jwt.SigningMethodRS256, "rsa", rsaKey, skewed)); err != nil {
+		t.Errorf("Expected a token within the clock skew to pass, but got %v", err)
+	}
+	verifier.SubjectClaim = "email"
+	principal, err = verifier.Verify(t.Context(), signToken(t, jwt.SigningMethodRS256, "rsa", rsaKey, claims(jwt.MapClaims{"email": "bob@example.com"})))
+	if err != nil || principal.Subject != "bob@example.com" {
+		t.Errorf("Expected the subject from the email claim, but got %v, %v", principal, err)
+	}
+	verifier.SubjectClaim = "sub"
+
+	invalid := map[string]string{
+		"expired":         signToken(t, jwt.SigningMethodRS256, "rsa", rsaKey, claims(jwt.MapClaims{"exp": now.Add(-2 * time.Minute).Unix()})),
+		"not yet valid":   signToken(t, jwt.SigningMethodRS256, "rsa", rsaKey, claims(jwt.MapClaims{"nbf": now.Add(2 * time.Minute).Unix()})),
+		"without expiry":  signToken(t, jwt.SigningMethodRS256, "rsa", rsaKey, claims(jwt.MapClaims{"exp": nil})),
+		"other issuer":    signToken(t, jwt.SigningMethodRS256, "rsa", rsaKey, claims(jwt.MapClaims{"iss": "https://evil.example.com"})),
+		"other audience":  signToken(t, jwt.SigningMethodRS256, "rsa", rsaKey, claims(jwt.MapClaims{"aud": "billing"})),
+		"without subject": signToken(t, jwt.SigningMethodRS256, "rsa", rsaKey, claims(jwt.MapClaims{"sub": nil})),
+		"unknown key":     signToken(t, jwt.SigningMethodRS256, "rsa", newRSAKey(t), claims(nil)),
+		"unknown kid":     signToken(t, jwt.SigningMethodRS256, "missing", rsaKey, claims(nil)),
+		"HS256":           signToken(t, jwt.SigningMethodHS256, "rsa", []byte("secret"), claims(nil)),
+		"unsigned":        signToken(t, jwt.SigningMethodNone, "rsa", jwt.UnsafeAllowNoneSignatureType, claims(nil)),
+		"malformed":       "a.b.c",
+	}
+	for name, token := range invalid {
+		if _, err := verifier.Verify(t.Context(), token); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("Expected ErrInvalidToken for the %s token, but got %v", name, err)
+		}
+	}
+}
+
+func TestVerifier_VerifySSOScopes(t *testing.T) {
+	key := newRSAKey(t)
+	file := filepath.Join(t.TempDir(), "jwks.json")
+	os.WriteFile(file, newJWKS(t, map[string]any{"rsa": key}), 0o600)
+
+	now := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	verifier := NewVerifier(NewKeySet(file), testIssuer, testAudience)
+	verifier.Clock = clock.Fixed(now)
+	verifier.SubjectClaim = "email"
+	// An access token as a Keycloak realm issues it to a CRM client
+	token := func(changes jwt.MapClaims) string {
+		claims := jwt.MapClaims{
+			"iss":                testIssuer,
+			"aud":                []any{testAudience, "account"},
+			"sub":                "f1c7c6a4-3b7e-4d0a-9d55-2a8f0a3b9c11",
+			"typ":                "Bearer",
+			"azp":                "crm-web",
+			"scope":              "openid profile email",
+			"email":              "alice@example.com",
+			"email_verified":     true,
+			"preferred_username": "alice",
+			"roles":              []any{"sales-rep", "offline_access"},
+			"iat":                now.Add(-time.Minute).Unix(),
+			"exp":                now.Add(5 * time.Minute).Unix(),
+		}
+		for claim, value := range changes {
+			claims[claim] = value
+		}
+		return signToken(t, jwt.SigningMethodRS256, "rsa", key, claims)
+	}
+
+	principal, err := verifier.Verify(t.Context(), token(nil))
+	if err != nil || principal.Subject != "alice@example.com" || len(principal.Scopes) != 0 {
+		t.Errorf("Expected the OIDC scopes not to grant CRM scopes, but got %v, %v", principal, err)
+	}
+
+	verifier.DefaultScopes = []string{"write"}
+	principal, err = verifier.Verify(t.Context(), token(nil))
+	if err != nil || !slices.Equal(principal.Scopes, []string{"write"}) || !slices.Equal(principal.Roles, []string{"sales-rep", "offline_access"}) {
+		t.Errorf("Expected the default scopes and the roles of the token, but got %v, %v", principal, err)
+	}
+
+	verifier.ScopePrefix = "crm."
+	principal, err = verifier.Verify(t.Context(), token(jwt.MapClaims{"scope": "openid profile email crm.read read"}))
+	if err != nil || !slices.Equal(principal.Scopes, []string{"read"}) {
+		t.Errorf("Expected only the prefixed scope, but got %v, %v", principal, err)
+	}
+
+	// Azure AD names its scopes in scp and its application roles in roles
+	verifier.ScopeClaim = "scp"
+	principal, err = verifier.Verify(t.Context(), token(jwt.MapClaims{"scope": "crm.read", "scp": "crm.admin User.Read"}))
+	if err != nil || !slices.Equal(principal.Scopes, []string{"admin"}) {
+		t.Errorf("Expected the scopes of the scp claim, but got %v, %v", principal, err)
+	}
+}
@@ -0,0 +1,133 @@
+package policy
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	viewmodels "congdinh.com/crm/view-models"
+)
+
+// Access is what a caller may do and see under a policy
+type Access struct {
+	permissions []string
+	hidden      []string
+}
+
+// Unrestricted may do and see everything. It is the access of requests
+// without a policy, as when authentication is disabled.
+var Unrestricted = Access{permissions: []string{allPermissions}}
+
+// Allows reports whether the caller may use the route named permission
+func (a Access) Allows(permission string) bool {
+	return slices.ContainsFunc(a.permissions, func(granted string) bool { return grants(granted, permission) })
+}
+
+// Hides reports whether the caller may not see the customer field
+func (a Access) Hides(field string) bool {
+	return slices.Contains(a.hidden, field)
+}
+
+// Restricted reports whether the caller may not see some customer fields
+func (a Access) Restricted() bool {
+	return len(a.hidden) > 0
+}
+
+// Customer returns customer with the fields the caller may not see cleared
+func (a Access) Customer(customer viewmodels.CustomerViewModel) viewmodels.CustomerViewModel {
+	for _, field := range a.hidden {
+		switch field {
+		case "Name":
+			customer.Name = ""
+		case "Role":
+			customer.Role = ""
+		case "Email":
+			customer.Email = ""
+		case "Phone":
+			customer.Phone = ""
+		case "Contacted":
+			customer.Contacted = false
+		}
+	}
+	return customer
+}
+
+// KeepHidden returns edit with the fields the caller may not see taken from
+// current, so replacing a customer does not wipe them
+func (a Access) KeepHidden(edit viewmodels.CustomerEditViewModel, current viewmodels.CustomerViewModel) viewmodels.CustomerEditViewModel {
+	for _, field := range a.hidden {
+		switch field {
+		case "Name":
+			edit.Name = current.Name
+		case "Role":
+			edit.Role = current.Role
+		case "Email":
+			edit.Email = current.Email
+		case "Phone":
+			edit.Phone = current.Phone
+		case "Contacted":
+			edit.Contacted = current.Contacted
+		}
+	}
+	return edit
+}
+
+// CheckWrite returns a *HiddenFieldError for the first field the caller may
+// not see that edit sets. Writing it would change what the caller cannot see,
+// and the outcome, such as a conflict with another customer, would reveal it.
+func (a Access) CheckWrite(edit viewmodels.CustomerEditViewModel) error {
+	for _, field := range a.hidden {
+		var set bool
+		switch field {
+		case "Name":
+			set = edit.Name != ""
+		case "Role":
+			set = edit.Role != ""
+		case "Email":
+			set = edit.Email != ""
+		case "Phone":
+			set = edit.Phone != ""
+		case "Contacted":
+			set = edit.Contacted
+		}
+		if set {
+			return &HiddenFieldError{Field: field}
+		}
+	}
+	return nil
+}
+
+// Changes returns changes without those of fields the caller may not see
+func (a Access) Changes(changes []viewmodels.FieldChangeViewModel) []viewmodels.FieldChangeViewModel {
+	if !a.Restricted() {
+		return changes
+	}
+	return slices.DeleteFunc(slices.Clone(changes), func(change viewmodels.FieldChangeViewModel) bool {
+		return a.Hides(change.Field)
+	})
+}
+
+// HiddenFieldError rejects a request that would reveal a field the caller
+// may not see, such as filtering or sorting by it
+type HiddenFieldError struct {
+	Field string
+}
+
+func (e *HiddenFieldError) Error() string {
+	return fmt.Sprintf("the %s field is hidden from the caller", e.Field)
+}
+
+type accessKey struct{}
+
+// WithAccess returns a copy of ctx that carries access
+func WithAccess(ctx context.Context, access Access) context.Context {
+	return context.WithValue(ctx, accessKey{}, access)
+}
+
+// FromContext returns the access ctx carries, or Unrestricted
+func FromContext(ctx context.Context) Access {
+	if access, ok := ctx.Value(accessKey{}).(Access); ok {
+		return access
+	}
+	return Unrestricted
+}
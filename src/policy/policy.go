@@ -0,0 +1,155 @@
+package policy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+
+	"congdinh.com/crm/auth"
+)
+
+// Permissions are the names of the routes a role may use, such as
+// "customers.delete". "customers.*" allows every route of the customers and
+// "*" allows every route.
+const allPermissions = "*"
+
+// CustomerFields are the customer fields a role can be kept from seeing
+var CustomerFields = []string{"Name", "Role", "Email", "Phone", "Contacted"}
+
+// Role is what callers in a role may do and see
+type Role struct {
+	// Permissions are the routes the role may use
+	Permissions []string
+	// HiddenFields are the customer fields the role does not see
+	HiddenFields []string
+}
+
+// Policy maps the roles of callers to what they may do and see
+type Policy struct {
+	// DefaultRoles are the roles of callers that have none
+	DefaultRoles []string
+	Roles        map[string]Role
+}
+
+// Default is the policy used without a policy file. Callers without roles
+// are viewers, so a key or token nobody gave roles cannot delete customers
+// or see their phones.
+func Default() *Policy {
+	viewer := []string{"customers.list", "customers.search", "customers.get", "customers.history", "customers.events", "customers.live", "customers.stream-tickets"}
+	return &Policy{
+		DefaultRoles: []string{"viewer"},
+		Roles: map[string]Role{
+			"viewer": {Permissions: viewer, HiddenFields: []string{"Phone"}},
+			"sales-rep": {Permissions: append(slices.Clone(viewer),
+				"customers.create", "customers.update", "customers.patch", "customers.batch", "customers.export")},
+			"manager": {Permissions: []string{"customers.*"}},
+			"admin":   {Permissions: []string{allPermissions}},
+		},
+	}
+}
+
+// Load reads a policy from a JSON file
+func Load(file string) (*Policy, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	// A misspelled key would silently grant or hide nothing
+	decoder.DisallowUnknownFields()
+	var policy Policy
+	if err := decoder.Decode(&policy); err != nil {
+		return nil, fmt.Errorf("failed to parse the policy %s: %w", file, err)
+	}
+	if err := policy.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid policy %s: %w", file, err)
+	}
+	return &policy, nil
+}
+
+// Validate checks that the default roles exist and the hidden fields are
+// CustomerFields
+func (p *Policy) Validate() error {
+	var errs []error
+	if len(p.Roles) == 0 {
+		errs = append(errs, errors.New("no roles"))
+	}
+	for _, role := range p.DefaultRoles {
+		if _, ok := p.Roles[role]; !ok {
+			errs = append(errs, fmt.Errorf("unknown default role %q", role))
+		}
+	}
+	for _, name := range p.RoleNames() {
+		for _, field := range p.Roles[name].HiddenFields {
+			if !slices.Contains(CustomerFields, field) {
+				errs = append(errs, fmt.Errorf("role %q hides unknown field %q, expected one of %s", name, field, strings.Join(CustomerFields, ", ")))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// CheckPermissions returns an error for permissions that match none of the
+// routes, which are probably misspelled
+func (p *Policy) CheckPermissions(routes []string) error {
+	var errs []error
+	for _, name := range p.RoleNames() {
+		for _, permission := range p.Roles[name].Permissions {
+			if !slices.ContainsFunc(routes, func(route string) bool { return grants(permission, route) }) {
+				errs = append(errs, fmt.Errorf("role %q has permission %q, which matches no route", name, permission))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// RoleNames returns the names of the roles in order
+func (p *Policy) RoleNames() []string {
+	return slices.Sorted(maps.Keys(p.Roles))
+}
+
+// Access returns what principal may do and see: every permission of its
+// roles, and only the fields all of them hide stay hidden. Roles the policy
+// does not have grant nothing.
+func (p *Policy) Access(principal auth.Principal) Access {
+	roles := principal.Roles
+	if len(roles) == 0 {
+		roles = p.DefaultRoles
+	}
+
+	access := Access{}
+	hidden := map[string]int{}
+	known := 0
+	for _, name := range roles {
+		role, ok := p.Roles[name]
+		if !ok {
+			continue
+		}
+		known++
+		access.permissions = append(access.permissions, role.Permissions...)
+		for _, field := range slices.Compact(slices.Sorted(slices.Values(role.HiddenFields))) {
+			hidden[field]++
+		}
+	}
+	for field, count := range hidden {
+		if count == known {
+			access.hidden = append(access.hidden, field)
+		}
+	}
+	slices.Sort(access.hidden)
+	return access
+}
+
+// grants reports whether permission allows route
+func grants(permission string, route string) bool {
+	if permission == allPermissions || permission == route {
+		return true
+	}
+	prefix, ok := strings.CutSuffix(permission, "*")
+	return ok && strings.HasSuffix(prefix, ".") && strings.HasPrefix(route, prefix)
+}
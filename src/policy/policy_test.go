@@ -0,0 +1,108 @@
+package policy
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"congdinh.com/crm/auth"
+	viewmodels "congdinh.com/crm/view-models"
+)
+
+func TestPolicy_Access(t *testing.T) {
+	policy := &Policy{
+		DefaultRoles: []string{"viewer"},
+		Roles: map[string]Role{
+			"viewer":    {Permissions: []string{"customers.list", "customers.get"}, HiddenFields: []string{"Phone", "Email"}},
+			"sales-rep": {Permissions: []string{"customers.update"}, HiddenFields: []string{"Phone"}},
+			"manager":   {Permissions: []string{"customers.*"}},
+		},
+	}
+
+	viewer := policy.Access(auth.Principal{Subject: "nobody"})
+	if !viewer.Allows("customers.get") || viewer.Allows("customers.update") || !viewer.Hides("Phone") || !viewer.Hides("Email") {
+		t.Errorf("Expected a caller without roles to be a viewer, but got %v", viewer)
+	}
+	salesRep := policy.Access(auth.Principal{Roles: []string{"viewer", "sales-rep"}})
+	if !salesRep.Allows("customers.get") || !salesRep.Allows("customers.update") || !salesRep.Hides("Phone") || salesRep.Hides("Email") {
+		t.Errorf("Expected the permissions of both roles and only the fields both hide, but got %v", salesRep)
+	}
+	manager := policy.Access(auth.Principal{Roles: []string{"manager", "viewer"}})
+	if !manager.Allows("customers.delete") || manager.Allows("webhooks.list") || manager.Restricted() {
+		t.Errorf("Expected the manager to use every customer route and see every field, but got %v", manager)
+	}
+	if unknown := policy.Access(auth.Principal{Roles: []string{"root"}}); unknown.Allows("customers.list") || unknown.Restricted() {
+		t.Errorf("Expected an unknown role to grant nothing, but got %v", unknown)
+	}
+	if !Unrestricted.Allows("api-keys.revoke") || Unrestricted.Restricted() {
+		t.Errorf("Expected Unrestricted to allow everything")
+	}
+
+	customer := viewer.Customer(viewmodels.CustomerViewModel{Name: "Lead", Email: "lead@domain.com", Phone: "5550009303"})
+	if customer.Name != "Lead" || customer.Email != "" || customer.Phone != "" {
+		t.Errorf("Expected the email and phone to be cleared, but got %v", customer)
+	}
+	changes := viewer.Changes([]viewmodels.FieldChangeViewModel{{Field: "Name"}, {Field: "Phone"}})
+	if len(changes) != 1 || changes[0].Field != "Name" {
+		t.Errorf("Expected only the name change, but got %v", changes)
+	}
+	edit := salesRep.KeepHidden(viewmodels.CustomerEditViewModel{Name: "Renamed"}, viewmodels.CustomerViewModel{Name: "Lead", Phone: "5550009303"})
+	if edit.Name != "Renamed" || edit.Phone != "5550009303" {
+		t.Errorf("Expected the hidden phone to be kept, but got %v", edit)
+	}
+}
+
+func TestDefault(t *testing.T) {
+	policy := Default()
+	if err := policy.Validate(); err != nil {
+		t.Fatalf("Expected the default policy to be valid, but got %v", err)
+	}
+	nobody := policy.Access(auth.Principal{Subject: "nobody"})
+	if nobody.Allows("customers.delete") || nobody.Allows("api-keys.create") || !nobody.Allows("customers.get") || !nobody.Hides("Phone") {
+		t.Errorf("Expected a caller without roles to be a viewer, but got %v", nobody)
+	}
+	if admin := policy.Access(auth.Principal{Roles: []string{"admin"}}); !admin.Allows("api-keys.create") || admin.Restricted() {
+		t.Errorf("Expected an admin to do and see everything, but got %v", admin)
+	}
+}
+
+func TestPolicy_CheckPermissions(t *testing.T) {
+	routes := []string{"customers.list", "customers.delete", "webhooks.list"}
+	if err := Default().CheckPermissions(append(routes, "customers.get", "customers.search", "customers.history", "customers.events",
+		"customers.live", "customers.stream-tickets", "customers.create", "customers.update", "customers.patch", "customers.batch", "customers.export")); err != nil {
+		t.Errorf("Expected the default policy to match the routes, but got %v", err)
+	}
+	policy := &Policy{Roles: map[string]Role{"viewer": {Permissions: []string{"customers.lst", "webhooks.*"}}}}
+	if err := policy.CheckPermissions(routes); err == nil || !strings.Contains(err.Error(), "customers.lst") || strings.Contains(err.Error(), "webhooks.*") {
+		t.Errorf("Expected only the misspelled permission to be reported, but got %v", err)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	write := func(content string) string {
+		file := filepath.Join(dir, "policy.json")
+		os.WriteFile(file, []byte(content), 0o600)
+		return file
+	}
+
+	policy, err := Load(write(`{"DefaultRoles": ["viewer"], "Roles": {"viewer": {"Permissions": ["customers.list"], "HiddenFields": ["Phone"]}, "admin": {"Permissions": ["*"]}}}`))
+	if err != nil || !slices.Equal(policy.RoleNames(), []string{"admin", "viewer"}) {
+		t.Fatalf("Expected the policy with its roles, but got %v, %v", policy, err)
+	}
+
+	invalid := map[string]string{
+		"unknown key":          `{"Roles": {"viewer": {"Permission": ["customers.list"]}}}`,
+		"unknown hidden field": `{"Roles": {"viewer": {"HiddenFields": ["Address"]}}}`,
+		"unknown default role": `{"DefaultRoles": ["guest"], "Roles": {"viewer": {}}}`,
+		"no roles":             `{}`,
+		"malformed":            `{"Roles":`,
+	}
+	for name, content := range invalid {
+		if _, err := Load(write(content)); err == nil {
+			t.Errorf("Expected an error for a policy with %s, but got nil", name)
+		}
+	}
+}
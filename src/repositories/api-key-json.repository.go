@@ -0,0 +1,139 @@
+package repositories
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"slices"
+	"sync"
+	"time"
+
+	"congdinh.com/crm/models"
+	"github.com/google/uuid"
+)
+
+// DefaultAPIKeyFile returns the path of data/api-keys.json next to the bundled JSON data
+func DefaultAPIKeyFile() string {
+	return path.Join(path.Dir(DefaultDataFile()), "api-keys.json")
+}
+
+// JSONAPIKeyRepository keeps the API keys in memory and, when it has a file,
+// rewrites the file atomically after every change
+type JSONAPIKeyRepository struct {
+	file string
+
+	mu sync.RWMutex
+	// keys are in creation order
+	keys []models.APIKey
+}
+
+// NewJSONAPIKeyRepository opens the API key file at file, which is created on
+// the first change
+func NewJSONAPIKeyRepository(file string) (*JSONAPIKeyRepository, error) {
+	r := NewMemoryAPIKeyRepository()
+	r.file = file
+
+	data, err := os.ReadFile(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read api keys: %w", err)
+	}
+	if err := json.Unmarshal(data, &r.keys); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal api keys: %w", err)
+	}
+	return r, nil
+}
+
+// NewMemoryAPIKeyRepository creates an API key repository that only lives in memory
+func NewMemoryAPIKeyRepository() *JSONAPIKeyRepository {
+	return &JSONAPIKeyRepository{keys: []models.APIKey{}}
+}
+
+// update stores the keys change returns, writing them to the file first, so
+// memory and file stay the same when the write fails
+func (r *JSONAPIKeyRepository) update(change func(keys []models.APIKey) ([]models.APIKey, error)) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	keys, err := change(slices.Clone(r.keys))
+	if err != nil {
+		return err
+	}
+	if r.file != "" {
+		content, err := json.Marshal(keys)
+		if err != nil {
+			return fmt.Errorf("failed to marshal JSON: %w", err)
+		}
+		if err := writeFileAtomic(r.file, content); err != nil {
+			return err
+		}
+	}
+	r.keys = keys
+	return nil
+}
+
+// ListAPIKeys returns the keys in creation order
+func (r *JSONAPIKeyRepository) ListAPIKeys(ctx context.Context) ([]models.APIKey, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return slices.Clone(r.keys), nil
+}
+
+// FindAPIKey returns the key with the given ID
+func (r *JSONAPIKeyRepository) FindAPIKey(ctx context.Context, id uuid.UUID) (models.APIKey, error) {
+	return r.find(func(key models.APIKey) bool { return key.ID == id })
+}
+
+// FindAPIKeyByHash returns the key whose current or previous hash is hash
+func (r *JSONAPIKeyRepository) FindAPIKeyByHash(ctx context.Context, hash string) (models.APIKey, error) {
+	return r.find(func(key models.APIKey) bool { return key.Hash == hash || key.PreviousHash == hash })
+}
+
+func (r *JSONAPIKeyRepository) find(match func(key models.APIKey) bool) (models.APIKey, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	i := slices.IndexFunc(r.keys, match)
+	if i < 0 {
+		return models.APIKey{}, ErrAPIKeyNotFound
+	}
+	return r.keys[i], nil
+}
+
+// InsertAPIKey adds a key
+func (r *JSONAPIKeyRepository) InsertAPIKey(ctx context.Context, key models.APIKey) error {
+	return r.update(func(keys []models.APIKey) ([]models.APIKey, error) {
+		return append(keys, key), nil
+	})
+}
+
+// ReplaceAPIKey replaces the key with the ID of key
+func (r *JSONAPIKeyRepository) ReplaceAPIKey(ctx context.Context, key models.APIKey) error {
+	return r.update(func(keys []models.APIKey) ([]models.APIKey, error) {
+		i := slices.IndexFunc(keys, func(k models.APIKey) bool { return k.ID == key.ID })
+		if i < 0 {
+			return keys, ErrAPIKeyNotFound
+		}
+		keys[i] = key
+		return keys, nil
+	})
+}
+
+// TouchAPIKey sets when the key with the given ID was last used
+func (r *JSONAPIKeyRepository) TouchAPIKey(ctx context.Context, id uuid.UUID, lastUsedAt time.Time) error {
+	return r.update(func(keys []models.APIKey) ([]models.APIKey, error) {
+		i := slices.IndexFunc(keys, func(k models.APIKey) bool { return k.ID == id })
+		if i < 0 {
+			return keys, ErrAPIKeyNotFound
+		}
+		keys[i].LastUsedAt = &lastUsedAt
+		return keys, nil
+	})
+}
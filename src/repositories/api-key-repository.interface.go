@@ -0,0 +1,28 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"congdinh.com/crm/models"
+	"github.com/google/uuid"
+)
+
+// ErrAPIKeyNotFound is returned when no API key has the requested ID or hash
+var ErrAPIKeyNotFound = errors.New("api key not found")
+
+// IAPIKeyRepository stores the API keys.
+//
+// ListAPIKeys returns the keys in creation order, revoked ones included.
+// FindAPIKeyByHash matches the current hash of a key as well as the previous
+// one. TouchAPIKey only sets LastUsedAt, so it cannot undo a rotation or a
+// revocation it races with.
+type IAPIKeyRepository interface {
+	ListAPIKeys(ctx context.Context) ([]models.APIKey, error)
+	FindAPIKey(ctx context.Context, id uuid.UUID) (models.APIKey, error)
+	FindAPIKeyByHash(ctx context.Context, hash string) (models.APIKey, error)
+	InsertAPIKey(ctx context.Context, key models.APIKey) error
+	ReplaceAPIKey(ctx context.Context, key models.APIKey) error
+	TouchAPIKey(ctx context.Context, id uuid.UUID, lastUsedAt time.Time) error
+}
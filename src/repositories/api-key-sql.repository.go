@@ -0,0 +1,136 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"congdinh.com/crm/models"
+	"github.com/google/uuid"
+)
+
+const apiKeyColumns = "id, name, prefix, hash, previous_hash, previous_expires_at, scopes, created_at, expires_at, rotated_at, revoked_at, last_used_at, roles"
+
+// ListAPIKeys returns the keys in creation order
+func (r *SQLCustomerRepository) ListAPIKeys(ctx context.Context) ([]models.APIKey, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT "+apiKeyColumns+" FROM api_keys ORDER BY "+r.dialect.insertionOrder)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []models.APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// FindAPIKey returns the key with the given ID
+func (r *SQLCustomerRepository) FindAPIKey(ctx context.Context, id uuid.UUID) (models.APIKey, error) {
+	return r.findAPIKey(ctx, "id = ?", id)
+}
+
+// FindAPIKeyByHash returns the key whose current or previous hash is hash
+func (r *SQLCustomerRepository) FindAPIKeyByHash(ctx context.Context, hash string) (models.APIKey, error) {
+	return r.findAPIKey(ctx, "hash = ? OR previous_hash = ?", hash, hash)
+}
+
+func (r *SQLCustomerRepository) findAPIKey(ctx context.Context, condition string, args ...any) (models.APIKey, error) {
+	key, err := scanAPIKey(r.db.QueryRowContext(ctx, r.dialect.rebind("SELECT "+apiKeyColumns+" FROM api_keys WHERE "+condition), args...))
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.APIKey{}, ErrAPIKeyNotFound
+	}
+	return key, err
+}
+
+// InsertAPIKey adds a key. The scopes and roles are stored as JSON.
+func (r *SQLCustomerRepository) InsertAPIKey(ctx context.Context, key models.APIKey) error {
+	scopes, roles, err := marshalAPIKeyLists(key)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.ExecContext(ctx, r.dialect.rebind("INSERT INTO api_keys ("+apiKeyColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"),
+		key.ID, key.Name, key.Prefix, key.Hash, key.PreviousHash, nullTime(key.PreviousExpiresAt), scopes,
+		key.CreatedAt.UTC(), nullTime(key.ExpiresAt), nullTime(key.RotatedAt), nullTime(key.RevokedAt), nullTime(key.LastUsedAt), roles)
+	return err
+}
+
+// ReplaceAPIKey replaces the key with the ID of key
+func (r *SQLCustomerRepository) ReplaceAPIKey(ctx context.Context, key models.APIKey) error {
+	scopes, roles, err := marshalAPIKeyLists(key)
+	if err != nil {
+		return err
+	}
+	result, err := r.db.ExecContext(ctx, r.dialect.rebind("UPDATE api_keys SET name = ?, prefix = ?, hash = ?, previous_hash = ?, previous_expires_at = ?, scopes = ?, created_at = ?, expires_at = ?, rotated_at = ?, revoked_at = ?, last_used_at = ?, roles = ? WHERE id = ?"),
+		key.Name, key.Prefix, key.Hash, key.PreviousHash, nullTime(key.PreviousExpiresAt), scopes,
+		key.CreatedAt.UTC(), nullTime(key.ExpiresAt), nullTime(key.RotatedAt), nullTime(key.RevokedAt), nullTime(key.LastUsedAt), roles, key.ID)
+	return expectAffected(result, err, ErrAPIKeyNotFound)
+}
+
+// TouchAPIKey sets when the key with the given ID was last used
+func (r *SQLCustomerRepository) TouchAPIKey(ctx context.Context, id uuid.UUID, lastUsedAt time.Time) error {
+	result, err := r.db.ExecContext(ctx, r.dialect.rebind("UPDATE api_keys SET last_used_at = ? WHERE id = ?"), lastUsedAt.UTC(), id)
+	return expectAffected(result, err, ErrAPIKeyNotFound)
+}
+
+// marshalAPIKeyLists returns the scopes and roles of key as JSON
+func marshalAPIKeyLists(key models.APIKey) (string, string, error) {
+	scopes, err := json.Marshal(key.Scopes)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to marshal JSON: %w", err)
+	}
+	roles := []string{}
+	if key.Roles != nil {
+		roles = key.Roles
+	}
+	rolesJSON, err := json.Marshal(roles)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to marshal JSON: %w", err)
+	}
+	return string(scopes), string(rolesJSON), nil
+}
+
+func scanAPIKey(row rowScanner) (models.APIKey, error) {
+	var (
+		key                                                            models.APIKey
+		scopes, roles                                                  string
+		previousExpiresAt, expiresAt, rotatedAt, revokedAt, lastUsedAt sql.NullTime
+	)
+	if err := row.Scan(&key.ID, &key.Name, &key.Prefix, &key.Hash, &key.PreviousHash, &previousExpiresAt, &scopes,
+		&key.CreatedAt, &expiresAt, &rotatedAt, &revokedAt, &lastUsedAt, &roles); err != nil {
+		return key, err
+	}
+	key.CreatedAt = key.CreatedAt.UTC()
+	key.PreviousExpiresAt = timePointer(previousExpiresAt)
+	key.ExpiresAt = timePointer(expiresAt)
+	key.RotatedAt = timePointer(rotatedAt)
+	key.RevokedAt = timePointer(revokedAt)
+	key.LastUsedAt = timePointer(lastUsedAt)
+	if err := json.Unmarshal([]byte(scopes), &key.Scopes); err != nil {
+		return key, fmt.Errorf("failed to parse api key scopes: %w", err)
+	}
+	if err := json.Unmarshal([]byte(roles), &key.Roles); err != nil {
+		return key, fmt.Errorf("failed to parse api key roles: %w", err)
+	}
+	if len(key.Roles) == 0 {
+		key.Roles = nil
+	}
+	return key, nil
+}
+
+// timePointer is the reverse of nullTime
+func timePointer(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	utc := t.Time.UTC()
+	return &utc
+}
@@ -0,0 +1,98 @@
+package repositories
+
+import (
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"congdinh.com/crm/models"
+	"github.com/google/uuid"
+)
+
+// testAPIKeys checks how every backend stores API keys
+func testAPIKeys(t *testing.T, repository IAPIKeyRepository) {
+	t.Helper()
+
+	created := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	expires := created.Add(30 * 24 * time.Hour)
+	reporting := models.APIKey{ID: uuid.New(), Name: "reporting", Prefix: "crm_AAAA", Hash: "hash-reporting", Scopes: []string{"read"}, Roles: []string{"viewer"}, CreatedAt: created, ExpiresAt: &expires}
+	admin := models.APIKey{ID: uuid.New(), Name: "admin", Prefix: "crm_BBBB", Hash: "hash-admin", Scopes: []string{"admin"}, CreatedAt: created}
+	for _, key := range []models.APIKey{reporting, admin} {
+		if err := repository.InsertAPIKey(t.Context(), key); err != nil {
+			t.Fatalf("Expected InsertAPIKey to return nil error, but got %s", err.Error())
+		}
+	}
+
+	keys, err := repository.ListAPIKeys(t.Context())
+	if err != nil || len(keys) != 2 || keys[0].ID != reporting.ID || keys[1].ID != admin.ID {
+		t.Fatalf("Expected ListAPIKeys to return both keys in creation order, but got %v (%v)", keys, err)
+	}
+	found, err := repository.FindAPIKey(t.Context(), reporting.ID)
+	if err != nil || found.Hash != "hash-reporting" || !slices.Equal(found.Scopes, reporting.Scopes) || !slices.Equal(found.Roles, reporting.Roles) || found.ExpiresAt == nil || !found.ExpiresAt.Equal(expires) || found.RevokedAt != nil || found.LastUsedAt != nil {
+		t.Errorf("Expected FindAPIKey to return %v, but got %v (%v)", reporting, found, err)
+	}
+	if found, _ := repository.FindAPIKey(t.Context(), admin.ID); found.Roles != nil {
+		t.Errorf("Expected a key without roles to have none, but got %v", found.Roles)
+	}
+	if _, err := repository.FindAPIKey(t.Context(), uuid.New()); !errors.Is(err, ErrAPIKeyNotFound) {
+		t.Errorf("Expected FindAPIKey of an unknown key to return ErrAPIKeyNotFound, but got %v", err)
+	}
+
+	// Rotate the reporting key, keeping the previous hash for a while
+	rotated := created.Add(time.Hour)
+	previousExpires := rotated.Add(time.Hour)
+	reporting.PreviousHash = reporting.Hash
+	reporting.PreviousExpiresAt = &previousExpires
+	reporting.Hash = "hash-rotated"
+	reporting.RotatedAt = &rotated
+	if err := repository.ReplaceAPIKey(t.Context(), reporting); err != nil {
+		t.Fatalf("Expected ReplaceAPIKey to return nil error, but got %s", err.Error())
+	}
+	for _, hash := range []string{"hash-rotated", "hash-reporting"} {
+		found, err := repository.FindAPIKeyByHash(t.Context(), hash)
+		if err != nil || found.ID != reporting.ID || found.Hash != "hash-rotated" || found.PreviousExpiresAt == nil || !found.PreviousExpiresAt.Equal(previousExpires) {
+			t.Errorf("Expected FindAPIKeyByHash of %s to return the rotated key, but got %v (%v)", hash, found, err)
+		}
+	}
+	if _, err := repository.FindAPIKeyByHash(t.Context(), "unknown"); !errors.Is(err, ErrAPIKeyNotFound) {
+		t.Errorf("Expected FindAPIKeyByHash of an unknown hash to return ErrAPIKeyNotFound, but got %v", err)
+	}
+	if err := repository.ReplaceAPIKey(t.Context(), models.APIKey{ID: uuid.New(), Hash: "other", Scopes: []string{}}); !errors.Is(err, ErrAPIKeyNotFound) {
+		t.Errorf("Expected ReplaceAPIKey of an unknown key to return ErrAPIKeyNotFound, but got %v", err)
+	}
+
+	used := created.Add(2 * time.Hour)
+	if err := repository.TouchAPIKey(t.Context(), admin.ID, used); err != nil {
+		t.Fatalf("Expected TouchAPIKey to return nil error, but got %s", err.Error())
+	}
+	if found, _ := repository.FindAPIKey(t.Context(), admin.ID); found.LastUsedAt == nil || !found.LastUsedAt.Equal(used) {
+		t.Errorf("Expected TouchAPIKey to set LastUsedAt to %v, but got %v", used, found.LastUsedAt)
+	}
+	if err := repository.TouchAPIKey(t.Context(), uuid.New(), used); !errors.Is(err, ErrAPIKeyNotFound) {
+		t.Errorf("Expected TouchAPIKey of an unknown key to return ErrAPIKeyNotFound, but got %v", err)
+	}
+}
+
+func TestJSONAPIKeyRepository_APIKeys(t *testing.T) {
+	testAPIKeys(t, NewMemoryAPIKeyRepository())
+}
+
+func TestJSONAPIKeyRepository_Reopen(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "api-keys.json")
+	repository, err := NewJSONAPIKeyRepository(file)
+	if err != nil {
+		t.Fatalf("Failed to open api keys: %s", err.Error())
+	}
+	testAPIKeys(t, repository)
+
+	reopened, err := NewJSONAPIKeyRepository(file)
+	if err != nil {
+		t.Fatalf("Failed to reopen api keys: %s", err.Error())
+	}
+	keys, _ := reopened.ListAPIKeys(t.Context())
+	if len(keys) != 2 || keys[1].LastUsedAt == nil {
+		t.Errorf("Expected both keys with their last use after reopening, but got %v", keys)
+	}
+}
@@ -0,0 +1,212 @@
+package repositories
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"congdinh.com/crm/models"
+)
+
+// DefaultEventLogFile returns the path of data/customers.events.jsonl next to the bundled JSON data
+func DefaultEventLogFile() string {
+	return path.Join(path.Dir(DefaultDataFile()), "customers.events.jsonl")
+}
+
+// DefaultSnapshotFile returns the path of data/customers.snapshot.json next to the bundled JSON data
+func DefaultSnapshotFile() string {
+	return path.Join(path.Dir(DefaultDataFile()), "customers.snapshot.json")
+}
+
+// customerSnapshot is the state of the event log after event Sequence
+type customerSnapshot struct {
+	Sequence  int64
+	Customers []models.Customer
+}
+
+// CustomerEventStore persists the customer dataset as an append-only log of
+// events, one JSON line each. The current state is rebuilt by replaying the
+// log on startup. Every snapshotEvery events the state is also written to a
+// snapshot file, so startup only replays the events after the snapshot. The
+// log itself is never rewritten and holds the full history.
+type CustomerEventStore struct {
+	snapshotFile  string
+	snapshotEvery int64
+
+	mu    sync.Mutex
+	log   *jsonLinesFile
+	state *customerState
+	// sequence is the number of the last event in the log
+	sequence         int64
+	snapshotSequence int64
+}
+
+// OpenCustomerEventStore opens (or creates) the event log at logFile and
+// rebuilds the state from the snapshot at snapshotFile and the events after it.
+// A snapshotEvery of 0 disables snapshots.
+func OpenCustomerEventStore(logFile string, snapshotFile string, snapshotEvery int) (*CustomerEventStore, error) {
+	s := &CustomerEventStore{snapshotFile: snapshotFile, snapshotEvery: int64(snapshotEvery)}
+
+	snapshot, err := readSnapshot(snapshotFile)
+	if err != nil {
+		return nil, err
+	}
+	s.state = newCustomerState(snapshot.Customers)
+	s.sequence = snapshot.Sequence
+	s.snapshotSequence = snapshot.Sequence
+
+	s.log, err = openJSONLines(logFile, func(line int, data []byte) error {
+		var event CustomerEvent
+		if err := json.Unmarshal(data, &event); err != nil {
+			return err
+		}
+		if event.Sequence <= snapshot.Sequence {
+			return nil
+		}
+		if event.Sequence != s.sequence+1 {
+			return fmt.Errorf("expected event %d, but got %d", s.sequence+1, event.Sequence)
+		}
+		s.state.apply(event)
+		s.sequence = event.Sequence
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if s.sequence < snapshot.Sequence {
+		s.log.Close()
+		return nil, fmt.Errorf("snapshot %s is ahead of the event log at event %d", snapshotFile, s.sequence)
+	}
+	return s, nil
+}
+
+// readSnapshot reads the snapshot at file, or an empty one if there is none
+func readSnapshot(file string) (customerSnapshot, error) {
+	snapshot := customerSnapshot{Customers: []models.Customer{}}
+	if file == "" {
+		return snapshot, nil
+	}
+	data, err := os.ReadFile(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return snapshot, nil
+	}
+	if err != nil {
+		return snapshot, fmt.Errorf("failed to read snapshot: %w", err)
+	}
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return snapshot, fmt.Errorf("failed to unmarshal snapshot: %w", err)
+	}
+	return snapshot, nil
+}
+
+// Load returns the current state
+func (s *CustomerEventStore) Load() ([]models.Customer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]models.Customer{}, s.state.customers...), nil
+}
+
+// Append appends the events recording changes, which were made in order on
+// the current state, and writes a snapshot when enough events have
+// accumulated. Only the changes that succeeded may be passed. The events are
+// on disk when Append returns.
+func (s *CustomerEventStore) Append(changes []CustomerChange) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	events := s.state.events(changes)
+	if len(events) == 0 {
+		return nil
+	}
+	now := time.Now().UTC()
+	values := make([]any, len(events))
+	for i := range events {
+		events[i].Sequence = s.sequence + int64(i) + 1
+		events[i].Timestamp = now
+		values[i] = events[i]
+	}
+	if err := s.log.append(values...); err != nil {
+		return err
+	}
+
+	for _, event := range events {
+		s.state.apply(event)
+	}
+	s.sequence += int64(len(events))
+
+	if s.snapshotEvery > 0 && s.sequence-s.snapshotSequence >= s.snapshotEvery {
+		// The events are already durable, so a failed snapshot only slows
+		// down the next startup and must not fail the save
+		if err := s.writeSnapshot(); err != nil {
+			log.Printf("Failed to write customer snapshot: %v", err)
+		}
+	}
+	return nil
+}
+
+// commit appends changes; the log already holds every customer
+func (s *CustomerEventStore) commit(customers []models.Customer, changes []CustomerChange) error {
+	return s.Append(changes)
+}
+
+// Snapshot writes the current state to the snapshot file
+func (s *CustomerEventStore) Snapshot() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.writeSnapshot()
+}
+
+func (s *CustomerEventStore) writeSnapshot() error {
+	if s.snapshotFile == "" {
+		return nil
+	}
+	data, err := json.Marshal(customerSnapshot{Sequence: s.sequence, Customers: s.state.customers})
+	if err != nil {
+		return fmt.Errorf("failed to marshal JSON: %w", err)
+	}
+	if err := writeFileAtomic(s.snapshotFile, data); err != nil {
+		return err
+	}
+	s.snapshotSequence = s.sequence
+	return nil
+}
+
+// Sequence returns the number of the last event in the log
+func (s *CustomerEventStore) Sequence() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.sequence
+}
+
+// Close closes the event log
+func (s *CustomerEventStore) Close() error {
+	return s.log.Close()
+}
+
+// ReadCustomerEvents passes every event of the log at file to apply, in
+// order. Projections use it to rebuild their state from the log.
+func ReadCustomerEvents(file string, apply func(CustomerEvent) error) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return fmt.Errorf("failed to open event log: %w", err)
+	}
+	defer f.Close()
+
+	_, err = readJSONLines(f, func(line int, data []byte) error {
+		var event CustomerEvent
+		if err := json.Unmarshal(data, &event); err != nil {
+			return err
+		}
+		return apply(event)
+	})
+	return err
+}
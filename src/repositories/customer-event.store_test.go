@@ -0,0 +1,182 @@
+package repositories
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"congdinh.com/crm/models"
+	"github.com/google/uuid"
+)
+
+// newEventRepository opens an event-sourced repository over a log and snapshot in dir
+func newEventRepository(t *testing.T, dir string, snapshotEvery int) (*JSONCustomerRepository, *CustomerEventStore) {
+	t.Helper()
+
+	store, err := OpenCustomerEventStore(filepath.Join(dir, "customers.events.jsonl"), filepath.Join(dir, "customers.snapshot.json"), snapshotEvery)
+	if err != nil {
+		t.Fatalf("Failed to open event store: %s", err.Error())
+	}
+	repository, err := NewEventCustomerRepository(store)
+	if err != nil {
+		t.Fatalf("Failed to create repository: %s", err.Error())
+	}
+	t.Cleanup(func() { repository.Close() })
+	return repository, store
+}
+
+func TestEventCustomerRepository_Query(t *testing.T) {
+	repository, _ := newEventRepository(t, t.TempDir(), 0)
+	for _, customer := range queryFixtures() {
+		if err := repository.Insert(t.Context(), customer); err != nil {
+			t.Fatalf("Expected Insert to return nil error, but got %s", err.Error())
+		}
+	}
+
+	testCustomerQuery(t, repository)
+}
+
+func TestEventCustomerRepository_Versions(t *testing.T) {
+	repository, _ := newEventRepository(t, t.TempDir(), 0)
+	testCustomerVersions(t, repository)
+}
+
+func TestEventCustomerRepository_Uniqueness(t *testing.T) {
+	repository, _ := newEventRepository(t, t.TempDir(), 0)
+	testCustomerUniqueness(t, repository)
+}
+
+func TestEventCustomerRepository_Apply(t *testing.T) {
+	repository, _ := newEventRepository(t, t.TempDir(), 0)
+	testCustomerApply(t, repository)
+}
+
+func TestEventCustomerRepository_Trash(t *testing.T) {
+	repository, _ := newEventRepository(t, t.TempDir(), 0)
+	testCustomerTrash(t, repository)
+}
+
+func TestEventCustomerRepository_Audit(t *testing.T) {
+	repository, _ := newEventRepository(t, t.TempDir(), 0)
+	testCustomerAudit(t, repository)
+}
+
+func TestCustomerEventStore_Events(t *testing.T) {
+	dir := t.TempDir()
+	repository, store := newEventRepository(t, dir, 0)
+
+	customer := models.Customer{ID: uuid.New(), Name: "Lead", Role: "Sales", Email: "lead@domain.com", Phone: "5550007001", Version: 1}
+	repository.Insert(t.Context(), customer)
+	customer.Contacted, customer.Version = true, 2
+	repository.Replace(t.Context(), customer, 1)
+	customer.Role, customer.Version = "Manager", 3
+	repository.Replace(t.Context(), customer, 2)
+	deletedAt := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	customer.DeletedAt, customer.Version = &deletedAt, 4
+	repository.Replace(t.Context(), customer, 3)
+	repository.Purge(t.Context(), deletedAt.Add(time.Second))
+
+	types := []CustomerEventType{}
+	err := ReadCustomerEvents(filepath.Join(dir, "customers.events.jsonl"), func(event CustomerEvent) error {
+		if event.Sequence != int64(len(types))+1 || event.CustomerID != customer.ID {
+			t.Errorf("Expected event %d of customer %s, but got %v", len(types)+1, customer.ID, event)
+		}
+		types = append(types, event.Type)
+		return nil
+	})
+	expected := []CustomerEventType{CustomerCreated, CustomerContacted, CustomerUpdated, CustomerDeleted, CustomerPurged}
+	if err != nil || !slices.Equal(types, expected) {
+		t.Errorf("Expected the events %v, but got %v (%v)", expected, types, err)
+	}
+	if store.Sequence() != 5 {
+		t.Errorf("Expected the log to end at event 5, but got %d", store.Sequence())
+	}
+}
+
+func TestCustomerEventStore_ReplayAndSnapshots(t *testing.T) {
+	dir := t.TempDir()
+	repository, _ := newEventRepository(t, dir, 4)
+
+	customers := []models.Customer{}
+	for i := range 4 {
+		customer := models.Customer{ID: uuid.New(), Name: "Lead", Email: uuid.NewString() + "@domain.com", Phone: "555000800" + string(rune('0'+i)), Version: 1}
+		if err := repository.Insert(t.Context(), customer); err != nil {
+			t.Fatalf("Expected Insert to return nil error, but got %s", err.Error())
+		}
+		customers = append(customers, customer)
+	}
+	customers[1].Contacted, customers[1].Version = true, 2
+	repository.Replace(t.Context(), customers[1], 1)
+	repository.Remove(t.Context(), customers[2].ID, 0)
+	customers = slices.Delete(customers, 2, 3)
+	repository.Close()
+
+	// The snapshot was taken after the inserts, so startup replays the last two events
+	snapshot, err := readSnapshot(filepath.Join(dir, "customers.snapshot.json"))
+	if err != nil || snapshot.Sequence != 4 || len(snapshot.Customers) != 4 {
+		t.Fatalf("Expected a snapshot of 4 customers at event 4, but got %v (%v)", snapshot, err)
+	}
+
+	reopened, store := newEventRepository(t, dir, 4)
+	stored, _ := reopened.List(t.Context())
+	if !slices.EqualFunc(stored, customers, sameCustomer) || store.Sequence() != 6 {
+		t.Errorf("Expected the replayed customers %v at event 6, but got %v at event %d", customers, stored, store.Sequence())
+	}
+
+	// A crash in the middle of an append leaves a partial last line
+	reopened.Close()
+	f, _ := os.OpenFile(filepath.Join(dir, "customers.events.jsonl"), os.O_WRONLY|os.O_APPEND, 0o644)
+	f.WriteString(`{"Sequence":7,"Type":"Customer`)
+	f.Close()
+
+	reopened, store = newEventRepository(t, dir, 4)
+	if stored, _ := reopened.List(t.Context()); len(stored) != 3 || store.Sequence() != 6 {
+		t.Errorf("Expected the partial event to be dropped, but got %v at event %d", stored, store.Sequence())
+	}
+	if err := reopened.Insert(t.Context(), models.Customer{ID: uuid.New(), Email: "after@domain.com", Phone: "5550008009", Version: 1}); err != nil {
+		t.Errorf("Expected Insert after the recovery to return nil error, but got %s", err.Error())
+	}
+}
+
+func TestCustomerEventStore_AppendChanges(t *testing.T) {
+	dir := t.TempDir()
+	repository, store := newEventRepository(t, dir, 0)
+
+	customer := models.Customer{ID: uuid.New(), Name: "Lead", Role: "Sales", Email: "lead@domain.com", Phone: "5550007101", Version: 1}
+	contacted := customer
+	contacted.Contacted, contacted.Version = true, 2
+	other := models.Customer{ID: uuid.New(), Name: "Other", Email: "other@domain.com", Phone: "5550007102", Version: 1}
+	changes := []CustomerChange{
+		{Kind: ChangeInsert, Customer: customer, History: &models.CustomerHistoryEntry{Operation: models.HistoryCreate, Actor: "importer"}},
+		{Kind: ChangeReplace, Customer: contacted, History: &models.CustomerHistoryEntry{Operation: models.HistoryUpdate, Actor: "rep"}},
+		{Kind: ChangeInsert, Customer: other},
+		{Kind: ChangeRemove, ID: uuid.New()},
+	}
+	errs, err := repository.Apply(t.Context(), changes, false)
+	if err != nil || errs[3] == nil {
+		t.Fatalf("Expected only the remove of an unknown customer to fail, but got %v (%v)", errs, err)
+	}
+	// Replacing a customer with itself records nothing
+	repository.Replace(t.Context(), other, 0)
+
+	events := []CustomerEvent{}
+	ReadCustomerEvents(filepath.Join(dir, "customers.events.jsonl"), func(event CustomerEvent) error {
+		events = append(events, event)
+		return nil
+	})
+	expected := []CustomerEvent{
+		{Type: CustomerCreated, CustomerID: customer.ID, Actor: "importer", Operation: models.HistoryCreate},
+		{Type: CustomerContacted, CustomerID: customer.ID, Actor: "rep", Operation: models.HistoryUpdate},
+		{Type: CustomerCreated, CustomerID: other.ID},
+	}
+	if len(events) != len(expected) || store.Sequence() != int64(len(expected)) {
+		t.Fatalf("Expected %d events, but got %v", len(expected), events)
+	}
+	for i, event := range events {
+		if event.Type != expected[i].Type || event.CustomerID != expected[i].CustomerID || event.Actor != expected[i].Actor || event.Operation != expected[i].Operation {
+			t.Errorf("Expected event %d to be %v, but got %v", i+1, expected[i], event)
+		}
+	}
+}
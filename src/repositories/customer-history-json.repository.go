@@ -0,0 +1,137 @@
+package repositories
+
+import (
+	"context"
+	"encoding/json"
+	"path"
+	"slices"
+	"sync"
+	"time"
+
+	"congdinh.com/crm/models"
+	"github.com/google/uuid"
+)
+
+// DefaultHistoryFile returns the path of data/customers.history.jsonl next to the bundled JSON data
+func DefaultHistoryFile() string {
+	return path.Join(path.Dir(DefaultDataFile()), "customers.history.jsonl")
+}
+
+// JSONCustomerHistoryRepository keeps the history in memory and, when it has
+// a file, appends every entry to it as one line of JSON
+type JSONCustomerHistoryRepository struct {
+	mu sync.RWMutex
+	// entries holds every entry in the order it was appended
+	entries []models.CustomerHistoryEntry
+	// customers holds the positions in entries of the entries of each customer
+	customers map[uuid.UUID][]int
+	file      *jsonLinesFile
+}
+
+// NewJSONCustomerHistoryRepository opens (or creates) the history file at file
+func NewJSONCustomerHistoryRepository(file string) (*JSONCustomerHistoryRepository, error) {
+	r := NewMemoryCustomerHistoryRepository()
+	f, err := openJSONLines(file, func(line int, data []byte) error {
+		var entry models.CustomerHistoryEntry
+		if err := json.Unmarshal(data, &entry); err != nil {
+			return err
+		}
+		r.add(entry)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	r.file = f
+	return r, nil
+}
+
+// NewMemoryCustomerHistoryRepository creates a history that only lives in memory
+func NewMemoryCustomerHistoryRepository() *JSONCustomerHistoryRepository {
+	return &JSONCustomerHistoryRepository{customers: map[uuid.UUID][]int{}}
+}
+
+// add appends entry to memory
+func (r *JSONCustomerHistoryRepository) add(entry models.CustomerHistoryEntry) {
+	r.customers[entry.CustomerID] = append(r.customers[entry.CustomerID], len(r.entries))
+	r.entries = append(r.entries, entry)
+}
+
+// Close closes the history file
+func (r *JSONCustomerHistoryRepository) Close() error {
+	if r.file == nil {
+		return nil
+	}
+	return r.file.Close()
+}
+
+// AppendHistory writes entries to the file in one write, then adds them to memory
+func (r *JSONCustomerHistoryRepository) AppendHistory(ctx context.Context, entries []models.CustomerHistoryEntry) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file != nil {
+		values := make([]any, len(entries))
+		for i, entry := range entries {
+			values[i] = entry
+		}
+		if err := r.file.append(values...); err != nil {
+			return err
+		}
+	}
+
+	for _, entry := range entries {
+		r.add(entry)
+	}
+	return nil
+}
+
+// History returns a page of the entries of customer id, newest first
+func (r *JSONCustomerHistoryRepository) History(ctx context.Context, id uuid.UUID, offset int, limit int) (CustomerHistoryPage, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	positions := r.customers[id]
+	page := CustomerHistoryPage{Entries: []models.CustomerHistoryEntry{}, Total: len(positions)}
+	for i := len(positions) - 1 - offset; i >= 0; i-- {
+		if limit > 0 && len(page.Entries) == limit {
+			break
+		}
+		page.Entries = append(page.Entries, r.entries[positions[i]])
+	}
+	return page, nil
+}
+
+// HistoryAsOf returns the newest entry of customer id recorded at or before at
+func (r *JSONCustomerHistoryRepository) HistoryAsOf(ctx context.Context, id uuid.UUID, at time.Time) (models.CustomerHistoryEntry, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, position := range slices.Backward(r.customers[id]) {
+		if entry := r.entries[position]; !entry.Timestamp.After(at) {
+			return entry, nil
+		}
+	}
+	return models.CustomerHistoryEntry{}, ErrCustomerNotFound
+}
+
+// HistorySince returns up to limit entries after the first position ones, in
+// the order they were appended
+func (r *JSONCustomerHistoryRepository) HistorySince(ctx context.Context, position int64, limit int) ([]models.CustomerHistoryEntry, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	entries := r.entries[min(position, int64(len(r.entries))):]
+	if limit > 0 && len(entries) > limit {
+		entries = entries[:limit]
+	}
+	return slices.Clone(entries), nil
+}
+
+// HistoryLength returns the number of entries
+func (r *JSONCustomerHistoryRepository) HistoryLength(ctx context.Context) (int64, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return int64(len(r.entries)), nil
+}
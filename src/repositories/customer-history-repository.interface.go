@@ -0,0 +1,44 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"congdinh.com/crm/models"
+	"github.com/google/uuid"
+)
+
+// CustomerHistoryPage is one page of the history of a customer
+type CustomerHistoryPage struct {
+	Entries []models.CustomerHistoryEntry
+	// Total is the number of entries of the customer, ignoring the paging
+	Total int
+}
+
+// ICustomerHistoryRepository stores the append-only history of customers.
+// History is kept when a customer is purged.
+//
+// History returns the entries of a customer newest first; a limit of 0 means
+// no limit. HistoryAsOf returns the last entry recorded at or before at, or
+// ErrCustomerNotFound when the customer had no recorded state yet.
+type ICustomerHistoryRepository interface {
+	AppendHistory(ctx context.Context, entries []models.CustomerHistoryEntry) error
+	History(ctx context.Context, id uuid.UUID, offset int, limit int) (CustomerHistoryPage, error)
+	HistoryAsOf(ctx context.Context, id uuid.UUID, at time.Time) (models.CustomerHistoryEntry, error)
+}
+
+// ICustomerHistoryLog reads the history in the order it was appended, which
+// the file backends use as the outbox of webhook deliveries. HistorySince
+// returns up to limit entries after the first position ones; a limit of 0
+// means no limit. HistoryLength returns the number of entries.
+type ICustomerHistoryLog interface {
+	HistorySince(ctx context.Context, position int64, limit int) ([]models.CustomerHistoryEntry, error)
+	HistoryLength(ctx context.Context) (int64, error)
+}
+
+// ICustomerHistoryRecorder is a customer repository whose Apply stores the
+// History of its changes together with them. CustomerHistory returns the
+// history they are stored in.
+type ICustomerHistoryRecorder interface {
+	CustomerHistory() ICustomerHistoryRepository
+}
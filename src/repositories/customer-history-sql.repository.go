@@ -0,0 +1,108 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"congdinh.com/crm/models"
+	"github.com/google/uuid"
+)
+
+const historyColumns = "customer_id, version, operation, actor, recorded_at, changes, customer"
+
+// CustomerHistory returns the repository itself, which stores the history
+// of a change in the transaction of the change
+func (r *SQLCustomerRepository) CustomerHistory() ICustomerHistoryRepository {
+	return r
+}
+
+// AppendHistory inserts entries in one transaction
+func (r *SQLCustomerRepository) AppendHistory(ctx context.Context, entries []models.CustomerHistoryEntry) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := r.insertHistory(ctx, tx, entries); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// insertHistory adds entries within tx, which Apply shares with the changes
+// they record. The changes and the customer state are stored as JSON.
+func (r *SQLCustomerRepository) insertHistory(ctx context.Context, tx *sql.Tx, entries []models.CustomerHistoryEntry) error {
+	stmt := tx.StmtContext(ctx, r.appendHistoryStmt)
+	for _, entry := range entries {
+		changes, err := json.Marshal(entry.Changes)
+		if err != nil {
+			return fmt.Errorf("failed to marshal JSON: %w", err)
+		}
+		customer, err := json.Marshal(entry.Customer)
+		if err != nil {
+			return fmt.Errorf("failed to marshal JSON: %w", err)
+		}
+		if _, err := stmt.ExecContext(ctx, entry.CustomerID, entry.Version, string(entry.Operation), entry.Actor, entry.Timestamp.UTC(), string(changes), string(customer)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// History returns a page of the entries of customer id, newest first
+func (r *SQLCustomerRepository) History(ctx context.Context, id uuid.UUID, offset int, limit int) (CustomerHistoryPage, error) {
+	page := CustomerHistoryPage{Entries: []models.CustomerHistoryEntry{}}
+	if err := r.countHistoryStmt.QueryRowContext(ctx, id).Scan(&page.Total); err != nil {
+		return CustomerHistoryPage{}, err
+	}
+	if limit == 0 {
+		limit = page.Total
+	}
+
+	rows, err := r.historyStmt.QueryContext(ctx, id, limit, offset)
+	if err != nil {
+		return CustomerHistoryPage{}, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		entry, err := scanHistoryEntry(rows)
+		if err != nil {
+			return CustomerHistoryPage{}, err
+		}
+		page.Entries = append(page.Entries, entry)
+	}
+	return page, rows.Err()
+}
+
+// HistoryAsOf returns the newest entry of customer id recorded at or before at
+func (r *SQLCustomerRepository) HistoryAsOf(ctx context.Context, id uuid.UUID, at time.Time) (models.CustomerHistoryEntry, error) {
+	entry, err := scanHistoryEntry(r.historyAsOfStmt.QueryRowContext(ctx, id, at.UTC()))
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.CustomerHistoryEntry{}, ErrCustomerNotFound
+	}
+	return entry, err
+}
+
+func scanHistoryEntry(row rowScanner) (models.CustomerHistoryEntry, error) {
+	var (
+		entry             models.CustomerHistoryEntry
+		changes, customer string
+	)
+	if err := row.Scan(&entry.CustomerID, &entry.Version, &entry.Operation, &entry.Actor, &entry.Timestamp, &changes, &customer); err != nil {
+		return entry, err
+	}
+	entry.Timestamp = entry.Timestamp.UTC()
+	if err := json.Unmarshal([]byte(changes), &entry.Changes); err != nil {
+		return entry, fmt.Errorf("failed to parse history changes: %w", err)
+	}
+	if err := json.Unmarshal([]byte(customer), &entry.Customer); err != nil {
+		return entry, fmt.Errorf("failed to parse history customer: %w", err)
+	}
+	return entry, nil
+}
@@ -0,0 +1,399 @@
+package repositories
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"congdinh.com/crm/models"
+	"github.com/google/uuid"
+)
+
+// customerStore persists the dataset of a JSONCustomerRepository
+type customerStore interface {
+	Load() ([]models.Customer, error)
+	// commit records a mutation: customers is the full dataset after it and
+	// changes are the changes that made it, in order
+	commit(customers []models.Customer, changes []CustomerChange) error
+	Close() error
+}
+
+// JSONCustomerRepository keeps customers in a slice and, when it has a
+// store, hands each mutation to it: the JSON file store writes the whole
+// slice back to the file, the event store appends the changes to its log.
+// It is safe for concurrent use: reads share a read lock and mutations take
+// the write lock for the whole check-modify-persist sequence.
+type JSONCustomerRepository struct {
+	mu        sync.RWMutex
+	customers []models.Customer
+	// index maps a customer ID to its position in customers
+	index map[uuid.UUID]int
+	// sequences maps a customer ID to its insertion sequence, which orders
+	// queries; nextSequence is the one of the next insert
+	sequences    map[uuid.UUID]int64
+	nextSequence int64
+	store        customerStore
+	// History is where Apply appends the history of its changes, right
+	// before committing them; it only lives in memory unless replaced
+	History ICustomerHistoryRepository
+}
+
+// NewJSONCustomerRepository creates a repository backed by the given file store
+func NewJSONCustomerRepository(store *CustomerFileStore) (*JSONCustomerRepository, error) {
+	return newStoredCustomerRepository(store)
+}
+
+// NewEventCustomerRepository creates a repository backed by the given event store
+func NewEventCustomerRepository(store *CustomerEventStore) (*JSONCustomerRepository, error) {
+	return newStoredCustomerRepository(store)
+}
+
+func newStoredCustomerRepository(store customerStore) (*JSONCustomerRepository, error) {
+	customers, err := store.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	initVersions(customers)
+	r := &JSONCustomerRepository{
+		customers: customers,
+		store:     store,
+		History:   NewMemoryCustomerHistoryRepository(),
+	}
+	r.reindex()
+	r.sequence()
+	return r, nil
+}
+
+// NewMemoryCustomerRepository creates a repository that only lives in memory
+func NewMemoryCustomerRepository(customers []models.Customer) *JSONCustomerRepository {
+	r := &JSONCustomerRepository{
+		customers: append([]models.Customer{}, customers...),
+		History:   NewMemoryCustomerHistoryRepository(),
+	}
+	initVersions(r.customers)
+	r.reindex()
+	r.sequence()
+	return r
+}
+
+// initVersions sets the first version on customers stored before versions existed
+func initVersions(customers []models.Customer) {
+	for i := range customers {
+		if customers[i].Version == 0 {
+			customers[i].Version = 1
+		}
+	}
+}
+
+func (r *JSONCustomerRepository) reindex() {
+	r.index = make(map[uuid.UUID]int, len(r.customers))
+	for i, customer := range r.customers {
+		r.index[customer.ID] = i
+	}
+}
+
+// sequence numbers the loaded customers in the order they are stored
+func (r *JSONCustomerRepository) sequence() {
+	r.sequences = make(map[uuid.UUID]int64, len(r.customers))
+	for i, customer := range r.customers {
+		r.sequences[customer.ID] = int64(i) + 1
+	}
+	r.nextSequence = int64(len(r.customers)) + 1
+}
+
+// sequenceChanges numbers the customers inserted since the last call, in
+// the order they were inserted, and forgets those removed
+func (r *JSONCustomerRepository) sequenceChanges() {
+	for id := range r.sequences {
+		if _, ok := r.index[id]; !ok {
+			delete(r.sequences, id)
+		}
+	}
+	for _, customer := range r.customers {
+		if _, ok := r.sequences[customer.ID]; !ok {
+			r.sequences[customer.ID] = r.nextSequence
+			r.nextSequence++
+		}
+	}
+}
+
+// commit persists customers, which changes made, and makes them the current
+// dataset; the caller holds the write lock
+func (r *JSONCustomerRepository) commit(customers []models.Customer, changes []CustomerChange) error {
+	if r.store != nil {
+		if err := r.store.commit(customers, changes); err != nil {
+			return err
+		}
+	}
+	r.customers = customers
+	return nil
+}
+
+// CustomerHistory returns History
+func (r *JSONCustomerRepository) CustomerHistory() ICustomerHistoryRepository {
+	return r.History
+}
+
+// Close flushes pending writes to the store
+func (r *JSONCustomerRepository) Close() error {
+	if r.store == nil {
+		return nil
+	}
+	return r.store.Close()
+}
+
+// Find returns the customer with the given ID
+func (r *JSONCustomerRepository) Find(ctx context.Context, id uuid.UUID) (models.Customer, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	i, ok := r.index[id]
+	if !ok {
+		return models.Customer{}, ErrCustomerNotFound
+	}
+	return r.customers[i], nil
+}
+
+// List returns all customers in insertion order
+func (r *JSONCustomerRepository) List(ctx context.Context) ([]models.Customer, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return append([]models.Customer{}, r.customers...), nil
+}
+
+// Query returns the page of customers described by query
+func (r *JSONCustomerRepository) Query(ctx context.Context, query CustomerQuery) (CustomerPage, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return query.apply(r.customers, r.sequences), nil
+}
+
+// Insert adds a new customer unless its email or phone is already taken
+func (r *JSONCustomerRepository) Insert(ctx context.Context, customer models.Customer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if insertConflict(r.customers, r.index, customer) {
+		return ErrCustomerExists
+	}
+
+	customers := make([]models.Customer, len(r.customers), len(r.customers)+1)
+	copy(customers, r.customers)
+	if err := r.commit(append(customers, customer), []CustomerChange{{Kind: ChangeInsert, Customer: customer}}); err != nil {
+		return err
+	}
+	r.index[customer.ID] = len(r.customers) - 1
+	r.sequences[customer.ID] = r.nextSequence
+	r.nextSequence++
+	return nil
+}
+
+// Replace overwrites the stored customer that has the same ID unless its
+// email or phone is taken by another customer
+func (r *JSONCustomerRepository) Replace(ctx context.Context, customer models.Customer, expectedVersion int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i, ok := r.index[customer.ID]
+	if !ok {
+		return ErrCustomerNotFound
+	}
+	if expectedVersion != 0 && r.customers[i].Version != expectedVersion {
+		return ErrVersionConflict
+	}
+	if uniqueConflict(r.customers, customer) {
+		return ErrCustomerExists
+	}
+
+	customers := make([]models.Customer, len(r.customers))
+	copy(customers, r.customers)
+	customers[i] = customer
+	return r.commit(customers, []CustomerChange{{Kind: ChangeReplace, Customer: customer}})
+}
+
+// Remove deletes the customer with the given ID
+func (r *JSONCustomerRepository) Remove(ctx context.Context, id uuid.UUID, expectedVersion int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i, ok := r.index[id]
+	if !ok {
+		return ErrCustomerNotFound
+	}
+	if expectedVersion != 0 && r.customers[i].Version != expectedVersion {
+		return ErrVersionConflict
+	}
+
+	customers := make([]models.Customer, 0, len(r.customers)-1)
+	customers = append(customers, r.customers[:i]...)
+	customers = append(customers, r.customers[i+1:]...)
+	if err := r.commit(customers, []CustomerChange{{Kind: ChangeRemove, ID: id}}); err != nil {
+		return err
+	}
+	r.reindex()
+	delete(r.sequences, id)
+	return nil
+}
+
+// Purge removes the customers deleted before deletedBefore
+func (r *JSONCustomerRepository) Purge(ctx context.Context, deletedBefore time.Time) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	customers := make([]models.Customer, 0, len(r.customers))
+	changes := []CustomerChange{}
+	for _, customer := range r.customers {
+		if customer.DeletedAt == nil || !customer.DeletedAt.Before(deletedBefore) {
+			customers = append(customers, customer)
+		} else {
+			changes = append(changes, CustomerChange{Kind: ChangeRemove, ID: customer.ID})
+		}
+	}
+	purged := len(changes)
+	if purged == 0 {
+		return 0, nil
+	}
+	if err := r.commit(customers, changes); err != nil {
+		return 0, err
+	}
+	r.reindex()
+	r.sequenceChanges()
+	return purged, nil
+}
+
+// Apply performs a batch of changes on a copy of the customers and persists
+// the result with a single write. The history of the changes is appended to
+// History first, and nothing is stored when that fails: a failed write of the
+// customers then leaves entries of changes that were not stored, which is
+// better than stored changes without history. Webhooks live in a file of
+// their own, so changes with deliveries are rejected.
+func (r *JSONCustomerRepository) Apply(ctx context.Context, changes []CustomerChange, atomic bool) ([]error, error) {
+	if err := validateChanges(changes); err != nil {
+		return nil, err
+	}
+	if slices.ContainsFunc(changes, func(change CustomerChange) bool { return len(change.Deliveries) > 0 }) {
+		return nil, ErrDeliveriesNotSupported
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	customers := append([]models.Customer{}, r.customers...)
+	index := make(map[uuid.UUID]int, len(customers))
+	for id, i := range r.index {
+		index[id] = i
+	}
+
+	errs := make([]error, len(changes))
+	for i, change := range changes {
+		errs[i] = applyChange(&customers, index, change)
+		if errs[i] != nil && atomic {
+			return abortRemaining(errs), nil
+		}
+	}
+
+	if entries := historyEntries(changes, errs); len(entries) > 0 {
+		if err := r.History.AppendHistory(ctx, entries); err != nil {
+			return nil, err
+		}
+	}
+	applied := make([]CustomerChange, 0, len(changes))
+	for i, change := range changes {
+		if errs[i] == nil {
+			applied = append(applied, change)
+		}
+	}
+	if err := r.commit(customers, applied); err != nil {
+		return nil, err
+	}
+	r.index = index
+	r.sequenceChanges()
+	return errs, nil
+}
+
+// applyChange performs change on customers and keeps index in step
+func applyChange(customers *[]models.Customer, index map[uuid.UUID]int, change CustomerChange) error {
+	switch change.Kind {
+	case ChangeInsert:
+		if insertConflict(*customers, index, change.Customer) {
+			return ErrCustomerExists
+		}
+		*customers = append(*customers, change.Customer)
+		index[change.Customer.ID] = len(*customers) - 1
+	case ChangeReplace:
+		i, ok := index[change.Customer.ID]
+		if !ok {
+			return ErrCustomerNotFound
+		}
+		if change.ExpectedVersion != 0 && (*customers)[i].Version != change.ExpectedVersion {
+			return ErrVersionConflict
+		}
+		if uniqueConflict(*customers, change.Customer) {
+			return ErrCustomerExists
+		}
+		(*customers)[i] = change.Customer
+	case ChangeRemove:
+		i, ok := index[change.ID]
+		if !ok {
+			return ErrCustomerNotFound
+		}
+		if change.ExpectedVersion != 0 && (*customers)[i].Version != change.ExpectedVersion {
+			return ErrVersionConflict
+		}
+		*customers = append((*customers)[:i], (*customers)[i+1:]...)
+		delete(index, change.ID)
+		for j := i; j < len(*customers); j++ {
+			index[(*customers)[j].ID] = j
+		}
+	}
+	return nil
+}
+
+// insertConflict reports whether customer reuses a stored ID, email or phone
+func insertConflict(customers []models.Customer, index map[uuid.UUID]int, customer models.Customer) bool {
+	if _, ok := index[customer.ID]; ok {
+		return true
+	}
+	return uniqueConflict(customers, customer)
+}
+
+// uniqueConflict reports whether a customer other than customer uses its
+// email or phone, like the unique indexes of the SQL backends
+func uniqueConflict(customers []models.Customer, customer models.Customer) bool {
+	for _, c := range customers {
+		if c.ID != customer.ID && (c.Email == customer.Email || c.Phone == customer.Phone) {
+			return true
+		}
+	}
+	return false
+}
+
+// ExistsByEmail reports whether a customer uses the given email
+func (r *JSONCustomerRepository) ExistsByEmail(ctx context.Context, email string) (bool, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, customer := range r.customers {
+		if customer.Email == email {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// ExistsByPhone reports whether a customer uses the given phone number
+func (r *JSONCustomerRepository) ExistsByPhone(ctx context.Context, phone string) (bool, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, customer := range r.customers {
+		if customer.Phone == phone {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,142 @@
+package repositories
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"congdinh.com/crm/models"
+	"github.com/google/uuid"
+)
+
+func TestJSONCustomerRepository_Find(t *testing.T) {
+	repository, err := NewJSONCustomerRepository(NewCustomerFileStore(copyDataFile(t), FlushAlways, 0))
+	if err != nil {
+		t.Fatalf("Failed to create repository: %s", err.Error())
+	}
+
+	existingCustomerId := uuid.MustParse("4405071c-2adc-499d-966f-3cfdfa1deedc")
+	customer, err := repository.Find(t.Context(), existingCustomerId)
+	if err != nil {
+		t.Fatalf("Expected Find to return nil error, but got %s", err.Error())
+	}
+	if customer.ID != existingCustomerId {
+		t.Errorf("Expected customer with ID %s, but got ID %s", existingCustomerId.String(), customer.ID.String())
+	}
+
+	if _, err := repository.Find(t.Context(), uuid.New()); !errors.Is(err, ErrCustomerNotFound) {
+		t.Errorf("Expected ErrCustomerNotFound for an unknown ID, but got %v", err)
+	}
+}
+
+func TestJSONCustomerRepository_ExistsByEmailAndPhone(t *testing.T) {
+	repository := NewMemoryCustomerRepository([]models.Customer{
+		{ID: uuid.New(), Email: "taken@domain.com", Phone: "111"},
+	})
+
+	if exists, _ := repository.ExistsByEmail(t.Context(), "taken@domain.com"); !exists {
+		t.Error("Expected ExistsByEmail to find the stored email")
+	}
+	if exists, _ := repository.ExistsByEmail(t.Context(), "free@domain.com"); exists {
+		t.Error("Expected ExistsByEmail to return false for an unknown email")
+	}
+	if exists, _ := repository.ExistsByPhone(t.Context(), "111"); !exists {
+		t.Error("Expected ExistsByPhone to find the stored phone")
+	}
+	if exists, _ := repository.ExistsByPhone(t.Context(), "222"); exists {
+		t.Error("Expected ExistsByPhone to return false for an unknown phone")
+	}
+}
+
+func TestJSONCustomerRepository_PersistsMutations(t *testing.T) {
+	store := NewCustomerFileStore(copyDataFile(t), FlushAlways, 0)
+	repository, err := NewJSONCustomerRepository(store)
+	if err != nil {
+		t.Fatalf("Failed to create repository: %s", err.Error())
+	}
+
+	created := models.Customer{ID: uuid.New(), Name: "Durable Customer", Email: "durable@domain.com", Phone: "5550001111"}
+	if err := repository.Insert(t.Context(), created); err != nil {
+		t.Fatalf("Expected Insert to return nil error, but got %s", err.Error())
+	}
+
+	updated := created
+	updated.Contacted = true
+	if err := repository.Replace(t.Context(), updated, 0); err != nil {
+		t.Fatalf("Expected Replace to return nil error, but got %s", err.Error())
+	}
+
+	existingCustomerId := uuid.MustParse("4405071c-2adc-499d-966f-3cfdfa1deedc")
+	if err := repository.Remove(t.Context(), existingCustomerId, 0); err != nil {
+		t.Fatalf("Expected Remove to return nil error, but got %s", err.Error())
+	}
+
+	// A fresh repository simulates a restart
+	restarted, err := NewJSONCustomerRepository(store)
+	if err != nil {
+		t.Fatalf("Failed to reload repository: %s", err.Error())
+	}
+
+	customers, _ := restarted.List(t.Context())
+	if len(customers) != 5 {
+		t.Errorf("Expected 5 customers after restart, but got %d", len(customers))
+	}
+	if customer, err := restarted.Find(t.Context(), created.ID); err != nil || !customer.Contacted {
+		t.Errorf("Expected updated customer %s to survive a restart, but got %v (%v)", created.ID.String(), customer, err)
+	}
+	if _, err := restarted.Find(t.Context(), existingCustomerId); !errors.Is(err, ErrCustomerNotFound) {
+		t.Errorf("Expected removed customer %s to stay removed after restart", existingCustomerId.String())
+	}
+}
+
+func TestJSONCustomerRepository_ConcurrentAccess(t *testing.T) {
+	// Run with -race: readers and writers share the repository and its file store
+	repository, err := NewJSONCustomerRepository(NewCustomerFileStore(copyDataFile(t), FlushDebounce, time.Millisecond))
+	if err != nil {
+		t.Fatalf("Failed to create repository: %s", err.Error())
+	}
+	defer repository.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			customer := models.Customer{ID: uuid.New(), Name: "Concurrent", Email: fmt.Sprintf("c%d@domain.com", i), Phone: fmt.Sprint(9000 + i)}
+			if err := repository.Insert(t.Context(), customer); err != nil {
+				t.Errorf("Expected Insert to return nil error, but got %s", err.Error())
+				return
+			}
+			repository.List(t.Context())
+			repository.ExistsByEmail(t.Context(), customer.Email)
+
+			customer.Contacted = true
+			if err := repository.Replace(t.Context(), customer, 0); err != nil {
+				t.Errorf("Expected Replace to return nil error, but got %s", err.Error())
+			}
+			if found, err := repository.Find(t.Context(), customer.ID); err != nil || !found.Contacted {
+				t.Errorf("Expected to find the replaced customer, but got %v (%v)", found, err)
+			}
+			if i%2 == 0 {
+				if err := repository.Remove(t.Context(), customer.ID, 0); err != nil {
+					t.Errorf("Expected Remove to return nil error, but got %s", err.Error())
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	customers, _ := repository.List(t.Context())
+	if len(customers) != 15 {
+		t.Errorf("Expected 15 customers, but got %d", len(customers))
+	}
+	// The index must still point at the right positions after interleaved removals
+	for _, customer := range customers {
+		if found, err := repository.Find(t.Context(), customer.ID); err != nil || found != customer {
+			t.Errorf("Expected Find to return %v, but got %v (%v)", customer, found, err)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+// postgresUniqueViolation is the SQLSTATE raised when a unique constraint is violated
+const postgresUniqueViolation = "23505"
+
+var postgresDialect = &sqlDialect{
+	name:           "postgres",
+	bindvar:        "$",
+	insertionOrder: "seq",
+	// The advisory lock key is arbitrary; it makes several instances
+	// starting at once apply migrations one at a time
+	migrationLock:   "SELECT pg_advisory_lock(7340215)",
+	migrationUnlock: "SELECT pg_advisory_unlock(7340215)",
+	textOrder:       ` COLLATE "C"`,
+	unlimited:       "ALL",
+	migrations: []migration{
+		{
+			version: 1,
+			name:    "create customers",
+			statements: []string{
+				`CREATE TABLE customers (
+					id UUID PRIMARY KEY,
+					seq BIGSERIAL NOT NULL,
+					name TEXT NOT NULL,
+					role TEXT NOT NULL,
+					email TEXT NOT NULL,
+					phone TEXT NOT NULL,
+					contacted BOOLEAN NOT NULL DEFAULT FALSE,
+					CONSTRAINT customers_email_key UNIQUE (email),
+					CONSTRAINT customers_phone_key UNIQUE (phone)
+				)`,
+			},
+		},
+		{
+			version:    2,
+			name:       "add customer version",
+			statements: []string{"ALTER TABLE customers ADD COLUMN version BIGINT NOT NULL DEFAULT 1"},
+		},
+		{
+			version:    3,
+			name:       "add customer deleted_at",
+			statements: []string{"ALTER TABLE customers ADD COLUMN deleted_at TIMESTAMPTZ"},
+		},
+		{
+			version: 4,
+			name:    "add customer audit fields",
+			statements: []string{
+				"ALTER TABLE customers ADD COLUMN created_at TIMESTAMPTZ",
+				"ALTER TABLE customers ADD COLUMN updated_at TIMESTAMPTZ",
+				"ALTER TABLE customers ADD COLUMN created_by TEXT NOT NULL DEFAULT ''",
+				"ALTER TABLE customers ADD COLUMN updated_by TEXT NOT NULL DEFAULT ''",
+				"CREATE INDEX customers_updated_at_idx ON customers (updated_at)",
+			},
+		},
+		{
+			version: 5,
+			name:    "create customer history",
+			statements: []string{
+				`CREATE TABLE customer_history (
+					customer_id UUID NOT NULL,
+					version BIGINT NOT NULL,
+					operation TEXT NOT NULL,
+					actor TEXT NOT NULL,
+					recorded_at TIMESTAMPTZ NOT NULL,
+					changes TEXT NOT NULL,
+					customer TEXT NOT NULL,
+					PRIMARY KEY (customer_id, version)
+				)`,
+			},
+		},
+		{
+			version: 6,
+			name:    "create webhooks",
+			statements: []string{
+				`CREATE TABLE webhooks (
+					id UUID PRIMARY KEY,
+					seq BIGSERIAL NOT NULL,
+					url TEXT NOT NULL,
+					secret TEXT NOT NULL,
+					events TEXT NOT NULL,
+					active BOOLEAN NOT NULL,
+					created_at TIMESTAMPTZ NOT NULL,
+					updated_at TIMESTAMPTZ NOT NULL
+				)`,
+				`CREATE TABLE webhook_deliveries (
+					id UUID PRIMARY KEY,
+					seq BIGSERIAL NOT NULL,
+					webhook_id UUID NOT NULL,
+					event TEXT NOT NULL,
+					payload TEXT NOT NULL,
+					status TEXT NOT NULL,
+					created_at TIMESTAMPTZ NOT NULL,
+					next_attempt_at TIMESTAMPTZ NOT NULL,
+					attempts TEXT NOT NULL
+				)`,
+				"CREATE INDEX webhook_deliveries_due_idx ON webhook_deliveries (status, next_attempt_at)",
+				"CREATE INDEX webhook_deliveries_webhook_idx ON webhook_deliveries (webhook_id)",
+			},
+		},
+		{
+			version: 7,
+			name:    "create api keys",
+			statements: []string{
+				`CREATE TABLE api_keys (
+					id UUID PRIMARY KEY,
+					seq BIGSERIAL NOT NULL,
+					name TEXT NOT NULL,
+					prefix TEXT NOT NULL,
+					hash TEXT NOT NULL UNIQUE,
+					previous_hash TEXT NOT NULL,
+					previous_expires_at TIMESTAMPTZ,
+					scopes TEXT NOT NULL,
+					created_at TIMESTAMPTZ NOT NULL,
+					expires_at TIMESTAMPTZ,
+					rotated_at TIMESTAMPTZ,
+					revoked_at TIMESTAMPTZ,
+					last_used_at TIMESTAMPTZ
+				)`,
+				"CREATE INDEX api_keys_previous_hash_idx ON api_keys (previous_hash)",
+			},
+		},
+		{
+			version:    8,
+			name:       "add api key roles",
+			statements: []string{"ALTER TABLE api_keys ADD COLUMN roles TEXT NOT NULL DEFAULT '[]'"},
+		},
+	},
+	isUniqueViolation: func(err error) bool {
+		var pgErr *pgconn.PgError
+		return errors.As(err, &pgErr) && pgErr.Code == postgresUniqueViolation
+	},
+}
+
+// PostgresOptions tunes the connection pool of the Postgres repository
+type PostgresOptions struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+}
+
+// NewPostgresCustomerRepository connects to the database described by dsn,
+// brings its schema up to date and prepares the customer statements
+func NewPostgresCustomerRepository(ctx context.Context, dsn string, options PostgresOptions) (*SQLCustomerRepository, error) {
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	db.SetMaxOpenConns(options.MaxOpenConns)
+	db.SetMaxIdleConns(options.MaxIdleConns)
+	db.SetConnMaxLifetime(options.ConnMaxLifetime)
+
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	repository, err := newSQLCustomerRepository(ctx, db, postgresDialect)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return repository, nil
+}
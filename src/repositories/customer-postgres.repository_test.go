@@ -0,0 +1,409 @@
+package repositories
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"congdinh.com/crm/models"
+	embeddedpostgres "github.com/fergusstrange/embedded-postgres"
+	"github.com/google/uuid"
+)
+
+// The Postgres tests run against CRM_TEST_POSTGRES_DSN when it is set.
+// Otherwise they start a throwaway cluster from the local initdb/pg_ctl
+// binaries, listening only on a unix socket, or else from the binaries
+// embedded-postgres downloads once into its cache, listening only on
+// localhost. Postgres refuses to run as root, so the tests are skipped
+// there, unless CI is set: then they fail rather than pass untested.
+var (
+	postgresOnce    sync.Once
+	postgresBaseDSN string
+	postgresErr     error
+	postgresStop    func()
+)
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	if postgresStop != nil {
+		postgresStop()
+	}
+	os.Exit(code)
+}
+
+func findPostgresBinary(name string) (string, error) {
+	if dir := os.Getenv("CRM_TEST_POSTGRES_BIN"); dir != "" {
+		return filepath.Join(dir, name), nil
+	}
+	if path, err := exec.LookPath(name); err == nil {
+		return path, nil
+	}
+	for _, pattern := range []string{"/usr/lib/postgresql/*/bin/", "/usr/local/pgsql/bin/", "/opt/homebrew/opt/postgresql*/bin/"} {
+		if matches, _ := filepath.Glob(pattern + name); len(matches) > 0 {
+			return matches[len(matches)-1], nil
+		}
+	}
+	return "", fmt.Errorf("%s not found; set CRM_TEST_POSTGRES_DSN or CRM_TEST_POSTGRES_BIN", name)
+}
+
+func freePort() (int, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port, nil
+}
+
+func startPostgres() {
+	if dsn := os.Getenv("CRM_TEST_POSTGRES_DSN"); dsn != "" {
+		postgresBaseDSN = dsn
+		return
+	}
+	if os.Geteuid() == 0 {
+		postgresErr = errors.New("initdb refuses to run as root; set CRM_TEST_POSTGRES_DSN")
+		return
+	}
+
+	initdb, err := findPostgresBinary("initdb")
+	if err != nil {
+		startEmbeddedPostgres()
+		return
+	}
+	pgCtl, err := findPostgresBinary("pg_ctl")
+	if err != nil {
+		startEmbeddedPostgres()
+		return
+	}
+	startLocalPostgres(initdb, pgCtl)
+}
+
+// startLocalPostgres starts a cluster with the local binaries on a unix socket
+func startLocalPostgres(initdb string, pgCtl string) {
+	port, err := freePort()
+	if err != nil {
+		postgresErr = err
+		return
+	}
+
+	dir, err := os.MkdirTemp("", "crm-pg-")
+	if err != nil {
+		postgresErr = err
+		return
+	}
+	data := filepath.Join(dir, "data")
+
+	if out, err := exec.Command(initdb, "-D", data, "-U", "postgres", "-A", "trust", "--no-sync").CombinedOutput(); err != nil {
+		os.RemoveAll(dir)
+		postgresErr = fmt.Errorf("initdb failed: %v: %s", err, out)
+		return
+	}
+	options := fmt.Sprintf("-p %d -k %s -c listen_addresses='' -c fsync=off", port, dir)
+	if out, err := exec.Command(pgCtl, "-D", data, "-l", filepath.Join(dir, "postgres.log"), "-o", options, "-w", "start").CombinedOutput(); err != nil {
+		os.RemoveAll(dir)
+		postgresErr = fmt.Errorf("pg_ctl start failed: %v: %s", err, out)
+		return
+	}
+
+	postgresBaseDSN = fmt.Sprintf("host=%s port=%d user=postgres dbname=postgres sslmode=disable", dir, port)
+	postgresStop = func() {
+		exec.Command(pgCtl, "-D", data, "-m", "immediate", "stop").Run()
+		os.RemoveAll(dir)
+	}
+}
+
+// startEmbeddedPostgres starts a cluster with the binaries of embedded-postgres
+func startEmbeddedPostgres() {
+	port, err := freePort()
+	if err != nil {
+		postgresErr = err
+		return
+	}
+	dir, err := os.MkdirTemp("", "crm-pg-")
+	if err != nil {
+		postgresErr = err
+		return
+	}
+
+	var log bytes.Buffer
+	database := embeddedpostgres.NewDatabase(embeddedpostgres.DefaultConfig().
+		Port(uint32(port)).
+		RuntimePath(dir).
+		StartParameters(map[string]string{"listen_addresses": "127.0.0.1", "fsync": "off"}).
+		StartTimeout(time.Minute).
+		Logger(&log))
+	if err := database.Start(); err != nil {
+		os.RemoveAll(dir)
+		postgresErr = fmt.Errorf("embedded postgres failed: %v: %s", err, log.String())
+		return
+	}
+
+	postgresBaseDSN = fmt.Sprintf("host=127.0.0.1 port=%d user=postgres password=postgres dbname=postgres sslmode=disable", port)
+	postgresStop = func() {
+		database.Stop()
+		os.RemoveAll(dir)
+	}
+}
+
+// withDatabase points dsn at another database on the same server
+func withDatabase(dsn string, name string) string {
+	if u, err := url.Parse(dsn); err == nil && (u.Scheme == "postgres" || u.Scheme == "postgresql") {
+		u.Path = "/" + name
+		return u.String()
+	}
+	return dsn + " dbname=" + name
+}
+
+// postgresDSN creates an empty database for the calling test and returns its DSN
+func postgresDSN(t *testing.T) string {
+	t.Helper()
+
+	postgresOnce.Do(startPostgres)
+	if postgresErr != nil && os.Getenv("CI") != "" {
+		t.Fatalf("Postgres is not available: %v", postgresErr)
+	}
+	if postgresErr != nil {
+		t.Skipf("Postgres is not available: %v", postgresErr)
+	}
+
+	admin, err := sql.Open("pgx", postgresBaseDSN)
+	if err != nil {
+		t.Fatalf("Failed to connect to Postgres: %s", err.Error())
+	}
+	defer admin.Close()
+
+	name := "crm_test_" + strings.ReplaceAll(uuid.NewString(), "-", "")
+	if _, err := admin.Exec("CREATE DATABASE " + name); err != nil {
+		t.Fatalf("Failed to create database: %s", err.Error())
+	}
+	t.Cleanup(func() {
+		admin, err := sql.Open("pgx", postgresBaseDSN)
+		if err != nil {
+			return
+		}
+		defer admin.Close()
+		admin.Exec("DROP DATABASE IF EXISTS " + name + " WITH (FORCE)")
+	})
+
+	return withDatabase(postgresBaseDSN, name)
+}
+
+func newPostgresRepository(t *testing.T, dsn string) *SQLCustomerRepository {
+	t.Helper()
+
+	repository, err := NewPostgresCustomerRepository(t.Context(), dsn, PostgresOptions{MaxOpenConns: 4, MaxIdleConns: 4})
+	if err != nil {
+		t.Fatalf("Failed to open Postgres repository: %s", err.Error())
+	}
+	t.Cleanup(func() { repository.Close() })
+	return repository
+}
+
+func TestPostgresCustomerRepository_CRUD(t *testing.T) {
+	repository := newPostgresRepository(t, postgresDSN(t))
+
+	first := models.Customer{ID: uuid.New(), Name: "First", Role: "Developer", Email: "first@domain.com", Phone: "111"}
+	second := models.Customer{ID: uuid.New(), Name: "Second", Role: "Tester", Email: "second@domain.com", Phone: "222", Contacted: true}
+	for _, customer := range []models.Customer{first, second} {
+		if err := repository.Insert(t.Context(), customer); err != nil {
+			t.Fatalf("Expected Insert to return nil error, but got %s", err.Error())
+		}
+	}
+
+	customers, err := repository.List(t.Context())
+	if err != nil {
+		t.Fatalf("Expected List to return nil error, but got %s", err.Error())
+	}
+	if len(customers) != 2 || customers[0] != first || customers[1] != second {
+		t.Errorf("Expected %v in insertion order, but got %v", []models.Customer{first, second}, customers)
+	}
+
+	first.Name = "First Updated"
+	first.Contacted = true
+	if err := repository.Replace(t.Context(), first, 0); err != nil {
+		t.Fatalf("Expected Replace to return nil error, but got %s", err.Error())
+	}
+	if found, err := repository.Find(t.Context(), first.ID); err != nil || found != first {
+		t.Errorf("Expected Find to return %v, but got %v (%v)", first, found, err)
+	}
+
+	if err := repository.Remove(t.Context(), second.ID, 0); err != nil {
+		t.Fatalf("Expected Remove to return nil error, but got %s", err.Error())
+	}
+	if _, err := repository.Find(t.Context(), second.ID); !errors.Is(err, ErrCustomerNotFound) {
+		t.Errorf("Expected ErrCustomerNotFound after Remove, but got %v", err)
+	}
+	if err := repository.Replace(t.Context(), second, 0); !errors.Is(err, ErrCustomerNotFound) {
+		t.Errorf("Expected replacing a removed customer to return ErrCustomerNotFound, but got %v", err)
+	}
+}
+
+func TestPostgresCustomerRepository_UniqueConstraints(t *testing.T) {
+	repository := newPostgresRepository(t, postgresDSN(t))
+
+	existing := models.Customer{ID: uuid.New(), Name: "Existing", Email: "taken@domain.com", Phone: "111"}
+	other := models.Customer{ID: uuid.New(), Name: "Other", Email: "other@domain.com", Phone: "222"}
+	repository.Insert(t.Context(), existing)
+	repository.Insert(t.Context(), other)
+
+	duplicates := []models.Customer{
+		{ID: uuid.New(), Name: "Same Email", Email: "taken@domain.com", Phone: "333"},
+		{ID: uuid.New(), Name: "Same Phone", Email: "new@domain.com", Phone: "111"},
+	}
+	for _, duplicate := range duplicates {
+		err := repository.Insert(t.Context(), duplicate)
+		if !errors.Is(err, ErrCustomerExists) {
+			t.Errorf("Expected Insert of %s to return ErrCustomerExists, but got %v", duplicate.Name, err)
+		} else if err.Error() != "customer already exists" {
+			t.Errorf("Expected the message 'customer already exists', but got '%s'", err.Error())
+		}
+	}
+
+	other.Phone = existing.Phone
+	if err := repository.Replace(t.Context(), other, 0); !errors.Is(err, ErrCustomerExists) {
+		t.Errorf("Expected Replace onto a taken phone to return ErrCustomerExists, but got %v", err)
+	}
+}
+
+func TestPostgresCustomerRepository_ContextCancellation(t *testing.T) {
+	repository := newPostgresRepository(t, postgresDSN(t))
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+
+	if _, err := repository.List(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected List with a cancelled context to return context.Canceled, but got %v", err)
+	}
+}
+
+func TestPostgresCustomerRepository_ConcurrentStartupMigratesOnce(t *testing.T) {
+	dsn := postgresDSN(t)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 3)
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			repository, err := NewPostgresCustomerRepository(ctx, dsn, PostgresOptions{MaxOpenConns: 2})
+			if err == nil {
+				repository.Close()
+			}
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("Expected concurrent startups to succeed, but got %s", err.Error())
+		}
+	}
+
+	repository := newPostgresRepository(t, dsn)
+	var applied int
+	if err := repository.db.QueryRow("SELECT COUNT(*) FROM schema_migrations").Scan(&applied); err != nil {
+		t.Fatalf("Failed to count migrations: %s", err.Error())
+	}
+	if applied != len(postgresDialect.migrations) {
+		t.Errorf("Expected %d applied migrations, but got %d", len(postgresDialect.migrations), applied)
+	}
+}
+
+func TestPostgresCustomerRepository_ConcurrentInserts(t *testing.T) {
+	repository := newPostgresRepository(t, postgresDSN(t))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			customer := models.Customer{ID: uuid.New(), Name: "Pooled", Email: fmt.Sprintf("pool%d@domain.com", i), Phone: fmt.Sprint(1000 + i)}
+			if err := repository.Insert(t.Context(), customer); err != nil {
+				t.Errorf("Expected Insert to return nil error, but got %s", err.Error())
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	customers, _ := repository.List(t.Context())
+	if len(customers) != 20 {
+		t.Errorf("Expected 20 customers, but got %d", len(customers))
+	}
+}
+
+func TestSQLDialect_Rebind(t *testing.T) {
+	query := "UPDATE customers SET name = ? WHERE id = ?"
+
+	if got := postgresDialect.rebind(query); got != "UPDATE customers SET name = $1 WHERE id = $2" {
+		t.Errorf("Expected numbered placeholders, but got %s", got)
+	}
+	if got := sqliteDialect.rebind(query); got != query {
+		t.Errorf("Expected SQLite placeholders to be unchanged, but got %s", got)
+	}
+}
+
+func TestPostgresCustomerRepository_Query(t *testing.T) {
+	repository := newPostgresRepository(t, postgresDSN(t))
+	for _, customer := range queryFixtures() {
+		if err := repository.Insert(t.Context(), customer); err != nil {
+			t.Fatalf("Expected Insert to return nil error, but got %s", err.Error())
+		}
+	}
+
+	testCustomerQuery(t, repository)
+}
+
+func TestPostgresCustomerRepository_Versions(t *testing.T) {
+	testCustomerVersions(t, newPostgresRepository(t, postgresDSN(t)))
+}
+
+func TestPostgresCustomerRepository_Uniqueness(t *testing.T) {
+	testCustomerUniqueness(t, newPostgresRepository(t, postgresDSN(t)))
+}
+
+func TestPostgresCustomerRepository_Apply(t *testing.T) {
+	testCustomerApply(t, newPostgresRepository(t, postgresDSN(t)))
+}
+
+func TestPostgresCustomerRepository_Trash(t *testing.T) {
+	testCustomerTrash(t, newPostgresRepository(t, postgresDSN(t)))
+}
+
+func TestPostgresCustomerRepository_Audit(t *testing.T) {
+	testCustomerAudit(t, newPostgresRepository(t, postgresDSN(t)))
+}
+
+func TestPostgresCustomerRepository_History(t *testing.T) {
+	testCustomerHistory(t, newPostgresRepository(t, postgresDSN(t)))
+}
+
+func TestPostgresCustomerRepository_Webhooks(t *testing.T) {
+	testWebhooks(t, newPostgresRepository(t, postgresDSN(t)))
+}
+
+func TestPostgresCustomerRepository_APIKeys(t *testing.T) {
+	testAPIKeys(t, newPostgresRepository(t, postgresDSN(t)))
+}
+
+func TestPostgresCustomerRepository_ApplyHistory(t *testing.T) {
+	repository := newPostgresRepository(t, postgresDSN(t))
+	testCustomerApplyHistory(t, repository, func() {
+		if _, err := repository.db.Exec("DROP TABLE customer_history"); err != nil {
+			t.Fatalf("Failed to drop the history: %s", err.Error())
+		}
+	})
+}
@@ -0,0 +1,53 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"congdinh.com/crm/models"
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrCustomerNotFound is returned when no customer has the requested ID
+	ErrCustomerNotFound = errors.New("customer not found")
+	// ErrCustomerExists is returned when another customer already uses the email or phone
+	ErrCustomerExists = errors.New("customer already exists")
+	// ErrVersionConflict is returned when the stored customer is not at the expected version
+	ErrVersionConflict = errors.New("customer version conflict")
+)
+
+// ICustomerRepository defines the storage operations for customers.
+// Every method takes the caller's context so database backends can cancel
+// queries when the request goes away.
+//
+// Replace and Remove only change a customer whose stored version equals
+// expectedVersion and return ErrVersionConflict otherwise; an
+// expectedVersion of 0 skips the check. Replace stores customer.Version as
+// given, so callers set it to the next version. Insert and Replace, also
+// within Apply, return ErrCustomerExists when another customer uses the
+// email or phone.
+//
+// Apply performs a batch of changes in order and returns one error per
+// change, nil for the ones that were stored. When atomic is set the batch is
+// all or nothing: after the first failing change nothing is stored and the
+// other changes report ErrChangeNotApplied. Otherwise every change that can
+// be stored is. The returned error is set when the batch as a whole failed.
+//
+// Deleted customers stay in storage with DeletedAt set, keeping their email
+// and phone, until Purge removes the ones deleted before deletedBefore. Find
+// and List return them too; Query returns either the live or the deleted
+// customers.
+type ICustomerRepository interface {
+	Find(ctx context.Context, id uuid.UUID) (models.Customer, error)
+	List(ctx context.Context) ([]models.Customer, error)
+	Query(ctx context.Context, query CustomerQuery) (CustomerPage, error)
+	Insert(ctx context.Context, customer models.Customer) error
+	Replace(ctx context.Context, customer models.Customer, expectedVersion int64) error
+	Remove(ctx context.Context, id uuid.UUID, expectedVersion int64) error
+	Apply(ctx context.Context, changes []CustomerChange, atomic bool) ([]error, error)
+	Purge(ctx context.Context, deletedBefore time.Time) (int, error)
+	ExistsByEmail(ctx context.Context, email string) (bool, error)
+	ExistsByPhone(ctx context.Context, phone string) (bool, error)
+}
@@ -0,0 +1,465 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"congdinh.com/crm/models"
+	"github.com/google/uuid"
+)
+
+// sqlDialect captures what differs between the SQL databases we support
+type sqlDialect struct {
+	name string
+	// bindvar is the placeholder style: "?" or "$" for numbered placeholders
+	bindvar string
+	// insertionOrder is the expression List sorts by to keep insertion order
+	insertionOrder string
+	// textOrder is appended to text sort columns so every backend sorts by
+	// byte order, like the in-memory repository does
+	textOrder string
+	// unlimited is the LIMIT value meaning "no limit", needed before OFFSET
+	unlimited  string
+	migrations []migration
+	// migrationLock and migrationUnlock serialize migrations across processes
+	migrationLock   string
+	migrationUnlock string
+	// isUniqueViolation reports whether err was caused by a unique constraint
+	isUniqueViolation func(err error) bool
+}
+
+// rebind rewrites "?" placeholders into the dialect's placeholder style
+func (d *sqlDialect) rebind(query string) string {
+	if d.bindvar != "$" {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+const customerColumns = "id, name, role, email, phone, contacted, version, deleted_at, created_at, updated_at, created_by, updated_by"
+
+// SQLCustomerRepository stores customers in a SQL database through database/sql
+type SQLCustomerRepository struct {
+	db      *sql.DB
+	dialect *sqlDialect
+
+	findStmt    *sql.Stmt
+	listStmt    *sql.Stmt
+	insertStmt  *sql.Stmt
+	replaceStmt *sql.Stmt
+	removeStmt  *sql.Stmt
+	purgeStmt   *sql.Stmt
+	emailStmt   *sql.Stmt
+	phoneStmt   *sql.Stmt
+
+	appendHistoryStmt *sql.Stmt
+	countHistoryStmt  *sql.Stmt
+	historyStmt       *sql.Stmt
+	historyAsOfStmt   *sql.Stmt
+}
+
+// newSQLCustomerRepository migrates the schema and prepares the statements used by the repository
+func newSQLCustomerRepository(ctx context.Context, db *sql.DB, dialect *sqlDialect) (*SQLCustomerRepository, error) {
+	if err := migrate(ctx, db, dialect); err != nil {
+		return nil, err
+	}
+
+	r := &SQLCustomerRepository{db: db, dialect: dialect}
+	statements := []struct {
+		stmt  **sql.Stmt
+		query string
+	}{
+		{&r.findStmt, "SELECT " + customerColumns + " FROM customers WHERE id = ?"},
+		{&r.listStmt, "SELECT " + customerColumns + " FROM customers ORDER BY " + dialect.insertionOrder},
+		{&r.insertStmt, "INSERT INTO customers (" + customerColumns + ") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"},
+		{&r.replaceStmt, "UPDATE customers SET name = ?, role = ?, email = ?, phone = ?, contacted = ?, version = ?, deleted_at = ?, created_at = ?, updated_at = ?, created_by = ?, updated_by = ? WHERE id = ? AND CAST(? AS BIGINT) IN (0, version)"},
+		{&r.removeStmt, "DELETE FROM customers WHERE id = ? AND CAST(? AS BIGINT) IN (0, version)"},
+		{&r.purgeStmt, "DELETE FROM customers WHERE deleted_at < ?"},
+		{&r.emailStmt, "SELECT COUNT(*) FROM customers WHERE email = ?"},
+		{&r.phoneStmt, "SELECT COUNT(*) FROM customers WHERE phone = ?"},
+		{&r.appendHistoryStmt, "INSERT INTO customer_history (" + historyColumns + ") VALUES (?, ?, ?, ?, ?, ?, ?)"},
+		{&r.countHistoryStmt, "SELECT COUNT(*) FROM customer_history WHERE customer_id = ?"},
+		{&r.historyStmt, "SELECT " + historyColumns + " FROM customer_history WHERE customer_id = ? ORDER BY version DESC LIMIT ? OFFSET ?"},
+		{&r.historyAsOfStmt, "SELECT " + historyColumns + " FROM customer_history WHERE customer_id = ? AND recorded_at <= ? ORDER BY version DESC LIMIT 1"},
+	}
+	for _, s := range statements {
+		stmt, err := db.PrepareContext(ctx, dialect.rebind(s.query))
+		if err != nil {
+			r.Close()
+			return nil, fmt.Errorf("failed to prepare %q: %w", s.query, err)
+		}
+		*s.stmt = stmt
+	}
+
+	return r, nil
+}
+
+// Close releases the prepared statements and the connection pool
+func (r *SQLCustomerRepository) Close() error {
+	for _, stmt := range []*sql.Stmt{r.findStmt, r.listStmt, r.insertStmt, r.replaceStmt, r.removeStmt, r.purgeStmt, r.emailStmt, r.phoneStmt, r.appendHistoryStmt, r.countHistoryStmt, r.historyStmt, r.historyAsOfStmt} {
+		if stmt != nil {
+			stmt.Close()
+		}
+	}
+	return r.db.Close()
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanCustomer(row rowScanner) (models.Customer, error) {
+	var (
+		customer                        models.Customer
+		deletedAt, createdAt, updatedAt sql.NullTime
+	)
+	err := row.Scan(&customer.ID, &customer.Name, &customer.Role, &customer.Email, &customer.Phone, &customer.Contacted, &customer.Version, &deletedAt, &createdAt, &updatedAt, &customer.CreatedBy, &customer.UpdatedBy)
+	if deletedAt.Valid {
+		deleted := deletedAt.Time.UTC()
+		customer.DeletedAt = &deleted
+	}
+	if createdAt.Valid {
+		customer.CreatedAt = createdAt.Time.UTC()
+	}
+	if updatedAt.Valid {
+		customer.UpdatedAt = updatedAt.Time.UTC()
+	}
+	return customer, err
+}
+
+// nullTime converts an optional timestamp to a query argument, in UTC so
+// SQLite's text timestamps compare in time order. Nil and zero times are NULL.
+func nullTime(t *time.Time) sql.NullTime {
+	if t == nil || t.IsZero() {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: t.UTC(), Valid: true}
+}
+
+// mapError translates driver errors into repository errors
+func (r *SQLCustomerRepository) mapError(err error) error {
+	if err != nil && r.dialect.isUniqueViolation(err) {
+		return ErrCustomerExists
+	}
+	return err
+}
+
+// bindStmt returns the prepared statement to run, bound to a transaction when there is one
+type bindStmt func(stmt *sql.Stmt) *sql.Stmt
+
+func unbound(stmt *sql.Stmt) *sql.Stmt {
+	return stmt
+}
+
+// Find returns the customer with the given ID
+func (r *SQLCustomerRepository) Find(ctx context.Context, id uuid.UUID) (models.Customer, error) {
+	return r.find(ctx, unbound, id)
+}
+
+func (r *SQLCustomerRepository) find(ctx context.Context, bind bindStmt, id uuid.UUID) (models.Customer, error) {
+	customer, err := scanCustomer(bind(r.findStmt).QueryRowContext(ctx, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.Customer{}, ErrCustomerNotFound
+	}
+	return customer, err
+}
+
+// List returns all customers in insertion order
+func (r *SQLCustomerRepository) List(ctx context.Context) ([]models.Customer, error) {
+	rows, err := r.listStmt.QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	customers := []models.Customer{}
+	for rows.Next() {
+		customer, err := scanCustomer(rows)
+		if err != nil {
+			return nil, err
+		}
+		customers = append(customers, customer)
+	}
+	return customers, rows.Err()
+}
+
+// escapeLike escapes the LIKE wildcards in a user supplied prefix
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(value)
+}
+
+// Query returns the page of customers described by query, filtering,
+// sorting and paging in the database
+func (r *SQLCustomerRepository) Query(ctx context.Context, query CustomerQuery) (CustomerPage, error) {
+	conditions := []string{"deleted_at IS NULL"}
+	if query.Deleted {
+		conditions = []string{"deleted_at IS NOT NULL"}
+	}
+	args := []any{}
+	if query.Role != "" {
+		conditions = append(conditions, "LOWER(role) = LOWER(?)")
+		args = append(args, query.Role)
+	}
+	if query.Contacted != nil {
+		conditions = append(conditions, "contacted = ?")
+		args = append(args, *query.Contacted)
+	}
+	if query.NamePrefix != "" {
+		conditions = append(conditions, `LOWER(name) LIKE LOWER(?) ESCAPE '\'`)
+		args = append(args, escapeLike(query.NamePrefix)+"%")
+	}
+	if query.EmailPrefix != "" {
+		conditions = append(conditions, `LOWER(email) LIKE LOWER(?) ESCAPE '\'`)
+		args = append(args, escapeLike(query.EmailPrefix)+"%")
+	}
+	if !query.UpdatedSince.IsZero() {
+		conditions = append(conditions, "updated_at >= ?")
+		args = append(args, nullTime(&query.UpdatedSince))
+	}
+
+	where := " WHERE " + strings.Join(conditions, " AND ")
+
+	page := CustomerPage{Customers: []models.Customer{}}
+	countQuery := r.dialect.rebind("SELECT COUNT(*) FROM customers" + where)
+	if err := r.db.QueryRowContext(ctx, countQuery, args...).Scan(&page.Total); err != nil {
+		return CustomerPage{}, err
+	}
+
+	// Column names come from the sortColumns whitelist, never from user input
+	order := []string{}
+	columns := []string{}
+	for _, field := range query.Sort {
+		column, ok := sortColumns[field.Field]
+		if !ok {
+			return CustomerPage{}, fmt.Errorf("cannot sort by %q", field.Field)
+		}
+		if field.Field != "contacted" {
+			column += r.dialect.textOrder
+		}
+		columns = append(columns, column)
+		if field.Descending {
+			column += " DESC"
+		}
+		order = append(order, column)
+	}
+	order = append(order, r.dialect.insertionOrder)
+
+	if query.After != nil {
+		after, afterArgs := keysetCondition(query.Sort, columns, r.dialect.insertionOrder, *query.After, query.AfterSequence)
+		where += " AND " + after
+		args = append(args, afterArgs...)
+	}
+
+	selectQuery := "SELECT " + customerColumns + ", " + r.dialect.insertionOrder + " FROM customers" + where + " ORDER BY " + strings.Join(order, ", ")
+	if query.Limit > 0 {
+		selectQuery += " LIMIT ?"
+		args = append(args, query.Limit)
+	} else {
+		selectQuery += " LIMIT " + r.dialect.unlimited
+	}
+	selectQuery += " OFFSET ?"
+	args = append(args, query.Offset)
+
+	rows, err := r.db.QueryContext(ctx, r.dialect.rebind(selectQuery), args...)
+	if err != nil {
+		return CustomerPage{}, err
+	}
+	defer rows.Close()
+
+	page.Sequences = []int64{}
+	for rows.Next() {
+		var sequence int64
+		customer, err := scanCustomer(sequencedRow{rows, &sequence})
+		if err != nil {
+			return CustomerPage{}, err
+		}
+		page.Customers = append(page.Customers, customer)
+		page.Sequences = append(page.Sequences, sequence)
+	}
+	return page, rows.Err()
+}
+
+// sequencedRow scans a customer followed by its insertion sequence
+type sequencedRow struct {
+	row      rowScanner
+	sequence *int64
+}
+
+func (r sequencedRow) Scan(dest ...any) error {
+	return r.row.Scan(append(dest, r.sequence)...)
+}
+
+// keysetCondition returns the condition selecting the customers ordered
+// after customer by sort, then in insertion order, and its arguments. The
+// sort fields may mix directions, so it spells out the row comparison: the
+// first field is past customer's, or equal and the rest of the key is past it.
+func keysetCondition(sort []SortField, columns []string, insertionOrder string, customer models.Customer, sequence int64) (string, []any) {
+	condition, args := insertionOrder+" > ?", []any{sequence}
+	for i := len(sort) - 1; i >= 0; i-- {
+		value := SortValue(customer, sort[i].Field)
+		operator := ">"
+		if sort[i].Descending {
+			operator = "<"
+		}
+		condition = fmt.Sprintf("(%s %s ? OR (%s = ? AND %s))", columns[i], operator, columns[i], condition)
+		args = append([]any{value, value}, args...)
+	}
+	return condition, args
+}
+
+// Insert adds a new customer; the unique indexes reject duplicate emails and phones
+func (r *SQLCustomerRepository) Insert(ctx context.Context, customer models.Customer) error {
+	return r.insert(ctx, unbound, customer)
+}
+
+func (r *SQLCustomerRepository) insert(ctx context.Context, bind bindStmt, customer models.Customer) error {
+	_, err := bind(r.insertStmt).ExecContext(ctx, customer.ID, customer.Name, customer.Role, customer.Email, customer.Phone, customer.Contacted, customer.Version, nullTime(customer.DeletedAt), nullTime(&customer.CreatedAt), nullTime(&customer.UpdatedAt), customer.CreatedBy, customer.UpdatedBy)
+	return r.mapError(err)
+}
+
+// Replace overwrites the stored customer that has the same ID
+func (r *SQLCustomerRepository) Replace(ctx context.Context, customer models.Customer, expectedVersion int64) error {
+	return r.replace(ctx, unbound, customer, expectedVersion)
+}
+
+func (r *SQLCustomerRepository) replace(ctx context.Context, bind bindStmt, customer models.Customer, expectedVersion int64) error {
+	result, err := bind(r.replaceStmt).ExecContext(ctx, customer.Name, customer.Role, customer.Email, customer.Phone, customer.Contacted, customer.Version, nullTime(customer.DeletedAt), nullTime(&customer.CreatedAt), nullTime(&customer.UpdatedAt), customer.CreatedBy, customer.UpdatedBy, customer.ID, expectedVersion)
+	if err != nil {
+		return r.mapError(err)
+	}
+	return r.expectOneRow(ctx, bind, result, customer.ID)
+}
+
+// Remove deletes the customer with the given ID
+func (r *SQLCustomerRepository) Remove(ctx context.Context, id uuid.UUID, expectedVersion int64) error {
+	return r.remove(ctx, unbound, id, expectedVersion)
+}
+
+func (r *SQLCustomerRepository) remove(ctx context.Context, bind bindStmt, id uuid.UUID, expectedVersion int64) error {
+	result, err := bind(r.removeStmt).ExecContext(ctx, id, expectedVersion)
+	if err != nil {
+		return err
+	}
+	return r.expectOneRow(ctx, bind, result, id)
+}
+
+// Purge removes the customers deleted before deletedBefore
+func (r *SQLCustomerRepository) Purge(ctx context.Context, deletedBefore time.Time) (int, error) {
+	result, err := r.purgeStmt.ExecContext(ctx, deletedBefore.UTC())
+	if err != nil {
+		return 0, err
+	}
+	purged, err := result.RowsAffected()
+	return int(purged), err
+}
+
+// Apply performs a batch of changes, with their webhook deliveries, in one
+// transaction. In best-effort mode each change runs inside a savepoint, so a
+// failing change is rolled back with its deliveries without losing the
+// others.
+func (r *SQLCustomerRepository) Apply(ctx context.Context, changes []CustomerChange, atomic bool) ([]error, error) {
+	if err := validateChanges(changes); err != nil {
+		return nil, err
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	bind := func(stmt *sql.Stmt) *sql.Stmt {
+		return tx.StmtContext(ctx, stmt)
+	}
+
+	errs := make([]error, len(changes))
+	for i, change := range changes {
+		if !atomic {
+			if _, err := tx.ExecContext(ctx, "SAVEPOINT batch_change"); err != nil {
+				return nil, err
+			}
+		}
+
+		switch change.Kind {
+		case ChangeInsert:
+			errs[i] = r.insert(ctx, bind, change.Customer)
+		case ChangeReplace:
+			errs[i] = r.replace(ctx, bind, change.Customer, change.ExpectedVersion)
+		case ChangeRemove:
+			errs[i] = r.remove(ctx, bind, change.ID, change.ExpectedVersion)
+		}
+		if errs[i] == nil && change.History != nil {
+			errs[i] = r.insertHistory(ctx, tx, []models.CustomerHistoryEntry{*change.History})
+		}
+		if errs[i] == nil {
+			errs[i] = r.insertDeliveries(ctx, tx, change.Deliveries)
+		}
+		if errs[i] != nil && !isChangeError(errs[i]) {
+			return nil, errs[i]
+		}
+
+		switch {
+		case errs[i] != nil && atomic:
+			return abortRemaining(errs), nil
+		case errs[i] != nil:
+			_, err = tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT batch_change")
+		case !atomic:
+			_, err = tx.ExecContext(ctx, "RELEASE SAVEPOINT batch_change")
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return errs, tx.Commit()
+}
+
+// ExistsByEmail reports whether a customer uses the given email
+func (r *SQLCustomerRepository) ExistsByEmail(ctx context.Context, email string) (bool, error) {
+	return r.exists(ctx, r.emailStmt, email)
+}
+
+// ExistsByPhone reports whether a customer uses the given phone number
+func (r *SQLCustomerRepository) ExistsByPhone(ctx context.Context, phone string) (bool, error) {
+	return r.exists(ctx, r.phoneStmt, phone)
+}
+
+func (r *SQLCustomerRepository) exists(ctx context.Context, stmt *sql.Stmt, value string) (bool, error) {
+	var count int
+	if err := stmt.QueryRowContext(ctx, value).Scan(&count); err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// expectOneRow checks that a versioned write changed customer id and tells
+// a missing customer apart from a version conflict when it did not
+func (r *SQLCustomerRepository) expectOneRow(ctx context.Context, bind bindStmt, result sql.Result, id uuid.UUID) error {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected > 0 {
+		return nil
+	}
+	if _, err := r.find(ctx, bind, id); err != nil {
+		return err
+	}
+	return ErrVersionConflict
+}
@@ -0,0 +1,163 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/url"
+	"path"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+var sqliteDialect = &sqlDialect{
+	name:           "sqlite",
+	bindvar:        "?",
+	insertionOrder: "rowid",
+	unlimited:      "-1",
+	migrations: []migration{
+		{
+			version: 1,
+			name:    "create customers",
+			statements: []string{
+				`CREATE TABLE customers (
+					id TEXT PRIMARY KEY,
+					name TEXT NOT NULL,
+					role TEXT NOT NULL,
+					email TEXT NOT NULL,
+					phone TEXT NOT NULL,
+					contacted BOOLEAN NOT NULL DEFAULT 0
+				)`,
+				"CREATE UNIQUE INDEX customers_email_idx ON customers (email)",
+				"CREATE UNIQUE INDEX customers_phone_idx ON customers (phone)",
+			},
+		},
+		{
+			version:    2,
+			name:       "add customer version",
+			statements: []string{"ALTER TABLE customers ADD COLUMN version BIGINT NOT NULL DEFAULT 1"},
+		},
+		{
+			version:    3,
+			name:       "add customer deleted_at",
+			statements: []string{"ALTER TABLE customers ADD COLUMN deleted_at TIMESTAMP"},
+		},
+		{
+			version: 4,
+			name:    "add customer audit fields",
+			statements: []string{
+				"ALTER TABLE customers ADD COLUMN created_at TIMESTAMP",
+				"ALTER TABLE customers ADD COLUMN updated_at TIMESTAMP",
+				"ALTER TABLE customers ADD COLUMN created_by TEXT NOT NULL DEFAULT ''",
+				"ALTER TABLE customers ADD COLUMN updated_by TEXT NOT NULL DEFAULT ''",
+				"CREATE INDEX customers_updated_at_idx ON customers (updated_at)",
+			},
+		},
+		{
+			version: 5,
+			name:    "create customer history",
+			statements: []string{
+				`CREATE TABLE customer_history (
+					customer_id TEXT NOT NULL,
+					version BIGINT NOT NULL,
+					operation TEXT NOT NULL,
+					actor TEXT NOT NULL,
+					recorded_at TIMESTAMP NOT NULL,
+					changes TEXT NOT NULL,
+					customer TEXT NOT NULL,
+					PRIMARY KEY (customer_id, version)
+				)`,
+			},
+		},
+		{
+			version: 6,
+			name:    "create webhooks",
+			statements: []string{
+				`CREATE TABLE webhooks (
+					id TEXT PRIMARY KEY,
+					url TEXT NOT NULL,
+					secret TEXT NOT NULL,
+					events TEXT NOT NULL,
+					active BOOLEAN NOT NULL,
+					created_at TIMESTAMP NOT NULL,
+					updated_at TIMESTAMP NOT NULL
+				)`,
+				`CREATE TABLE webhook_deliveries (
+					id TEXT PRIMARY KEY,
+					webhook_id TEXT NOT NULL,
+					event TEXT NOT NULL,
+					payload TEXT NOT NULL,
+					status TEXT NOT NULL,
+					created_at TIMESTAMP NOT NULL,
+					next_attempt_at TIMESTAMP NOT NULL,
+					attempts TEXT NOT NULL
+				)`,
+				"CREATE INDEX webhook_deliveries_due_idx ON webhook_deliveries (status, next_attempt_at)",
+				"CREATE INDEX webhook_deliveries_webhook_idx ON webhook_deliveries (webhook_id)",
+			},
+		},
+		{
+			version: 7,
+			name:    "create api keys",
+			statements: []string{
+				`CREATE TABLE api_keys (
+					id TEXT PRIMARY KEY,
+					name TEXT NOT NULL,
+					prefix TEXT NOT NULL,
+					hash TEXT NOT NULL UNIQUE,
+					previous_hash TEXT NOT NULL,
+					previous_expires_at TIMESTAMP,
+					scopes TEXT NOT NULL,
+					created_at TIMESTAMP NOT NULL,
+					expires_at TIMESTAMP,
+					rotated_at TIMESTAMP,
+					revoked_at TIMESTAMP,
+					last_used_at TIMESTAMP
+				)`,
+				"CREATE INDEX api_keys_previous_hash_idx ON api_keys (previous_hash)",
+			},
+		},
+		{
+			version:    8,
+			name:       "add api key roles",
+			statements: []string{"ALTER TABLE api_keys ADD COLUMN roles TEXT NOT NULL DEFAULT '[]'"},
+		},
+	},
+	isUniqueViolation: func(err error) bool {
+		var sqliteErr *sqlite.Error
+		if !errors.As(err, &sqliteErr) {
+			return false
+		}
+		return sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE || sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY
+	},
+}
+
+// DefaultSQLiteFile returns the path of data/customers.db next to the bundled JSON data
+func DefaultSQLiteFile() string {
+	return path.Join(path.Dir(DefaultDataFile()), "customers.db")
+}
+
+// NewSQLiteCustomerRepository opens (or creates) the SQLite database at path
+// and brings its schema up to date
+func NewSQLiteCustomerRepository(ctx context.Context, file string) (*SQLCustomerRepository, error) {
+	// WAL lets readers proceed while a write is in progress; busy_timeout
+	// makes concurrent writers wait for the lock instead of failing. The
+	// sqlite time format stores timestamps as text that sorts in time order.
+	dsn := "file:" + file + "?" + url.Values{
+		"_pragma":      []string{"busy_timeout(5000)", "journal_mode(WAL)"},
+		"_time_format": []string{"sqlite"},
+	}.Encode()
+
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	repository, err := newSQLCustomerRepository(ctx, db, sqliteDialect)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return repository, nil
+}
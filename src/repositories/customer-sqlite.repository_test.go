@@ -0,0 +1,163 @@
+package repositories
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"congdinh.com/crm/models"
+	"github.com/google/uuid"
+)
+
+func newSQLiteRepository(t *testing.T, file string) *SQLCustomerRepository {
+	t.Helper()
+
+	repository, err := NewSQLiteCustomerRepository(t.Context(), file)
+	if err != nil {
+		t.Fatalf("Failed to open SQLite repository: %s", err.Error())
+	}
+	t.Cleanup(func() { repository.Close() })
+	return repository
+}
+
+func TestSQLiteCustomerRepository_CRUD(t *testing.T) {
+	repository := newSQLiteRepository(t, filepath.Join(t.TempDir(), "crm.db"))
+
+	first := models.Customer{ID: uuid.New(), Name: "First", Role: "Developer", Email: "first@domain.com", Phone: "111"}
+	second := models.Customer{ID: uuid.New(), Name: "Second", Role: "Tester", Email: "second@domain.com", Phone: "222", Contacted: true}
+	for _, customer := range []models.Customer{first, second} {
+		if err := repository.Insert(t.Context(), customer); err != nil {
+			t.Fatalf("Expected Insert to return nil error, but got %s", err.Error())
+		}
+	}
+
+	customers, err := repository.List(t.Context())
+	if err != nil {
+		t.Fatalf("Expected List to return nil error, but got %s", err.Error())
+	}
+	if len(customers) != 2 || customers[0] != first || customers[1] != second {
+		t.Errorf("Expected %v in insertion order, but got %v", []models.Customer{first, second}, customers)
+	}
+
+	first.Name = "First Updated"
+	first.Contacted = true
+	if err := repository.Replace(t.Context(), first, 0); err != nil {
+		t.Fatalf("Expected Replace to return nil error, but got %s", err.Error())
+	}
+	if found, err := repository.Find(t.Context(), first.ID); err != nil || found != first {
+		t.Errorf("Expected Find to return %v, but got %v (%v)", first, found, err)
+	}
+
+	if err := repository.Remove(t.Context(), second.ID, 0); err != nil {
+		t.Fatalf("Expected Remove to return nil error, but got %s", err.Error())
+	}
+	if _, err := repository.Find(t.Context(), second.ID); !errors.Is(err, ErrCustomerNotFound) {
+		t.Errorf("Expected ErrCustomerNotFound after Remove, but got %v", err)
+	}
+	if err := repository.Remove(t.Context(), second.ID, 0); !errors.Is(err, ErrCustomerNotFound) {
+		t.Errorf("Expected removing twice to return ErrCustomerNotFound, but got %v", err)
+	}
+	if err := repository.Replace(t.Context(), second, 0); !errors.Is(err, ErrCustomerNotFound) {
+		t.Errorf("Expected replacing a removed customer to return ErrCustomerNotFound, but got %v", err)
+	}
+}
+
+func TestSQLiteCustomerRepository_UniqueIndexes(t *testing.T) {
+	repository := newSQLiteRepository(t, filepath.Join(t.TempDir(), "crm.db"))
+
+	existing := models.Customer{ID: uuid.New(), Name: "Existing", Email: "taken@domain.com", Phone: "111"}
+	other := models.Customer{ID: uuid.New(), Name: "Other", Email: "other@domain.com", Phone: "222"}
+	repository.Insert(t.Context(), existing)
+	repository.Insert(t.Context(), other)
+
+	duplicates := []models.Customer{
+		{ID: uuid.New(), Name: "Same Email", Email: "taken@domain.com", Phone: "333"},
+		{ID: uuid.New(), Name: "Same Phone", Email: "new@domain.com", Phone: "111"},
+	}
+	for _, duplicate := range duplicates {
+		if err := repository.Insert(t.Context(), duplicate); !errors.Is(err, ErrCustomerExists) {
+			t.Errorf("Expected Insert of %s to return ErrCustomerExists, but got %v", duplicate.Name, err)
+		}
+	}
+
+	other.Email = existing.Email
+	if err := repository.Replace(t.Context(), other, 0); !errors.Is(err, ErrCustomerExists) {
+		t.Errorf("Expected Replace onto a taken email to return ErrCustomerExists, but got %v", err)
+	}
+
+	if exists, _ := repository.ExistsByEmail(t.Context(), "taken@domain.com"); !exists {
+		t.Error("Expected ExistsByEmail to find the stored email")
+	}
+	if exists, _ := repository.ExistsByPhone(t.Context(), "999"); exists {
+		t.Error("Expected ExistsByPhone to return false for an unknown phone")
+	}
+}
+
+func TestSQLiteCustomerRepository_MigratesOnce(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "crm.db")
+
+	repository := newSQLiteRepository(t, file)
+	customer := models.Customer{ID: uuid.New(), Name: "Persisted", Email: "persisted@domain.com", Phone: "111"}
+	if err := repository.Insert(t.Context(), customer); err != nil {
+		t.Fatalf("Expected Insert to return nil error, but got %s", err.Error())
+	}
+	repository.Close()
+
+	// Reopening must not re-run migrations or lose data
+	reopened := newSQLiteRepository(t, file)
+
+	var applied int
+	if err := reopened.db.QueryRow("SELECT COUNT(*) FROM schema_migrations").Scan(&applied); err != nil {
+		t.Fatalf("Failed to count migrations: %s", err.Error())
+	}
+	if applied != len(sqliteDialect.migrations) {
+		t.Errorf("Expected %d applied migrations, but got %d", len(sqliteDialect.migrations), applied)
+	}
+
+	if found, err := reopened.Find(t.Context(), customer.ID); err != nil || found != customer {
+		t.Errorf("Expected %v after reopening, but got %v (%v)", customer, found, err)
+	}
+}
+
+func TestSQLiteCustomerRepository_Query(t *testing.T) {
+	repository := newSQLiteRepository(t, filepath.Join(t.TempDir(), "crm.db"))
+	for _, customer := range queryFixtures() {
+		if err := repository.Insert(t.Context(), customer); err != nil {
+			t.Fatalf("Expected Insert to return nil error, but got %s", err.Error())
+		}
+	}
+
+	testCustomerQuery(t, repository)
+}
+
+func TestSQLiteCustomerRepository_Versions(t *testing.T) {
+	testCustomerVersions(t, newSQLiteRepository(t, filepath.Join(t.TempDir(), "crm.db")))
+}
+
+func TestSQLiteCustomerRepository_Uniqueness(t *testing.T) {
+	testCustomerUniqueness(t, newSQLiteRepository(t, filepath.Join(t.TempDir(), "crm.db")))
+}
+
+func TestSQLiteCustomerRepository_Apply(t *testing.T) {
+	testCustomerApply(t, newSQLiteRepository(t, filepath.Join(t.TempDir(), "crm.db")))
+}
+
+func TestSQLiteCustomerRepository_Trash(t *testing.T) {
+	testCustomerTrash(t, newSQLiteRepository(t, filepath.Join(t.TempDir(), "crm.db")))
+}
+
+func TestSQLiteCustomerRepository_Audit(t *testing.T) {
+	testCustomerAudit(t, newSQLiteRepository(t, filepath.Join(t.TempDir(), "crm.db")))
+}
+
+func TestSQLiteCustomerRepository_History(t *testing.T) {
+	testCustomerHistory(t, newSQLiteRepository(t, filepath.Join(t.TempDir(), "crm.db")))
+}
+
+func TestSQLiteCustomerRepository_Webhooks(t *testing.T) {
+	testWebhooks(t, newSQLiteRepository(t, filepath.Join(t.TempDir(), "crm.db")))
+}
+
+func TestSQLiteCustomerRepository_APIKeys(t *testing.T) {
+	testAPIKeys(t, newSQLiteRepository(t, filepath.Join(t.TempDir(), "crm.db")))
+}
@@ -0,0 +1,58 @@
+package repositories
+
+import (
+	"testing"
+	"time"
+
+	"congdinh.com/crm/models"
+	"github.com/google/uuid"
+)
+
+// testCustomerAudit checks that every backend keeps the audit fields and filters by them
+func testCustomerAudit(t *testing.T, repository ICustomerRepository) {
+	t.Helper()
+
+	created := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	updated := time.Date(2026, 3, 8, 17, 45, 30, 250000000, time.UTC)
+	legacy := models.Customer{ID: uuid.New(), Name: "Legacy", Email: "legacy@domain.com", Phone: "5550002001", Version: 1}
+	stale := models.Customer{ID: uuid.New(), Name: "Stale", Email: "stale@domain.com", Phone: "5550002002", Version: 1,
+		CreatedAt: created, UpdatedAt: created, CreatedBy: "alice", UpdatedBy: "alice"}
+	fresh := models.Customer{ID: uuid.New(), Name: "Fresh", Email: "fresh@domain.com", Phone: "5550002003", Version: 2,
+		CreatedAt: created, UpdatedAt: updated, CreatedBy: "alice", UpdatedBy: "bob"}
+	for _, customer := range []models.Customer{legacy, stale, fresh} {
+		if err := repository.Insert(t.Context(), customer); err != nil {
+			t.Fatalf("Expected Insert to return nil error, but got %s", err.Error())
+		}
+	}
+
+	found, err := repository.Find(t.Context(), fresh.ID)
+	if err != nil || !found.CreatedAt.Equal(created) || !found.UpdatedAt.Equal(updated) || found.CreatedBy != "alice" || found.UpdatedBy != "bob" {
+		t.Errorf("Expected Find to return the audit fields of %v, but got %v (%v)", fresh, found, err)
+	}
+	if found, _ := repository.Find(t.Context(), legacy.ID); !found.CreatedAt.IsZero() || !found.UpdatedAt.IsZero() || found.CreatedBy != "" {
+		t.Errorf("Expected a customer stored without audit fields to keep them zero, but got %v", found)
+	}
+
+	page, err := repository.Query(t.Context(), CustomerQuery{UpdatedSince: updated})
+	if err != nil || page.Total != 1 || page.Customers[0].ID != fresh.ID {
+		t.Errorf("Expected Query to return only the customer updated since %s, but got %v (%v)", updated, page, err)
+	}
+	page, err = repository.Query(t.Context(), CustomerQuery{UpdatedSince: created, Sort: []SortField{{Field: "name"}}})
+	if err != nil || page.Total != 2 || page.Customers[0].ID != fresh.ID || page.Customers[1].ID != stale.ID {
+		t.Errorf("Expected Query to return the customers updated since %s, but got %v (%v)", created, page, err)
+	}
+
+	fresh.UpdatedAt = updated.Add(time.Hour)
+	fresh.UpdatedBy = "carol"
+	fresh.Version = 3
+	if err := repository.Replace(t.Context(), fresh, 2); err != nil {
+		t.Fatalf("Expected Replace to return nil error, but got %s", err.Error())
+	}
+	if found, _ := repository.Find(t.Context(), fresh.ID); !found.UpdatedAt.Equal(fresh.UpdatedAt) || found.UpdatedBy != "carol" || found.CreatedBy != "alice" {
+		t.Errorf("Expected Replace to store the audit fields of %v, but got %v", fresh, found)
+	}
+}
+
+func TestJSONCustomerRepository_Audit(t *testing.T) {
+	testCustomerAudit(t, NewMemoryCustomerRepository(nil))
+}
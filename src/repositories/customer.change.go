@@ -0,0 +1,78 @@
+package repositories
+
+import (
+	"errors"
+	"fmt"
+
+	"congdinh.com/crm/models"
+	"github.com/google/uuid"
+)
+
+// ErrChangeNotApplied is reported for the changes of an atomic batch that were
+// rolled back because another change in the batch failed
+var ErrChangeNotApplied = errors.New("not applied because another change in the batch failed")
+
+// ChangeKind is the write a CustomerChange performs
+type ChangeKind string
+
+const (
+	ChangeInsert  ChangeKind = "insert"
+	ChangeReplace ChangeKind = "replace"
+	ChangeRemove  ChangeKind = "remove"
+)
+
+// ErrDeliveriesNotSupported is returned by Apply of the backends that keep
+// webhooks apart from the customers when a change carries deliveries
+var ErrDeliveriesNotSupported = errors.New("webhook deliveries are not stored with the customers")
+
+// CustomerChange is one write of a batch passed to Apply. Insert and Replace
+// use Customer; Remove uses ID. ExpectedVersion has the same meaning as for
+// Replace and Remove. Deliveries are the webhook deliveries the change
+// raises; the backends that also store webhooks add them in the transaction
+// of the change, so they are stored if and only if the change is. History,
+// when set, is the history entry recording the change, which Apply stores
+// with it the same way.
+type CustomerChange struct {
+	Kind            ChangeKind
+	Customer        models.Customer
+	ID              uuid.UUID
+	ExpectedVersion int64
+	Deliveries      []models.WebhookDelivery
+	History         *models.CustomerHistoryEntry
+}
+
+// historyEntries returns the history entries of the changes that did not fail
+func historyEntries(changes []CustomerChange, errs []error) []models.CustomerHistoryEntry {
+	entries := []models.CustomerHistoryEntry{}
+	for i, change := range changes {
+		if errs[i] == nil && change.History != nil {
+			entries = append(entries, *change.History)
+		}
+	}
+	return entries
+}
+
+// validateChanges rejects a batch that contains an unknown kind of change
+func validateChanges(changes []CustomerChange) error {
+	for i, change := range changes {
+		if change.Kind != ChangeInsert && change.Kind != ChangeReplace && change.Kind != ChangeRemove {
+			return fmt.Errorf("change %d: unknown kind %q", i, change.Kind)
+		}
+	}
+	return nil
+}
+
+// isChangeError reports whether err rejects a single change rather than the whole batch
+func isChangeError(err error) bool {
+	return errors.Is(err, ErrCustomerNotFound) || errors.Is(err, ErrCustomerExists) || errors.Is(err, ErrVersionConflict)
+}
+
+// abortRemaining marks every change without an error as not applied
+func abortRemaining(errs []error) []error {
+	for i := range errs {
+		if errs[i] == nil {
+			errs[i] = ErrChangeNotApplied
+		}
+	}
+	return errs
+}
@@ -0,0 +1,118 @@
+package repositories
+
+import (
+	"errors"
+	"testing"
+
+	"congdinh.com/crm/models"
+	"github.com/google/uuid"
+)
+
+// testCustomerApply checks the batch semantics every backend must share
+func testCustomerApply(t *testing.T, repository ICustomerRepository) {
+	t.Helper()
+
+	existing := models.Customer{ID: uuid.New(), Name: "Existing", Email: "existing@domain.com", Phone: "5550003333", Version: 1}
+	doomed := models.Customer{ID: uuid.New(), Name: "Doomed", Email: "doomed@domain.com", Phone: "5550004444", Version: 1}
+	for _, customer := range []models.Customer{existing, doomed} {
+		if err := repository.Insert(t.Context(), customer); err != nil {
+			t.Fatalf("Expected Insert to return nil error, but got %s", err.Error())
+		}
+	}
+
+	created := models.Customer{ID: uuid.New(), Name: "Created", Email: "created@domain.com", Phone: "5550005555", Version: 1}
+	duplicate := models.Customer{ID: uuid.New(), Name: "Duplicate", Email: existing.Email, Phone: "5550006666", Version: 1}
+	updated := existing
+	updated.Contacted = true
+	updated.Version = 2
+
+	errs, err := repository.Apply(t.Context(), []CustomerChange{
+		{Kind: ChangeInsert, Customer: created},
+		{Kind: ChangeInsert, Customer: duplicate},
+		{Kind: ChangeReplace, Customer: models.Customer{ID: uuid.New(), Version: 2}},
+		{Kind: ChangeReplace, Customer: updated, ExpectedVersion: 1},
+		{Kind: ChangeRemove, ID: doomed.ID, ExpectedVersion: 2},
+		{Kind: ChangeRemove, ID: doomed.ID},
+	}, false)
+	if err != nil {
+		t.Fatalf("Expected a best-effort Apply to return nil error, but got %s", err.Error())
+	}
+	expected := []error{nil, ErrCustomerExists, ErrCustomerNotFound, nil, ErrVersionConflict, nil}
+	for i := range expected {
+		if !errors.Is(errs[i], expected[i]) {
+			t.Errorf("Expected change %d to return %v, but got %v", i, expected[i], errs[i])
+		}
+	}
+
+	customers, _ := repository.List(t.Context())
+	if len(customers) != 2 || customers[0] != updated || customers[1] != created {
+		t.Errorf("Expected %v after the best-effort batch, but got %v", []models.Customer{updated, created}, customers)
+	}
+
+	rolledBack := models.Customer{ID: uuid.New(), Name: "Rolled Back", Email: "rolledback@domain.com", Phone: "5550007777", Version: 1}
+	errs, err = repository.Apply(t.Context(), []CustomerChange{
+		{Kind: ChangeInsert, Customer: rolledBack},
+		{Kind: ChangeRemove, ID: created.ID},
+		{Kind: ChangeRemove, ID: uuid.New()},
+		{Kind: ChangeInsert, Customer: models.Customer{ID: uuid.New(), Email: "later@domain.com", Phone: "5550008888"}},
+	}, true)
+	if err != nil {
+		t.Fatalf("Expected a failed atomic Apply to return nil error, but got %s", err.Error())
+	}
+	expected = []error{ErrChangeNotApplied, ErrChangeNotApplied, ErrCustomerNotFound, ErrChangeNotApplied}
+	for i := range expected {
+		if !errors.Is(errs[i], expected[i]) {
+			t.Errorf("Expected atomic change %d to return %v, but got %v", i, expected[i], errs[i])
+		}
+	}
+	if after, _ := repository.List(t.Context()); len(after) != 2 {
+		t.Errorf("Expected a failed atomic batch to store nothing, but got %v", after)
+	}
+
+	errs, err = repository.Apply(t.Context(), []CustomerChange{
+		{Kind: ChangeInsert, Customer: rolledBack},
+		{Kind: ChangeRemove, ID: created.ID, ExpectedVersion: 1},
+	}, true)
+	if err != nil || errs[0] != nil || errs[1] != nil {
+		t.Fatalf("Expected an atomic Apply to succeed, but got %v (%v)", errs, err)
+	}
+	if found, err := repository.Find(t.Context(), rolledBack.ID); err != nil || found != rolledBack {
+		t.Errorf("Expected Find to return %v, but got %v (%v)", rolledBack, found, err)
+	}
+	if _, err := repository.Find(t.Context(), created.ID); !errors.Is(err, ErrCustomerNotFound) {
+		t.Errorf("Expected the removed customer to be gone, but got %v", err)
+	}
+
+	if _, err := repository.Apply(t.Context(), []CustomerChange{{Kind: "upsert"}}, false); err == nil {
+		t.Error("Expected Apply to reject an unknown kind of change")
+	}
+}
+
+func TestJSONCustomerRepository_Apply(t *testing.T) {
+	testCustomerApply(t, NewMemoryCustomerRepository(nil))
+}
+
+func TestJSONCustomerRepository_ApplyPersists(t *testing.T) {
+	store := NewCustomerFileStore(copyDataFile(t), FlushAlways, 0)
+	repository, err := NewJSONCustomerRepository(store)
+	if err != nil {
+		t.Fatalf("Failed to create repository: %s", err.Error())
+	}
+	before, _ := repository.List(t.Context())
+
+	batch := []CustomerChange{
+		{Kind: ChangeInsert, Customer: models.Customer{ID: uuid.New(), Email: "one@domain.com", Phone: "1", Version: 1}},
+		{Kind: ChangeInsert, Customer: models.Customer{ID: uuid.New(), Email: "two@domain.com", Phone: "2", Version: 1}},
+	}
+	if _, err := repository.Apply(t.Context(), batch, true); err != nil {
+		t.Fatalf("Expected Apply to return nil error, but got %s", err.Error())
+	}
+
+	restarted, err := NewJSONCustomerRepository(store)
+	if err != nil {
+		t.Fatalf("Failed to reload repository: %s", err.Error())
+	}
+	if customers, _ := restarted.List(t.Context()); len(customers) != len(before)+2 {
+		t.Errorf("Expected the batch to survive a restart, but got %v", customers)
+	}
+}
@@ -0,0 +1,134 @@
+package repositories
+
+import (
+	"slices"
+	"time"
+
+	"congdinh.com/crm/models"
+	"github.com/google/uuid"
+)
+
+// CustomerEventType names a kind of change in the customer event log
+type CustomerEventType string
+
+const (
+	// CustomerCreated adds a customer
+	CustomerCreated CustomerEventType = "CustomerCreated"
+	// CustomerUpdated changes a customer, including restoring it from the trash
+	CustomerUpdated CustomerEventType = "CustomerUpdated"
+	// CustomerContacted changes only whether a customer was contacted
+	CustomerContacted CustomerEventType = "CustomerContacted"
+	// CustomerDeleted moves a customer to the trash
+	CustomerDeleted CustomerEventType = "CustomerDeleted"
+	// CustomerPurged removes a customer for good
+	CustomerPurged CustomerEventType = "CustomerPurged"
+)
+
+// CustomerEvent is one entry of the customer event log. Every event but
+// CustomerPurged carries the full state the customer is in after it, so a
+// projection can apply any event on its own.
+type CustomerEvent struct {
+	// Sequence numbers the events of a log from 1 without gaps
+	Sequence   int64
+	Type       CustomerEventType
+	Timestamp  time.Time
+	CustomerID uuid.UUID
+	Customer   *models.Customer `json:",omitempty"`
+	// Actor and Operation are who made the change and what they did, taken
+	// from its history entry; they are empty for changes without history
+	Actor     string                  `json:",omitempty"`
+	Operation models.HistoryOperation `json:",omitempty"`
+}
+
+// customerEventType tells which event turns previous into customer
+func customerEventType(previous models.Customer, customer models.Customer) CustomerEventType {
+	switch {
+	case previous.DeletedAt == nil && customer.DeletedAt != nil:
+		return CustomerDeleted
+	case previous.Contacted != customer.Contacted && sameDetails(previous, customer):
+		return CustomerContacted
+	}
+	return CustomerUpdated
+}
+
+// sameDetails reports whether a and b agree on every field a user edits
+// except Contacted
+func sameDetails(a models.Customer, b models.Customer) bool {
+	return a.Name == b.Name && a.Role == b.Role && a.Email == b.Email && a.Phone == b.Phone &&
+		(a.DeletedAt == nil) == (b.DeletedAt == nil)
+}
+
+// sameCustomer reports whether a and b are the same stored state
+func sameCustomer(a models.Customer, b models.Customer) bool {
+	deletedAtEqual := a.DeletedAt == b.DeletedAt || a.DeletedAt != nil && b.DeletedAt != nil && a.DeletedAt.Equal(*b.DeletedAt)
+	a.DeletedAt, b.DeletedAt = nil, nil
+	return deletedAtEqual && a == b
+}
+
+// customerState is the dataset an event log describes, in creation order
+type customerState struct {
+	customers []models.Customer
+	index     map[uuid.UUID]int
+}
+
+func newCustomerState(customers []models.Customer) *customerState {
+	s := &customerState{customers: slices.Clone(customers), index: map[uuid.UUID]int{}}
+	for i, customer := range s.customers {
+		s.index[customer.ID] = i
+	}
+	return s
+}
+
+// apply replays event on the state
+func (s *customerState) apply(event CustomerEvent) {
+	i, exists := s.index[event.CustomerID]
+	switch {
+	case event.Type == CustomerPurged && exists:
+		s.customers = slices.Delete(s.customers, i, i+1)
+		delete(s.index, event.CustomerID)
+		for j := i; j < len(s.customers); j++ {
+			s.index[s.customers[j].ID] = j
+		}
+	case event.Type == CustomerPurged || event.Customer == nil:
+	case exists:
+		s.customers[i] = *event.Customer
+	default:
+		s.index[event.CustomerID] = len(s.customers)
+		s.customers = append(s.customers, *event.Customer)
+	}
+}
+
+// events returns the events recording changes, which were made in order on
+// the state, without their sequence and timestamp. It only looks up the
+// customers the changes touch, and leaves out replaces that change nothing.
+func (s *customerState) events(changes []CustomerChange) []CustomerEvent {
+	events := make([]CustomerEvent, 0, len(changes))
+	// changed holds the customers as earlier changes of the batch left them
+	changed := map[uuid.UUID]models.Customer{}
+	for _, change := range changes {
+		event := CustomerEvent{CustomerID: change.Customer.ID, Customer: &change.Customer}
+		if change.History != nil {
+			event.Actor, event.Operation = change.History.Actor, change.History.Operation
+		}
+		switch change.Kind {
+		case ChangeInsert:
+			event.Type = CustomerCreated
+		case ChangeReplace:
+			previous, ok := changed[change.Customer.ID]
+			if !ok {
+				previous = s.customers[s.index[change.Customer.ID]]
+			}
+			if sameCustomer(previous, change.Customer) {
+				continue
+			}
+			event.Type = customerEventType(previous, change.Customer)
+		case ChangeRemove:
+			event.Type, event.CustomerID, event.Customer = CustomerPurged, change.ID, nil
+		}
+		if event.Customer != nil {
+			changed[event.CustomerID] = *event.Customer
+		}
+		events = append(events, event)
+	}
+	return events
+}
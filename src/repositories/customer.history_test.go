@@ -0,0 +1,210 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"congdinh.com/crm/models"
+	"github.com/google/uuid"
+)
+
+// testCustomerHistory checks how every backend stores and pages the history of a customer
+func testCustomerHistory(t *testing.T, repository ICustomerHistoryRepository) {
+	t.Helper()
+
+	id := uuid.New()
+	created := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	customer := models.Customer{ID: id, Name: "Lead", Role: "Sales", Email: "lead@domain.com", Phone: "5550004001", Version: 1}
+	entries := []models.CustomerHistoryEntry{{
+		CustomerID: id, Version: 1, Operation: models.HistoryCreate, Actor: "alice", Timestamp: created,
+		Changes:  []models.FieldChange{{Field: "Name", After: "Lead"}, {Field: "Contacted", After: false}},
+		Customer: customer,
+	}}
+	for version := int64(2); version <= 4; version++ {
+		customer.Version = version
+		customer.Contacted = version%2 == 0
+		entries = append(entries, models.CustomerHistoryEntry{
+			CustomerID: id, Version: version, Operation: models.HistoryUpdate, Actor: "bob", Timestamp: created.Add(time.Duration(version) * time.Hour),
+			Changes:  []models.FieldChange{{Field: "Contacted", Before: !customer.Contacted, After: customer.Contacted}},
+			Customer: customer,
+		})
+	}
+	if err := repository.AppendHistory(t.Context(), entries[:1]); err != nil {
+		t.Fatalf("Expected AppendHistory to return nil error, but got %s", err.Error())
+	}
+	if err := repository.AppendHistory(t.Context(), entries[1:]); err != nil {
+		t.Fatalf("Expected AppendHistory to return nil error, but got %s", err.Error())
+	}
+	other := models.CustomerHistoryEntry{CustomerID: uuid.New(), Version: 1, Operation: models.HistoryCreate, Timestamp: created, Changes: []models.FieldChange{}}
+	if err := repository.AppendHistory(t.Context(), []models.CustomerHistoryEntry{other}); err != nil {
+		t.Fatalf("Expected AppendHistory to return nil error, but got %s", err.Error())
+	}
+
+	page, err := repository.History(t.Context(), id, 1, 2)
+	if err != nil || page.Total != 4 || len(page.Entries) != 2 || page.Entries[0].Version != 3 || page.Entries[1].Version != 2 {
+		t.Fatalf("Expected History to return versions 3 and 2 of 4, but got %v (%v)", page, err)
+	}
+	entry := page.Entries[1]
+	if entry.Actor != "bob" || !entry.Timestamp.Equal(entries[1].Timestamp) || entry.Customer.Version != 2 || !entry.Customer.Contacted ||
+		len(entry.Changes) != 1 || entry.Changes[0].Field != "Contacted" || entry.Changes[0].Before != false || entry.Changes[0].After != true {
+		t.Errorf("Expected History to return %v, but got %v", entries[1], entry)
+	}
+	if page, _ := repository.History(t.Context(), id, 0, 0); len(page.Entries) != 4 {
+		t.Errorf("Expected History without a limit to return 4 entries, but got %v", page.Entries)
+	}
+	if page, err := repository.History(t.Context(), uuid.New(), 0, 10); err != nil || page.Total != 0 || len(page.Entries) != 0 {
+		t.Errorf("Expected History of an unknown customer to be empty, but got %v (%v)", page, err)
+	}
+
+	asOf, err := repository.HistoryAsOf(t.Context(), id, created.Add(150*time.Minute))
+	if err != nil || asOf.Version != 2 {
+		t.Errorf("Expected HistoryAsOf to return version 2, but got %v (%v)", asOf, err)
+	}
+	if asOf, err := repository.HistoryAsOf(t.Context(), id, created); err != nil || asOf.Version != 1 || asOf.Customer.Name != "Lead" {
+		t.Errorf("Expected HistoryAsOf the creation time to return version 1, but got %v (%v)", asOf, err)
+	}
+	if _, err := repository.HistoryAsOf(t.Context(), id, created.Add(-time.Second)); err != ErrCustomerNotFound {
+		t.Errorf("Expected HistoryAsOf before the creation to return ErrCustomerNotFound, but got %v", err)
+	}
+}
+
+func TestJSONCustomerHistoryRepository_History(t *testing.T) {
+	testCustomerHistory(t, NewMemoryCustomerHistoryRepository())
+}
+
+func TestJSONCustomerHistoryRepository_Reopen(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "customers.history.jsonl")
+	repository, err := NewJSONCustomerHistoryRepository(file)
+	if err != nil {
+		t.Fatalf("Expected NewJSONCustomerHistoryRepository to return nil error, but got %s", err.Error())
+	}
+	testCustomerHistory(t, repository)
+	repository.Close()
+
+	// A crash in the middle of a write leaves a partial last line
+	f, _ := os.OpenFile(file, os.O_WRONLY|os.O_APPEND, 0o644)
+	f.WriteString(`{"CustomerID":`)
+	f.Close()
+
+	repository, err = NewJSONCustomerHistoryRepository(file)
+	if err != nil {
+		t.Fatalf("Expected the reopened history to drop the partial line, but got %s", err.Error())
+	}
+	defer repository.Close()
+	id := uuid.New()
+	repository.AppendHistory(t.Context(), []models.CustomerHistoryEntry{{CustomerID: id, Version: 1, Operation: models.HistoryCreate}})
+
+	reopened, err := NewJSONCustomerHistoryRepository(file)
+	if err != nil {
+		t.Fatalf("Expected NewJSONCustomerHistoryRepository to return nil error, but got %s", err.Error())
+	}
+	defer reopened.Close()
+	if page, _ := reopened.History(t.Context(), id, 0, 0); page.Total != 1 {
+		t.Errorf("Expected the entry appended after the truncation, but got %v", page)
+	}
+}
+
+func TestJSONCustomerHistoryRepository_HistorySince(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "customers.history.jsonl")
+	repository, err := NewJSONCustomerHistoryRepository(file)
+	if err != nil {
+		t.Fatalf("Expected NewJSONCustomerHistoryRepository to return nil error, but got %s", err.Error())
+	}
+	defer repository.Close()
+	first, second := uuid.New(), uuid.New()
+	repository.AppendHistory(t.Context(), []models.CustomerHistoryEntry{{CustomerID: first, Version: 1}, {CustomerID: second, Version: 1}})
+	repository.AppendHistory(t.Context(), []models.CustomerHistoryEntry{{CustomerID: first, Version: 2}})
+
+	entries, err := repository.HistorySince(t.Context(), 1, 0)
+	if err != nil || len(entries) != 2 || entries[0].CustomerID != second || entries[1].CustomerID != first || entries[1].Version != 2 {
+		t.Errorf("Expected the entries after the first in the order they were appended, but got %v (%v)", entries, err)
+	}
+	if entries, _ := repository.HistorySince(t.Context(), 0, 1); len(entries) != 1 || entries[0].CustomerID != first {
+		t.Errorf("Expected HistorySince to honor the limit, but got %v", entries)
+	}
+	if entries, _ := repository.HistorySince(t.Context(), 5, 0); len(entries) != 0 {
+		t.Errorf("Expected no entries past the end, but got %v", entries)
+	}
+
+	// The positions survive a reopen, so a cursor into the log stays valid
+	reopened, err := NewJSONCustomerHistoryRepository(file)
+	if err != nil {
+		t.Fatalf("Expected NewJSONCustomerHistoryRepository to return nil error, but got %s", err.Error())
+	}
+	defer reopened.Close()
+	if length, _ := reopened.HistoryLength(t.Context()); length != 3 {
+		t.Errorf("Expected 3 entries after reopening, but got %d", length)
+	}
+	if entries, _ := reopened.HistorySince(t.Context(), 2, 0); len(entries) != 1 || entries[0].Version != 2 {
+		t.Errorf("Expected the last entry after reopening, but got %v", entries)
+	}
+}
+
+// failingHistoryRepository is a history whose appends fail
+type failingHistoryRepository struct {
+	*JSONCustomerHistoryRepository
+}
+
+func (r failingHistoryRepository) AppendHistory(ctx context.Context, entries []models.CustomerHistoryEntry) error {
+	return errors.New("disk full")
+}
+
+// testCustomerApplyHistory checks that every backend stores the history of a
+// change with the change, and rejects the change when it cannot after
+// breakHistory
+func testCustomerApplyHistory(t *testing.T, repository interface {
+	ICustomerRepository
+	ICustomerHistoryRecorder
+}, breakHistory func()) {
+	t.Helper()
+
+	existing := models.Customer{ID: uuid.New(), Name: "Existing", Email: "taken@domain.com", Phone: "111", Version: 1}
+	repository.Insert(t.Context(), existing)
+	entry := func(customer models.Customer) *models.CustomerHistoryEntry {
+		return &models.CustomerHistoryEntry{CustomerID: customer.ID, Version: customer.Version, Operation: models.HistoryCreate, Timestamp: time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC), Changes: []models.FieldChange{}, Customer: customer}
+	}
+	stored := models.Customer{ID: uuid.New(), Name: "New", Email: "new@domain.com", Phone: "222", Version: 1}
+	duplicate := models.Customer{ID: uuid.New(), Name: "Duplicate", Email: "taken@domain.com", Phone: "333", Version: 1}
+	errs, err := repository.Apply(t.Context(), []CustomerChange{
+		{Kind: ChangeInsert, Customer: stored, History: entry(stored)},
+		{Kind: ChangeInsert, Customer: duplicate, History: entry(duplicate)},
+	}, false)
+	if err != nil || errs[0] != nil || !errors.Is(errs[1], ErrCustomerExists) {
+		t.Fatalf("Expected only the duplicate to fail, but got %v (%v)", errs, err)
+	}
+	if page, _ := repository.CustomerHistory().History(t.Context(), stored.ID, 0, 0); page.Total != 1 {
+		t.Errorf("Expected the entry of the stored change, but got %v", page)
+	}
+	if page, _ := repository.CustomerHistory().History(t.Context(), duplicate.ID, 0, 0); page.Total != 0 {
+		t.Errorf("Expected no entry for the failed change, but got %v", page)
+	}
+
+	breakHistory()
+	rejected := models.Customer{ID: uuid.New(), Name: "Rejected", Email: "rejected@domain.com", Phone: "444", Version: 1}
+	if _, err := repository.Apply(t.Context(), []CustomerChange{{Kind: ChangeInsert, Customer: rejected, History: entry(rejected)}}, true); err == nil {
+		t.Error("Expected Apply to fail when the history cannot be written")
+	}
+	if _, err := repository.Find(t.Context(), rejected.ID); !errors.Is(err, ErrCustomerNotFound) {
+		t.Errorf("Expected the change without history not to be stored, but got %v", err)
+	}
+}
+
+func TestJSONCustomerRepository_ApplyHistory(t *testing.T) {
+	repository := NewMemoryCustomerRepository(nil)
+	testCustomerApplyHistory(t, repository, func() {
+		repository.History = failingHistoryRepository{NewMemoryCustomerHistoryRepository()}
+	})
+}
+
+func TestSQLiteCustomerRepository_ApplyHistory(t *testing.T) {
+	repository := newSQLiteRepository(t, filepath.Join(t.TempDir(), "crm.db"))
+	testCustomerApplyHistory(t, repository, func() {
+		if _, err := repository.db.Exec("DROP TABLE customer_history"); err != nil {
+			t.Fatalf("Failed to drop the history: %s", err.Error())
+		}
+	})
+}
@@ -0,0 +1,227 @@
+package repositories
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"congdinh.com/crm/models"
+	"github.com/google/uuid"
+)
+
+// SortField orders query results by one customer field
+type SortField struct {
+	Field      string
+	Descending bool
+}
+
+// CustomerQuery describes a filtered, sorted page of customers.
+// Backends push it down to their storage where they can.
+type CustomerQuery struct {
+	// Limit caps the number of returned customers; zero means no limit
+	Limit  int
+	Offset int
+	// Sort orders the customers; the insertion order breaks ties and orders
+	// customers when Sort is empty, so the order is total
+	Sort []SortField
+	// After, when set, continues a keyset page: only customers ordered after
+	// a customer with its sort fields and the insertion sequence
+	// AfterSequence are returned, however the customers before it changed
+	// since
+	After         *models.Customer
+	AfterSequence int64
+
+	// Role matches customers with this role, ignoring case
+	Role string
+	// Contacted matches customers with this contacted flag when set
+	Contacted *bool
+	// NamePrefix and EmailPrefix match the start of the field, ignoring case
+	NamePrefix  string
+	EmailPrefix string
+	// UpdatedSince matches customers last changed at or after this time when
+	// set. Customers stored without an update time never match.
+	UpdatedSince time.Time
+	// Deleted selects the customers in the trash instead of the live ones
+	Deleted bool
+}
+
+// CustomerPage is one page of query results
+type CustomerPage struct {
+	Customers []models.Customer
+	// Sequences holds the insertion sequence of each customer, which
+	// increases in the order customers were inserted, for keyset pages
+	Sequences []int64
+	// Total is the number of customers matching the filters, ignoring Limit, Offset and After
+	Total int
+}
+
+// sortColumns maps the sortable field names to their storage columns
+var sortColumns = map[string]string{
+	"name":      "name",
+	"role":      "role",
+	"email":     "email",
+	"phone":     "phone",
+	"contacted": "contacted",
+}
+
+// ParseSort parses a sort expression such as "name,-email". A leading "-"
+// sorts that field in descending order.
+func ParseSort(expression string) ([]SortField, error) {
+	fields := []SortField{}
+	for _, part := range strings.Split(expression, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		field := SortField{Field: strings.ToLower(part)}
+		if strings.HasPrefix(part, "-") {
+			field = SortField{Field: strings.ToLower(part[1:]), Descending: true}
+		}
+		if _, ok := sortColumns[field.Field]; !ok {
+			return nil, fmt.Errorf("cannot sort by %q", field.Field)
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// matches reports whether customer passes the query filters
+func (q CustomerQuery) matches(customer models.Customer) bool {
+	if (customer.DeletedAt != nil) != q.Deleted {
+		return false
+	}
+	if q.Role != "" && !strings.EqualFold(customer.Role, q.Role) {
+		return false
+	}
+	if q.Contacted != nil && customer.Contacted != *q.Contacted {
+		return false
+	}
+	if q.NamePrefix != "" && !strings.HasPrefix(strings.ToLower(customer.Name), strings.ToLower(q.NamePrefix)) {
+		return false
+	}
+	if q.EmailPrefix != "" && !strings.HasPrefix(strings.ToLower(customer.Email), strings.ToLower(q.EmailPrefix)) {
+		return false
+	}
+	if !q.UpdatedSince.IsZero() && (customer.UpdatedAt.IsZero() || customer.UpdatedAt.Before(q.UpdatedSince)) {
+		return false
+	}
+	return true
+}
+
+// SortValue returns the value of the sortable field of customer
+func SortValue(customer models.Customer, field string) any {
+	switch field {
+	case "name":
+		return customer.Name
+	case "role":
+		return customer.Role
+	case "email":
+		return customer.Email
+	case "phone":
+		return customer.Phone
+	case "contacted":
+		return customer.Contacted
+	}
+	return nil
+}
+
+// SetSortValue sets the sortable field of customer to value and reports
+// whether value has the type of the field
+func SetSortValue(customer *models.Customer, field string, value any) bool {
+	text, isText := value.(string)
+	switch field {
+	case "name":
+		customer.Name = text
+	case "role":
+		customer.Role = text
+	case "email":
+		customer.Email = text
+	case "phone":
+		customer.Phone = text
+	case "contacted":
+		contacted, ok := value.(bool)
+		customer.Contacted = contacted
+		return ok
+	default:
+		return false
+	}
+	return isText
+}
+
+func compareField(a models.Customer, b models.Customer, field string) int {
+	switch field {
+	case "name":
+		return strings.Compare(a.Name, b.Name)
+	case "role":
+		return strings.Compare(a.Role, b.Role)
+	case "email":
+		return strings.Compare(a.Email, b.Email)
+	case "phone":
+		return strings.Compare(a.Phone, b.Phone)
+	case "contacted":
+		switch {
+		case a.Contacted == b.Contacted:
+			return 0
+		case !a.Contacted:
+			return -1
+		}
+		return 1
+	}
+	return 0
+}
+
+// sequenced is a customer with its insertion sequence
+type sequenced struct {
+	customer models.Customer
+	sequence int64
+}
+
+// compare orders a before b by the sort fields, then in insertion order
+func (q CustomerQuery) compare(a sequenced, b sequenced) int {
+	for _, field := range q.Sort {
+		c := compareField(a.customer, b.customer, field.Field)
+		if field.Descending {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return cmp.Compare(a.sequence, b.sequence)
+}
+
+// apply filters, sorts and pages customers in memory. sequences holds the
+// insertion sequence of each customer.
+func (q CustomerQuery) apply(customers []models.Customer, sequences map[uuid.UUID]int64) CustomerPage {
+	matched := []sequenced{}
+	for _, customer := range customers {
+		if q.matches(customer) {
+			matched = append(matched, sequenced{customer, sequences[customer.ID]})
+		}
+	}
+	slices.SortFunc(matched, q.compare)
+
+	page := CustomerPage{Customers: []models.Customer{}, Sequences: []int64{}, Total: len(matched)}
+	if q.After != nil {
+		start, found := slices.BinarySearchFunc(matched, sequenced{*q.After, q.AfterSequence}, q.compare)
+		if found {
+			start++
+		}
+		matched = matched[start:]
+	}
+	if q.Offset >= len(matched) {
+		return page
+	}
+	end := len(matched)
+	if q.Limit > 0 && q.Offset+q.Limit < end {
+		end = q.Offset + q.Limit
+	}
+	for _, m := range matched[q.Offset:end] {
+		page.Customers = append(page.Customers, m.customer)
+		page.Sequences = append(page.Sequences, m.sequence)
+	}
+	return page
+}
@@ -0,0 +1,103 @@
+package repositories
+
+import (
+	"reflect"
+	"testing"
+
+	"congdinh.com/crm/models"
+	"github.com/google/uuid"
+)
+
+func queryFixtures() []models.Customer {
+	return []models.Customer{
+		{ID: uuid.New(), Name: "Cong Dinh", Role: "Developer", Email: "cong@domain.com", Phone: "1", Contacted: false},
+		{ID: uuid.New(), Name: "Van Nguyen", Role: "Manager", Email: "van@domain.com", Phone: "2", Contacted: true},
+		{ID: uuid.New(), Name: "An Dinh", Role: "Developer", Email: "an@domain.com", Phone: "3", Contacted: false},
+		{ID: uuid.New(), Name: "Anh_Tran", Role: "developer", Email: "anh@other.com", Phone: "4", Contacted: true},
+	}
+}
+
+func names(customers []models.Customer) []string {
+	result := []string{}
+	for _, customer := range customers {
+		result = append(result, customer.Name)
+	}
+	return result
+}
+
+func TestParseSort(t *testing.T) {
+	fields, err := ParseSort("name, -Email")
+	if err != nil {
+		t.Fatalf("Expected ParseSort to return nil error, but got %s", err.Error())
+	}
+
+	expected := []SortField{{Field: "name"}, {Field: "email", Descending: true}}
+	if !reflect.DeepEqual(fields, expected) {
+		t.Errorf("Expected %v, but got %v", expected, fields)
+	}
+
+	if _, err := ParseSort("password"); err == nil {
+		t.Error("Expected ParseSort to reject an unknown field")
+	}
+}
+
+// testCustomerQuery checks the Query semantics every backend must share, on
+// the queryFixtures inserted in order
+func testCustomerQuery(t *testing.T, repository ICustomerRepository) {
+	t.Helper()
+
+	contacted := true
+	fixtures, err := repository.List(t.Context())
+	if err != nil {
+		t.Fatalf("Expected List to return nil error, but got %s", err.Error())
+	}
+	all, _ := repository.Query(t.Context(), CustomerQuery{})
+	sequence := map[string]int64{}
+	for i, customer := range all.Customers {
+		sequence[customer.Name] = all.Sequences[i]
+		if i > 0 && all.Sequences[i] <= all.Sequences[i-1] {
+			t.Errorf("Expected the sequences to increase in insertion order, but got %v", all.Sequences)
+		}
+	}
+	byName := []SortField{{Field: "name"}}
+	cases := []struct {
+		name     string
+		query    CustomerQuery
+		expected []string
+		total    int
+	}{
+		{"no filters keeps insertion order", CustomerQuery{}, []string{"Cong Dinh", "Van Nguyen", "An Dinh", "Anh_Tran"}, 4},
+		{"role ignores case", CustomerQuery{Role: "DEVELOPER"}, []string{"Cong Dinh", "An Dinh", "Anh_Tran"}, 3},
+		{"contacted", CustomerQuery{Contacted: &contacted}, []string{"Van Nguyen", "Anh_Tran"}, 2},
+		{"name prefix", CustomerQuery{NamePrefix: "an"}, []string{"An Dinh", "Anh_Tran"}, 2},
+		{"prefix wildcards are literal", CustomerQuery{NamePrefix: "Anh_"}, []string{"Anh_Tran"}, 1},
+		{"email prefix", CustomerQuery{EmailPrefix: "V"}, []string{"Van Nguyen"}, 1},
+		{"sort descending then ascending", CustomerQuery{Sort: []SortField{{Field: "role", Descending: true}, {Field: "name"}}}, []string{"Anh_Tran", "Van Nguyen", "An Dinh", "Cong Dinh"}, 4},
+		{"limit and offset", CustomerQuery{Sort: []SortField{{Field: "name"}}, Limit: 2, Offset: 1}, []string{"Anh_Tran", "Cong Dinh"}, 4},
+		{"offset past the end", CustomerQuery{Offset: 10}, []string{}, 4},
+		{"after a customer", CustomerQuery{Sort: byName, After: &fixtures[3], AfterSequence: sequence["Anh_Tran"], Limit: 1}, []string{"Cong Dinh"}, 4},
+		{"after a removed customer", CustomerQuery{Sort: byName, After: &models.Customer{Name: "B"}}, []string{"Cong Dinh", "Van Nguyen"}, 4},
+		{"after with mixed directions", CustomerQuery{Sort: []SortField{{Field: "role", Descending: true}, {Field: "name"}}, After: &fixtures[1], AfterSequence: sequence["Van Nguyen"]}, []string{"An Dinh", "Cong Dinh"}, 4},
+		{"after breaks ties in insertion order", CustomerQuery{Sort: []SortField{{Field: "contacted"}}, After: &fixtures[0], AfterSequence: sequence["Cong Dinh"]}, []string{"An Dinh", "Van Nguyen", "Anh_Tran"}, 4},
+		{"after a customer in insertion order", CustomerQuery{After: &fixtures[1], AfterSequence: sequence["Van Nguyen"]}, []string{"An Dinh", "Anh_Tran"}, 4},
+		{"after the last customer", CustomerQuery{After: &fixtures[3], AfterSequence: sequence["Anh_Tran"]}, []string{}, 4},
+	}
+
+	for _, c := range cases {
+		page, err := repository.Query(t.Context(), c.query)
+		if err != nil {
+			t.Errorf("%s: expected Query to return nil error, but got %s", c.name, err.Error())
+			continue
+		}
+		if !reflect.DeepEqual(names(page.Customers), c.expected) {
+			t.Errorf("%s: expected %v, but got %v", c.name, c.expected, names(page.Customers))
+		}
+		if page.Total != c.total {
+			t.Errorf("%s: expected total %d, but got %d", c.name, c.total, page.Total)
+		}
+	}
+}
+
+func TestJSONCustomerRepository_Query(t *testing.T) {
+	testCustomerQuery(t, NewMemoryCustomerRepository(queryFixtures()))
+}
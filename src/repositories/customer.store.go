@@ -0,0 +1,198 @@
+package repositories
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+
+	"congdinh.com/crm/models"
+)
+
+// FlushPolicy controls when the customer file store writes changes to disk
+type FlushPolicy string
+
+const (
+	// FlushAlways writes the dataset to disk after every mutation
+	FlushAlways FlushPolicy = "always"
+	// FlushDebounce coalesces mutations and writes them once the flush delay has elapsed
+	FlushDebounce FlushPolicy = "debounce"
+)
+
+// ParseFlushPolicy converts a configuration value into a FlushPolicy
+func ParseFlushPolicy(value string) (FlushPolicy, error) {
+	switch FlushPolicy(value) {
+	case FlushAlways, FlushDebounce:
+		return FlushPolicy(value), nil
+	}
+	return "", fmt.Errorf("unknown flush policy %q", value)
+}
+
+// DefaultDataFile returns the path of the bundled data/customers.json file
+func DefaultDataFile() string {
+	_, filename, _, _ := runtime.Caller(0)
+	return path.Join(path.Dir(filename), "../data/customers.json")
+}
+
+// CustomerFileStore persists the customer dataset as a JSON file. Every
+// write replaces the whole file atomically, see writeFileAtomic.
+type CustomerFileStore struct {
+	path   string
+	policy FlushPolicy
+	delay  time.Duration
+
+	mu      sync.Mutex
+	pending []models.Customer
+	dirty   bool
+	timer   *time.Timer
+	lastErr error
+}
+
+// NewCustomerFileStore creates a file store for the given path
+func NewCustomerFileStore(path string, policy FlushPolicy, delay time.Duration) *CustomerFileStore {
+	return &CustomerFileStore{
+		path:   path,
+		policy: policy,
+		delay:  delay,
+	}
+}
+
+// Path returns the file the store reads and writes
+func (s *CustomerFileStore) Path() string {
+	return s.path
+}
+
+// Load reads all customers from the file
+func (s *CustomerFileStore) Load() ([]models.Customer, error) {
+	return ReadCustomersFile(s.path)
+}
+
+// ReadCustomersFile reads a JSON array of customers from path
+func ReadCustomersFile(path string) ([]models.Customer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	var customers []models.Customer
+	if err := json.Unmarshal(data, &customers); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal JSON: %w", err)
+	}
+
+	return customers, nil
+}
+
+// Save records the full dataset. With FlushAlways the data is on disk when
+// Save returns; with FlushDebounce it is written once the flush delay elapses.
+func (s *CustomerFileStore) Save(customers []models.Customer) error {
+	snapshot := make([]models.Customer, len(customers))
+	copy(snapshot, customers)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.policy != FlushDebounce {
+		return s.write(snapshot)
+	}
+
+	s.pending = snapshot
+	s.dirty = true
+	if s.timer == nil {
+		s.timer = time.AfterFunc(s.delay, func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			s.timer = nil
+			s.lastErr = s.flushLocked()
+		})
+	}
+
+	// Surface the error of a failed background flush to the next caller
+	err := s.lastErr
+	s.lastErr = nil
+	return err
+}
+
+// commit saves customers; the file holds no record of the changes
+func (s *CustomerFileStore) commit(customers []models.Customer, changes []CustomerChange) error {
+	return s.Save(customers)
+}
+
+// Flush writes any pending changes to disk immediately
+func (s *CustomerFileStore) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	return s.flushLocked()
+}
+
+// Close flushes pending changes; it should be called on shutdown
+func (s *CustomerFileStore) Close() error {
+	return s.Flush()
+}
+
+func (s *CustomerFileStore) flushLocked() error {
+	if !s.dirty {
+		return nil
+	}
+	if err := s.write(s.pending); err != nil {
+		return err
+	}
+	s.pending = nil
+	s.dirty = false
+	return nil
+}
+
+func (s *CustomerFileStore) write(customers []models.Customer) error {
+	data, err := json.MarshalIndent(customers, "", "    ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal JSON: %w", err)
+	}
+	return writeFileAtomic(s.path, data)
+}
+
+// writeFileAtomic replaces the file at path with data. The data goes to a
+// temporary file in the same directory which is fsynced and then renamed over
+// the target, so readers never observe a partially written file.
+func writeFileAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+"-*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	// Remove the temp file if anything goes wrong before the rename
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write temp file: %w", err)
+	}
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to chmod temp file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync temp file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close temp file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace data file: %w", err)
+	}
+
+	// Sync the directory so the rename itself is durable
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
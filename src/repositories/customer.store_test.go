@@ -0,0 +1,91 @@
+package repositories
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"congdinh.com/crm/models"
+	"github.com/google/uuid"
+)
+
+// copyDataFile copies the bundled customers.json into a temp dir so tests can write to it
+func copyDataFile(t *testing.T) string {
+	t.Helper()
+
+	data, err := os.ReadFile(DefaultDataFile())
+	if err != nil {
+		t.Fatalf("Failed to read data file: %s", err.Error())
+	}
+
+	path := filepath.Join(t.TempDir(), "customers.json")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatalf("Failed to write data file: %s", err.Error())
+	}
+	return path
+}
+
+func TestCustomerFileStore_SaveAlways(t *testing.T) {
+	path := copyDataFile(t)
+	store := NewCustomerFileStore(path, FlushAlways, 0)
+
+	customers := []models.Customer{{ID: uuid.New(), Name: "Saved Customer"}}
+	if err := store.Save(customers); err != nil {
+		t.Fatalf("Expected Save to return nil error, but got %s", err.Error())
+	}
+
+	loaded, err := store.Load()
+	if err != nil {
+		t.Fatalf("Expected Load to return nil error, but got %s", err.Error())
+	}
+	if len(loaded) != 1 || loaded[0] != customers[0] {
+		t.Errorf("Expected %v to be loaded, but got %v", customers, loaded)
+	}
+
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	if len(entries) != 1 {
+		t.Errorf("Expected only the data file to remain, but got %d entries", len(entries))
+	}
+}
+
+func TestCustomerFileStore_SaveDebounce(t *testing.T) {
+	path := copyDataFile(t)
+	store := NewCustomerFileStore(path, FlushDebounce, time.Hour)
+
+	if err := store.Save([]models.Customer{{ID: uuid.New(), Name: "Pending"}}); err != nil {
+		t.Fatalf("Expected Save to return nil error, but got %s", err.Error())
+	}
+
+	loaded, _ := store.Load()
+	if len(loaded) != 5 {
+		t.Errorf("Expected the file to be untouched before the flush, but got %d customers", len(loaded))
+	}
+
+	if err := store.Flush(); err != nil {
+		t.Fatalf("Expected Flush to return nil error, but got %s", err.Error())
+	}
+
+	loaded, _ = store.Load()
+	if len(loaded) != 1 || loaded[0].Name != "Pending" {
+		t.Errorf("Expected the pending customer after Flush, but got %v", loaded)
+	}
+}
+
+func TestCustomerFileStore_DebounceFlushesAfterDelay(t *testing.T) {
+	path := copyDataFile(t)
+	store := NewCustomerFileStore(path, FlushDebounce, 10*time.Millisecond)
+
+	store.Save([]models.Customer{{ID: uuid.New(), Name: "First"}})
+	store.Save([]models.Customer{{ID: uuid.New(), Name: "Second"}})
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		loaded, _ := store.Load()
+		if len(loaded) == 1 && loaded[0].Name == "Second" {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Error("Expected the latest snapshot to be flushed after the delay")
+}
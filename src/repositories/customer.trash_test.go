@@ -0,0 +1,68 @@
+package repositories
+
+import (
+	"testing"
+	"time"
+
+	"congdinh.com/crm/models"
+	"github.com/google/uuid"
+)
+
+// testCustomerTrash checks how every backend stores deleted customers
+func testCustomerTrash(t *testing.T, repository ICustomerRepository) {
+	t.Helper()
+
+	now := time.Date(2026, 3, 1, 12, 30, 15, 123456000, time.UTC)
+	weekAgo := now.Add(-7 * 24 * time.Hour)
+	live := models.Customer{ID: uuid.New(), Name: "Live", Email: "live@domain.com", Phone: "5550001001", Version: 1}
+	recent := models.Customer{ID: uuid.New(), Name: "Recent", Email: "recent@domain.com", Phone: "5550001002", Version: 2, DeletedAt: &now}
+	old := models.Customer{ID: uuid.New(), Name: "Old", Email: "old@domain.com", Phone: "5550001003", Version: 2, DeletedAt: &weekAgo}
+	for _, customer := range []models.Customer{live, recent, old} {
+		if err := repository.Insert(t.Context(), customer); err != nil {
+			t.Fatalf("Expected Insert to return nil error, but got %s", err.Error())
+		}
+	}
+
+	page, err := repository.Query(t.Context(), CustomerQuery{})
+	if err != nil || page.Total != 1 || page.Customers[0].ID != live.ID {
+		t.Errorf("Expected Query to return only the live customer, but got %v (%v)", page, err)
+	}
+	page, err = repository.Query(t.Context(), CustomerQuery{Deleted: true, Sort: []SortField{{Field: "name"}}})
+	if err != nil || page.Total != 2 || page.Customers[0].ID != old.ID {
+		t.Fatalf("Expected Query to return the deleted customers, but got %v (%v)", page, err)
+	}
+	if deletedAt := page.Customers[1].DeletedAt; deletedAt == nil || !deletedAt.Equal(now) {
+		t.Errorf("Expected DeletedAt %s, but got %v", now, deletedAt)
+	}
+
+	// Deleted customers keep their email and phone until they are purged
+	if exists, _ := repository.ExistsByEmail(t.Context(), recent.Email); !exists {
+		t.Error("Expected a deleted customer's email to stay taken")
+	}
+
+	restored := recent
+	restored.DeletedAt = nil
+	restored.Version = 3
+	if err := repository.Replace(t.Context(), restored, 2); err != nil {
+		t.Fatalf("Expected Replace to return nil error, but got %s", err.Error())
+	}
+	if found, _ := repository.Find(t.Context(), recent.ID); found.DeletedAt != nil {
+		t.Errorf("Expected the restored customer to have no DeletedAt, but got %v", found.DeletedAt)
+	}
+
+	purged, err := repository.Purge(t.Context(), now.Add(-24*time.Hour))
+	if err != nil || purged != 1 {
+		t.Errorf("Expected Purge to remove 1 customer, but got %d (%v)", purged, err)
+	}
+	customers, _ := repository.List(t.Context())
+	if len(customers) != 2 {
+		t.Errorf("Expected 2 customers after the purge, but got %v", customers)
+	}
+	if purged, _ := repository.Purge(t.Context(), now); purged != 0 {
+		t.Errorf("Expected nothing left to purge, but got %d", purged)
+	}
+}
+
+func TestJSONCustomerRepository_Trash(t *testing.T) {
+	testCustomerTrash(t, NewMemoryCustomerRepository(nil))
+}
@@ -0,0 +1,63 @@
+package repositories
+
+import (
+	"errors"
+	"testing"
+
+	"congdinh.com/crm/models"
+	"github.com/google/uuid"
+)
+
+// testCustomerUniqueness checks that every backend keeps emails and phones
+// unique across inserts, replaces and batches
+func testCustomerUniqueness(t *testing.T, repository ICustomerRepository) {
+	t.Helper()
+
+	existing := models.Customer{ID: uuid.New(), Name: "Existing", Email: "taken@domain.com", Phone: "5550001111", Version: 1}
+	other := models.Customer{ID: uuid.New(), Name: "Other", Email: "other@domain.com", Phone: "5550002222", Version: 1}
+	for _, customer := range []models.Customer{existing, other} {
+		if err := repository.Insert(t.Context(), customer); err != nil {
+			t.Fatalf("Expected Insert to return nil error, but got %s", err.Error())
+		}
+	}
+
+	duplicates := []models.Customer{
+		{ID: uuid.New(), Name: "Same Email", Email: existing.Email, Phone: "5550003333", Version: 1},
+		{ID: uuid.New(), Name: "Same Phone", Email: "new@domain.com", Phone: existing.Phone, Version: 1},
+	}
+	for _, duplicate := range duplicates {
+		if err := repository.Insert(t.Context(), duplicate); !errors.Is(err, ErrCustomerExists) {
+			t.Errorf("Expected Insert of %s to return ErrCustomerExists, but got %v", duplicate.Name, err)
+		}
+	}
+
+	takenEmail, takenPhone := other, other
+	takenEmail.Email, takenEmail.Version = existing.Email, 2
+	takenPhone.Phone, takenPhone.Version = existing.Phone, 2
+	for _, customer := range []models.Customer{takenEmail, takenPhone} {
+		if err := repository.Replace(t.Context(), customer, 1); !errors.Is(err, ErrCustomerExists) {
+			t.Errorf("Expected Replace onto a taken email or phone to return ErrCustomerExists, but got %v", err)
+		}
+	}
+	errs, err := repository.Apply(t.Context(), []CustomerChange{
+		{Kind: ChangeReplace, Customer: takenEmail, ExpectedVersion: 1},
+		{Kind: ChangeReplace, Customer: takenPhone, ExpectedVersion: 1},
+	}, false)
+	if err != nil || !errors.Is(errs[0], ErrCustomerExists) || !errors.Is(errs[1], ErrCustomerExists) {
+		t.Errorf("Expected batch replaces onto a taken email or phone to return ErrCustomerExists, but got %v (%v)", errs, err)
+	}
+	if found, _ := repository.Find(t.Context(), other.ID); found != other {
+		t.Errorf("Expected the refused replaces to leave %v, but got %v", other, found)
+	}
+
+	// A customer keeps its own email and phone
+	contacted := other
+	contacted.Contacted, contacted.Version = true, 2
+	if err := repository.Replace(t.Context(), contacted, 1); err != nil {
+		t.Errorf("Expected Replace keeping the email and phone to return nil error, but got %s", err.Error())
+	}
+}
+
+func TestJSONCustomerRepository_Uniqueness(t *testing.T) {
+	testCustomerUniqueness(t, NewMemoryCustomerRepository(nil))
+}
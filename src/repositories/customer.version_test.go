@@ -0,0 +1,67 @@
+package repositories
+
+import (
+	"errors"
+	"testing"
+
+	"congdinh.com/crm/models"
+	"github.com/google/uuid"
+)
+
+// testCustomerVersions checks the optimistic concurrency rules every backend must share
+func testCustomerVersions(t *testing.T, repository ICustomerRepository) {
+	t.Helper()
+
+	customer := models.Customer{ID: uuid.New(), Name: "Versioned", Email: "versioned@domain.com", Phone: "5550002222", Version: 1}
+	if err := repository.Insert(t.Context(), customer); err != nil {
+		t.Fatalf("Expected Insert to return nil error, but got %s", err.Error())
+	}
+
+	updated := customer
+	updated.Contacted = true
+	updated.Version = 2
+	if err := repository.Replace(t.Context(), updated, 1); err != nil {
+		t.Fatalf("Expected Replace at the current version to return nil error, but got %s", err.Error())
+	}
+	if found, _ := repository.Find(t.Context(), customer.ID); found != updated {
+		t.Errorf("Expected Find to return %v, but got %v", updated, found)
+	}
+
+	stale := customer
+	stale.Name = "Stale Write"
+	stale.Version = 2
+	if err := repository.Replace(t.Context(), stale, 1); !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("Expected Replace at a stale version to return ErrVersionConflict, but got %v", err)
+	}
+	if err := repository.Remove(t.Context(), customer.ID, 1); !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("Expected Remove at a stale version to return ErrVersionConflict, but got %v", err)
+	}
+	if found, _ := repository.Find(t.Context(), customer.ID); found != updated {
+		t.Errorf("Expected stale writes to leave %v, but got %v", updated, found)
+	}
+
+	missing := models.Customer{ID: uuid.New(), Version: 2}
+	if err := repository.Replace(t.Context(), missing, 1); !errors.Is(err, ErrCustomerNotFound) {
+		t.Errorf("Expected Replace of a missing customer to return ErrCustomerNotFound, but got %v", err)
+	}
+	if err := repository.Remove(t.Context(), missing.ID, 1); !errors.Is(err, ErrCustomerNotFound) {
+		t.Errorf("Expected Remove of a missing customer to return ErrCustomerNotFound, but got %v", err)
+	}
+
+	if err := repository.Remove(t.Context(), customer.ID, 2); err != nil {
+		t.Errorf("Expected Remove at the current version to return nil error, but got %s", err.Error())
+	}
+}
+
+func TestJSONCustomerRepository_Versions(t *testing.T) {
+	testCustomerVersions(t, NewMemoryCustomerRepository(nil))
+}
+
+func TestJSONCustomerRepository_InitialVersion(t *testing.T) {
+	repository := NewMemoryCustomerRepository([]models.Customer{{ID: uuid.New(), Name: "Legacy"}})
+
+	customers, _ := repository.List(t.Context())
+	if customers[0].Version != 1 {
+		t.Errorf("Expected customers stored without a version to start at 1, but got %d", customers[0].Version)
+	}
+}
@@ -0,0 +1,89 @@
+package repositories
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// jsonLinesFile is an append-only file holding one JSON value per line. A
+// crash can at worst leave a partial last line, which is dropped when the
+// file is opened again.
+type jsonLinesFile struct {
+	file *os.File
+}
+
+// openJSONLines opens (or creates) the file at path and passes each complete
+// line to read, numbered from 1
+func openJSONLines(path string, read func(line int, data []byte) error) (*jsonLinesFile, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	complete, err := readJSONLines(file, read)
+	if err == nil {
+		err = file.Truncate(complete)
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &jsonLinesFile{file: file}, nil
+}
+
+// readJSONLines passes each complete line of file to read. It returns the
+// size of the complete lines, which leaves out a partial last line.
+func readJSONLines(file *os.File, read func(line int, data []byte) error) (int64, error) {
+	reader := bufio.NewReader(file)
+	var complete int64
+	for line := 1; ; line++ {
+		data, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			return complete, nil
+		}
+		if err != nil {
+			return complete, fmt.Errorf("failed to read %s: %w", file.Name(), err)
+		}
+		complete += int64(len(data))
+
+		if len(bytes.TrimSpace(data)) == 0 {
+			continue
+		}
+		if err := read(line, data); err != nil {
+			return complete, fmt.Errorf("failed to parse %s line %d: %w", file.Name(), line, err)
+		}
+	}
+}
+
+// append writes values in a single write and syncs the file
+func (f *jsonLinesFile) append(values ...any) error {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, value := range values {
+		if err := encoder.Encode(value); err != nil {
+			return fmt.Errorf("failed to marshal JSON: %w", err)
+		}
+	}
+
+	info, err := f.file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %w", f.file.Name(), err)
+	}
+	if _, err := f.file.Write(buf.Bytes()); err != nil {
+		// Drop a partial write so the next values start on a line of their own
+		f.file.Truncate(info.Size())
+		return fmt.Errorf("failed to write %s: %w", f.file.Name(), err)
+	}
+	if err := f.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync %s: %w", f.file.Name(), err)
+	}
+	return nil
+}
+
+func (f *jsonLinesFile) Close() error {
+	return f.file.Close()
+}
@@ -0,0 +1,231 @@
+package repositories
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"slices"
+	"sync"
+	"time"
+
+	"congdinh.com/crm/models"
+	"github.com/google/uuid"
+)
+
+// DefaultWebhookFile returns the path of data/webhooks.json next to the bundled JSON data
+func DefaultWebhookFile() string {
+	return path.Join(path.Dir(DefaultDataFile()), "webhooks.json")
+}
+
+// webhookData is the content of the webhook file
+type webhookData struct {
+	Webhooks   []models.Webhook
+	Deliveries []models.WebhookDelivery
+	// Cursor is how many history entries the deliveries were derived from;
+	// files written before it was kept have none
+	Cursor *int64 `json:",omitempty"`
+}
+
+// JSONWebhookRepository keeps webhooks and deliveries in memory and, when it
+// has a file, rewrites the file atomically after every change
+type JSONWebhookRepository struct {
+	file string
+
+	mu sync.RWMutex
+	// data holds the webhooks and deliveries in creation order
+	data webhookData
+}
+
+// NewJSONWebhookRepository opens the webhook file at file, which is created
+// on the first change
+func NewJSONWebhookRepository(file string) (*JSONWebhookRepository, error) {
+	r := NewMemoryWebhookRepository()
+	r.file = file
+
+	data, err := os.ReadFile(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read webhooks: %w", err)
+	}
+	r.data.Cursor = nil
+	if err := json.Unmarshal(data, &r.data); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal webhooks: %w", err)
+	}
+	return r, nil
+}
+
+// NewMemoryWebhookRepository creates a webhook repository that only lives in memory
+func NewMemoryWebhookRepository() *JSONWebhookRepository {
+	var cursor int64
+	return &JSONWebhookRepository{data: webhookData{Webhooks: []models.Webhook{}, Deliveries: []models.WebhookDelivery{}, Cursor: &cursor}}
+}
+
+// update stores the data change returns, writing it to the file first, so
+// memory and file stay the same when the write fails
+func (r *JSONWebhookRepository) update(change func(data webhookData) (webhookData, error)) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	data, err := change(webhookData{Webhooks: slices.Clone(r.data.Webhooks), Deliveries: slices.Clone(r.data.Deliveries), Cursor: r.data.Cursor})
+	if err != nil {
+		return err
+	}
+	if r.file != "" {
+		content, err := json.Marshal(data)
+		if err != nil {
+			return fmt.Errorf("failed to marshal JSON: %w", err)
+		}
+		if err := writeFileAtomic(r.file, content); err != nil {
+			return err
+		}
+	}
+	r.data = data
+	return nil
+}
+
+// ListWebhooks returns the webhooks in creation order
+func (r *JSONWebhookRepository) ListWebhooks(ctx context.Context) ([]models.Webhook, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return slices.Clone(r.data.Webhooks), nil
+}
+
+// FindWebhook returns the webhook with the given ID
+func (r *JSONWebhookRepository) FindWebhook(ctx context.Context, id uuid.UUID) (models.Webhook, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	i := slices.IndexFunc(r.data.Webhooks, func(webhook models.Webhook) bool { return webhook.ID == id })
+	if i < 0 {
+		return models.Webhook{}, ErrWebhookNotFound
+	}
+	return r.data.Webhooks[i], nil
+}
+
+// InsertWebhook adds a webhook
+func (r *JSONWebhookRepository) InsertWebhook(ctx context.Context, webhook models.Webhook) error {
+	return r.update(func(data webhookData) (webhookData, error) {
+		data.Webhooks = append(data.Webhooks, webhook)
+		return data, nil
+	})
+}
+
+// ReplaceWebhook replaces the webhook with the ID of webhook
+func (r *JSONWebhookRepository) ReplaceWebhook(ctx context.Context, webhook models.Webhook) error {
+	return r.update(func(data webhookData) (webhookData, error) {
+		i := slices.IndexFunc(data.Webhooks, func(w models.Webhook) bool { return w.ID == webhook.ID })
+		if i < 0 {
+			return data, ErrWebhookNotFound
+		}
+		data.Webhooks[i] = webhook
+		return data, nil
+	})
+}
+
+// RemoveWebhook removes a webhook and its deliveries
+func (r *JSONWebhookRepository) RemoveWebhook(ctx context.Context, id uuid.UUID) error {
+	return r.update(func(data webhookData) (webhookData, error) {
+		i := slices.IndexFunc(data.Webhooks, func(w models.Webhook) bool { return w.ID == id })
+		if i < 0 {
+			return data, ErrWebhookNotFound
+		}
+		data.Webhooks = slices.Delete(data.Webhooks, i, i+1)
+		data.Deliveries = slices.DeleteFunc(data.Deliveries, func(delivery models.WebhookDelivery) bool { return delivery.WebhookID == id })
+		return data, nil
+	})
+}
+
+// InsertDeliveries adds deliveries to the outbox in one write
+func (r *JSONWebhookRepository) InsertDeliveries(ctx context.Context, deliveries []models.WebhookDelivery) error {
+	return r.update(func(data webhookData) (webhookData, error) {
+		data.Deliveries = append(data.Deliveries, deliveries...)
+		return data, nil
+	})
+}
+
+// RelayCursor returns how many history entries the deliveries were derived
+// from, and false for a file written before the cursor was kept
+func (r *JSONWebhookRepository) RelayCursor(ctx context.Context) (int64, bool, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.data.Cursor == nil {
+		return 0, false, nil
+	}
+	return *r.data.Cursor, true, nil
+}
+
+// RelayDeliveries adds deliveries to the outbox and moves the cursor in one write
+func (r *JSONWebhookRepository) RelayDeliveries(ctx context.Context, deliveries []models.WebhookDelivery, cursor int64) error {
+	return r.update(func(data webhookData) (webhookData, error) {
+		data.Deliveries = append(data.Deliveries, deliveries...)
+		data.Cursor = &cursor
+		return data, nil
+	})
+}
+
+// FindDelivery returns the delivery with the given ID
+func (r *JSONWebhookRepository) FindDelivery(ctx context.Context, id uuid.UUID) (models.WebhookDelivery, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	i := slices.IndexFunc(r.data.Deliveries, func(delivery models.WebhookDelivery) bool { return delivery.ID == id })
+	if i < 0 {
+		return models.WebhookDelivery{}, ErrDeliveryNotFound
+	}
+	return r.data.Deliveries[i], nil
+}
+
+// ReplaceDelivery replaces the delivery with the ID of delivery
+func (r *JSONWebhookRepository) ReplaceDelivery(ctx context.Context, delivery models.WebhookDelivery) error {
+	return r.update(func(data webhookData) (webhookData, error) {
+		i := slices.IndexFunc(data.Deliveries, func(d models.WebhookDelivery) bool { return d.ID == delivery.ID })
+		if i < 0 {
+			return data, ErrDeliveryNotFound
+		}
+		data.Deliveries[i] = delivery
+		return data, nil
+	})
+}
+
+// DueDeliveries returns up to limit pending deliveries due at now, the most overdue first
+func (r *JSONWebhookRepository) DueDeliveries(ctx context.Context, now time.Time, limit int) ([]models.WebhookDelivery, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	due := []models.WebhookDelivery{}
+	for _, delivery := range r.data.Deliveries {
+		if delivery.Status == models.DeliveryPending && !delivery.NextAttemptAt.After(now) {
+			due = append(due, delivery)
+		}
+	}
+	slices.SortStableFunc(due, func(a, b models.WebhookDelivery) int { return a.NextAttemptAt.Compare(b.NextAttemptAt) })
+	if limit > 0 && len(due) > limit {
+		due = due[:limit]
+	}
+	return due, nil
+}
+
+// Deliveries returns the deliveries query selects, newest first
+func (r *JSONWebhookRepository) Deliveries(ctx context.Context, query WebhookDeliveryQuery) ([]models.WebhookDelivery, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	deliveries := []models.WebhookDelivery{}
+	for _, delivery := range slices.Backward(r.data.Deliveries) {
+		if query.Limit > 0 && len(deliveries) == query.Limit {
+			break
+		}
+		if (query.WebhookID == uuid.Nil || delivery.WebhookID == query.WebhookID) && (query.Status == "" || delivery.Status == query.Status) {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	return deliveries, nil
+}
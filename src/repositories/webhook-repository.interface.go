@@ -0,0 +1,55 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"congdinh.com/crm/models"
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrWebhookNotFound is returned when no webhook has the requested ID
+	ErrWebhookNotFound = errors.New("webhook not found")
+	// ErrDeliveryNotFound is returned when no webhook delivery has the requested ID
+	ErrDeliveryNotFound = errors.New("webhook delivery not found")
+)
+
+// WebhookDeliveryQuery selects webhook deliveries. The zero WebhookID and an
+// empty Status match every delivery; a Limit of 0 means no limit.
+type WebhookDeliveryQuery struct {
+	WebhookID uuid.UUID
+	Status    models.DeliveryStatus
+	Limit     int
+}
+
+// IWebhookRepository stores webhooks and the outbox of their deliveries.
+//
+// ListWebhooks returns the webhooks in creation order. RemoveWebhook also
+// removes the deliveries of the webhook. DueDeliveries returns the pending
+// deliveries whose next attempt is at or before now, the most overdue first;
+// Deliveries returns the deliveries a query selects, newest first.
+type IWebhookRepository interface {
+	ListWebhooks(ctx context.Context) ([]models.Webhook, error)
+	FindWebhook(ctx context.Context, id uuid.UUID) (models.Webhook, error)
+	InsertWebhook(ctx context.Context, webhook models.Webhook) error
+	ReplaceWebhook(ctx context.Context, webhook models.Webhook) error
+	RemoveWebhook(ctx context.Context, id uuid.UUID) error
+	InsertDeliveries(ctx context.Context, deliveries []models.WebhookDelivery) error
+	FindDelivery(ctx context.Context, id uuid.UUID) (models.WebhookDelivery, error)
+	ReplaceDelivery(ctx context.Context, delivery models.WebhookDelivery) error
+	DueDeliveries(ctx context.Context, now time.Time, limit int) ([]models.WebhookDelivery, error)
+	Deliveries(ctx context.Context, query WebhookDeliveryQuery) ([]models.WebhookDelivery, error)
+}
+
+// IWebhookRelayRepository is a webhook repository that also keeps how many
+// entries of a customer history log its deliveries were derived from, as the
+// outbox of the file backends. RelayCursor returns false when no cursor was
+// stored yet; RelayDeliveries adds deliveries and moves the cursor in one
+// write, so every entry is relayed exactly once.
+type IWebhookRelayRepository interface {
+	IWebhookRepository
+	RelayCursor(ctx context.Context) (int64, bool, error)
+	RelayDeliveries(ctx context.Context, deliveries []models.WebhookDelivery, cursor int64) error
+}
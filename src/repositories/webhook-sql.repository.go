@@ -0,0 +1,249 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"congdinh.com/crm/models"
+	"github.com/google/uuid"
+)
+
+const (
+	webhookColumns  = "id, url, secret, events, active, created_at, updated_at"
+	deliveryColumns = "id, webhook_id, event, payload, status, created_at, next_attempt_at, attempts"
+)
+
+// ListWebhooks returns the webhooks in creation order
+func (r *SQLCustomerRepository) ListWebhooks(ctx context.Context) ([]models.Webhook, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT "+webhookColumns+" FROM webhooks ORDER BY "+r.dialect.insertionOrder)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := []models.Webhook{}
+	for rows.Next() {
+		webhook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	return webhooks, rows.Err()
+}
+
+// FindWebhook returns the webhook with the given ID
+func (r *SQLCustomerRepository) FindWebhook(ctx context.Context, id uuid.UUID) (models.Webhook, error) {
+	webhook, err := scanWebhook(r.db.QueryRowContext(ctx, r.dialect.rebind("SELECT "+webhookColumns+" FROM webhooks WHERE id = ?"), id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.Webhook{}, ErrWebhookNotFound
+	}
+	return webhook, err
+}
+
+// InsertWebhook adds a webhook. The events are stored as JSON.
+func (r *SQLCustomerRepository) InsertWebhook(ctx context.Context, webhook models.Webhook) error {
+	events, err := json.Marshal(webhook.Events)
+	if err != nil {
+		return fmt.Errorf("failed to marshal JSON: %w", err)
+	}
+	_, err = r.db.ExecContext(ctx, r.dialect.rebind("INSERT INTO webhooks ("+webhookColumns+") VALUES (?, ?, ?, ?, ?, ?, ?)"),
+		webhook.ID, webhook.URL, webhook.Secret, string(events), webhook.Active, webhook.CreatedAt.UTC(), webhook.UpdatedAt.UTC())
+	return err
+}
+
+// ReplaceWebhook replaces the webhook with the ID of webhook
+func (r *SQLCustomerRepository) ReplaceWebhook(ctx context.Context, webhook models.Webhook) error {
+	events, err := json.Marshal(webhook.Events)
+	if err != nil {
+		return fmt.Errorf("failed to marshal JSON: %w", err)
+	}
+	result, err := r.db.ExecContext(ctx, r.dialect.rebind("UPDATE webhooks SET url = ?, secret = ?, events = ?, active = ?, created_at = ?, updated_at = ? WHERE id = ?"),
+		webhook.URL, webhook.Secret, string(events), webhook.Active, webhook.CreatedAt.UTC(), webhook.UpdatedAt.UTC(), webhook.ID)
+	return expectAffected(result, err, ErrWebhookNotFound)
+}
+
+// RemoveWebhook removes a webhook and its deliveries in one transaction
+func (r *SQLCustomerRepository) RemoveWebhook(ctx context.Context, id uuid.UUID) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, r.dialect.rebind("DELETE FROM webhook_deliveries WHERE webhook_id = ?"), id); err != nil {
+		return err
+	}
+	result, err := tx.ExecContext(ctx, r.dialect.rebind("DELETE FROM webhooks WHERE id = ?"), id)
+	if err := expectAffected(result, err, ErrWebhookNotFound); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// InsertDeliveries adds deliveries to the outbox in one transaction
+func (r *SQLCustomerRepository) InsertDeliveries(ctx context.Context, deliveries []models.WebhookDelivery) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := r.insertDeliveries(ctx, tx, deliveries); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// insertDeliveries adds deliveries within tx, which Apply shares with the
+// customer changes raising them. The payload and the attempts are stored as
+// JSON.
+func (r *SQLCustomerRepository) insertDeliveries(ctx context.Context, tx *sql.Tx, deliveries []models.WebhookDelivery) error {
+	if len(deliveries) == 0 {
+		return nil
+	}
+	stmt, err := tx.PrepareContext(ctx, r.dialect.rebind("INSERT INTO webhook_deliveries ("+deliveryColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?)"))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, delivery := range deliveries {
+		attempts, err := json.Marshal(delivery.Attempts)
+		if err != nil {
+			return fmt.Errorf("failed to marshal JSON: %w", err)
+		}
+		if _, err := stmt.ExecContext(ctx, delivery.ID, delivery.WebhookID, delivery.Event, string(delivery.Payload), string(delivery.Status),
+			delivery.CreatedAt.UTC(), delivery.NextAttemptAt.UTC(), string(attempts)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FindDelivery returns the delivery with the given ID
+func (r *SQLCustomerRepository) FindDelivery(ctx context.Context, id uuid.UUID) (models.WebhookDelivery, error) {
+	delivery, err := scanDelivery(r.db.QueryRowContext(ctx, r.dialect.rebind("SELECT "+deliveryColumns+" FROM webhook_deliveries WHERE id = ?"), id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.WebhookDelivery{}, ErrDeliveryNotFound
+	}
+	return delivery, err
+}
+
+// ReplaceDelivery stores the status, next attempt and attempts of delivery
+func (r *SQLCustomerRepository) ReplaceDelivery(ctx context.Context, delivery models.WebhookDelivery) error {
+	attempts, err := json.Marshal(delivery.Attempts)
+	if err != nil {
+		return fmt.Errorf("failed to marshal JSON: %w", err)
+	}
+	result, err := r.db.ExecContext(ctx, r.dialect.rebind("UPDATE webhook_deliveries SET status = ?, next_attempt_at = ?, attempts = ? WHERE id = ?"),
+		string(delivery.Status), delivery.NextAttemptAt.UTC(), string(attempts), delivery.ID)
+	return expectAffected(result, err, ErrDeliveryNotFound)
+}
+
+// DueDeliveries returns up to limit pending deliveries due at now, the most overdue first
+func (r *SQLCustomerRepository) DueDeliveries(ctx context.Context, now time.Time, limit int) ([]models.WebhookDelivery, error) {
+	query := "SELECT " + deliveryColumns + " FROM webhook_deliveries WHERE status = ? AND next_attempt_at <= ? ORDER BY next_attempt_at, " + r.dialect.insertionOrder
+	args := []any{string(models.DeliveryPending), now.UTC()}
+	if limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, limit)
+	}
+	return r.queryDeliveries(ctx, query, args...)
+}
+
+// Deliveries returns the deliveries query selects, newest first
+func (r *SQLCustomerRepository) Deliveries(ctx context.Context, query WebhookDeliveryQuery) ([]models.WebhookDelivery, error) {
+	conditions := []string{}
+	args := []any{}
+	if query.WebhookID != uuid.Nil {
+		conditions = append(conditions, "webhook_id = ?")
+		args = append(args, query.WebhookID)
+	}
+	if query.Status != "" {
+		conditions = append(conditions, "status = ?")
+		args = append(args, string(query.Status))
+	}
+
+	selectQuery := "SELECT " + deliveryColumns + " FROM webhook_deliveries"
+	if len(conditions) > 0 {
+		selectQuery += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	selectQuery += " ORDER BY " + r.dialect.insertionOrder + " DESC"
+	if query.Limit > 0 {
+		selectQuery += " LIMIT ?"
+		args = append(args, query.Limit)
+	}
+	return r.queryDeliveries(ctx, selectQuery, args...)
+}
+
+func (r *SQLCustomerRepository) queryDeliveries(ctx context.Context, query string, args ...any) ([]models.WebhookDelivery, error) {
+	rows, err := r.db.QueryContext(ctx, r.dialect.rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []models.WebhookDelivery{}
+	for rows.Next() {
+		delivery, err := scanDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, rows.Err()
+}
+
+// expectAffected turns an update of no row into notFound
+func expectAffected(result sql.Result, err error, notFound error) error {
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return notFound
+	}
+	return nil
+}
+
+func scanWebhook(row rowScanner) (models.Webhook, error) {
+	var (
+		webhook models.Webhook
+		events  string
+	)
+	if err := row.Scan(&webhook.ID, &webhook.URL, &webhook.Secret, &events, &webhook.Active, &webhook.CreatedAt, &webhook.UpdatedAt); err != nil {
+		return webhook, err
+	}
+	webhook.CreatedAt = webhook.CreatedAt.UTC()
+	webhook.UpdatedAt = webhook.UpdatedAt.UTC()
+	if err := json.Unmarshal([]byte(events), &webhook.Events); err != nil {
+		return webhook, fmt.Errorf("failed to parse webhook events: %w", err)
+	}
+	return webhook, nil
+}
+
+func scanDelivery(row rowScanner) (models.WebhookDelivery, error) {
+	var (
+		delivery          models.WebhookDelivery
+		payload, attempts string
+	)
+	if err := row.Scan(&delivery.ID, &delivery.WebhookID, &delivery.Event, &payload, &delivery.Status, &delivery.CreatedAt, &delivery.NextAttemptAt, &attempts); err != nil {
+		return delivery, err
+	}
+	delivery.Payload = []byte(payload)
+	delivery.CreatedAt = delivery.CreatedAt.UTC()
+	delivery.NextAttemptAt = delivery.NextAttemptAt.UTC()
+	if err := json.Unmarshal([]byte(attempts), &delivery.Attempts); err != nil {
+		return delivery, fmt.Errorf("failed to parse delivery attempts: %w", err)
+	}
+	return delivery, nil
+}
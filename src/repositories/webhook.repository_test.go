@@ -0,0 +1,194 @@
+package repositories
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"congdinh.com/crm/models"
+	"github.com/google/uuid"
+)
+
+// testWebhooks checks how every backend stores webhooks and their outbox
+func testWebhooks(t *testing.T, repository IWebhookRepository) {
+	t.Helper()
+
+	created := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	billing := models.Webhook{ID: uuid.New(), URL: "https://billing.example.com/hooks", Secret: "s3cret", Events: []string{"customer.created"}, Active: true, CreatedAt: created, UpdatedAt: created}
+	marketing := models.Webhook{ID: uuid.New(), URL: "https://marketing.example.com/hooks", Secret: "other", Events: []string{"customer.contacted", "customer.deleted"}, CreatedAt: created, UpdatedAt: created}
+	for _, webhook := range []models.Webhook{billing, marketing} {
+		if err := repository.InsertWebhook(t.Context(), webhook); err != nil {
+			t.Fatalf("Expected InsertWebhook to return nil error, but got %s", err.Error())
+		}
+	}
+
+	billing.Events = append(billing.Events, "customer.updated")
+	billing.UpdatedAt = created.Add(time.Hour)
+	if err := repository.ReplaceWebhook(t.Context(), billing); err != nil {
+		t.Fatalf("Expected ReplaceWebhook to return nil error, but got %s", err.Error())
+	}
+	webhooks, err := repository.ListWebhooks(t.Context())
+	if err != nil || len(webhooks) != 2 || webhooks[0].ID != billing.ID || webhooks[1].ID != marketing.ID {
+		t.Fatalf("Expected ListWebhooks to return both webhooks in creation order, but got %v (%v)", webhooks, err)
+	}
+	found, err := repository.FindWebhook(t.Context(), billing.ID)
+	if err != nil || !slices.Equal(found.Events, billing.Events) || !found.UpdatedAt.Equal(billing.UpdatedAt) || found.Secret != "s3cret" || !found.Active {
+		t.Errorf("Expected FindWebhook to return %v, but got %v (%v)", billing, found, err)
+	}
+	if _, err := repository.FindWebhook(t.Context(), uuid.New()); !errors.Is(err, ErrWebhookNotFound) {
+		t.Errorf("Expected FindWebhook of an unknown webhook to return ErrWebhookNotFound, but got %v", err)
+	}
+	if err := repository.ReplaceWebhook(t.Context(), models.Webhook{ID: uuid.New(), Events: []string{}}); !errors.Is(err, ErrWebhookNotFound) {
+		t.Errorf("Expected ReplaceWebhook of an unknown webhook to return ErrWebhookNotFound, but got %v", err)
+	}
+
+	deliveries := []models.WebhookDelivery{}
+	for i, webhook := range []models.Webhook{billing, billing, marketing} {
+		deliveries = append(deliveries, models.WebhookDelivery{
+			ID: uuid.New(), WebhookID: webhook.ID, Event: "customer.created", Payload: []byte(`{"Event":"customer.created"}`),
+			Status: models.DeliveryPending, CreatedAt: created, NextAttemptAt: created.Add(time.Duration(2-i) * time.Minute), Attempts: []models.WebhookAttempt{},
+		})
+	}
+	if err := repository.InsertDeliveries(t.Context(), deliveries); err != nil {
+		t.Fatalf("Expected InsertDeliveries to return nil error, but got %s", err.Error())
+	}
+
+	due, err := repository.DueDeliveries(t.Context(), created.Add(time.Minute), 10)
+	if err != nil || len(due) != 2 || due[0].ID != deliveries[2].ID || due[1].ID != deliveries[1].ID {
+		t.Fatalf("Expected DueDeliveries to return the 2 due deliveries, most overdue first, but got %v (%v)", due, err)
+	}
+	if due, _ := repository.DueDeliveries(t.Context(), created.Add(time.Hour), 1); len(due) != 1 {
+		t.Errorf("Expected DueDeliveries to honor the limit, but got %d deliveries", len(due))
+	}
+
+	dead := deliveries[0]
+	dead.Status = models.DeliveryDead
+	dead.Attempts = []models.WebhookAttempt{{Timestamp: created, StatusCode: 500, Duration: 20 * time.Millisecond}, {Timestamp: created.Add(time.Minute), Error: "connection refused"}}
+	if err := repository.ReplaceDelivery(t.Context(), dead); err != nil {
+		t.Fatalf("Expected ReplaceDelivery to return nil error, but got %s", err.Error())
+	}
+	found2, err := repository.FindDelivery(t.Context(), dead.ID)
+	if err != nil || found2.Status != models.DeliveryDead || len(found2.Attempts) != 2 || found2.Attempts[0].StatusCode != 500 ||
+		found2.Attempts[0].Duration != 20*time.Millisecond || found2.Attempts[1].Error != "connection refused" || string(found2.Payload) != `{"Event":"customer.created"}` {
+		t.Errorf("Expected FindDelivery to return %v, but got %v (%v)", dead, found2, err)
+	}
+	if _, err := repository.FindDelivery(t.Context(), uuid.New()); !errors.Is(err, ErrDeliveryNotFound) {
+		t.Errorf("Expected FindDelivery of an unknown delivery to return ErrDeliveryNotFound, but got %v", err)
+	}
+	if err := repository.ReplaceDelivery(t.Context(), models.WebhookDelivery{ID: uuid.New(), Attempts: []models.WebhookAttempt{}}); !errors.Is(err, ErrDeliveryNotFound) {
+		t.Errorf("Expected ReplaceDelivery of an unknown delivery to return ErrDeliveryNotFound, but got %v", err)
+	}
+
+	selected, err := repository.Deliveries(t.Context(), WebhookDeliveryQuery{WebhookID: billing.ID})
+	if err != nil || len(selected) != 2 || selected[0].ID != deliveries[1].ID || selected[1].ID != deliveries[0].ID {
+		t.Errorf("Expected the deliveries of the webhook newest first, but got %v (%v)", selected, err)
+	}
+	if selected, _ := repository.Deliveries(t.Context(), WebhookDeliveryQuery{Status: models.DeliveryDead}); len(selected) != 1 || selected[0].ID != dead.ID {
+		t.Errorf("Expected the dead deliveries, but got %v", selected)
+	}
+	if selected, _ := repository.Deliveries(t.Context(), WebhookDeliveryQuery{Limit: 1}); len(selected) != 1 || selected[0].ID != deliveries[2].ID {
+		t.Errorf("Expected the newest delivery, but got %v", selected)
+	}
+
+	if err := repository.RemoveWebhook(t.Context(), billing.ID); err != nil {
+		t.Fatalf("Expected RemoveWebhook to return nil error, but got %s", err.Error())
+	}
+	if err := repository.RemoveWebhook(t.Context(), billing.ID); !errors.Is(err, ErrWebhookNotFound) {
+		t.Errorf("Expected removing twice to return ErrWebhookNotFound, but got %v", err)
+	}
+	if selected, _ := repository.Deliveries(t.Context(), WebhookDeliveryQuery{}); len(selected) != 1 || selected[0].WebhookID != marketing.ID {
+		t.Errorf("Expected RemoveWebhook to remove its deliveries, but got %v", selected)
+	}
+}
+
+func TestJSONWebhookRepository_Webhooks(t *testing.T) {
+	testWebhooks(t, NewMemoryWebhookRepository())
+}
+
+func TestJSONWebhookRepository_Reopen(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "webhooks.json")
+	repository, err := NewJSONWebhookRepository(file)
+	if err != nil {
+		t.Fatalf("Failed to open webhooks: %s", err.Error())
+	}
+	testWebhooks(t, repository)
+
+	reopened, err := NewJSONWebhookRepository(file)
+	if err != nil {
+		t.Fatalf("Failed to reopen webhooks: %s", err.Error())
+	}
+	webhooks, _ := reopened.ListWebhooks(t.Context())
+	deliveries, _ := reopened.Deliveries(t.Context(), WebhookDeliveryQuery{})
+	if len(webhooks) != 1 || len(deliveries) != 1 {
+		t.Errorf("Expected the webhook and delivery left by the test after reopening, but got %v and %v", webhooks, deliveries)
+	}
+}
+
+func TestJSONWebhookRepository_Relay(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "webhooks.json")
+	repository, err := NewJSONWebhookRepository(file)
+	if err != nil {
+		t.Fatalf("Failed to open webhooks: %s", err.Error())
+	}
+	if cursor, ok, err := repository.RelayCursor(t.Context()); err != nil || !ok || cursor != 0 {
+		t.Errorf("Expected a new file to relay from the start, but got %d %v (%v)", cursor, ok, err)
+	}
+	delivery := models.WebhookDelivery{ID: uuid.New(), WebhookID: uuid.New(), Status: models.DeliveryPending, Attempts: []models.WebhookAttempt{}}
+	if err := repository.RelayDeliveries(t.Context(), []models.WebhookDelivery{delivery}, 3); err != nil {
+		t.Fatalf("Expected RelayDeliveries to return nil error, but got %s", err.Error())
+	}
+
+	reopened, err := NewJSONWebhookRepository(file)
+	if err != nil {
+		t.Fatalf("Failed to reopen webhooks: %s", err.Error())
+	}
+	cursor, ok, err := reopened.RelayCursor(t.Context())
+	if _, findErr := reopened.FindDelivery(t.Context(), delivery.ID); err != nil || !ok || cursor != 3 || findErr != nil {
+		t.Errorf("Expected the cursor and the delivery written together to be kept, but got %d %v (%v, %v)", cursor, ok, err, findErr)
+	}
+
+	// Files written before the cursor was kept have none
+	os.WriteFile(file, []byte(`{"Webhooks": [], "Deliveries": []}`), 0o644)
+	legacy, err := NewJSONWebhookRepository(file)
+	if err != nil {
+		t.Fatalf("Failed to open webhooks: %s", err.Error())
+	}
+	if _, ok, _ := legacy.RelayCursor(t.Context()); ok {
+		t.Error("Expected a file without a cursor to report none")
+	}
+}
+
+func TestSQLiteCustomerRepository_ApplyDeliveries(t *testing.T) {
+	repository := newSQLiteRepository(t, filepath.Join(t.TempDir(), "crm.db"))
+	webhook := models.Webhook{ID: uuid.New(), URL: "https://billing.example.com/hooks", Events: []string{"customer.created"}, Active: true}
+	repository.InsertWebhook(t.Context(), webhook)
+	existing := models.Customer{ID: uuid.New(), Name: "Existing", Email: "taken@domain.com", Phone: "111", Version: 1}
+	repository.Insert(t.Context(), existing)
+
+	delivery := func() []models.WebhookDelivery {
+		return []models.WebhookDelivery{{ID: uuid.New(), WebhookID: webhook.ID, Event: "customer.created", Payload: []byte(`{}`), Status: models.DeliveryPending, Attempts: []models.WebhookAttempt{}}}
+	}
+	changes := []CustomerChange{
+		{Kind: ChangeInsert, Customer: models.Customer{ID: uuid.New(), Name: "New", Email: "new@domain.com", Phone: "222", Version: 1}, Deliveries: delivery()},
+		{Kind: ChangeInsert, Customer: models.Customer{ID: uuid.New(), Name: "Duplicate", Email: "taken@domain.com", Phone: "333", Version: 1}, Deliveries: delivery()},
+	}
+	errs, err := repository.Apply(t.Context(), changes, false)
+	if err != nil || errs[0] != nil || !errors.Is(errs[1], ErrCustomerExists) {
+		t.Fatalf("Expected only the duplicate to fail, but got %v (%v)", errs, err)
+	}
+	deliveries, _ := repository.Deliveries(t.Context(), WebhookDeliveryQuery{})
+	if len(deliveries) != 1 || deliveries[0].ID != changes[0].Deliveries[0].ID {
+		t.Errorf("Expected only the delivery of the stored change, but got %v", deliveries)
+	}
+}
+
+func TestJSONCustomerRepository_ApplyDeliveries(t *testing.T) {
+	repository := NewMemoryCustomerRepository(nil)
+	changes := []CustomerChange{{Kind: ChangeInsert, Customer: models.Customer{ID: uuid.New()}, Deliveries: []models.WebhookDelivery{{ID: uuid.New()}}}}
+	if _, err := repository.Apply(t.Context(), changes, true); !errors.Is(err, ErrDeliveriesNotSupported) {
+		t.Errorf("Expected deliveries to be rejected, but got %v", err)
+	}
+}
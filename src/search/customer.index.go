@@ -0,0 +1,503 @@
+package search
+
+import (
+	"html"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"congdinh.com/crm/models"
+	"github.com/google/uuid"
+)
+
+// Result is one customer matching a search, best matches first
+type Result struct {
+	ID    uuid.UUID
+	Score float64
+	// Highlights maps a field name to its value with the matched parts wrapped in <em> tags
+	Highlights map[string]string
+}
+
+// Scores for the kinds of match between a query term and an indexed term
+const (
+	exactScore     = 1.0
+	prefixScore    = 0.8
+	substringScore = 0.6
+	fuzzyScore     = 0.5
+)
+
+// fieldWeights makes a name match count for more than a role match
+var fieldWeights = map[string]float64{
+	"Name":  3,
+	"Email": 2,
+	"Phone": 2,
+	"Role":  1,
+}
+
+// document is what the index remembers about one customer
+type document struct {
+	values map[string]string
+	tokens map[string][]token
+}
+
+// CustomerIndex is an in-memory inverted index over customer names, emails,
+// phone numbers and roles. Matching ignores case and accents, accepts
+// prefixes, digit fragments of phone numbers and small typos.
+type CustomerIndex struct {
+	mu sync.RWMutex
+	// postings maps a term to the customers and fields that contain it
+	postings map[string]map[uuid.UUID]map[string]bool
+	docs     map[uuid.UUID]document
+	// versions holds the newest version Put for each customer, deleted
+	// ones included, so older versions arriving late are ignored
+	versions map[uuid.UUID]int64
+
+	// The terms of postings, arranged so a query term only looks at the
+	// terms it can match. terms is sorted for exact and prefix matches.
+	terms []string
+	// lengths maps a length in runes to the terms of that length, the
+	// candidates of typo matches
+	lengths map[int]map[string]bool
+	// digitGrams maps three digits to the terms containing them, the
+	// candidates of digit fragment matches
+	digitGrams map[string]map[string]bool
+}
+
+// NewCustomerIndex creates an empty index
+func NewCustomerIndex() *CustomerIndex {
+	return &CustomerIndex{
+		postings:   map[string]map[uuid.UUID]map[string]bool{},
+		docs:       map[uuid.UUID]document{},
+		versions:   map[uuid.UUID]int64{},
+		terms:      []string{},
+		lengths:    map[int]map[string]bool{},
+		digitGrams: map[string]map[string]bool{},
+	}
+}
+
+func analyze(customer models.Customer) document {
+	doc := document{
+		values: map[string]string{
+			"Name":  customer.Name,
+			"Email": customer.Email,
+			"Phone": customer.Phone,
+			"Role":  customer.Role,
+		},
+		tokens: map[string][]token{
+			"Name":  tokenize(customer.Name),
+			"Email": tokenize(customer.Email),
+			"Phone": tokenizePhone(customer.Phone),
+			"Role":  tokenize(customer.Role),
+		},
+	}
+	return doc
+}
+
+// Add indexes customer, replacing what was indexed for the same ID before
+func (ix *CustomerIndex) Add(customer models.Customer) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	ix.removeLocked(customer.ID)
+	ix.insertLocked(customer)
+}
+
+// Put brings the index up to date with a stored change to customer: a live
+// customer is indexed and a deleted one dropped. Writers that race put their
+// changes in any order, so a version older than one already put is ignored.
+func (ix *CustomerIndex) Put(customer models.Customer) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	if version, ok := ix.versions[customer.ID]; ok && customer.Version < version {
+		return
+	}
+	ix.versions[customer.ID] = customer.Version
+	ix.removeLocked(customer.ID)
+	if customer.DeletedAt == nil {
+		ix.insertLocked(customer)
+	}
+}
+
+// insertLocked adds customer and files its new terms in order
+func (ix *CustomerIndex) insertLocked(customer models.Customer) {
+	for _, term := range ix.addLocked(customer) {
+		i, _ := slices.BinarySearch(ix.terms, term)
+		ix.terms = slices.Insert(ix.terms, i, term)
+	}
+}
+
+// addLocked adds customer to the postings and returns the terms that are new
+// to the index. The caller adds them to terms.
+func (ix *CustomerIndex) addLocked(customer models.Customer) []string {
+	added := []string{}
+	doc := analyze(customer)
+	for field, tokens := range doc.tokens {
+		for _, t := range tokens {
+			if ix.postings[t.term] == nil {
+				ix.postings[t.term] = map[uuid.UUID]map[string]bool{}
+				ix.addCandidate(t.term)
+				added = append(added, t.term)
+			}
+			if ix.postings[t.term][customer.ID] == nil {
+				ix.postings[t.term][customer.ID] = map[string]bool{}
+			}
+			ix.postings[t.term][customer.ID][field] = true
+		}
+	}
+	ix.docs[customer.ID] = doc
+	return added
+}
+
+// digitGramsOf returns the runs of three digits in term
+func digitGramsOf(term string) []string {
+	grams := []string{}
+	r := []rune(term)
+	for i := 0; i+3 <= len(r); i++ {
+		if gram := string(r[i : i+3]); isDigits(gram) {
+			grams = append(grams, gram)
+		}
+	}
+	return grams
+}
+
+// addCandidate files a new term under its length and digit runs
+func (ix *CustomerIndex) addCandidate(term string) {
+	n := utf8.RuneCountInString(term)
+	if ix.lengths[n] == nil {
+		ix.lengths[n] = map[string]bool{}
+	}
+	ix.lengths[n][term] = true
+	for _, gram := range digitGramsOf(term) {
+		if ix.digitGrams[gram] == nil {
+			ix.digitGrams[gram] = map[string]bool{}
+		}
+		ix.digitGrams[gram][term] = true
+	}
+}
+
+// removeTerm drops a term no customer contains anymore
+func (ix *CustomerIndex) removeTerm(term string) {
+	delete(ix.postings, term)
+	if i, found := slices.BinarySearch(ix.terms, term); found {
+		ix.terms = slices.Delete(ix.terms, i, i+1)
+	}
+	n := utf8.RuneCountInString(term)
+	if delete(ix.lengths[n], term); len(ix.lengths[n]) == 0 {
+		delete(ix.lengths, n)
+	}
+	for _, gram := range digitGramsOf(term) {
+		if delete(ix.digitGrams[gram], term); len(ix.digitGrams[gram]) == 0 {
+			delete(ix.digitGrams, gram)
+		}
+	}
+}
+
+// Remove drops a customer from the index
+func (ix *CustomerIndex) Remove(id uuid.UUID) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	ix.removeLocked(id)
+}
+
+func (ix *CustomerIndex) removeLocked(id uuid.UUID) {
+	doc, ok := ix.docs[id]
+	if !ok {
+		return
+	}
+	for _, tokens := range doc.tokens {
+		for _, t := range tokens {
+			if _, ok := ix.postings[t.term]; !ok {
+				// A term that occurs twice in the customer is gone already
+				continue
+			}
+			delete(ix.postings[t.term], id)
+			if len(ix.postings[t.term]) == 0 {
+				ix.removeTerm(t.term)
+			}
+		}
+	}
+	delete(ix.docs, id)
+}
+
+// Reset replaces the whole index with the given customers
+func (ix *CustomerIndex) Reset(customers []models.Customer) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	ix.postings = map[string]map[uuid.UUID]map[string]bool{}
+	ix.docs = map[uuid.UUID]document{}
+	ix.versions = map[uuid.UUID]int64{}
+	ix.lengths = map[int]map[string]bool{}
+	ix.digitGrams = map[string]map[string]bool{}
+	for _, customer := range customers {
+		ix.removeLocked(customer.ID)
+		ix.addLocked(customer)
+		ix.versions[customer.ID] = customer.Version
+	}
+	// Sorting once is cheaper than inserting every term in order
+	ix.terms = slices.Sorted(maps.Keys(ix.postings))
+}
+
+// termMatch is how well an indexed term matches a query term. The matched
+// part of the indexed term is runes [from, to).
+type termMatch struct {
+	score float64
+	from  int
+	to    int
+}
+
+// maxEdits is the number of typos tolerated for a query term of length n
+func maxEdits(n int) int {
+	switch {
+	case n >= 8:
+		return 2
+	case n >= 4:
+		return 1
+	}
+	return 0
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if !unicode.IsDigit(r) {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// matchTerm compares a query term to an indexed term
+func matchTerm(query string, term string) (termMatch, bool) {
+	queryLen := utf8.RuneCountInString(query)
+	termLen := utf8.RuneCountInString(term)
+
+	if query == term {
+		return termMatch{exactScore, 0, termLen}, true
+	}
+	if queryLen >= 2 && strings.HasPrefix(term, query) {
+		return termMatch{prefixScore, 0, queryLen}, true
+	}
+	if queryLen >= 3 && isDigits(query) {
+		if i := strings.Index(term, query); i >= 0 {
+			from := utf8.RuneCountInString(term[:i])
+			return termMatch{substringScore, from, from + queryLen}, true
+		}
+	}
+	if edits := maxEdits(queryLen); edits > 0 && !isDigits(query) {
+		if d := levenshtein(query, term, edits); d <= edits {
+			return termMatch{fuzzyScore / float64(d), 0, termLen}, true
+		}
+	}
+	return termMatch{}, false
+}
+
+// levenshtein returns the edit distance between a and b, or max+1 once it is
+// clear the distance exceeds max
+func levenshtein(a string, b string, max int) int {
+	ra, rb := []rune(a), []rune(b)
+	if diff := len(ra) - len(rb); diff > max || -diff > max {
+		return max + 1
+	}
+
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		best := curr[0]
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+			best = min(best, curr[j])
+		}
+		if best > max {
+			return max + 1
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
+
+// candidates returns the terms query can match: the term itself and those
+// it prefixes, the terms containing it for digits, and the terms of a length
+// within the tolerated typos otherwise. Other terms cannot match, so Search
+// does not have to look at every term.
+func (ix *CustomerIndex) candidates(query string) map[string]bool {
+	queryLen := utf8.RuneCountInString(query)
+	candidates := map[string]bool{}
+	if _, ok := ix.postings[query]; ok {
+		candidates[query] = true
+	}
+	if queryLen >= 2 {
+		for i := sort.SearchStrings(ix.terms, query); i < len(ix.terms) && strings.HasPrefix(ix.terms[i], query); i++ {
+			candidates[ix.terms[i]] = true
+		}
+	}
+	if queryLen >= 3 && isDigits(query) {
+		// Every gram of the query is in a term containing it, so the rarest gram bounds the candidates
+		var rarest map[string]bool
+		for _, gram := range digitGramsOf(query) {
+			if terms := ix.digitGrams[gram]; rarest == nil || len(terms) < len(rarest) {
+				rarest = terms
+			}
+		}
+		for term := range rarest {
+			if strings.Contains(term, query) {
+				candidates[term] = true
+			}
+		}
+	}
+	if edits := maxEdits(queryLen); edits > 0 && !isDigits(query) {
+		for n := queryLen - edits; n <= queryLen+edits; n++ {
+			for term := range ix.lengths[n] {
+				candidates[term] = true
+			}
+		}
+	}
+	return candidates
+}
+
+// hit records the best match of one query term inside one field of a customer
+type hit struct {
+	field string
+	term  string
+	match termMatch
+}
+
+// Search returns up to limit customers matching every term of query, best first
+func (ix *CustomerIndex) Search(query string, limit int) []Result {
+	queryTokens := tokenize(query)
+	if len(queryTokens) == 0 {
+		return []Result{}
+	}
+
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	scores := map[uuid.UUID]float64{}
+	hits := map[uuid.UUID][]hit{}
+	for i, qt := range queryTokens {
+		// best score of this query term per customer
+		best := map[uuid.UUID]float64{}
+		for term := range ix.candidates(qt.term) {
+			match, ok := matchTerm(qt.term, term)
+			if !ok {
+				continue
+			}
+			for id, fields := range ix.postings[term] {
+				for field := range fields {
+					score := match.score * fieldWeights[field]
+					if score > best[id] {
+						best[id] = score
+					}
+					hits[id] = append(hits[id], hit{field, term, match})
+				}
+			}
+		}
+
+		// A customer has to match every query term
+		for id := range scores {
+			if _, ok := best[id]; !ok {
+				delete(scores, id)
+			}
+		}
+		for id, score := range best {
+			if i == 0 {
+				scores[id] = score
+			} else if _, ok := scores[id]; ok {
+				scores[id] += score
+			}
+		}
+	}
+
+	results := []Result{}
+	for id, score := range scores {
+		results = append(results, Result{
+			ID:         id,
+			Score:      score,
+			Highlights: ix.highlight(ix.docs[id], hits[id]),
+		})
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return ix.docs[results[i].ID].values["Name"] < ix.docs[results[j].ID].values["Name"]
+	})
+
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+// highlight wraps the matched parts of each field in <em> tags
+func (ix *CustomerIndex) highlight(doc document, hits []hit) map[string]string {
+	type span struct{ start, end int }
+
+	spans := map[string][]span{}
+	for _, h := range hits {
+		for _, t := range doc.tokens[h.field] {
+			if t.term != h.term {
+				continue
+			}
+			s := span{t.start, t.end}
+			if t.aligned {
+				original := doc.values[h.field][t.start:t.end]
+				s = span{t.start + runeOffset(original, h.match.from), t.start + runeOffset(original, h.match.to)}
+			}
+			spans[h.field] = append(spans[h.field], s)
+		}
+	}
+
+	highlights := map[string]string{}
+	for field, fieldSpans := range spans {
+		sort.Slice(fieldSpans, func(i, j int) bool { return fieldSpans[i].start < fieldSpans[j].start })
+
+		// Merge overlapping spans so tags never nest
+		merged := []span{}
+		for _, s := range fieldSpans {
+			if n := len(merged); n > 0 && s.start <= merged[n-1].end {
+				merged[n-1].end = max(merged[n-1].end, s.end)
+				continue
+			}
+			merged = append(merged, s)
+		}
+
+		// Values are escaped so the highlights are safe to render as HTML
+		value := doc.values[field]
+		var b strings.Builder
+		pos := 0
+		for _, s := range merged {
+			b.WriteString(html.EscapeString(value[pos:s.start]))
+			b.WriteString("<em>" + html.EscapeString(value[s.start:s.end]) + "</em>")
+			pos = s.end
+		}
+		b.WriteString(html.EscapeString(value[pos:]))
+		highlights[field] = b.String()
+	}
+	return highlights
+}
+
+// runeOffset converts a rune index within s into a byte offset
+func runeOffset(s string, runeIndex int) int {
+	i := 0
+	for offset := range s {
+		if i == runeIndex {
+			return offset
+		}
+		i++
+	}
+	return len(s)
+}
@@ -0,0 +1,201 @@
+package search
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"congdinh.com/crm/models"
+	"github.com/google/uuid"
+)
+
+func newTestIndex() (*CustomerIndex, map[string]models.Customer) {
+	customers := map[string]models.Customer{
+		"cong":  {ID: uuid.New(), Name: "Cong Dinh", Role: "Developer", Email: "cong@acme.com", Phone: "1234567890"},
+		"van":   {ID: uuid.New(), Name: "Văn Nguyễn", Role: "Manager", Email: "van@globex.com", Phone: "0987654321"},
+		"thang": {ID: uuid.New(), Name: "Thắng Nguyen", Role: "Designer", Email: "thang@acme.com", Phone: "+84 (90) 555-1234"},
+		"dung":  {ID: uuid.New(), Name: "Đức Trần", Role: "Tester", Email: "duc@initech.com", Phone: "1357924680"},
+	}
+
+	index := NewCustomerIndex()
+	for _, customer := range customers {
+		index.Add(customer)
+	}
+	return index, customers
+}
+
+func ids(results []Result) map[uuid.UUID]bool {
+	found := map[uuid.UUID]bool{}
+	for _, result := range results {
+		found[result.ID] = true
+	}
+	return found
+}
+
+func TestCustomerIndex_Search(t *testing.T) {
+	index, customers := newTestIndex()
+
+	cases := []struct {
+		name     string
+		query    string
+		expected []string
+	}{
+		{"accents and case are ignored", "NGUYEN", []string{"van", "thang"}},
+		{"accented query matches plain text", "Công", []string{"cong"}},
+		{"d with stroke folds to d", "duc", []string{"dung"}},
+		{"partial name", "ngu", []string{"van", "thang"}},
+		{"email domain", "acme.com", []string{"cong", "thang"}},
+		{"phone fragment", "65432", []string{"van"}},
+		{"formatted phone fragment", "5551234", []string{"thang"}},
+		{"typo", "nguyem", []string{"van", "thang"}},
+		{"every term must match", "nguyen acme", []string{"thang"}},
+		{"no match", "zebra", []string{}},
+	}
+
+	for _, c := range cases {
+		found := ids(index.Search(c.query, 0))
+		if len(found) != len(c.expected) {
+			t.Errorf("%s: expected %d results for %q, but got %d", c.name, len(c.expected), c.query, len(found))
+			continue
+		}
+		for _, key := range c.expected {
+			if !found[customers[key].ID] {
+				t.Errorf("%s: expected %q to find %s", c.name, c.query, customers[key].Name)
+			}
+		}
+	}
+}
+
+func TestCustomerIndex_Ranking(t *testing.T) {
+	index := NewCustomerIndex()
+	exact := models.Customer{ID: uuid.New(), Name: "Linh", Email: "a@domain.com", Phone: "1"}
+	prefix := models.Customer{ID: uuid.New(), Name: "Linhda", Email: "b@domain.com", Phone: "2"}
+	typo := models.Customer{ID: uuid.New(), Name: "Lính", Role: "Linx", Email: "c@domain.com", Phone: "3"}
+	for _, customer := range []models.Customer{typo, prefix, exact} {
+		index.Add(customer)
+	}
+
+	results := index.Search("linh", 0)
+	if len(results) != 3 {
+		t.Fatalf("Expected 3 results, but got %d", len(results))
+	}
+	// "Lính" folds to "linh" as well, so it ties with the exact match on name
+	if results[2].ID != prefix.ID {
+		t.Errorf("Expected the prefix match to rank last, but got %v", results)
+	}
+	if results[0].Score < results[2].Score {
+		t.Errorf("Expected results sorted by score, but got %v", results)
+	}
+
+	if limited := index.Search("linh", 1); len(limited) != 1 {
+		t.Errorf("Expected the limit to cap results at 1, but got %d", len(limited))
+	}
+}
+
+func TestCustomerIndex_Highlights(t *testing.T) {
+	index, customers := newTestIndex()
+
+	results := index.Search("nguy 654", 0)
+	if len(results) != 1 || results[0].ID != customers["van"].ID {
+		t.Fatalf("Expected only Văn Nguyễn, but got %v", results)
+	}
+
+	highlights := results[0].Highlights
+	if highlights["Name"] != "Văn <em>Nguy</em>ễn" {
+		t.Errorf("Expected the name prefix to be highlighted, but got '%s'", highlights["Name"])
+	}
+	if highlights["Phone"] != "0987<em>654</em>321" {
+		t.Errorf("Expected the phone fragment to be highlighted, but got '%s'", highlights["Phone"])
+	}
+
+	index.Add(models.Customer{ID: uuid.New(), Name: "<script>Mallory</script>", Phone: "9"})
+	results = index.Search("mallory", 0)
+	if len(results) != 1 || results[0].Highlights["Name"] != "&lt;script&gt;<em>Mallory</em>&lt;/script&gt;" {
+		t.Errorf("Expected highlights to be HTML escaped, but got %v", results)
+	}
+}
+
+func TestCustomerIndex_AddReplacesAndRemove(t *testing.T) {
+	index, customers := newTestIndex()
+
+	renamed := customers["cong"]
+	renamed.Name = "Minh Pham"
+	renamed.Email = "minh@acme.com"
+	index.Add(renamed)
+
+	if results := index.Search("cong", 0); len(results) != 0 {
+		t.Errorf("Expected the old name to be gone after re-adding, but got %v", results)
+	}
+	if results := index.Search("minh", 0); len(results) != 1 || results[0].ID != renamed.ID {
+		t.Errorf("Expected the new name to be searchable, but got %v", results)
+	}
+
+	index.Remove(renamed.ID)
+	if results := index.Search("minh", 0); len(results) != 0 {
+		t.Errorf("Expected no results after Remove, but got %v", results)
+	}
+	if len(index.postings) == 0 {
+		t.Error("Expected the other customers to stay indexed")
+	}
+}
+
+func TestCustomerIndex_Put(t *testing.T) {
+	index, customers := newTestIndex()
+
+	first := customers["cong"]
+	first.Version = 2
+	first.Name = "Minh Pham"
+	second := first
+	second.Version = 3
+	second.Name = "Khoa Vo"
+	index.Put(second)
+	index.Put(first)
+	if results := index.Search("khoa", 0); len(results) != 1 {
+		t.Errorf("Expected the newer version to stay indexed, but got %v", results)
+	}
+	if results := index.Search("minh", 0); len(results) != 0 {
+		t.Errorf("Expected the older version to be ignored, but got %v", results)
+	}
+
+	deleted := second
+	deleted.Version = 4
+	deleted.DeletedAt = &time.Time{}
+	index.Put(deleted)
+	index.Put(second)
+	if results := index.Search("khoa", 0); len(results) != 0 {
+		t.Errorf("Expected the deleted customer to stay out of the index, but got %v", results)
+	}
+}
+
+func TestCustomerIndex_Candidates(t *testing.T) {
+	index, customers := newTestIndex()
+	index.Remove(customers["dung"].ID)
+	index.Add(customers["dung"])
+
+	reset := NewCustomerIndex()
+	reset.Reset([]models.Customer{customers["cong"], customers["van"], customers["thang"], customers["dung"]})
+
+	queries := []string{"nguyen", "ngu", "nguyem", "n", "acme", "acme.com", "65432", "5551234", "84", "123", "duc", "tester", "zebra"}
+	for name, ix := range map[string]*CustomerIndex{"added": index, "reset": reset} {
+		if len(ix.terms) != len(ix.postings) || !slices.IsSorted(ix.terms) {
+			t.Errorf("%s: expected the %d terms sorted, but got %v", name, len(ix.postings), ix.terms)
+		}
+		for _, query := range queries {
+			candidates := ix.candidates(query)
+			// Every term the query matches must be a candidate, without looking at all of them
+			for term := range ix.postings {
+				if _, ok := matchTerm(query, term); ok && !candidates[term] {
+					t.Errorf("%s: expected %q to be a candidate of %q", name, term, query)
+				}
+			}
+			if len(candidates) == len(ix.postings) {
+				t.Errorf("%s: expected %q to skip some of the %d terms", name, query, len(ix.postings))
+			}
+		}
+	}
+
+	index.Remove(customers["van"].ID)
+	if _, ok := index.postings["globex"]; ok || slices.Contains(index.terms, "globex") || index.candidates("globe")["globex"] {
+		t.Error("Expected the terms of a removed customer to be dropped")
+	}
+}
@@ -0,0 +1,89 @@
+package search
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// token is a normalized term together with where it came from in the original text
+type token struct {
+	term string
+	// start and end are byte offsets of the token in the original text
+	start int
+	end   int
+	// aligned is true when every rune of term corresponds to one rune of the
+	// original text, so a part of term can be mapped back for highlighting
+	aligned bool
+}
+
+// accentFolder strips combining marks after canonical decomposition, so "Nguyễn" becomes "Nguyen"
+var accentFolder = transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+
+// fold lowercases s and removes accents
+func fold(s string) string {
+	folded, _, err := transform.String(accentFolder, s)
+	if err != nil {
+		folded = s
+	}
+	// đ/Đ is a letter of its own rather than d with a combining mark
+	folded = strings.NewReplacer("đ", "d", "Đ", "D").Replace(folded)
+	return strings.ToLower(folded)
+}
+
+// tokenize splits text into words made of letters and digits
+func tokenize(text string) []token {
+	tokens := []token{}
+	start := -1
+	for i, r := range text {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.Mn, r) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 {
+			tokens = append(tokens, newToken(text, start, i))
+			start = -1
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, newToken(text, start, len(text)))
+	}
+	return tokens
+}
+
+func newToken(text string, start int, end int) token {
+	original := norm.NFC.String(text[start:end])
+	term := fold(original)
+	return token{
+		term:    term,
+		start:   start,
+		end:     end,
+		aligned: original == text[start:end] && utf8.RuneCountInString(term) == utf8.RuneCountInString(original),
+	}
+}
+
+// tokenizePhone keeps only the digits of a phone number as a single term, so
+// "+84 (90) 123-4567" can be found by any run of its digits
+func tokenizePhone(phone string) []token {
+	var digits strings.Builder
+	for _, r := range phone {
+		if unicode.IsDigit(r) {
+			digits.WriteRune(r)
+		}
+	}
+	if digits.Len() == 0 {
+		return nil
+	}
+	return []token{{
+		term:    digits.String(),
+		start:   0,
+		end:     len(phone),
+		aligned: digits.String() == phone,
+	}}
+}
@@ -0,0 +1,23 @@
+package services
+
+import (
+	"context"
+
+	"congdinh.com/crm/auth"
+	viewmodels "congdinh.com/crm/view-models"
+	"github.com/google/uuid"
+)
+
+// IAPIKeyService defines the interface for managing API keys and
+// authenticating requests with them. Create reports invalid input as
+// *ValidationError; Get, Rotate and Revoke report an unknown key as
+// *APIKeyNotFoundError, and Rotate reports a revoked key as *ConflictError.
+// Authenticate returns ErrInvalidAPIKey for a key that cannot be used.
+type IAPIKeyService interface {
+	List(ctx context.Context) ([]viewmodels.APIKeyViewModel, error)
+	Get(ctx context.Context, id uuid.UUID) (viewmodels.APIKeyViewModel, error)
+	Create(ctx context.Context, key viewmodels.APIKeyCreateViewModel) (viewmodels.APIKeyViewModel, error)
+	Rotate(ctx context.Context, id uuid.UUID) (viewmodels.APIKeyViewModel, error)
+	Revoke(ctx context.Context, id uuid.UUID) error
+	Authenticate(ctx context.Context, key string) (auth.Principal, error)
+}
@@ -0,0 +1,27 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+
+	"congdinh.com/crm/repositories"
+	"github.com/google/uuid"
+)
+
+// ErrInvalidAPIKey is returned by Authenticate for a key that is unknown,
+// revoked or expired
+var ErrInvalidAPIKey = errors.New("invalid api key")
+
+// APIKeyNotFoundError is returned when the requested API key does not exist
+type APIKeyNotFoundError struct {
+	ID uuid.UUID
+}
+
+func (e *APIKeyNotFoundError) Error() string {
+	return fmt.Sprintf("api key %s not found", e.ID)
+}
+
+// Unwrap lets errors.Is match repositories.ErrAPIKeyNotFound
+func (e *APIKeyNotFoundError) Unwrap() error {
+	return repositories.ErrAPIKeyNotFound
+}
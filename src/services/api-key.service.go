@@ -0,0 +1,264 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"congdinh.com/crm/auth"
+	"congdinh.com/crm/clock"
+	"congdinh.com/crm/models"
+	"congdinh.com/crm/repositories"
+	"congdinh.com/crm/validation"
+	viewmodels "congdinh.com/crm/view-models"
+	"github.com/google/uuid"
+)
+
+const (
+	// apiKeyPrefixLength is how much of a key is kept to tell keys apart
+	apiKeyPrefixLength = 12
+	// lastUsedResolution is how stale LastUsedAt may get, so a busy key is
+	// not written on every request
+	lastUsedResolution = time.Minute
+	// BootstrapAPIKeyName is the name of the key EnsureBootstrapKey stores
+	BootstrapAPIKeyName = "bootstrap"
+	// BootstrapRole is the access policy role of the bootstrap key
+	BootstrapRole = "admin"
+	// minBootstrapKeyLength keeps a chosen bootstrap key hard to guess
+	minBootstrapKeyLength = 24
+)
+
+// APIKeyService manages the API keys and authenticates requests with them.
+// Keys are random, so a plain SHA-256 is enough to store them safely.
+type APIKeyService struct {
+	repository repositories.IAPIKeyRepository
+	// Clock stamps keys and decides when they expire
+	Clock clock.Clock
+	// RotationGrace is how long the key replaced by a rotation keeps working,
+	// so clients can switch without downtime
+	RotationGrace time.Duration
+	// Roles are the roles keys may be given; nil allows any
+	Roles []string
+}
+
+// NewAPIKeyService creates an API key service on top of the given repository
+func NewAPIKeyService(repository repositories.IAPIKeyRepository) *APIKeyService {
+	return &APIKeyService{
+		repository:    repository,
+		Clock:         clock.System,
+		RotationGrace: 24 * time.Hour,
+	}
+}
+
+func toAPIKeyViewModel(key models.APIKey) viewmodels.APIKeyViewModel {
+	return viewmodels.APIKeyViewModel{
+		ID:                key.ID,
+		Name:              key.Name,
+		Prefix:            key.Prefix,
+		Scopes:            key.Scopes,
+		Roles:             key.Roles,
+		CreatedAt:         key.CreatedAt,
+		ExpiresAt:         key.ExpiresAt,
+		RotatedAt:         key.RotatedAt,
+		PreviousExpiresAt: key.PreviousExpiresAt,
+		RevokedAt:         key.RevokedAt,
+		LastUsedAt:        key.LastUsedAt,
+	}
+}
+
+// apiKeyError translates repository errors about key id into the typed service errors
+func apiKeyError(id uuid.UUID, err error) error {
+	if errors.Is(err, repositories.ErrAPIKeyNotFound) {
+		return &APIKeyNotFoundError{ID: id}
+	}
+	return err
+}
+
+// newAPIKey returns a random key
+func newAPIKey() string {
+	return "crm_" + rand.Text()
+}
+
+// hashAPIKey returns the hex SHA-256 a key is stored and looked up by
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// List method return every API key in creation order, revoked ones included
+func (ks *APIKeyService) List(ctx context.Context) ([]viewmodels.APIKeyViewModel, error) {
+	keys, err := ks.repository.ListAPIKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result := []viewmodels.APIKeyViewModel{}
+	for _, key := range keys {
+		result = append(result, toAPIKeyViewModel(key))
+	}
+	return result, nil
+}
+
+// Get method return an API key by ID, without the key itself
+func (ks *APIKeyService) Get(ctx context.Context, id uuid.UUID) (viewmodels.APIKeyViewModel, error) {
+	key, err := ks.repository.FindAPIKey(ctx, id)
+	if err != nil {
+		return viewmodels.APIKeyViewModel{}, apiKeyError(id, err)
+	}
+	return toAPIKeyViewModel(key), nil
+}
+
+// Create method create an API key. The result includes the key, which is
+// not stored and cannot be returned again.
+func (ks *APIKeyService) Create(ctx context.Context, key viewmodels.APIKeyCreateViewModel) (viewmodels.APIKeyViewModel, error) {
+	now := ks.Clock.Now().UTC().Truncate(time.Microsecond)
+
+	var errs validation.Errors
+	if err := validation.Validate(key); err != nil && !errors.As(err, &errs) {
+		return viewmodels.APIKeyViewModel{}, err
+	}
+	if len(key.Scopes) == 0 || slices.ContainsFunc(key.Scopes, func(scope string) bool { return !slices.Contains(auth.Scopes, scope) }) {
+		errs = append(errs, validation.FieldError{Field: "Scopes", Reason: "must list one or more of " + strings.Join(auth.Scopes, ", ")})
+	}
+	if ks.Roles != nil && slices.ContainsFunc(key.Roles, func(role string) bool { return !slices.Contains(ks.Roles, role) }) {
+		errs = append(errs, validation.FieldError{Field: "Roles", Reason: "must list roles of " + strings.Join(ks.Roles, ", ")})
+	}
+	if key.ExpiresAt != nil && !key.ExpiresAt.After(now) {
+		errs = append(errs, validation.FieldError{Field: "ExpiresAt", Reason: "must be in the future"})
+	}
+	if len(errs) > 0 {
+		return viewmodels.APIKeyViewModel{}, &ValidationError{Errors: errs}
+	}
+
+	secret := newAPIKey()
+	newKey := models.APIKey{
+		ID:        uuid.New(),
+		Name:      key.Name,
+		Prefix:    secret[:apiKeyPrefixLength],
+		Hash:      hashAPIKey(secret),
+		Scopes:    slices.Compact(slices.Sorted(slices.Values(key.Scopes))),
+		CreatedAt: now,
+	}
+	if len(key.Roles) > 0 {
+		newKey.Roles = slices.Compact(slices.Sorted(slices.Values(key.Roles)))
+	}
+	if key.ExpiresAt != nil {
+		expiresAt := key.ExpiresAt.UTC().Truncate(time.Microsecond)
+		newKey.ExpiresAt = &expiresAt
+	}
+	if err := ks.repository.InsertAPIKey(ctx, newKey); err != nil {
+		return viewmodels.APIKeyViewModel{}, err
+	}
+
+	result := toAPIKeyViewModel(newKey)
+	result.Key = secret
+	return result, nil
+}
+
+// Rotate method replace the key of an API key with a new one, which the
+// result includes. The replaced key keeps working for RotationGrace.
+func (ks *APIKeyService) Rotate(ctx context.Context, id uuid.UUID) (viewmodels.APIKeyViewModel, error) {
+	key, err := ks.repository.FindAPIKey(ctx, id)
+	if err != nil {
+		return viewmodels.APIKeyViewModel{}, apiKeyError(id, err)
+	}
+	if key.RevokedAt != nil {
+		return viewmodels.APIKeyViewModel{}, &ConflictError{Reason: fmt.Sprintf("api key %s is revoked", id)}
+	}
+
+	now := ks.Clock.Now().UTC().Truncate(time.Microsecond)
+	previousExpiresAt := now.Add(ks.RotationGrace)
+	secret := newAPIKey()
+	key.PreviousHash = key.Hash
+	key.PreviousExpiresAt = &previousExpiresAt
+	key.Hash = hashAPIKey(secret)
+	key.Prefix = secret[:apiKeyPrefixLength]
+	key.RotatedAt = &now
+	if err := ks.repository.ReplaceAPIKey(ctx, key); err != nil {
+		return viewmodels.APIKeyViewModel{}, apiKeyError(id, err)
+	}
+
+	result := toAPIKeyViewModel(key)
+	result.Key = secret
+	return result, nil
+}
+
+// Revoke method stop an API key, and the key a rotation replaced, from
+// working. The key is kept, so its name and last use can still be looked up;
+// revoking it again changes nothing.
+func (ks *APIKeyService) Revoke(ctx context.Context, id uuid.UUID) error {
+	key, err := ks.repository.FindAPIKey(ctx, id)
+	if err != nil {
+		return apiKeyError(id, err)
+	}
+	if key.RevokedAt != nil {
+		return nil
+	}
+	now := ks.Clock.Now().UTC().Truncate(time.Microsecond)
+	key.RevokedAt = &now
+	return apiKeyError(id, ks.repository.ReplaceAPIKey(ctx, key))
+}
+
+// Authenticate returns the principal of key: the key's name and scopes. It
+// records when the key was used, to the minute.
+func (ks *APIKeyService) Authenticate(ctx context.Context, key string) (auth.Principal, error) {
+	hash := hashAPIKey(key)
+	found, err := ks.repository.FindAPIKeyByHash(ctx, hash)
+	if errors.Is(err, repositories.ErrAPIKeyNotFound) {
+		return auth.Principal{}, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return auth.Principal{}, err
+	}
+
+	now := ks.Clock.Now()
+	if found.RevokedAt != nil || (found.ExpiresAt != nil && !now.Before(*found.ExpiresAt)) {
+		return auth.Principal{}, ErrInvalidAPIKey
+	}
+	if found.Hash != hash && (found.PreviousExpiresAt == nil || !now.Before(*found.PreviousExpiresAt)) {
+		return auth.Principal{}, ErrInvalidAPIKey
+	}
+
+	if found.LastUsedAt == nil || now.Sub(*found.LastUsedAt) >= lastUsedResolution {
+		// Failing to record the use must not lock the caller out
+		if err := ks.repository.TouchAPIKey(ctx, found.ID, now.UTC().Truncate(time.Microsecond)); err != nil {
+			log.Printf("Failed to record the use of api key %s: %v", found.ID, err)
+		}
+	}
+	return auth.Principal{Subject: found.Name, Scopes: found.Scopes, Roles: found.Roles}, nil
+}
+
+// EnsureBootstrapKey stores key as an admin key named "bootstrap" with the
+// role BootstrapRole unless it is stored already, so a new deployment has a
+// key to create the others with. A revoked bootstrap key stays revoked, and
+// one stored before keys had roles is given BootstrapRole.
+func (ks *APIKeyService) EnsureBootstrapKey(ctx context.Context, key string) error {
+	if len(key) < minBootstrapKeyLength {
+		return fmt.Errorf("the bootstrap api key must be at least %d characters", minBootstrapKeyLength)
+	}
+	hash := hashAPIKey(key)
+	found, err := ks.repository.FindAPIKeyByHash(ctx, hash)
+	if err == nil && found.Name == BootstrapAPIKeyName && len(found.Roles) == 0 {
+		found.Roles = []string{BootstrapRole}
+		return ks.repository.ReplaceAPIKey(ctx, found)
+	}
+	if !errors.Is(err, repositories.ErrAPIKeyNotFound) {
+		return err
+	}
+	return ks.repository.InsertAPIKey(ctx, models.APIKey{
+		ID:   uuid.New(),
+		Name: BootstrapAPIKeyName,
+		// Only a little of a chosen key, which may be shorter than generated ones
+		Prefix:    key[:4],
+		Hash:      hash,
+		Scopes:    []string{auth.ScopeAdmin},
+		Roles:     []string{BootstrapRole},
+		CreatedAt: ks.Clock.Now().UTC().Truncate(time.Microsecond),
+	})
+}
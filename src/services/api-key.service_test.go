@@ -0,0 +1,188 @@
+package services
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"congdinh.com/crm/auth"
+	"congdinh.com/crm/clock"
+	"congdinh.com/crm/repositories"
+	viewmodels "congdinh.com/crm/view-models"
+	"github.com/google/uuid"
+)
+
+// newAPIKeyService creates an API key service whose clock tells *now
+func newAPIKeyService(now *time.Time) *APIKeyService {
+	apiKeyService := NewAPIKeyService(repositories.NewMemoryAPIKeyRepository())
+	apiKeyService.Clock = clock.Func(func() time.Time { return *now })
+	return apiKeyService
+}
+
+func TestAPIKeyService_Create(t *testing.T) {
+	now := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	apiKeyService := newAPIKeyService(&now)
+
+	created, err := apiKeyService.Create(t.Context(), viewmodels.APIKeyCreateViewModel{Name: "reporting", Scopes: []string{"write", "read", "read"}})
+	if err != nil {
+		t.Fatalf("Expected Create to return nil error, but got %s", err.Error())
+	}
+	if !strings.HasPrefix(created.Key, created.Prefix) || !slices.Equal(created.Scopes, []string{"read", "write"}) || !created.CreatedAt.Equal(now) {
+		t.Errorf("Expected the key with its prefix and sorted scopes, but got %v", created)
+	}
+	if found, _ := apiKeyService.Get(t.Context(), created.ID); found.Key != "" || found.Name != "reporting" {
+		t.Errorf("Expected Get to return the key without the secret, but got %v", found)
+	}
+	if _, err := apiKeyService.Get(t.Context(), uuid.New()); !errors.As(err, new(*APIKeyNotFoundError)) {
+		t.Errorf("Expected Get of an unknown key to return *APIKeyNotFoundError, but got %v", err)
+	}
+
+	apiKeyService.Roles = []string{"admin", "viewer"}
+	if created, _ := apiKeyService.Create(t.Context(), viewmodels.APIKeyCreateViewModel{Name: "dashboard", Scopes: []string{"read"}, Roles: []string{"viewer", "viewer"}}); !slices.Equal(created.Roles, []string{"viewer"}) {
+		t.Errorf("Expected the key with its roles, but got %v", created)
+	}
+
+	past := now.Add(-time.Hour)
+	tests := []struct {
+		key    viewmodels.APIKeyCreateViewModel
+		fields []string
+	}{
+		{key: viewmodels.APIKeyCreateViewModel{Scopes: []string{"read"}}, fields: []string{"Name"}},
+		{key: viewmodels.APIKeyCreateViewModel{Name: "none"}, fields: []string{"Scopes"}},
+		{key: viewmodels.APIKeyCreateViewModel{Name: "root", Scopes: []string{"root"}}, fields: []string{"Scopes"}},
+		{key: viewmodels.APIKeyCreateViewModel{Name: "expired", Scopes: []string{"read"}, ExpiresAt: &past}, fields: []string{"ExpiresAt"}},
+		{key: viewmodels.APIKeyCreateViewModel{Name: "root", Scopes: []string{"read"}, Roles: []string{"root"}}, fields: []string{"Roles"}},
+	}
+	for _, test := range tests {
+		_, err := apiKeyService.Create(t.Context(), test.key)
+		var validationErr *ValidationError
+		if !errors.As(err, &validationErr) {
+			t.Errorf("Expected Create of %v to return *ValidationError, but got %v", test.key, err)
+			continue
+		}
+		fields := []string{}
+		for _, fieldErr := range validationErr.Errors {
+			fields = append(fields, fieldErr.Field)
+		}
+		if !slices.Equal(fields, test.fields) {
+			t.Errorf("Expected Create of %v to fail on %v, but got %v", test.key, test.fields, fields)
+		}
+	}
+}
+
+func TestAPIKeyService_Authenticate(t *testing.T) {
+	now := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	apiKeyService := newAPIKeyService(&now)
+	expires := now.Add(48 * time.Hour)
+	created, _ := apiKeyService.Create(t.Context(), viewmodels.APIKeyCreateViewModel{Name: "reporting", Scopes: []string{"read"}, ExpiresAt: &expires})
+
+	principal, err := apiKeyService.Authenticate(t.Context(), created.Key)
+	if err != nil || principal.Subject != "reporting" || !principal.Allows(auth.ScopeRead) || principal.Allows(auth.ScopeWrite) {
+		t.Errorf("Expected the principal of the reporting key, but got %v (%v)", principal, err)
+	}
+	if _, err := apiKeyService.Authenticate(t.Context(), "crm_unknown"); !errors.Is(err, ErrInvalidAPIKey) {
+		t.Errorf("Expected an unknown key to return ErrInvalidAPIKey, but got %v", err)
+	}
+
+	// The last use is only recorded once a minute
+	used := now
+	now = now.Add(30 * time.Second)
+	apiKeyService.Authenticate(t.Context(), created.Key)
+	if found, _ := apiKeyService.Get(t.Context(), created.ID); found.LastUsedAt == nil || !found.LastUsedAt.Equal(used) {
+		t.Errorf("Expected LastUsedAt to stay %v within a minute, but got %v", used, found.LastUsedAt)
+	}
+	now = now.Add(time.Minute)
+	apiKeyService.Authenticate(t.Context(), created.Key)
+	if found, _ := apiKeyService.Get(t.Context(), created.ID); found.LastUsedAt == nil || !found.LastUsedAt.Equal(now) {
+		t.Errorf("Expected LastUsedAt to move to %v, but got %v", now, found.LastUsedAt)
+	}
+
+	rotated, err := apiKeyService.Rotate(t.Context(), created.ID)
+	if err != nil || rotated.Key == "" || rotated.Key == created.Key || rotated.PreviousExpiresAt == nil || !rotated.PreviousExpiresAt.Equal(now.Add(apiKeyService.RotationGrace)) {
+		t.Fatalf("Expected Rotate to return a new key, but got %v (%v)", rotated, err)
+	}
+	for _, key := range []string{created.Key, rotated.Key} {
+		if _, err := apiKeyService.Authenticate(t.Context(), key); err != nil {
+			t.Errorf("Expected both keys to work during the grace period, but got %v", err)
+		}
+	}
+	now = now.Add(apiKeyService.RotationGrace)
+	if _, err := apiKeyService.Authenticate(t.Context(), created.Key); !errors.Is(err, ErrInvalidAPIKey) {
+		t.Errorf("Expected the replaced key to stop working after the grace period, but got %v", err)
+	}
+	if _, err := apiKeyService.Authenticate(t.Context(), rotated.Key); err != nil {
+		t.Errorf("Expected the new key to keep working, but got %v", err)
+	}
+
+	now = expires
+	if _, err := apiKeyService.Authenticate(t.Context(), rotated.Key); !errors.Is(err, ErrInvalidAPIKey) {
+		t.Errorf("Expected an expired key to return ErrInvalidAPIKey, but got %v", err)
+	}
+}
+
+func TestAPIKeyService_Revoke(t *testing.T) {
+	now := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	apiKeyService := newAPIKeyService(&now)
+	created, _ := apiKeyService.Create(t.Context(), viewmodels.APIKeyCreateViewModel{Name: "ci", Scopes: []string{"write"}})
+	rotated, _ := apiKeyService.Rotate(t.Context(), created.ID)
+
+	if err := apiKeyService.Revoke(t.Context(), created.ID); err != nil {
+		t.Fatalf("Expected Revoke to return nil error, but got %s", err.Error())
+	}
+	for _, key := range []string{created.Key, rotated.Key} {
+		if _, err := apiKeyService.Authenticate(t.Context(), key); !errors.Is(err, ErrInvalidAPIKey) {
+			t.Errorf("Expected a revoked key to return ErrInvalidAPIKey, but got %v", err)
+		}
+	}
+	if err := apiKeyService.Revoke(t.Context(), created.ID); err != nil {
+		t.Errorf("Expected revoking again to return nil error, but got %v", err)
+	}
+	if found, _ := apiKeyService.Get(t.Context(), created.ID); found.RevokedAt == nil || !found.RevokedAt.Equal(now) {
+		t.Errorf("Expected the revoked key to be kept with its RevokedAt, but got %v", found)
+	}
+	if _, err := apiKeyService.Rotate(t.Context(), created.ID); !errors.As(err, new(*ConflictError)) {
+		t.Errorf("Expected Rotate of a revoked key to return *ConflictError, but got %v", err)
+	}
+	if err := apiKeyService.Revoke(t.Context(), uuid.New()); !errors.As(err, new(*APIKeyNotFoundError)) {
+		t.Errorf("Expected Revoke of an unknown key to return *APIKeyNotFoundError, but got %v", err)
+	}
+}
+
+func TestAPIKeyService_EnsureBootstrapKey(t *testing.T) {
+	now := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	apiKeyService := newAPIKeyService(&now)
+	key := "bootstrap-0123456789abcdef"
+
+	if err := apiKeyService.EnsureBootstrapKey(t.Context(), "short"); err == nil {
+		t.Error("Expected a short bootstrap key to be refused")
+	}
+	for range 2 {
+		if err := apiKeyService.EnsureBootstrapKey(t.Context(), key); err != nil {
+			t.Fatalf("Expected EnsureBootstrapKey to return nil error, but got %s", err.Error())
+		}
+	}
+	keys, _ := apiKeyService.List(t.Context())
+	if len(keys) != 1 || keys[0].Name != BootstrapAPIKeyName || keys[0].Prefix != "boot" {
+		t.Fatalf("Expected one bootstrap key, but got %v", keys)
+	}
+	if principal, err := apiKeyService.Authenticate(t.Context(), key); err != nil || !principal.Allows(auth.ScopeAdmin) || !slices.Equal(principal.Roles, []string{BootstrapRole}) {
+		t.Errorf("Expected the bootstrap key to be an admin key, but got %v (%v)", principal, err)
+	}
+
+	// A bootstrap key stored before keys had roles becomes an admin
+	stored, _ := apiKeyService.repository.FindAPIKey(t.Context(), keys[0].ID)
+	stored.Roles = nil
+	apiKeyService.repository.ReplaceAPIKey(t.Context(), stored)
+	apiKeyService.EnsureBootstrapKey(t.Context(), key)
+	if principal, _ := apiKeyService.Authenticate(t.Context(), key); !slices.Equal(principal.Roles, []string{BootstrapRole}) {
+		t.Errorf("Expected the older bootstrap key to get the %s role, but got %v", BootstrapRole, principal.Roles)
+	}
+
+	apiKeyService.Revoke(t.Context(), keys[0].ID)
+	apiKeyService.EnsureBootstrapKey(t.Context(), key)
+	if _, err := apiKeyService.Authenticate(t.Context(), key); !errors.Is(err, ErrInvalidAPIKey) {
+		t.Errorf("Expected a revoked bootstrap key to stay revoked, but got %v", err)
+	}
+}
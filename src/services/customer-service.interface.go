@@ -1,15 +1,51 @@
 package services
 
 import (
+	"context"
+	"time"
+
+	"congdinh.com/crm/repositories"
 	viewmodels "congdinh.com/crm/view-models"
 	"github.com/google/uuid"
 )
 
-// ICustomerService defines the interface for customer service operations
+// CustomerQueryOptions selects a page of customers. Cursor, when set,
+// continues a previous query and takes precedence over Offset.
+type CustomerQueryOptions struct {
+	repositories.CustomerQuery
+	Cursor string
+}
+
+// ICustomerService defines the interface for customer service operations.
+// Create, Update, Patch, Delete and Restore report failures as
+// *NotFoundError, *ConflictError or *ValidationError; Patch also returns
+// *PatchError. Update, Patch, Delete and Restore take the version the caller
+// last saw, or 0 to skip the check, and return *PreconditionFailedError on a
+// mismatch.
+// Delete moves a customer to the trash: GetAll, GetById, Search and Query
+// leave it out, unless the query asks for Deleted customers, and Restore
+// brings it back.
+// Batch reports the same errors per operation, plus *NotAppliedError for the
+// operations of a failed atomic batch and *DependencyFailedError for those
+// building on a failed operation of a best-effort batch. Import reports the errors of Create
+// per customer.
+// Every write stamps the customer with the time and the auth.Principal of ctx
+// and is recorded in its history, then published to the functions passed to
+// Subscribe. GetHistory returns *NotFoundError for a customer that never
+// existed; GetAsOf returns nil like GetById.
 type ICustomerService interface {
-	GetAll() []viewmodels.CustomerViewModel
-	GetById(id uuid.UUID) *viewmodels.CustomerViewModel
-	Create(customer viewmodels.CustomerCreateViewModel) (viewmodels.CustomerViewModel, error)
-	Update(id uuid.UUID, customer viewmodels.CustomerEditViewModel) (viewmodels.CustomerViewModel, error)
-	Delete(id uuid.UUID) bool
+	GetAll(ctx context.Context) ([]viewmodels.CustomerViewModel, error)
+	Query(ctx context.Context, options CustomerQueryOptions) (viewmodels.CustomerPageViewModel, error)
+	Search(ctx context.Context, query string, limit int) ([]viewmodels.CustomerSearchResultViewModel, error)
+	GetById(ctx context.Context, id uuid.UUID) (*viewmodels.CustomerViewModel, error)
+	Create(ctx context.Context, customer viewmodels.CustomerCreateViewModel) (viewmodels.CustomerViewModel, error)
+	Update(ctx context.Context, id uuid.UUID, version int64, customer viewmodels.CustomerEditViewModel) (viewmodels.CustomerViewModel, error)
+	Patch(ctx context.Context, id uuid.UUID, version int64, patch CustomerPatch) (viewmodels.CustomerViewModel, error)
+	Delete(ctx context.Context, id uuid.UUID, version int64) error
+	Restore(ctx context.Context, id uuid.UUID, version int64) (viewmodels.CustomerViewModel, error)
+	Batch(ctx context.Context, operations []CustomerOperation, atomic bool) ([]CustomerOperationResult, error)
+	Import(ctx context.Context, customers []viewmodels.CustomerCreateViewModel, dryRun bool) ([]CustomerOperationResult, error)
+	GetHistory(ctx context.Context, id uuid.UUID, offset int, limit int) (viewmodels.CustomerHistoryPageViewModel, error)
+	GetAsOf(ctx context.Context, id uuid.UUID, at time.Time) (*viewmodels.CustomerViewModel, error)
+	Subscribe(publish func(viewmodels.CustomerEventViewModel))
 }
@@ -0,0 +1,212 @@
+package services
+
+import (
+	"context"
+	"errors"
+
+	"congdinh.com/crm/models"
+	"congdinh.com/crm/repositories"
+	"congdinh.com/crm/validation"
+	viewmodels "congdinh.com/crm/view-models"
+	"github.com/google/uuid"
+)
+
+// OperationKind is the write a CustomerOperation performs
+type OperationKind string
+
+const (
+	OperationCreate OperationKind = "create"
+	OperationUpdate OperationKind = "update"
+	OperationDelete OperationKind = "delete"
+)
+
+// CustomerOperation is one item of a batch. Create uses Customer, Update uses
+// ID and Customer, Delete uses ID and moves the customer to the trash. Version has the same meaning as for Update
+// and Delete; Customer.ID is ignored.
+type CustomerOperation struct {
+	Kind     OperationKind
+	ID       uuid.UUID
+	Version  int64
+	Customer viewmodels.CustomerEditViewModel
+}
+
+// CustomerOperationResult is the outcome of one operation of a batch.
+// Customer is set for stored creates and updates, Err for failed operations.
+type CustomerOperationResult struct {
+	Customer *viewmodels.CustomerViewModel
+	Err      error
+}
+
+// Batch method perform operations in order and return one result per
+// operation. Atomic batches are all or nothing: if any operation fails,
+// nothing is stored and the other operations report *NotAppliedError.
+// Otherwise every operation that can be stored is, and operations that build
+// on a failed operation on the same customer report *DependencyFailedError.
+// Operations fail with the same errors as Create, Update and Delete; the
+// returned error is set only when the batch as a whole could not run.
+func (cs *CustomerService) Batch(ctx context.Context, operations []CustomerOperation, atomic bool) ([]CustomerOperationResult, error) {
+	results := make([]CustomerOperationResult, len(operations))
+	changes := []repositories.CustomerChange{}
+	// positions maps each change to the operation it came from
+	positions := []int{}
+	// entries holds the history entry of each change
+	entries := []models.CustomerHistoryEntry{}
+	// depends holds the earlier change on the same customer each change
+	// builds on, or -1
+	depends := []int{}
+	last := map[uuid.UUID]int{}
+	// planned tracks the state each customer will be in after the changes so far
+	planned := map[uuid.UUID]models.Customer{}
+
+	for i, operation := range operations {
+		change, entry, err := cs.planOperation(ctx, operation, planned)
+		if err != nil && !isOperationError(err) {
+			return nil, err
+		}
+		if err != nil {
+			results[i].Err = err
+			if atomic {
+				return notApplied(results), nil
+			}
+			continue
+		}
+		dependency, ok := last[change.Customer.ID]
+		if !ok {
+			dependency = -1
+		}
+		last[change.Customer.ID] = len(changes)
+		changes = append(changes, change)
+		positions = append(positions, i)
+		entries = append(entries, entry)
+		depends = append(depends, dependency)
+	}
+	if len(changes) == 0 {
+		return results, nil
+	}
+
+	if err := cs.addDeliveries(ctx, changes, entries); err != nil {
+		return nil, err
+	}
+	errs, err := cs.repository.Apply(ctx, changes, atomic)
+	if err != nil {
+		return nil, err
+	}
+
+	applied := []models.CustomerHistoryEntry{}
+	for j, change := range changes {
+		i := positions[j]
+		operation := operations[i]
+		if errs[j] == nil {
+			applied = append(applied, entries[j])
+			cs.index.Put(change.Customer)
+		}
+		switch {
+		case errs[j] == nil && isDeleted(change.Customer):
+		case errs[j] == nil:
+			customer := toCustomerViewModel(change.Customer)
+			results[i].Customer = &customer
+		case errors.Is(errs[j], repositories.ErrChangeNotApplied):
+			results[i].Err = &NotAppliedError{}
+		case depends[j] >= 0 && errs[depends[j]] != nil:
+			// The change was planned on a state that was never stored
+			results[i].Err = &DependencyFailedError{Operation: positions[depends[j]]}
+		case errors.Is(errs[j], repositories.ErrVersionConflict) && operation.Version == 0:
+			results[i].Err = &ConflictError{Reason: "the customer was modified concurrently", Err: errs[j]}
+		case errors.Is(errs[j], repositories.ErrVersionConflict):
+			results[i].Err = &PreconditionFailedError{ID: operation.ID, Version: operation.Version}
+		default:
+			results[i].Err = domainError(change.Customer.ID, errs[j])
+		}
+	}
+	cs.record(ctx, applied...)
+	return results, nil
+}
+
+// planOperation validates operation and turns it into a repository change
+// and the history entry recording it. Updates and deletes are based on the
+// state the customer will be in when the change runs, so several operations
+// on a customer in one batch chain.
+func (cs *CustomerService) planOperation(ctx context.Context, operation CustomerOperation, planned map[uuid.UUID]models.Customer) (repositories.CustomerChange, models.CustomerHistoryEntry, error) {
+	switch operation.Kind {
+	case OperationCreate:
+		if err := validate(operation.Customer); err != nil {
+			return repositories.CustomerChange{}, models.CustomerHistoryEntry{}, err
+		}
+		customer := cs.created(ctx, fromCustomerEditViewModel(uuid.New(), operation.Customer, 1))
+		planned[customer.ID] = customer
+		return repositories.CustomerChange{Kind: repositories.ChangeInsert, Customer: customer}, historyEntry(models.HistoryCreate, nil, customer), nil
+
+	case OperationUpdate, OperationDelete:
+		if operation.Kind == OperationUpdate {
+			if err := validate(operation.Customer); err != nil {
+				return repositories.CustomerChange{}, models.CustomerHistoryEntry{}, err
+			}
+		}
+		current, ok := planned[operation.ID]
+		if !ok {
+			stored, err := cs.repository.Find(ctx, operation.ID)
+			if err != nil {
+				return repositories.CustomerChange{}, models.CustomerHistoryEntry{}, domainError(operation.ID, err)
+			}
+			current = stored
+		}
+		if isDeleted(current) {
+			return repositories.CustomerChange{}, models.CustomerHistoryEntry{}, &NotFoundError{ID: operation.ID}
+		}
+		if operation.Version != 0 && operation.Version != current.Version {
+			return repositories.CustomerChange{}, models.CustomerHistoryEntry{}, &PreconditionFailedError{ID: operation.ID, Version: operation.Version}
+		}
+
+		customer := current
+		historyOperation := models.HistoryDelete
+		if operation.Kind == OperationUpdate {
+			customer = fromCustomerEditViewModel(operation.ID, operation.Customer, 0)
+			historyOperation = models.HistoryUpdate
+		} else {
+			deletedAt := cs.timestamp()
+			customer.DeletedAt = &deletedAt
+		}
+		customer = cs.updated(ctx, current, customer)
+		customer.Version = current.Version + 1
+		planned[operation.ID] = customer
+		change := repositories.CustomerChange{Kind: repositories.ChangeReplace, Customer: customer, ExpectedVersion: current.Version}
+		return change, historyEntry(historyOperation, &current, customer), nil
+	}
+
+	return repositories.CustomerChange{}, models.CustomerHistoryEntry{}, &ValidationError{
+		Errors: validation.Errors{{Field: "Op", Reason: "must be one of create|update|delete"}},
+	}
+}
+
+// isOperationError reports whether err rejects a single operation rather than the whole batch
+func isOperationError(err error) bool {
+	var (
+		notFound   *NotFoundError
+		validation *ValidationError
+		stale      *PreconditionFailedError
+	)
+	return errors.As(err, &notFound) || errors.As(err, &validation) || errors.As(err, &stale)
+}
+
+// fromCustomerEditViewModel builds the stored customer id at version from its edited fields
+func fromCustomerEditViewModel(id uuid.UUID, customer viewmodels.CustomerEditViewModel, version int64) models.Customer {
+	return models.Customer{
+		ID:        id,
+		Name:      customer.Name,
+		Role:      customer.Role,
+		Email:     customer.Email,
+		Phone:     customer.Phone,
+		Contacted: customer.Contacted,
+		Version:   version,
+	}
+}
+
+// notApplied marks every operation without an error as not applied
+func notApplied(results []CustomerOperationResult) []CustomerOperationResult {
+	for i := range results {
+		if results[i].Err == nil {
+			results[i].Err = &NotAppliedError{}
+		}
+	}
+	return results
+}
@@ -0,0 +1,84 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"congdinh.com/crm/models"
+	"congdinh.com/crm/repositories"
+)
+
+// ErrInvalidCursor is returned when a pagination cursor cannot be used for the query
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// pageCursor is the payload of the opaque cursor handed to clients: the
+// sort key and insertion sequence of the last customer of a page, so the
+// next page starts after it however the customers before it changed. It is
+// tied to the filters and sort order it was issued for.
+type pageCursor struct {
+	Key      []any  `json:"k"`
+	Sequence int64  `json:"s"`
+	Query    string `json:"q"`
+}
+
+// queryFingerprint identifies the filters and sort order of a query
+func queryFingerprint(query repositories.CustomerQuery) string {
+	contacted := "any"
+	if query.Contacted != nil {
+		contacted = fmt.Sprint(*query.Contacted)
+	}
+	updatedSince := ""
+	if !query.UpdatedSince.IsZero() {
+		updatedSince = query.UpdatedSince.UTC().Format(time.RFC3339Nano)
+	}
+	key := fmt.Sprintf("%v|%s|%s|%s|%s|%s|%t", query.Sort, query.Role, contacted, query.NamePrefix, query.EmailPrefix, updatedSince, query.Deleted)
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:8])
+}
+
+// encodeCursor returns the cursor of the page of query after last, which
+// was inserted as sequence
+func encodeCursor(last models.Customer, sequence int64, query repositories.CustomerQuery) string {
+	payload := pageCursor{Key: []any{}, Sequence: sequence, Query: queryFingerprint(query)}
+	for _, field := range query.Sort {
+		payload.Key = append(payload.Key, repositories.SortValue(last, field.Field))
+	}
+	data, _ := json.Marshal(payload)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor returns query continuing after the customer whose sort
+// fields and insertion sequence are stored in cursor, after checking the
+// cursor was issued for query
+func decodeCursor(cursor string, query repositories.CustomerQuery) (repositories.CustomerQuery, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return query, ErrInvalidCursor
+	}
+
+	var payload pageCursor
+	if err := json.Unmarshal(data, &payload); err != nil {
+		return query, ErrInvalidCursor
+	}
+	if payload.Query != queryFingerprint(query) {
+		return query, fmt.Errorf("%w: it was issued for different filters or sort order", ErrInvalidCursor)
+	}
+	if len(payload.Key) != len(query.Sort) {
+		return query, ErrInvalidCursor
+	}
+	last := models.Customer{}
+	for i, field := range query.Sort {
+		if !repositories.SetSortValue(&last, field.Field, payload.Key[i]) {
+			return query, ErrInvalidCursor
+		}
+	}
+	query.After = &last
+	query.AfterSequence = payload.Sequence
+	query.Offset = 0
+	return query, nil
+}
@@ -0,0 +1,114 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+
+	"congdinh.com/crm/repositories"
+	"congdinh.com/crm/validation"
+	"github.com/google/uuid"
+)
+
+// NotFoundError is returned when the requested customer does not exist
+type NotFoundError struct {
+	ID uuid.UUID
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("customer %s not found", e.ID)
+}
+
+// Unwrap lets errors.Is match repositories.ErrCustomerNotFound
+func (e *NotFoundError) Unwrap() error {
+	return repositories.ErrCustomerNotFound
+}
+
+// ConflictError is returned when a change clashes with the stored customers,
+// e.g. a duplicate email or phone number
+type ConflictError struct {
+	Reason string
+	Err    error
+}
+
+func (e *ConflictError) Error() string {
+	return e.Reason
+}
+
+func (e *ConflictError) Unwrap() error {
+	return e.Err
+}
+
+// PreconditionFailedError is returned when a write expected a version the
+// customer is no longer at
+type PreconditionFailedError struct {
+	ID      uuid.UUID
+	Version int64
+}
+
+func (e *PreconditionFailedError) Error() string {
+	return fmt.Sprintf("customer %s is no longer at version %d", e.ID, e.Version)
+}
+
+func (e *PreconditionFailedError) Unwrap() error {
+	return repositories.ErrVersionConflict
+}
+
+// NotAppliedError is reported for the operations of an atomic batch that
+// were rolled back because another operation failed
+type NotAppliedError struct{}
+
+func (e *NotAppliedError) Error() string {
+	return repositories.ErrChangeNotApplied.Error()
+}
+
+func (e *NotAppliedError) Unwrap() error {
+	return repositories.ErrChangeNotApplied
+}
+
+// DependencyFailedError is reported for the operations of a best-effort
+// batch that were skipped because an earlier operation on the same customer,
+// which they build on, failed. Operation is the index of that operation.
+type DependencyFailedError struct {
+	Operation int
+}
+
+func (e *DependencyFailedError) Error() string {
+	return fmt.Sprintf("operation %d on the same customer failed", e.Operation)
+}
+
+func (e *DependencyFailedError) Unwrap() error {
+	return repositories.ErrChangeNotApplied
+}
+
+// ValidationError is returned when the customer fails input validation
+type ValidationError struct {
+	Errors validation.Errors
+}
+
+func (e *ValidationError) Error() string {
+	return e.Errors.Error()
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Errors
+}
+
+// validate checks v against its validate tags and wraps failures in a ValidationError
+func validate(v any) error {
+	var errs validation.Errors
+	if err := validation.Validate(v); errors.As(err, &errs) {
+		return &ValidationError{Errors: errs}
+	}
+	return nil
+}
+
+// domainError translates repository errors about customer id into the typed service errors
+func domainError(id uuid.UUID, err error) error {
+	switch {
+	case errors.Is(err, repositories.ErrCustomerNotFound):
+		return &NotFoundError{ID: id}
+	case errors.Is(err, repositories.ErrCustomerExists):
+		return &ConflictError{Reason: duplicateCustomer, Err: err}
+	}
+	return err
+}
@@ -0,0 +1,94 @@
+package services
+
+import (
+	"context"
+
+	"congdinh.com/crm/models"
+	"congdinh.com/crm/repositories"
+	viewmodels "congdinh.com/crm/view-models"
+)
+
+// The types of the events published for stored changes
+const (
+	EventCreated  = "created"
+	EventUpdated  = "updated"
+	EventDeleted  = "deleted"
+	EventRestored = "restored"
+)
+
+var eventTypes = map[models.HistoryOperation]string{
+	models.HistoryCreate:  EventCreated,
+	models.HistoryUpdate:  EventUpdated,
+	models.HistoryDelete:  EventDeleted,
+	models.HistoryRestore: EventRestored,
+}
+
+// Subscribe registers publish to be called with every change once it is
+// stored. publish runs on the goroutine of the write before the write
+// returns, so it must be quick; concurrent writes may publish out of order,
+// which the customer Version of each event tells apart.
+func (cs *CustomerService) Subscribe(publish func(viewmodels.CustomerEventViewModel)) {
+	cs.subscribersMu.Lock()
+	defer cs.subscribersMu.Unlock()
+
+	cs.subscribers = append(cs.subscribers, publish)
+}
+
+// record publishes entries of changes that are already stored, and recorded
+// in the history with them, to the subscribers
+func (cs *CustomerService) record(ctx context.Context, entries ...models.CustomerHistoryEntry) {
+	cs.subscribersMu.RLock()
+	defer cs.subscribersMu.RUnlock()
+
+	for _, entry := range entries {
+		event := toCustomerEventViewModel(entry)
+		for _, publish := range cs.subscribers {
+			publish(event)
+		}
+	}
+}
+
+// addDeliveries gives each change its history entry and, when there is an
+// Outbox, the webhook deliveries of the entry, so they are stored with the
+// change
+func (cs *CustomerService) addDeliveries(ctx context.Context, changes []repositories.CustomerChange, entries []models.CustomerHistoryEntry) error {
+	for i := range changes {
+		changes[i].History = &entries[i]
+	}
+	if cs.Outbox == nil {
+		return nil
+	}
+	deliveries, err := cs.Outbox(ctx, entries)
+	if err != nil {
+		return err
+	}
+	for i := range changes {
+		changes[i].Deliveries = deliveries[i]
+	}
+	return nil
+}
+
+// store performs a single change together with its history entry and its
+// webhook deliveries
+func (cs *CustomerService) store(ctx context.Context, change repositories.CustomerChange, entry models.CustomerHistoryEntry) error {
+	changes := []repositories.CustomerChange{change}
+	if err := cs.addDeliveries(ctx, changes, []models.CustomerHistoryEntry{entry}); err != nil {
+		return err
+	}
+	errs, err := cs.repository.Apply(ctx, changes, true)
+	if err != nil {
+		return err
+	}
+	return errs[0]
+}
+
+func toCustomerEventViewModel(entry models.CustomerHistoryEntry) viewmodels.CustomerEventViewModel {
+	history := toCustomerHistoryEntryViewModel(entry)
+	return viewmodels.CustomerEventViewModel{
+		Type:      eventTypes[entry.Operation],
+		Actor:     history.Actor,
+		Timestamp: history.Timestamp,
+		Customer:  toCustomerViewModel(entry.Customer),
+		Changes:   history.Changes,
+	}
+}
@@ -0,0 +1,114 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"congdinh.com/crm/models"
+	"congdinh.com/crm/repositories"
+	viewmodels "congdinh.com/crm/view-models"
+	"github.com/google/uuid"
+)
+
+// historyField is a customer field whose changes are recorded in the history
+type historyField struct {
+	name  string
+	value func(models.Customer) any
+}
+
+var historyFields = []historyField{
+	{"Name", func(c models.Customer) any { return c.Name }},
+	{"Role", func(c models.Customer) any { return c.Role }},
+	{"Email", func(c models.Customer) any { return c.Email }},
+	{"Phone", func(c models.Customer) any { return c.Phone }},
+	{"Contacted", func(c models.Customer) any { return c.Contacted }},
+	{"DeletedAt", func(c models.Customer) any {
+		if c.DeletedAt == nil {
+			return nil
+		}
+		return *c.DeletedAt
+	}},
+}
+
+// historyEntry describes the change from before, nil for a new customer, to
+// after. The actor and time are the ones after was stamped with.
+func historyEntry(operation models.HistoryOperation, before *models.Customer, after models.Customer) models.CustomerHistoryEntry {
+	entry := models.CustomerHistoryEntry{
+		CustomerID: after.ID,
+		Version:    after.Version,
+		Operation:  operation,
+		Actor:      after.UpdatedBy,
+		Timestamp:  after.UpdatedAt,
+		Changes:    []models.FieldChange{},
+		Customer:   after,
+	}
+	for _, field := range historyFields {
+		change := models.FieldChange{Field: field.name, After: field.value(after)}
+		if before != nil {
+			change.Before = field.value(*before)
+		}
+		if change.Before != change.After {
+			entry.Changes = append(entry.Changes, change)
+		}
+	}
+	return entry
+}
+
+// GetHistory method return a page of the changes made to customer id, newest
+// first. The history outlives the customer: it is kept in the trash and
+// after the purge.
+func (cs *CustomerService) GetHistory(ctx context.Context, id uuid.UUID, offset int, limit int) (viewmodels.CustomerHistoryPageViewModel, error) {
+	page, err := cs.History.History(ctx, id, offset, limit)
+	if err != nil {
+		return viewmodels.CustomerHistoryPageViewModel{}, err
+	}
+	if page.Total == 0 {
+		// Customers stored before the history existed have none
+		if _, err := cs.repository.Find(ctx, id); err != nil {
+			return viewmodels.CustomerHistoryPageViewModel{}, domainError(id, err)
+		}
+	}
+
+	result := viewmodels.CustomerHistoryPageViewModel{
+		Items:  []viewmodels.CustomerHistoryEntryViewModel{},
+		Total:  page.Total,
+		Offset: offset,
+		Limit:  limit,
+	}
+	for _, entry := range page.Entries {
+		result.Items = append(result.Items, toCustomerHistoryEntryViewModel(entry))
+	}
+	return result, nil
+}
+
+// GetAsOf method return customer id as it was at time at, or nil if it did
+// not exist or was in the trash then. Only changes recorded in the history
+// are known, so customers stored before the history existed are nil until
+// their first change.
+func (cs *CustomerService) GetAsOf(ctx context.Context, id uuid.UUID, at time.Time) (*viewmodels.CustomerViewModel, error) {
+	entry, err := cs.History.HistoryAsOf(ctx, id, at)
+	if errors.Is(err, repositories.ErrCustomerNotFound) || err == nil && isDeleted(entry.Customer) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	customer := toCustomerViewModel(entry.Customer)
+	return &customer, nil
+}
+
+func toCustomerHistoryEntryViewModel(entry models.CustomerHistoryEntry) viewmodels.CustomerHistoryEntryViewModel {
+	result := viewmodels.CustomerHistoryEntryViewModel{
+		Version:   entry.Version,
+		Operation: string(entry.Operation),
+		Actor:     entry.Actor,
+		Timestamp: entry.Timestamp,
+		Changes:   []viewmodels.FieldChangeViewModel{},
+	}
+	for _, change := range entry.Changes {
+		result.Changes = append(result.Changes, viewmodels.FieldChangeViewModel{Field: change.Field, Before: change.Before, After: change.After})
+	}
+	return result
+}
@@ -0,0 +1,78 @@
+package services
+
+import (
+	"context"
+
+	"congdinh.com/crm/models"
+	"congdinh.com/crm/repositories"
+	viewmodels "congdinh.com/crm/view-models"
+	"github.com/google/uuid"
+)
+
+// duplicateCustomer is the conflict Create reports for a taken email or phone
+const duplicateCustomer = "a customer with this email or phone already exists"
+
+// Import method create one customer per item and return one result per item,
+// like a best-effort Batch of creates. Items are rejected by the same rules
+// as Create, including the duplicate check against the stored customers and
+// the items before them. A dry run checks the items without storing anything;
+// its results carry the customers that would be created, without an ID.
+func (cs *CustomerService) Import(ctx context.Context, customers []viewmodels.CustomerCreateViewModel, dryRun bool) ([]CustomerOperationResult, error) {
+	if !dryRun {
+		operations := []CustomerOperation{}
+		for _, customer := range customers {
+			edit := toCustomerEditViewModel(fromCustomerCreateViewModel(uuid.Nil, customer))
+			operations = append(operations, CustomerOperation{Kind: OperationCreate, Customer: edit})
+		}
+		return cs.Batch(ctx, operations, false)
+	}
+
+	results := make([]CustomerOperationResult, len(customers))
+	emails := map[string]bool{}
+	phones := map[string]bool{}
+	for i, customer := range customers {
+		if err := validate(customer); err != nil {
+			results[i].Err = err
+			continue
+		}
+
+		duplicate := emails[customer.Email] || phones[customer.Phone]
+		if !duplicate {
+			exists, err := cs.repository.ExistsByEmail(ctx, customer.Email)
+			if err != nil {
+				return nil, err
+			}
+			duplicate = exists
+		}
+		if !duplicate {
+			exists, err := cs.repository.ExistsByPhone(ctx, customer.Phone)
+			if err != nil {
+				return nil, err
+			}
+			duplicate = exists
+		}
+		if duplicate {
+			results[i].Err = &ConflictError{Reason: duplicateCustomer, Err: repositories.ErrCustomerExists}
+			continue
+		}
+
+		emails[customer.Email] = true
+		phones[customer.Phone] = true
+		preview := toCustomerViewModel(fromCustomerCreateViewModel(uuid.Nil, customer))
+		results[i].Customer = &preview
+	}
+	return results, nil
+}
+
+// fromCustomerCreateViewModel builds the first version of the stored customer id
+func fromCustomerCreateViewModel(id uuid.UUID, customer viewmodels.CustomerCreateViewModel) models.Customer {
+	return models.Customer{
+		ID:        id,
+		Name:      customer.Name,
+		Role:      customer.Role,
+		Email:     customer.Email,
+		Phone:     customer.Phone,
+		Contacted: customer.Contacted,
+		Version:   1,
+	}
+}
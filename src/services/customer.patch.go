@@ -0,0 +1,135 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+
+	viewmodels "congdinh.com/crm/view-models"
+	jsonpatch "github.com/evanphx/json-patch/v5"
+)
+
+// Media types of the patch documents accepted by Patch
+const (
+	MergePatchContentType = "application/merge-patch+json"
+	JSONPatchContentType  = "application/json-patch+json"
+)
+
+// CustomerPatch is a patch document for one customer. ContentType selects
+// between JSON Merge Patch (RFC 7396) and JSON Patch (RFC 6902); both
+// address the fields of viewmodels.CustomerEditViewModel, e.g. /Contacted.
+type CustomerPatch struct {
+	ContentType string
+	Document    []byte
+}
+
+// PatchError is returned when a patch document is malformed or cannot be
+// applied to the customer, e.g. a failing test operation or an unknown field
+type PatchError struct {
+	Err error
+}
+
+func (e *PatchError) Error() string {
+	return "invalid patch: " + e.Err.Error()
+}
+
+func (e *PatchError) Unwrap() error {
+	return e.Err
+}
+
+// editFields are the JSON member names of CustomerEditViewModel
+var editFields = func() map[string]bool {
+	fields := map[string]bool{}
+	editType := reflect.TypeOf(viewmodels.CustomerEditViewModel{})
+	for i := 0; i < editType.NumField(); i++ {
+		fields[editType.Field(i).Name] = true
+	}
+	return fields
+}()
+
+// Fields returns the names of the fields the patch reads or writes, in
+// order. An operation on the whole customer names every field. A malformed
+// document names none, apply rejects it.
+func (p CustomerPatch) Fields() []string {
+	var fields []string
+	switch p.ContentType {
+	case MergePatchContentType:
+		if !json.Valid(p.Document) {
+			return nil
+		}
+		var members map[string]json.RawMessage
+		if err := json.Unmarshal(p.Document, &members); err != nil || members == nil {
+			// Anything but an object replaces the whole customer
+			return slices.Sorted(maps.Keys(editFields))
+		}
+		fields = slices.Collect(maps.Keys(members))
+	case JSONPatchContentType:
+		var operations []struct {
+			Path *string `json:"path"`
+			From *string `json:"from"`
+		}
+		if err := json.Unmarshal(p.Document, &operations); err != nil {
+			return nil
+		}
+		for _, operation := range operations {
+			for _, pointer := range []*string{operation.Path, operation.From} {
+				if pointer == nil {
+					continue
+				}
+				if *pointer == "" {
+					return slices.Sorted(maps.Keys(editFields))
+				}
+				field, _, _ := strings.Cut(strings.TrimPrefix(*pointer, "/"), "/")
+				fields = append(fields, strings.NewReplacer("~1", "/", "~0", "~").Replace(field))
+			}
+		}
+	}
+	slices.Sort(fields)
+	return slices.Compact(fields)
+}
+
+// apply returns customer with the patch applied
+func (p CustomerPatch) apply(customer viewmodels.CustomerEditViewModel) (viewmodels.CustomerEditViewModel, error) {
+	original, err := json.Marshal(customer)
+	if err != nil {
+		return customer, err
+	}
+
+	var patched []byte
+	switch p.ContentType {
+	case MergePatchContentType:
+		patched, err = jsonpatch.MergePatch(original, p.Document)
+	case JSONPatchContentType:
+		var operations jsonpatch.Patch
+		operations, err = jsonpatch.DecodePatch(p.Document)
+		if err == nil {
+			patched, err = operations.Apply(original)
+		}
+	default:
+		return customer, fmt.Errorf("unsupported patch content type %q", p.ContentType)
+	}
+	if err != nil {
+		return customer, &PatchError{Err: err}
+	}
+
+	// encoding/json matches member names case-insensitively, so reject
+	// anything that is not exactly a field rather than guess
+	members := map[string]json.RawMessage{}
+	if err := json.Unmarshal(patched, &members); err != nil {
+		return customer, &PatchError{Err: err}
+	}
+	for name := range members {
+		if !editFields[name] {
+			return customer, &PatchError{Err: fmt.Errorf("unknown field %q", name)}
+		}
+	}
+
+	result := viewmodels.CustomerEditViewModel{}
+	if err := json.Unmarshal(patched, &result); err != nil {
+		return customer, &PatchError{Err: err}
+	}
+	return result, nil
+}
@@ -0,0 +1,36 @@
+package services
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Purge method permanently delete the customers that have been in the trash
+// for longer than retention and return how many were deleted
+func (cs *CustomerService) Purge(ctx context.Context, retention time.Duration) (int, error) {
+	return cs.repository.Purge(ctx, cs.timestamp().Add(-retention))
+}
+
+// RunPurge calls Purge every interval until ctx is done
+func (cs *CustomerService) RunPurge(ctx context.Context, interval time.Duration, retention time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		purged, err := cs.Purge(ctx, retention)
+		if err != nil {
+			log.Printf("Failed to purge deleted customers: %v", err)
+			continue
+		}
+		if purged > 0 {
+			log.Printf("Purged %d customers deleted more than %s ago", purged, retention)
+		}
+	}
+}
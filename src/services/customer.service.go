@@ -2,160 +2,333 @@ package services
 
 // import Customer struct from models/customer.go
 import (
-	"encoding/json"
+	"context"
 	"errors"
-	"io"
 	"log"
-	"os"
-	"path"
-	"runtime"
+	"slices"
+	"sync"
+	"time"
 
+	"congdinh.com/crm/auth"
+	"congdinh.com/crm/clock"
 	"congdinh.com/crm/models"
+	"congdinh.com/crm/repositories"
+	"congdinh.com/crm/search"
+	"congdinh.com/crm/validation"
 	viewmodels "congdinh.com/crm/view-models"
 	"github.com/google/uuid"
 )
 
 // CustomerService struct
 type CustomerService struct {
-	Customers []models.Customer
+	repository repositories.ICustomerRepository
+	index      *search.CustomerIndex
+	// Clock stamps when customers are created, updated and deleted
+	Clock clock.Clock
+	// History is where the repository records every change with it; it
+	// only lives in memory for repositories that do not record changes
+	History repositories.ICustomerHistoryRepository
+	// Outbox, when set, returns the webhook deliveries each change raises,
+	// which the repository stores in the transaction of the change. Only the
+	// repositories that store webhooks too, the SQL ones, accept them.
+	Outbox func(ctx context.Context, entries []models.CustomerHistoryEntry) ([][]models.WebhookDelivery, error)
+
+	subscribersMu sync.RWMutex
+	subscribers   []func(viewmodels.CustomerEventViewModel)
 }
 
-func readData() []models.Customer {
-	// Open file
-	_, filename, _, _ := runtime.Caller(0)
-	dir := path.Join(path.Dir(filename), "../data/customers.json")
-	file, err := os.Open(dir)
-	if err != nil {
-		log.Fatalf("Failed to open file: %v", err)
+// NewCustomerService creates a customer service on top of the given repository
+// and builds the search index from the stored customers
+func NewCustomerService(repository repositories.ICustomerRepository) *CustomerService {
+	cs := &CustomerService{
+		repository: repository,
+		index:      search.NewCustomerIndex(),
+		Clock:      clock.System,
+		History:    repositories.NewMemoryCustomerHistoryRepository(),
+	}
+	if recorder, ok := repository.(repositories.ICustomerHistoryRecorder); ok {
+		cs.History = recorder.CustomerHistory()
 	}
-	defer file.Close()
 
-	// Read file content
-	data, err := io.ReadAll(file)
+	customers, err := repository.List(context.Background())
 	if err != nil {
-		log.Fatalf("Failed to read file: %v", err)
+		log.Printf("Failed to build the search index: %v", err)
 	}
+	cs.index.Reset(slices.DeleteFunc(customers, isDeleted))
 
-	// Unmarshal JSON data into struct
-	var customers []models.Customer
-	err = json.Unmarshal(data, &customers)
-	if err != nil {
-		log.Fatalf("Failed to unmarshal JSON: %v", err)
+	return cs
+}
+
+func toCustomerViewModel(customer models.Customer) viewmodels.CustomerViewModel {
+	return viewmodels.CustomerViewModel{
+		ID:        customer.ID,
+		Name:      customer.Name,
+		Role:      customer.Role,
+		Email:     customer.Email,
+		Phone:     customer.Phone,
+		Contacted: customer.Contacted,
+		Version:   customer.Version,
+		CreatedAt: customer.CreatedAt,
+		UpdatedAt: customer.UpdatedAt,
+		CreatedBy: customer.CreatedBy,
+		UpdatedBy: customer.UpdatedBy,
+		DeletedAt: customer.DeletedAt,
 	}
+}
 
-	return customers
+// isDeleted reports whether customer is in the trash
+func isDeleted(customer models.Customer) bool {
+	return customer.DeletedAt != nil
 }
 
-func NewCustomerService() *CustomerService {
-	return &CustomerService{
-		Customers: readData(),
+func toCustomerEditViewModel(customer models.Customer) viewmodels.CustomerEditViewModel {
+	return viewmodels.CustomerEditViewModel{
+		ID:        customer.ID,
+		Name:      customer.Name,
+		Role:      customer.Role,
+		Email:     customer.Email,
+		Phone:     customer.Phone,
+		Contacted: customer.Contacted,
 	}
 }
 
-// GetAl method return all customers
-func (cs *CustomerService) GetAll() []viewmodels.CustomerViewModel {
+// GetAl method return all customers that are not in the trash
+func (cs *CustomerService) GetAll(ctx context.Context) ([]viewmodels.CustomerViewModel, error) {
+	customers, err := cs.repository.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	customerViewModels := []viewmodels.CustomerViewModel{}
-	for _, customer := range cs.Customers {
-		customerViewModel := viewmodels.CustomerViewModel{
-			ID:        customer.ID,
-			Name:      customer.Name,
-			Role:      customer.Role,
-			Email:     customer.Email,
-			Phone:     customer.Phone,
-			Contacted: customer.Contacted,
+	for _, customer := range slices.DeleteFunc(customers, isDeleted) {
+		customerViewModels = append(customerViewModels, toCustomerViewModel(customer))
+	}
+	return customerViewModels, nil
+}
+
+// Query method return a filtered, sorted page of customers. A cursor
+// continues after the last customer of the previous page, so customers
+// added or removed before it neither repeat nor get skipped.
+func (cs *CustomerService) Query(ctx context.Context, options CustomerQueryOptions) (viewmodels.CustomerPageViewModel, error) {
+	query := options.CustomerQuery
+	if options.Cursor != "" {
+		var err error
+		if query, err = decodeCursor(options.Cursor, query); err != nil {
+			return viewmodels.CustomerPageViewModel{}, err
 		}
-		customerViewModels = append(customerViewModels, customerViewModel)
-	}
-	return customerViewModels
-}
-
-// GetById method return a customer by ID
-func (cs *CustomerService) GetById(id uuid.UUID) *viewmodels.CustomerViewModel {
-	for _, customer := range cs.Customers {
-		if customer.ID == id {
-			customerViewModel := viewmodels.CustomerViewModel{
-				ID:        customer.ID,
-				Name:      customer.Name,
-				Role:      customer.Role,
-				Email:     customer.Email,
-				Phone:     customer.Phone,
-				Contacted: customer.Contacted,
-			}
-			return &customerViewModel
+	}
+
+	// One customer more than the page tells whether there is a next page
+	limited := query
+	if query.Limit > 0 {
+		limited.Limit++
+	}
+	page, err := cs.repository.Query(ctx, limited)
+	if err != nil {
+		return viewmodels.CustomerPageViewModel{}, err
+	}
+	customers := page.Customers
+	if query.Limit > 0 && len(customers) > query.Limit {
+		customers = customers[:query.Limit]
+	}
+
+	result := viewmodels.CustomerPageViewModel{
+		Items:  []viewmodels.CustomerViewModel{},
+		Total:  page.Total,
+		Offset: query.Offset,
+		Limit:  query.Limit,
+	}
+	for _, customer := range customers {
+		result.Items = append(result.Items, toCustomerViewModel(customer))
+	}
+
+	if len(customers) < len(page.Customers) {
+		result.NextCursor = encodeCursor(customers[len(customers)-1], page.Sequences[len(customers)-1], query)
+	}
+	return result, nil
+}
+
+// Search method return the customers best matching query, with highlighted matches
+func (cs *CustomerService) Search(ctx context.Context, query string, limit int) ([]viewmodels.CustomerSearchResultViewModel, error) {
+	results := []viewmodels.CustomerSearchResultViewModel{}
+	for _, match := range cs.index.Search(query, limit) {
+		// Read the customer from the repository so results are never staler than GetById
+		customer, err := cs.repository.Find(ctx, match.ID)
+		if errors.Is(err, repositories.ErrCustomerNotFound) || err == nil && isDeleted(customer) {
+			continue
+		}
+		if err != nil {
+			return nil, err
 		}
+
+		results = append(results, viewmodels.CustomerSearchResultViewModel{
+			Customer:   toCustomerViewModel(customer),
+			Score:      match.Score,
+			Highlights: match.Highlights,
+		})
+	}
+	return results, nil
+}
+
+// GetById method return a customer by ID, or nil if there is none or it is in the trash
+func (cs *CustomerService) GetById(ctx context.Context, id uuid.UUID) (*viewmodels.CustomerViewModel, error) {
+	customer, err := cs.repository.Find(ctx, id)
+	if errors.Is(err, repositories.ErrCustomerNotFound) || err == nil && isDeleted(customer) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
 	}
-	return nil
+
+	customerViewModel := toCustomerViewModel(customer)
+	return &customerViewModel, nil
 }
 
 // Create method create a new customer
-func (cs *CustomerService) Create(customerCreateViewModel viewmodels.CustomerCreateViewModel) (viewmodels.CustomerViewModel, error) {
-	// Check if the customer id already exists
-	for _, c := range cs.Customers {
-		if c.Email == customerCreateViewModel.Email || c.Phone == customerCreateViewModel.Phone {
-			return viewmodels.CustomerViewModel{}, errors.New("customer already exists")
-		}
+func (cs *CustomerService) Create(ctx context.Context, customerCreateViewModel viewmodels.CustomerCreateViewModel) (viewmodels.CustomerViewModel, error) {
+	if err := validate(customerCreateViewModel); err != nil {
+		return viewmodels.CustomerViewModel{}, err
 	}
 
-	newCustomer := models.Customer{
-		ID:        uuid.New(),
-		Name:      customerCreateViewModel.Name,
-		Role:      customerCreateViewModel.Role,
-		Email:     customerCreateViewModel.Email,
-		Phone:     customerCreateViewModel.Phone,
-		Contacted: customerCreateViewModel.Contacted,
+	newCustomer := cs.created(ctx, fromCustomerCreateViewModel(uuid.New(), customerCreateViewModel))
+	entry := historyEntry(models.HistoryCreate, nil, newCustomer)
+
+	// The repository rejects customers whose email or phone already exists
+	if err := cs.store(ctx, repositories.CustomerChange{Kind: repositories.ChangeInsert, Customer: newCustomer}, entry); err != nil {
+		return viewmodels.CustomerViewModel{}, domainError(newCustomer.ID, err)
 	}
+	cs.index.Put(newCustomer)
+	cs.record(ctx, entry)
+
+	return toCustomerViewModel(newCustomer), nil
+}
 
-	cs.Customers = append(cs.Customers, newCustomer)
+// maxWriteAttempts bounds how often a write without an expected version is
+// retried when a concurrent write changes the customer underneath it
+const maxWriteAttempts = 3
 
-	customerViewModel := viewmodels.CustomerViewModel{
-		ID:        newCustomer.ID,
-		Name:      newCustomer.Name,
-		Role:      newCustomer.Role,
-		Email:     newCustomer.Email,
-		Phone:     newCustomer.Phone,
-		Contacted: newCustomer.Contacted,
+// Update method update a customer by ID. A version other than 0 must match
+// the stored version, otherwise Update returns a *PreconditionFailedError.
+func (cs *CustomerService) Update(ctx context.Context, id uuid.UUID, version int64, customer viewmodels.CustomerEditViewModel) (viewmodels.CustomerViewModel, error) {
+	if err := validate(customer); err != nil {
+		return viewmodels.CustomerViewModel{}, err
 	}
 
-	return customerViewModel, nil
+	return cs.replace(ctx, id, version, models.HistoryUpdate, func(current models.Customer) (models.Customer, error) {
+		return fromCustomerEditViewModel(id, customer, current.Version), nil
+	})
 }
 
-// Update method update a customer by ID
-func (cs *CustomerService) Update(id uuid.UUID, customer viewmodels.CustomerEditViewModel) (viewmodels.CustomerViewModel, error) {
-	var updatedCustomer models.Customer
-	for i, c := range cs.Customers {
-		if c.ID == id {
-			updatedCustomer = models.Customer{
-				ID:        id,
-				Name:      customer.Name,
-				Role:      customer.Role,
-				Email:     customer.Email,
-				Phone:     customer.Phone,
-				Contacted: customer.Contacted,
+// Patch method apply a partial update to a customer by ID. The patched
+// customer is validated like a full Update and version is checked the same way.
+func (cs *CustomerService) Patch(ctx context.Context, id uuid.UUID, version int64, patch CustomerPatch) (viewmodels.CustomerViewModel, error) {
+	return cs.replace(ctx, id, version, models.HistoryUpdate, func(current models.Customer) (models.Customer, error) {
+		patched, err := patch.apply(toCustomerEditViewModel(current))
+		if err != nil {
+			return current, err
+		}
+		if patched.ID != id {
+			return current, &ValidationError{
+				Errors: validation.Errors{{Field: "ID", Reason: "cannot be changed"}},
 			}
-			cs.Customers[i] = updatedCustomer
-
-			customerViewModel := viewmodels.CustomerViewModel{
-				ID:        updatedCustomer.ID,
-				Name:      updatedCustomer.Name,
-				Role:      updatedCustomer.Role,
-				Email:     updatedCustomer.Email,
-				Phone:     updatedCustomer.Phone,
-				Contacted: updatedCustomer.Contacted,
+		}
+		if err := validate(patched); err != nil {
+			return current, err
+		}
+		return fromCustomerEditViewModel(id, patched, current.Version), nil
+	})
+}
+
+// replace reads the customer, lets change compute its new state and stores
+// the result only if nobody changed the customer in between, recording it in
+// the history as operation. The customer must be in the trash for a restore
+// and live otherwise, or it is not found. Without an expected version a lost
+// race is retried, so the last writer wins.
+func (cs *CustomerService) replace(ctx context.Context, id uuid.UUID, version int64, operation models.HistoryOperation, change func(models.Customer) (models.Customer, error)) (viewmodels.CustomerViewModel, error) {
+	inTrash := operation == models.HistoryRestore
+	for attempt := 1; ; attempt++ {
+		current, err := cs.repository.Find(ctx, id)
+		if err == nil && (current.DeletedAt != nil) != inTrash {
+			err = repositories.ErrCustomerNotFound
+		}
+		if err != nil {
+			return viewmodels.CustomerViewModel{}, domainError(id, err)
+		}
+		if version != 0 && current.Version != version {
+			return viewmodels.CustomerViewModel{}, &PreconditionFailedError{ID: id, Version: version}
+		}
+
+		updatedCustomer, err := change(current)
+		if err != nil {
+			return viewmodels.CustomerViewModel{}, err
+		}
+		updatedCustomer = cs.updated(ctx, current, updatedCustomer)
+		updatedCustomer.ID = id
+		updatedCustomer.Version = current.Version + 1
+		entry := historyEntry(operation, &current, updatedCustomer)
+
+		err = cs.store(ctx, repositories.CustomerChange{Kind: repositories.ChangeReplace, Customer: updatedCustomer, ExpectedVersion: current.Version}, entry)
+		if errors.Is(err, repositories.ErrVersionConflict) {
+			if version != 0 {
+				return viewmodels.CustomerViewModel{}, &PreconditionFailedError{ID: id, Version: version}
+			}
+			if attempt < maxWriteAttempts {
+				continue
 			}
-			return customerViewModel, nil
+			return viewmodels.CustomerViewModel{}, &ConflictError{Reason: "the customer was modified concurrently", Err: err}
 		}
+		if err != nil {
+			return viewmodels.CustomerViewModel{}, domainError(id, err)
+		}
+
+		cs.index.Put(updatedCustomer)
+		cs.record(ctx, entry)
+		return toCustomerViewModel(updatedCustomer), nil
 	}
+}
 
-	return viewmodels.CustomerViewModel{}, errors.New("customer not found")
+// Delete method move a customer by ID to the trash, where it stays until
+// Restore or the purge. A version other than 0 must match the stored
+// version, otherwise Delete returns a *PreconditionFailedError.
+func (cs *CustomerService) Delete(ctx context.Context, id uuid.UUID, version int64) error {
+	_, err := cs.replace(ctx, id, version, models.HistoryDelete, func(current models.Customer) (models.Customer, error) {
+		deletedAt := cs.timestamp()
+		current.DeletedAt = &deletedAt
+		return current, nil
+	})
+	return err
 }
 
-// Delete method delete a customer by ID
-func (cs *CustomerService) Delete(id uuid.UUID) bool {
-	for i, customer := range cs.Customers {
-		if customer.ID == id {
-			cs.Customers = append(cs.Customers[:i], cs.Customers[i+1:]...)
-			return true
-		}
-	}
-	return false
+// Restore method move a customer by ID out of the trash. The version is
+// checked like for Delete.
+func (cs *CustomerService) Restore(ctx context.Context, id uuid.UUID, version int64) (viewmodels.CustomerViewModel, error) {
+	return cs.replace(ctx, id, version, models.HistoryRestore, func(current models.Customer) (models.Customer, error) {
+		current.DeletedAt = nil
+		return current, nil
+	})
+}
+
+// timestamp is the current time as stored, in UTC and at the microsecond
+// precision every backend keeps
+func (cs *CustomerService) timestamp() time.Time {
+	return cs.Clock.Now().UTC().Truncate(time.Microsecond)
+}
+
+// created stamps customer as created now by the principal of ctx
+func (cs *CustomerService) created(ctx context.Context, customer models.Customer) models.Customer {
+	customer.CreatedAt = cs.timestamp()
+	customer.CreatedBy = auth.FromContext(ctx).Subject
+	customer.UpdatedAt = customer.CreatedAt
+	customer.UpdatedBy = customer.CreatedBy
+	return customer
+}
+
+// updated stamps customer, the new state of current, as updated now by the
+// principal of ctx. When and by whom the customer was created is kept.
+func (cs *CustomerService) updated(ctx context.Context, current, customer models.Customer) models.Customer {
+	customer.CreatedAt = current.CreatedAt
+	customer.CreatedBy = current.CreatedBy
+	customer.UpdatedAt = cs.timestamp()
+	customer.UpdatedBy = auth.FromContext(ctx).Subject
+	return customer
 }
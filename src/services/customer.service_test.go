@@ -1,16 +1,185 @@
 package services
 
 import (
+	"context"
+	"errors"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"congdinh.com/crm/auth"
+	"congdinh.com/crm/clock"
+	"congdinh.com/crm/models"
+	"congdinh.com/crm/repositories"
+	"congdinh.com/crm/validation"
 	viewmodels "congdinh.com/crm/view-models"
 	"github.com/google/uuid"
 )
 
+// newCustomerService creates a service over an in-memory copy of data/customers.json
+func newCustomerService(t *testing.T) *CustomerService {
+	t.Helper()
+
+	customers, err := repositories.ReadCustomersFile(repositories.DefaultDataFile())
+	if err != nil {
+		t.Fatalf("Failed to read customers: %s", err.Error())
+	}
+	return NewCustomerService(repositories.NewMemoryCustomerRepository(customers))
+}
+
+// fakeCustomerRepository records calls so business rules can be tested in isolation
+type fakeCustomerRepository struct {
+	customers map[uuid.UUID]models.Customer
+	inserted  []models.Customer
+	err       error
+	// conflicts is the number of Replace calls that fail with ErrVersionConflict
+	conflicts int
+}
+
+func newFakeCustomerRepository(customers ...models.Customer) *fakeCustomerRepository {
+	repository := &fakeCustomerRepository{customers: map[uuid.UUID]models.Customer{}}
+	for _, customer := range customers {
+		repository.customers[customer.ID] = customer
+	}
+	return repository
+}
+
+func (f *fakeCustomerRepository) Find(ctx context.Context, id uuid.UUID) (models.Customer, error) {
+	if f.err != nil {
+		return models.Customer{}, f.err
+	}
+	customer, ok := f.customers[id]
+	if !ok {
+		return models.Customer{}, repositories.ErrCustomerNotFound
+	}
+	return customer, nil
+}
+
+func (f *fakeCustomerRepository) List(ctx context.Context) ([]models.Customer, error) {
+	customers := []models.Customer{}
+	for _, customer := range f.customers {
+		customers = append(customers, customer)
+	}
+	return customers, f.err
+}
+
+func (f *fakeCustomerRepository) Query(ctx context.Context, query repositories.CustomerQuery) (repositories.CustomerPage, error) {
+	customers, err := f.List(ctx)
+	sequences := []int64{}
+	for i := range customers {
+		sequences = append(sequences, int64(i+1))
+	}
+	return repositories.CustomerPage{Customers: customers, Sequences: sequences, Total: len(customers)}, err
+}
+
+func (f *fakeCustomerRepository) Insert(ctx context.Context, customer models.Customer) error {
+	if f.err != nil {
+		return f.err
+	}
+	for _, c := range f.customers {
+		if c.Email == customer.Email || c.Phone == customer.Phone {
+			return repositories.ErrCustomerExists
+		}
+	}
+	f.inserted = append(f.inserted, customer)
+	f.customers[customer.ID] = customer
+	return nil
+}
+
+func (f *fakeCustomerRepository) Replace(ctx context.Context, customer models.Customer, expectedVersion int64) error {
+	stored, ok := f.customers[customer.ID]
+	if !ok {
+		return repositories.ErrCustomerNotFound
+	}
+	// Simulate writers that win the race between Find and Replace
+	if f.conflicts > 0 {
+		f.conflicts--
+		return repositories.ErrVersionConflict
+	}
+	if expectedVersion != 0 && stored.Version != expectedVersion {
+		return repositories.ErrVersionConflict
+	}
+	f.customers[customer.ID] = customer
+	return f.err
+}
+
+func (f *fakeCustomerRepository) Remove(ctx context.Context, id uuid.UUID, expectedVersion int64) error {
+	stored, ok := f.customers[id]
+	if !ok {
+		return repositories.ErrCustomerNotFound
+	}
+	if expectedVersion != 0 && stored.Version != expectedVersion {
+		return repositories.ErrVersionConflict
+	}
+	delete(f.customers, id)
+	return f.err
+}
+
+func (f *fakeCustomerRepository) Apply(ctx context.Context, changes []repositories.CustomerChange, atomic bool) ([]error, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	snapshot := maps.Clone(f.customers)
+	errs := make([]error, len(changes))
+	for i, change := range changes {
+		switch change.Kind {
+		case repositories.ChangeInsert:
+			errs[i] = f.Insert(ctx, change.Customer)
+		case repositories.ChangeReplace:
+			errs[i] = f.Replace(ctx, change.Customer, change.ExpectedVersion)
+		case repositories.ChangeRemove:
+			errs[i] = f.Remove(ctx, change.ID, change.ExpectedVersion)
+		}
+		if errs[i] != nil && atomic {
+			f.customers = snapshot
+			for j := range errs {
+				if errs[j] == nil {
+					errs[j] = repositories.ErrChangeNotApplied
+				}
+			}
+			return errs, nil
+		}
+	}
+	return errs, nil
+}
+
+func (f *fakeCustomerRepository) Purge(ctx context.Context, deletedBefore time.Time) (int, error) {
+	purged := 0
+	for id, customer := range f.customers {
+		if customer.DeletedAt != nil && customer.DeletedAt.Before(deletedBefore) {
+			delete(f.customers, id)
+			purged++
+		}
+	}
+	return purged, f.err
+}
+
+func (f *fakeCustomerRepository) ExistsByEmail(ctx context.Context, email string) (bool, error) {
+	for _, customer := range f.customers {
+		if customer.Email == email {
+			return true, f.err
+		}
+	}
+	return false, f.err
+}
+
+func (f *fakeCustomerRepository) ExistsByPhone(ctx context.Context, phone string) (bool, error) {
+	for _, customer := range f.customers {
+		if customer.Phone == phone {
+			return true, f.err
+		}
+	}
+	return false, f.err
+}
+
 func TestCustomerService_GetAll(t *testing.T) {
-	customerService := NewCustomerService()
+	customerService := newCustomerService(t)
 
-	customers := customerService.GetAll()
+	customers, _ := customerService.GetAll(t.Context())
 
 	if len(customers) != 5 {
 		t.Errorf("Expected 5 customers, but got %d", len(customers))
@@ -18,7 +187,7 @@ func TestCustomerService_GetAll(t *testing.T) {
 }
 
 func TestCustomerService_GetById(t *testing.T) {
-	customerService := NewCustomerService()
+	customerService := newCustomerService(t)
 
 	existingCustomerId, err := uuid.Parse("4405071c-2adc-499d-966f-3cfdfa1deedc")
 
@@ -26,7 +195,7 @@ func TestCustomerService_GetById(t *testing.T) {
 		t.Errorf("Failed to parse existing customer ID: %s", err.Error())
 	}
 
-	customer := customerService.GetById(existingCustomerId)
+	customer, _ := customerService.GetById(t.Context(), existingCustomerId)
 
 	if customer == nil {
 		t.Errorf("Expected customer with ID 3, but got nil")
@@ -36,7 +205,7 @@ func TestCustomerService_GetById(t *testing.T) {
 }
 
 func TestCustomerService_Create(t *testing.T) {
-	customerService := NewCustomerService()
+	customerService := newCustomerService(t)
 
 	newCustomer := viewmodels.CustomerCreateViewModel{
 		Name:      "New Customer",
@@ -46,7 +215,7 @@ func TestCustomerService_Create(t *testing.T) {
 		Contacted: false,
 	}
 
-	result, err := customerService.Create(newCustomer)
+	result, err := customerService.Create(t.Context(), newCustomer)
 
 	if err != nil {
 		t.Errorf("Expected Create to return nil error, but got %s", err.Error())
@@ -56,7 +225,7 @@ func TestCustomerService_Create(t *testing.T) {
 		t.Error("Expected Create to return a new customer ID, but got nil")
 	}
 
-	customers := customerService.GetAll()
+	customers, _ := customerService.GetAll(t.Context())
 
 	if len(customers) != 6 {
 		t.Errorf("Expected 6 customers after Create, but got %d", len(customers))
@@ -64,7 +233,7 @@ func TestCustomerService_Create(t *testing.T) {
 
 	newCustomerId := result.ID
 
-	createdCustomer := customerService.GetById(newCustomerId)
+	createdCustomer, _ := customerService.GetById(t.Context(), newCustomerId)
 
 	if createdCustomer == nil {
 		t.Errorf("Expected customer with ID 6 after Create, but got nil")
@@ -84,7 +253,7 @@ func TestCustomerService_Create(t *testing.T) {
 }
 
 func TestCustomerService_Update(t *testing.T) {
-	customerService := NewCustomerService()
+	customerService := newCustomerService(t)
 
 	existingCustomerId, err := uuid.Parse("4405071c-2adc-499d-966f-3cfdfa1deedc")
 
@@ -101,7 +270,7 @@ func TestCustomerService_Update(t *testing.T) {
 		Contacted: true,
 	}
 
-	result, err := customerService.Update(existingCustomerId, updatedCustomer)
+	result, err := customerService.Update(t.Context(), existingCustomerId, 0, updatedCustomer)
 
 	if err != nil {
 		t.Errorf("Expected Update to return nil error, but got %s", err.Error())
@@ -111,7 +280,7 @@ func TestCustomerService_Update(t *testing.T) {
 		t.Errorf("Expected Update to return customer with ID %s, but got ID %s", existingCustomerId.String(), result.ID.String())
 	}
 
-	updatedCustomerEntity := customerService.GetById(existingCustomerId)
+	updatedCustomerEntity, _ := customerService.GetById(t.Context(), existingCustomerId)
 
 	if updatedCustomerEntity == nil {
 		t.Errorf("Expected customer with ID %s to be updated, but got nil", existingCustomerId.String())
@@ -135,7 +304,7 @@ func TestCustomerService_Update(t *testing.T) {
 }
 
 func TestCustomerService_Delete(t *testing.T) {
-	customerService := NewCustomerService()
+	customerService := newCustomerService(t)
 
 	existingCustomerId, err := uuid.Parse("4405071c-2adc-499d-966f-3cfdfa1deedc")
 
@@ -143,21 +312,957 @@ func TestCustomerService_Delete(t *testing.T) {
 		t.Errorf("Failed to parse existing customer ID: %s", err.Error())
 	}
 
-	success := customerService.Delete(existingCustomerId)
+	err = customerService.Delete(t.Context(), existingCustomerId, 0)
 
-	if !success {
-		t.Error("Expected Delete to return true, but got false")
+	if err != nil {
+		t.Errorf("Expected Delete to return nil error, but got %s", err.Error())
 	}
 
-	customers := customerService.GetAll()
+	customers, _ := customerService.GetAll(t.Context())
 
 	if len(customers) != 4 {
 		t.Errorf("Expected 4 customers after Delete, but got %d", len(customers))
 	}
 
-	deletedCustomer := customerService.GetById(existingCustomerId)
+	deletedCustomer, _ := customerService.GetById(t.Context(), existingCustomerId)
 
 	if deletedCustomer != nil {
 		t.Errorf("Expected customer with ID 2 to be deleted, but got customer with ID %d", deletedCustomer.ID)
 	}
 }
+
+func TestCustomerService_CreateRejectsDuplicates(t *testing.T) {
+	existing := models.Customer{ID: uuid.New(), Email: "taken@domain.com", Phone: "0900000111"}
+	repository := newFakeCustomerRepository(existing)
+	customerService := NewCustomerService(repository)
+
+	duplicates := []viewmodels.CustomerCreateViewModel{
+		{Name: "Same Email", Role: "Sales", Email: "taken@domain.com", Phone: "0900000222"},
+		{Name: "Same Phone", Role: "Sales", Email: "other@domain.com", Phone: "0900000111"},
+	}
+
+	for _, duplicate := range duplicates {
+		if _, err := customerService.Create(t.Context(), duplicate); !errors.Is(err, repositories.ErrCustomerExists) {
+			t.Errorf("Expected Create to reject %s with ErrCustomerExists, but got %v", duplicate.Name, err)
+		}
+	}
+
+	if len(repository.inserted) != 0 {
+		t.Errorf("Expected no customers to be inserted, but got %d", len(repository.inserted))
+	}
+}
+
+func TestCustomerService_CreatePropagatesRepositoryErrors(t *testing.T) {
+	repository := newFakeCustomerRepository()
+	repository.err = errors.New("disk full")
+	customerService := NewCustomerService(repository)
+
+	_, err := customerService.Create(t.Context(), viewmodels.CustomerCreateViewModel{Name: "New", Role: "Sales", Email: "new@domain.com", Phone: "0900000333"})
+
+	if err == nil || err.Error() != "disk full" {
+		t.Errorf("Expected Create to return the repository error, but got %v", err)
+	}
+}
+
+func TestCustomerService_UpdateUnknownCustomer(t *testing.T) {
+	customerService := NewCustomerService(newFakeCustomerRepository())
+
+	_, err := customerService.Update(t.Context(), uuid.New(), 0, viewmodels.CustomerEditViewModel{
+		Name:  "Nobody",
+		Role:  "Sales",
+		Email: "nobody@domain.com",
+		Phone: "0900000444",
+	})
+
+	if !errors.Is(err, repositories.ErrCustomerNotFound) {
+		t.Errorf("Expected Update to return ErrCustomerNotFound, but got %v", err)
+	}
+}
+
+func TestCustomerService_QueryCursor(t *testing.T) {
+	customerService := newCustomerService(t)
+
+	options := CustomerQueryOptions{
+		CustomerQuery: repositories.CustomerQuery{Limit: 2, Sort: []repositories.SortField{{Field: "name"}}},
+	}
+
+	seen := []string{}
+	for page := 0; page < 5; page++ {
+		result, err := customerService.Query(t.Context(), options)
+		if err != nil {
+			t.Fatalf("Expected Query to return nil error, but got %s", err.Error())
+		}
+		if result.Total != 5 {
+			t.Errorf("Expected total 5, but got %d", result.Total)
+		}
+		for _, customer := range result.Items {
+			seen = append(seen, customer.Name)
+		}
+		if result.NextCursor == "" {
+			break
+		}
+		options.Cursor = result.NextCursor
+	}
+
+	expected := []string{"An Dinh", "Cong Dinh", "Quynh Dinh", "Thang Nguyen", "Van Nguyen"}
+	if len(seen) != len(expected) {
+		t.Fatalf("Expected %v across pages, but got %v", expected, seen)
+	}
+	for i := range expected {
+		if seen[i] != expected[i] {
+			t.Errorf("Expected %v across pages, but got %v", expected, seen)
+			break
+		}
+	}
+}
+
+func TestCustomerService_QueryCursorKeyset(t *testing.T) {
+	customerService := newCustomerService(t)
+	options := CustomerQueryOptions{
+		CustomerQuery: repositories.CustomerQuery{Limit: 2, Sort: []repositories.SortField{{Field: "name"}}},
+	}
+
+	first, err := customerService.Query(t.Context(), options)
+	if err != nil || len(first.Items) != 2 || first.Items[1].Name != "Cong Dinh" {
+		t.Fatalf("Expected the first page to end with Cong Dinh, but got %v (%v)", first, err)
+	}
+	// Changes before the cursor would shift an offset by one either way
+	customerService.Create(t.Context(), viewmodels.CustomerCreateViewModel{Name: "Aaron", Role: "Sales", Email: "aaron@domain.com", Phone: "5550009071"})
+	customerService.Delete(t.Context(), first.Items[0].ID, 0)
+	customerService.Delete(t.Context(), first.Items[1].ID, 0)
+
+	options.Cursor = first.NextCursor
+	second, err := customerService.Query(t.Context(), options)
+	if err != nil || len(second.Items) != 2 || second.Items[0].Name != "Quynh Dinh" || second.Items[1].Name != "Thang Nguyen" {
+		t.Fatalf("Expected the second page to continue after Cong Dinh, but got %v (%v)", second, err)
+	}
+	options.Cursor = second.NextCursor
+	last, err := customerService.Query(t.Context(), options)
+	if err != nil || len(last.Items) != 1 || last.Items[0].Name != "Van Nguyen" || last.NextCursor != "" {
+		t.Errorf("Expected the last page without a next cursor, but got %v (%v)", last, err)
+	}
+}
+
+func TestCustomerService_QueryRejectsForeignCursor(t *testing.T) {
+	customerService := newCustomerService(t)
+
+	first, err := customerService.Query(t.Context(), CustomerQueryOptions{
+		CustomerQuery: repositories.CustomerQuery{Limit: 1, Role: "Developer"},
+	})
+	if err != nil || first.NextCursor == "" {
+		t.Fatalf("Expected a next cursor, but got %v (%v)", first, err)
+	}
+
+	_, err = customerService.Query(t.Context(), CustomerQueryOptions{
+		CustomerQuery: repositories.CustomerQuery{Limit: 1, Role: "Manager"},
+		Cursor:        first.NextCursor,
+	})
+	if !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("Expected ErrInvalidCursor for a cursor from another query, but got %v", err)
+	}
+
+	_, err = customerService.Query(t.Context(), CustomerQueryOptions{Cursor: "not-a-cursor"})
+	if !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("Expected ErrInvalidCursor for garbage, but got %v", err)
+	}
+}
+
+func TestCustomerService_SearchFollowsMutations(t *testing.T) {
+	customerService := newCustomerService(t)
+
+	if results, _ := customerService.Search(t.Context(), "quynh", 10); len(results) != 1 {
+		t.Fatalf("Expected the seeded customer to be searchable, but got %v", results)
+	}
+
+	created, err := customerService.Create(t.Context(), viewmodels.CustomerCreateViewModel{
+		Name:  "Hương Lê",
+		Role:  "Sales",
+		Email: "huong@partner.vn",
+		Phone: "0912345678",
+	})
+	if err != nil {
+		t.Fatalf("Expected Create to return nil error, but got %s", err.Error())
+	}
+
+	results, err := customerService.Search(t.Context(), "huong", 10)
+	if err != nil {
+		t.Fatalf("Expected Search to return nil error, but got %s", err.Error())
+	}
+	if len(results) != 1 || results[0].Customer.ID != created.ID {
+		t.Fatalf("Expected the created customer, but got %v", results)
+	}
+	if results[0].Highlights["Name"] != "<em>Hương</em> Lê" {
+		t.Errorf("Expected the name to be highlighted, but got '%s'", results[0].Highlights["Name"])
+	}
+
+	_, err = customerService.Update(t.Context(), created.ID, 0, viewmodels.CustomerEditViewModel{
+		Name:  "Hương Lê",
+		Role:  "Sales",
+		Email: "huong@newco.vn",
+		Phone: "0912345678",
+	})
+	if err != nil {
+		t.Fatalf("Expected Update to return nil error, but got %s", err.Error())
+	}
+	if results, _ := customerService.Search(t.Context(), "partner", 10); len(results) != 0 {
+		t.Errorf("Expected the old email domain to be unsearchable after Update, but got %v", results)
+	}
+	if results, _ := customerService.Search(t.Context(), "newco", 10); len(results) != 1 {
+		t.Errorf("Expected the new email domain to be searchable after Update, but got %v", results)
+	}
+
+	customerService.Delete(t.Context(), created.ID, 0)
+	if results, _ := customerService.Search(t.Context(), "huong", 10); len(results) != 0 {
+		t.Errorf("Expected no results after Delete, but got %v", results)
+	}
+}
+
+func TestCustomerService_SearchFollowsConcurrentUpdates(t *testing.T) {
+	customerService := newCustomerService(t)
+	created, err := customerService.Create(t.Context(), viewmodels.CustomerCreateViewModel{Name: "Hương Lê", Role: "Sales", Email: "huong@partner.vn", Phone: "0912345678"})
+	if err != nil {
+		t.Fatalf("Expected Create to return nil error, but got %s", err.Error())
+	}
+
+	domains := []string{"alpha", "bravo", "charlie", "delta", "echo", "foxtrot", "golf", "hotel"}
+	var wg sync.WaitGroup
+	for _, domain := range domains {
+		wg.Go(func() {
+			// Losing the race too often is a conflict, which is fine here
+			customerService.Update(t.Context(), created.ID, 0, viewmodels.CustomerEditViewModel{Name: "Hương Lê", Role: "Sales", Email: "huong@" + domain + ".vn", Phone: "0912345678"})
+		})
+	}
+	wg.Wait()
+
+	stored, _ := customerService.GetById(t.Context(), created.ID)
+	for _, domain := range append(domains, "partner") {
+		results, _ := customerService.Search(t.Context(), domain, 10)
+		if expected := strings.Contains(stored.Email, "@"+domain+"."); expected != (len(results) == 1) {
+			t.Errorf("Expected a search for %s to find the customer only if it is the stored %s, but got %v", domain, stored.Email, results)
+		}
+	}
+}
+
+func TestCustomerService_CreateValidatesInput(t *testing.T) {
+	repository := newFakeCustomerRepository()
+	customerService := NewCustomerService(repository)
+
+	_, err := customerService.Create(t.Context(), viewmodels.CustomerCreateViewModel{
+		Name:  "Invalid",
+		Role:  "Astronaut",
+		Email: "not-an-email",
+		Phone: "0900000555",
+	})
+
+	var validationErrors validation.Errors
+	if !errors.As(err, &validationErrors) {
+		t.Fatalf("Expected Create to return validation errors, but got %v", err)
+	}
+	fields := []string{}
+	for _, fieldError := range validationErrors {
+		fields = append(fields, fieldError.Field)
+	}
+	if !reflect.DeepEqual(fields, []string{"Role", "Email"}) {
+		t.Errorf("Expected Role and Email to fail validation, but got %v", fields)
+	}
+	if len(repository.inserted) != 0 {
+		t.Errorf("Expected no customers to be inserted, but got %d", len(repository.inserted))
+	}
+}
+
+func TestCustomerService_UpdateValidatesInput(t *testing.T) {
+	customerService := newCustomerService(t)
+	existingCustomerId := uuid.MustParse("4405071c-2adc-499d-966f-3cfdfa1deedc")
+
+	_, err := customerService.Update(t.Context(), existingCustomerId, 0, viewmodels.CustomerEditViewModel{
+		ID:    existingCustomerId,
+		Name:  "Cong Dinh",
+		Role:  "Developer",
+		Email: "congdinh@example.com",
+	})
+
+	var validationErrors validation.Errors
+	if !errors.As(err, &validationErrors) || len(validationErrors) != 1 || validationErrors[0].Field != "Phone" {
+		t.Fatalf("Expected Update to reject the missing phone, but got %v", err)
+	}
+
+	customer, _ := customerService.GetById(t.Context(), existingCustomerId)
+	if customer == nil || customer.Phone == "" {
+		t.Error("Expected the stored customer to be left unchanged")
+	}
+}
+
+func TestCustomerService_TypedErrors(t *testing.T) {
+	customerService := newCustomerService(t)
+	unknownId := uuid.New()
+
+	_, err := customerService.Update(t.Context(), unknownId, 0, viewmodels.CustomerEditViewModel{
+		Name:  "Nobody",
+		Role:  "Sales",
+		Email: "nobody@domain.com",
+		Phone: "0900000444",
+	})
+	var notFound *NotFoundError
+	if !errors.As(err, &notFound) || notFound.ID != unknownId {
+		t.Errorf("Expected Update to return a NotFoundError for %s, but got %v", unknownId, err)
+	}
+
+	err = customerService.Delete(t.Context(), unknownId, 0)
+	if !errors.As(err, &notFound) {
+		t.Errorf("Expected Delete to return a NotFoundError, but got %v", err)
+	}
+
+	_, err = customerService.Create(t.Context(), viewmodels.CustomerCreateViewModel{
+		Name:  "Copy",
+		Role:  "Developer",
+		Email: "cong@domain.com",
+		Phone: "0900000444",
+	})
+	var conflict *ConflictError
+	if !errors.As(err, &conflict) {
+		t.Errorf("Expected Create to return a ConflictError, but got %v", err)
+	}
+
+	_, err = customerService.Create(t.Context(), viewmodels.CustomerCreateViewModel{Name: "Incomplete"})
+	var invalid *ValidationError
+	if !errors.As(err, &invalid) || len(invalid.Errors) != 3 {
+		t.Errorf("Expected Create to return a ValidationError for 3 fields, but got %v", err)
+	}
+}
+
+func TestCustomerService_Patch(t *testing.T) {
+	existingCustomerId := uuid.MustParse("4405071c-2adc-499d-966f-3cfdfa1deedc")
+
+	tests := []struct {
+		name        string
+		contentType string
+		document    string
+		expected    func(c *viewmodels.CustomerViewModel)
+	}{
+		{"merge patch", MergePatchContentType, `{"Contacted": true, "Role": "Manager"}`,
+			func(c *viewmodels.CustomerViewModel) { c.Contacted, c.Role = true, "Manager" }},
+		{"json patch", JSONPatchContentType, `[{"op": "test", "path": "/Name", "value": "Cong Dinh"}, {"op": "replace", "path": "/Phone", "value": "0900000555"}]`,
+			func(c *viewmodels.CustomerViewModel) { c.Phone = "0900000555" }},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			customerService := newCustomerService(t)
+			now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+			customerService.Clock = clock.Fixed(now)
+			before, _ := customerService.GetById(t.Context(), existingCustomerId)
+
+			result, err := customerService.Patch(t.Context(), existingCustomerId, 0, CustomerPatch{ContentType: test.contentType, Document: []byte(test.document)})
+			if err != nil {
+				t.Fatalf("Expected Patch to return nil error, but got %s", err.Error())
+			}
+
+			expected := *before
+			expected.Version++
+			expected.UpdatedAt, expected.UpdatedBy = now, auth.Anonymous.Subject
+			test.expected(&expected)
+			if result != expected {
+				t.Errorf("Expected Patch to return %v, but got %v", expected, result)
+			}
+			if stored, _ := customerService.GetById(t.Context(), existingCustomerId); *stored != expected {
+				t.Errorf("Expected the stored customer to be %v, but got %v", expected, *stored)
+			}
+		})
+	}
+}
+
+func TestCustomerService_PatchErrors(t *testing.T) {
+	customerService := newCustomerService(t)
+	existingCustomerId := uuid.MustParse("4405071c-2adc-499d-966f-3cfdfa1deedc")
+
+	var (
+		notFound *NotFoundError
+		invalid  *ValidationError
+		badPatch *PatchError
+	)
+	tests := []struct {
+		name        string
+		id          uuid.UUID
+		contentType string
+		document    string
+		target      any
+	}{
+		{"unknown customer", uuid.New(), MergePatchContentType, `{"Contacted": true}`, &notFound},
+		{"malformed merge patch", existingCustomerId, MergePatchContentType, `{"Contacted":`, &badPatch},
+		{"malformed json patch", existingCustomerId, JSONPatchContentType, `{"op": "replace"}`, &badPatch},
+		{"failing test operation", existingCustomerId, JSONPatchContentType, `[{"op": "test", "path": "/Name", "value": "Someone Else"}]`, &badPatch},
+		{"missing path", existingCustomerId, JSONPatchContentType, `[{"op": "replace", "path": "/Nickname", "value": "Cong"}]`, &badPatch},
+		{"unknown member", existingCustomerId, MergePatchContentType, `{"contacted": true}`, &badPatch},
+		{"wrong type", existingCustomerId, MergePatchContentType, `{"Contacted": "yes"}`, &badPatch},
+		{"removed field", existingCustomerId, MergePatchContentType, `{"Name": null}`, &invalid},
+		{"invalid email", existingCustomerId, JSONPatchContentType, `[{"op": "replace", "path": "/Email", "value": "cong"}]`, &invalid},
+		{"changed id", existingCustomerId, MergePatchContentType, `{"ID": "` + uuid.NewString() + `"}`, &invalid},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := customerService.Patch(t.Context(), test.id, 0, CustomerPatch{ContentType: test.contentType, Document: []byte(test.document)})
+			if !errors.As(err, test.target) {
+				t.Errorf("Expected Patch to return %T, but got %v", test.target, err)
+			}
+		})
+	}
+
+	customer, _ := customerService.GetById(t.Context(), existingCustomerId)
+	if customer.Name != "Cong Dinh" || customer.Contacted {
+		t.Errorf("Expected failed patches to leave the customer unchanged, but got %v", *customer)
+	}
+}
+
+func TestCustomerPatch_Fields(t *testing.T) {
+	tests := []struct {
+		contentType string
+		document    string
+		expected    []string
+	}{
+		{MergePatchContentType, `{"Contacted": true, "Name": "Cong"}`, []string{"Contacted", "Name"}},
+		{MergePatchContentType, `null`, []string{"Contacted", "Email", "ID", "Name", "Phone", "Role"}},
+		{MergePatchContentType, `{"Name":`, nil},
+		{JSONPatchContentType, `[{"op": "copy", "from": "/Phone", "path": "/Name"}, {"op": "test", "path": "/Phone", "value": "1"}]`, []string{"Name", "Phone"}},
+		{JSONPatchContentType, `[{"op": "replace", "path": "/Ph~1one/0", "value": "1"}]`, []string{"Ph/one"}},
+		{JSONPatchContentType, `[{"op": "replace", "path": "", "value": {}}]`, []string{"Contacted", "Email", "ID", "Name", "Phone", "Role"}},
+	}
+	for _, test := range tests {
+		if fields := (CustomerPatch{ContentType: test.contentType, Document: []byte(test.document)}).Fields(); !slices.Equal(fields, test.expected) {
+			t.Errorf("Expected the %s %s to name %v, but got %v", test.contentType, test.document, test.expected, fields)
+		}
+	}
+}
+
+func TestCustomerService_Versions(t *testing.T) {
+	customerService := newCustomerService(t)
+	existingCustomerId := uuid.MustParse("4405071c-2adc-499d-966f-3cfdfa1deedc")
+	customer, _ := customerService.GetById(t.Context(), existingCustomerId)
+	if customer.Version != 1 {
+		t.Fatalf("Expected the seeded customer to be at version 1, but got %d", customer.Version)
+	}
+
+	edit := viewmodels.CustomerEditViewModel{
+		ID:        customer.ID,
+		Name:      customer.Name,
+		Role:      customer.Role,
+		Email:     customer.Email,
+		Phone:     customer.Phone,
+		Contacted: true,
+	}
+	updated, err := customerService.Update(t.Context(), existingCustomerId, 1, edit)
+	if err != nil || updated.Version != 2 {
+		t.Fatalf("Expected Update at version 1 to store version 2, but got %v (%v)", updated, err)
+	}
+
+	var preconditionFailed *PreconditionFailedError
+	if _, err := customerService.Update(t.Context(), existingCustomerId, 1, edit); !errors.As(err, &preconditionFailed) {
+		t.Errorf("Expected Update at a stale version to return a PreconditionFailedError, but got %v", err)
+	}
+	patch := CustomerPatch{ContentType: MergePatchContentType, Document: []byte(`{"Role": "Manager"}`)}
+	if _, err := customerService.Patch(t.Context(), existingCustomerId, 1, patch); !errors.As(err, &preconditionFailed) {
+		t.Errorf("Expected Patch at a stale version to return a PreconditionFailedError, but got %v", err)
+	}
+	if err := customerService.Delete(t.Context(), existingCustomerId, 1); !errors.As(err, &preconditionFailed) {
+		t.Errorf("Expected Delete at a stale version to return a PreconditionFailedError, but got %v", err)
+	}
+
+	patched, err := customerService.Patch(t.Context(), existingCustomerId, 2, patch)
+	if err != nil || patched.Version != 3 {
+		t.Fatalf("Expected Patch at version 2 to store version 3, but got %v (%v)", patched, err)
+	}
+	if err := customerService.Delete(t.Context(), existingCustomerId, 3); err != nil {
+		t.Errorf("Expected Delete at the current version to return nil error, but got %s", err.Error())
+	}
+}
+
+func TestCustomerService_UpdateRetriesLostRaces(t *testing.T) {
+	existing := models.Customer{ID: uuid.New(), Name: "Raced", Role: "Sales", Email: "raced@domain.com", Phone: "0900000666", Version: 4}
+	edit := viewmodels.CustomerEditViewModel{ID: existing.ID, Name: "Raced", Role: "Manager", Email: "raced@domain.com", Phone: "0900000666"}
+
+	repository := newFakeCustomerRepository(existing)
+	repository.conflicts = maxWriteAttempts - 1
+	customerService := NewCustomerService(repository)
+
+	updated, err := customerService.Update(t.Context(), existing.ID, 0, edit)
+	if err != nil || updated.Role != "Manager" || updated.Version != 5 {
+		t.Errorf("Expected Update without a version to retry until it wins, but got %v (%v)", updated, err)
+	}
+
+	repository.conflicts = maxWriteAttempts
+	var conflict *ConflictError
+	if _, err := customerService.Update(t.Context(), existing.ID, 0, edit); !errors.As(err, &conflict) {
+		t.Errorf("Expected Update to give up with a ConflictError, but got %v", err)
+	}
+
+	repository.conflicts = 1
+	var preconditionFailed *PreconditionFailedError
+	if _, err := customerService.Update(t.Context(), existing.ID, 5, edit); !errors.As(err, &preconditionFailed) {
+		t.Errorf("Expected Update with a version not to retry, but got %v", err)
+	}
+}
+
+func TestCustomerService_Batch(t *testing.T) {
+	customerService := newCustomerService(t)
+	existingCustomerId := uuid.MustParse("4405071c-2adc-499d-966f-3cfdfa1deedc")
+	existing, _ := customerService.GetById(t.Context(), existingCustomerId)
+	edit := viewmodels.CustomerEditViewModel{Name: existing.Name, Role: existing.Role, Email: existing.Email, Phone: existing.Phone, Contacted: true}
+	created := viewmodels.CustomerEditViewModel{Name: "Batch", Role: "Sales", Email: "batch@example.com", Phone: "0900000222"}
+
+	results, err := customerService.Batch(t.Context(), []CustomerOperation{
+		{Kind: OperationCreate, Customer: created},
+		{Kind: OperationCreate, Customer: created},
+		{Kind: OperationUpdate, ID: existingCustomerId, Customer: edit},
+		{Kind: OperationUpdate, ID: existingCustomerId, Version: 2, Customer: edit},
+		{Kind: OperationUpdate, ID: existingCustomerId, Version: 2, Customer: edit},
+		{Kind: OperationDelete, ID: uuid.New()},
+		{Kind: "upsert"},
+	}, false)
+	if err != nil {
+		t.Fatalf("Expected Batch to return nil error, but got %s", err.Error())
+	}
+
+	if results[0].Err != nil || results[0].Customer.Email != created.Email || results[0].Customer.Version != 1 {
+		t.Errorf("Expected the first create to succeed, but got %v", results[0])
+	}
+	var conflict *ConflictError
+	if !errors.As(results[1].Err, &conflict) {
+		t.Errorf("Expected the duplicate create to return a ConflictError, but got %v", results[1].Err)
+	}
+	if results[2].Err != nil || results[2].Customer.Version != 2 || results[3].Err != nil || results[3].Customer.Version != 3 {
+		t.Errorf("Expected chained updates to store versions 2 and 3, but got %v and %v", results[2], results[3])
+	}
+	var preconditionFailed *PreconditionFailedError
+	if !errors.As(results[4].Err, &preconditionFailed) {
+		t.Errorf("Expected an update at a stale version to return a PreconditionFailedError, but got %v", results[4].Err)
+	}
+	var notFound *NotFoundError
+	if !errors.As(results[5].Err, &notFound) {
+		t.Errorf("Expected deleting an unknown customer to return a NotFoundError, but got %v", results[5].Err)
+	}
+	var validationError *ValidationError
+	if !errors.As(results[6].Err, &validationError) || validationError.Errors[0].Field != "Op" {
+		t.Errorf("Expected an unknown operation to return a ValidationError on Op, but got %v", results[6].Err)
+	}
+
+	if matches, _ := customerService.Search(t.Context(), "batch@example.com", 1); len(matches) != 1 {
+		t.Errorf("Expected the created customer to be searchable, but got %v", matches)
+	}
+	if customer, _ := customerService.GetById(t.Context(), existingCustomerId); customer.Version != 3 || !customer.Contacted {
+		t.Errorf("Expected the updated customer at version 3, but got %v", customer)
+	}
+}
+
+func TestCustomerService_BatchAtomic(t *testing.T) {
+	customerService := newCustomerService(t)
+	existingCustomerId := uuid.MustParse("4405071c-2adc-499d-966f-3cfdfa1deedc")
+	before, _ := customerService.GetAll(t.Context())
+	created := viewmodels.CustomerEditViewModel{Name: "Batch", Role: "Sales", Email: "batch@example.com", Phone: "0900000222"}
+
+	// A repository failure rolls back the whole batch
+	results, err := customerService.Batch(t.Context(), []CustomerOperation{
+		{Kind: OperationCreate, Customer: created},
+		{Kind: OperationDelete, ID: existingCustomerId, Version: 7},
+		{Kind: OperationDelete, ID: existingCustomerId},
+	}, true)
+	if err != nil {
+		t.Fatalf("Expected Batch to return nil error, but got %s", err.Error())
+	}
+	var notApplied *NotAppliedError
+	var preconditionFailed *PreconditionFailedError
+	if !errors.As(results[0].Err, &notApplied) || !errors.As(results[1].Err, &preconditionFailed) || !errors.As(results[2].Err, &notApplied) {
+		t.Errorf("Expected the failed atomic batch to report not applied around the failure, but got %v", results)
+	}
+
+	// A validation failure stops the batch before anything is written
+	invalid := created
+	invalid.Email = "not an email"
+	results, _ = customerService.Batch(t.Context(), []CustomerOperation{
+		{Kind: OperationDelete, ID: existingCustomerId},
+		{Kind: OperationCreate, Customer: invalid},
+	}, true)
+	var validationError *ValidationError
+	if !errors.As(results[0].Err, &notApplied) || !errors.As(results[1].Err, &validationError) {
+		t.Errorf("Expected the invalid atomic batch to report not applied and a ValidationError, but got %v", results)
+	}
+
+	after, _ := customerService.GetAll(t.Context())
+	if !reflect.DeepEqual(before, after) {
+		t.Errorf("Expected failed atomic batches to leave %v, but got %v", before, after)
+	}
+	if matches, _ := customerService.Search(t.Context(), "batch@example.com", 1); len(matches) != 0 {
+		t.Errorf("Expected nothing from a failed batch to be searchable, but got %v", matches)
+	}
+
+	results, err = customerService.Batch(t.Context(), []CustomerOperation{
+		{Kind: OperationCreate, Customer: created},
+		{Kind: OperationDelete, ID: existingCustomerId, Version: 1},
+	}, true)
+	if err != nil || results[0].Err != nil || results[1].Err != nil {
+		t.Fatalf("Expected the atomic batch to succeed, but got %v (%v)", results, err)
+	}
+	if customer, _ := customerService.GetById(t.Context(), existingCustomerId); customer != nil {
+		t.Errorf("Expected the deleted customer to be gone, but got %v", customer)
+	}
+}
+
+func TestCustomerService_BatchDependencies(t *testing.T) {
+	customerService := newCustomerService(t)
+	existingCustomerId := uuid.MustParse("4405071c-2adc-499d-966f-3cfdfa1deedc")
+	existing, _ := customerService.GetById(t.Context(), existingCustomerId)
+	edit := viewmodels.CustomerEditViewModel{Name: existing.Name, Role: existing.Role, Email: existing.Email, Phone: existing.Phone, Contacted: true}
+	duplicate := edit
+	duplicate.Email = "van@domain.com"
+	created := viewmodels.CustomerEditViewModel{Name: "Batch", Role: "Sales", Email: "batch@example.com", Phone: "0900000222"}
+
+	results, err := customerService.Batch(t.Context(), []CustomerOperation{
+		{Kind: OperationCreate, Customer: created},
+		{Kind: OperationUpdate, ID: existingCustomerId, Customer: duplicate},
+		{Kind: OperationUpdate, ID: existingCustomerId, Customer: edit},
+		{Kind: OperationDelete, ID: existingCustomerId},
+	}, false)
+	if err != nil {
+		t.Fatalf("Expected Batch to return nil error, but got %s", err.Error())
+	}
+
+	var conflict *ConflictError
+	if results[0].Err != nil || !errors.As(results[1].Err, &conflict) {
+		t.Errorf("Expected the create to succeed and the duplicate update to conflict, but got %v and %v", results[0], results[1])
+	}
+	// Operations chained on the failed update report it instead of a concurrent modification
+	var dependency *DependencyFailedError
+	if !errors.As(results[2].Err, &dependency) || dependency.Operation != 1 {
+		t.Errorf("Expected the next update to depend on operation 1, but got %v", results[2].Err)
+	}
+	if !errors.As(results[3].Err, &dependency) || dependency.Operation != 2 {
+		t.Errorf("Expected the delete to depend on operation 2, but got %v", results[3].Err)
+	}
+	if errors.As(results[2].Err, &conflict) {
+		t.Errorf("Expected no ConflictError for a dependent operation, but got %v", results[2].Err)
+	}
+	if customer, _ := customerService.GetById(t.Context(), existingCustomerId); customer == nil || customer.Version != existing.Version {
+		t.Errorf("Expected the customer to stay at version %d, but got %v", existing.Version, customer)
+	}
+}
+
+func TestCustomerService_BatchPropagatesRepositoryErrors(t *testing.T) {
+	repository := newFakeCustomerRepository()
+	customerService := NewCustomerService(repository)
+	repository.err = errors.New("disk full")
+
+	_, err := customerService.Batch(t.Context(), []CustomerOperation{{Kind: OperationDelete, ID: uuid.New()}}, false)
+	if !errors.Is(err, repository.err) {
+		t.Errorf("Expected Batch to return the repository error, but got %v", err)
+	}
+}
+
+func TestCustomerService_Import(t *testing.T) {
+	customerService := newCustomerService(t)
+	customers := []viewmodels.CustomerCreateViewModel{
+		{Name: "Ana", Role: "Sales", Email: "ana@example.com", Phone: "0900000111"},
+		{Name: "Ana Again", Role: "Sales", Email: "ana@example.com", Phone: "0900000222"},
+		{Name: "Cong Again", Role: "Sales", Email: "other@example.com", Phone: "1234567890"},
+		{Name: "", Role: "Sales", Email: "nameless@example.com", Phone: "0900000333"},
+	}
+
+	for _, dryRun := range []bool{true, false} {
+		before, _ := customerService.GetAll(t.Context())
+		results, err := customerService.Import(t.Context(), customers, dryRun)
+		if err != nil {
+			t.Fatalf("Expected Import to return nil error, but got %s", err.Error())
+		}
+
+		if results[0].Err != nil || results[0].Customer.Email != "ana@example.com" {
+			t.Errorf("Expected the first row to be created (dry run %t), but got %v", dryRun, results[0])
+		}
+		var conflict *ConflictError
+		if !errors.As(results[1].Err, &conflict) || !errors.As(results[2].Err, &conflict) {
+			t.Errorf("Expected duplicates within the file and of stored customers to conflict (dry run %t), but got %v and %v", dryRun, results[1].Err, results[2].Err)
+		}
+		var validationError *ValidationError
+		if !errors.As(results[3].Err, &validationError) {
+			t.Errorf("Expected the nameless row to fail validation (dry run %t), but got %v", dryRun, results[3].Err)
+		}
+
+		after, _ := customerService.GetAll(t.Context())
+		expected := 1
+		if dryRun {
+			expected = 0
+		}
+		if created := len(after) - len(before); created != expected {
+			t.Errorf("Expected Import to create %d customers (dry run %t), but got %d", expected, dryRun, created)
+		}
+	}
+}
+
+func TestCustomerService_Trash(t *testing.T) {
+	customerService := newCustomerService(t)
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	customerService.Clock = clock.Func(func() time.Time { return now })
+	existingCustomerId := uuid.MustParse("4405071c-2adc-499d-966f-3cfdfa1deedc")
+
+	if err := customerService.Delete(t.Context(), existingCustomerId, 1); err != nil {
+		t.Fatalf("Expected Delete to return nil error, but got %s", err.Error())
+	}
+
+	if customer, _ := customerService.GetById(t.Context(), existingCustomerId); customer != nil {
+		t.Errorf("Expected GetById to hide the deleted customer, but got %v", customer)
+	}
+	if customers, _ := customerService.GetAll(t.Context()); len(customers) != 4 {
+		t.Errorf("Expected GetAll to return 4 customers, but got %d", len(customers))
+	}
+	if matches, _ := customerService.Search(t.Context(), "cong", 10); len(matches) != 0 {
+		t.Errorf("Expected Search to skip the deleted customer, but got %v", matches)
+	}
+	trash, err := customerService.Query(t.Context(), CustomerQueryOptions{CustomerQuery: repositories.CustomerQuery{Deleted: true}})
+	if err != nil || trash.Total != 1 || !trash.Items[0].DeletedAt.Equal(now) || trash.Items[0].Version != 2 {
+		t.Fatalf("Expected the trash to hold the customer deleted at %s, but got %v (%v)", now, trash, err)
+	}
+
+	var notFound *NotFoundError
+	edit := viewmodels.CustomerEditViewModel{Name: "Cong Dinh", Role: "Developer", Email: "cong@domain.com", Phone: "1234567890"}
+	if _, err := customerService.Update(t.Context(), existingCustomerId, 0, edit); !errors.As(err, &notFound) {
+		t.Errorf("Expected Update of a deleted customer to return a NotFoundError, but got %v", err)
+	}
+	if err := customerService.Delete(t.Context(), existingCustomerId, 0); !errors.As(err, &notFound) {
+		t.Errorf("Expected a second Delete to return a NotFoundError, but got %v", err)
+	}
+	if _, err := customerService.Restore(t.Context(), uuid.MustParse("1a29dde9-409a-4816-8a65-55455a6acee7"), 0); !errors.As(err, &notFound) {
+		t.Errorf("Expected Restore of a live customer to return a NotFoundError, but got %v", err)
+	}
+	var preconditionFailed *PreconditionFailedError
+	if _, err := customerService.Restore(t.Context(), existingCustomerId, 1); !errors.As(err, &preconditionFailed) {
+		t.Errorf("Expected Restore at a stale version to return a PreconditionFailedError, but got %v", err)
+	}
+
+	restored, err := customerService.Restore(t.Context(), existingCustomerId, 2)
+	if err != nil || restored.DeletedAt != nil || restored.Version != 3 {
+		t.Fatalf("Expected Restore to return the live customer at version 3, but got %v (%v)", restored, err)
+	}
+	if matches, _ := customerService.Search(t.Context(), "cong", 10); len(matches) != 1 {
+		t.Errorf("Expected the restored customer to be searchable, but got %v", matches)
+	}
+}
+
+func TestCustomerService_Purge(t *testing.T) {
+	customerService := newCustomerService(t)
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	customerService.Clock = clock.Func(func() time.Time { return now })
+
+	customerService.Delete(t.Context(), uuid.MustParse("4405071c-2adc-499d-966f-3cfdfa1deedc"), 0)
+	now = now.Add(48 * time.Hour)
+	customerService.Delete(t.Context(), uuid.MustParse("1a29dde9-409a-4816-8a65-55455a6acee7"), 0)
+	now = now.Add(time.Hour)
+
+	purged, err := customerService.Purge(t.Context(), 24*time.Hour)
+	if err != nil || purged != 1 {
+		t.Fatalf("Expected Purge to remove 1 customer, but got %d (%v)", purged, err)
+	}
+	trash, _ := customerService.Query(t.Context(), CustomerQueryOptions{CustomerQuery: repositories.CustomerQuery{Deleted: true}})
+	if trash.Total != 1 || trash.Items[0].Email != "van@domain.com" {
+		t.Errorf("Expected only the recently deleted customer in the trash, but got %v", trash.Items)
+	}
+	if _, err := customerService.Restore(t.Context(), uuid.MustParse("4405071c-2adc-499d-966f-3cfdfa1deedc"), 0); err == nil {
+		t.Error("Expected Restore of a purged customer to fail")
+	}
+}
+
+func TestCustomerService_RunPurge(t *testing.T) {
+	customerService := newCustomerService(t)
+	customerService.Delete(t.Context(), uuid.MustParse("4405071c-2adc-499d-966f-3cfdfa1deedc"), 0)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	done := make(chan struct{})
+	go func() {
+		customerService.RunPurge(ctx, time.Millisecond, 0)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		trash, _ := customerService.Query(t.Context(), CustomerQueryOptions{CustomerQuery: repositories.CustomerQuery{Deleted: true}})
+		if trash.Total == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected RunPurge to empty the trash")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+}
+
+func TestCustomerService_Audit(t *testing.T) {
+	customerService := newCustomerService(t)
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	customerService.Clock = clock.Func(func() time.Time { return now })
+	alice := auth.WithPrincipal(t.Context(), auth.Principal{Subject: "alice"})
+	bob := auth.WithPrincipal(t.Context(), auth.Principal{Subject: "bob"})
+
+	created, err := customerService.Create(alice, viewmodels.CustomerCreateViewModel{Name: "Lead", Role: "Sales", Email: "lead@domain.com", Phone: "5550003001"})
+	if err != nil {
+		t.Fatalf("Expected Create to return nil error, but got %s", err.Error())
+	}
+	if !created.CreatedAt.Equal(now) || !created.UpdatedAt.Equal(now) || created.CreatedBy != "alice" || created.UpdatedBy != "alice" {
+		t.Errorf("Expected Create to stamp alice at %s, but got %v", now, created)
+	}
+
+	createdAt := now
+	now = now.Add(7 * 24 * time.Hour)
+	updated, err := customerService.Update(bob, created.ID, 0, viewmodels.CustomerEditViewModel{ID: created.ID, Name: "Lead", Role: "Sales", Email: "lead@domain.com", Phone: "5550003001", Contacted: true})
+	if err != nil {
+		t.Fatalf("Expected Update to return nil error, but got %s", err.Error())
+	}
+	if !updated.CreatedAt.Equal(createdAt) || updated.CreatedBy != "alice" || !updated.UpdatedAt.Equal(now) || updated.UpdatedBy != "bob" {
+		t.Errorf("Expected Update to keep the creation and stamp bob at %s, but got %v", now, updated)
+	}
+
+	results, err := customerService.Batch(bob, []CustomerOperation{
+		{Kind: OperationCreate, Customer: viewmodels.CustomerEditViewModel{Name: "Batched", Role: "Sales", Email: "batched@domain.com", Phone: "5550003002"}},
+	}, true)
+	if err != nil || results[0].Customer == nil || results[0].Customer.CreatedBy != "bob" || !results[0].Customer.CreatedAt.Equal(now) {
+		t.Errorf("Expected Batch to stamp bob at %s, but got %v (%v)", now, results, err)
+	}
+
+	page, err := customerService.Query(t.Context(), CustomerQueryOptions{CustomerQuery: repositories.CustomerQuery{UpdatedSince: now, Sort: []repositories.SortField{{Field: "name"}}}})
+	if err != nil || page.Total != 2 || page.Items[0].Name != "Batched" || page.Items[1].ID != created.ID {
+		t.Errorf("Expected Query to return the customers updated since %s, but got %v (%v)", now, page.Items, err)
+	}
+
+	if err := customerService.Delete(t.Context(), created.ID, 0); err != nil {
+		t.Fatalf("Expected Delete to return nil error, but got %s", err.Error())
+	}
+	trash, _ := customerService.Query(t.Context(), CustomerQueryOptions{CustomerQuery: repositories.CustomerQuery{Deleted: true}})
+	if trash.Total != 1 || trash.Items[0].UpdatedBy != auth.Anonymous.Subject {
+		t.Errorf("Expected Delete without a principal to stamp %q, but got %v", auth.Anonymous.Subject, trash.Items)
+	}
+}
+
+func TestCustomerService_History(t *testing.T) {
+	customerService := newCustomerService(t)
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	customerService.Clock = clock.Func(func() time.Time { return now })
+	alice := auth.WithPrincipal(t.Context(), auth.Principal{Subject: "alice"})
+
+	created, err := customerService.Create(alice, viewmodels.CustomerCreateViewModel{Name: "Lead", Role: "Sales", Email: "lead@domain.com", Phone: "5550005001"})
+	if err != nil {
+		t.Fatalf("Expected Create to return nil error, but got %s", err.Error())
+	}
+	now = now.Add(time.Hour)
+	customerService.Patch(t.Context(), created.ID, 0, CustomerPatch{ContentType: MergePatchContentType, Document: []byte(`{"Contacted": true}`)})
+	now = now.Add(time.Hour)
+	customerService.Batch(alice, []CustomerOperation{{Kind: OperationDelete, ID: created.ID}}, true)
+	now = now.Add(time.Hour)
+	customerService.Restore(t.Context(), created.ID, 0)
+
+	history, err := customerService.GetHistory(t.Context(), created.ID, 0, 10)
+	if err != nil || history.Total != 4 || len(history.Items) != 4 {
+		t.Fatalf("Expected 4 history entries, but got %v (%v)", history, err)
+	}
+	operations := []string{}
+	for _, entry := range history.Items {
+		operations = append(operations, entry.Operation)
+	}
+	if !slices.Equal(operations, []string{"restore", "delete", "update", "create"}) {
+		t.Errorf("Expected the operations newest first, but got %v", operations)
+	}
+	update := history.Items[2]
+	expected := []viewmodels.FieldChangeViewModel{{Field: "Contacted", Before: false, After: true}}
+	if update.Actor != "anonymous" || update.Version != 2 || !update.Timestamp.Equal(now.Add(-2*time.Hour)) || !reflect.DeepEqual(update.Changes, expected) {
+		t.Errorf("Expected the update to change %v, but got %v", expected, update)
+	}
+	if create := history.Items[3]; create.Actor != "alice" || len(create.Changes) != 5 || create.Changes[0].Before != nil || create.Changes[0].After != "Lead" {
+		t.Errorf("Expected the create to set every field, but got %v", create)
+	}
+
+	page, _ := customerService.GetHistory(t.Context(), created.ID, 1, 2)
+	if page.Total != 4 || len(page.Items) != 2 || page.Items[0].Operation != "delete" {
+		t.Errorf("Expected the second page to start with the delete, but got %v", page)
+	}
+
+	asOf, err := customerService.GetAsOf(t.Context(), created.ID, now.Add(-150*time.Minute))
+	if err != nil || asOf == nil || asOf.Contacted || asOf.Version != 1 {
+		t.Errorf("Expected the customer as created, but got %v (%v)", asOf, err)
+	}
+	if asOf, _ := customerService.GetAsOf(t.Context(), created.ID, now.Add(-time.Hour)); asOf != nil {
+		t.Errorf("Expected no customer while it was in the trash, but got %v", asOf)
+	}
+	if asOf, _ := customerService.GetAsOf(t.Context(), created.ID, now.Add(-4*time.Hour)); asOf != nil {
+		t.Errorf("Expected no customer before it was created, but got %v", asOf)
+	}
+
+	// Customers stored before the history existed have an empty history
+	if history, err := customerService.GetHistory(t.Context(), uuid.MustParse("4405071c-2adc-499d-966f-3cfdfa1deedc"), 0, 10); err != nil || history.Total != 0 {
+		t.Errorf("Expected an empty history, but got %v (%v)", history, err)
+	}
+	var notFound *NotFoundError
+	if _, err := customerService.GetHistory(t.Context(), uuid.New(), 0, 10); !errors.As(err, &notFound) {
+		t.Errorf("Expected GetHistory of an unknown customer to return a NotFoundError, but got %v", err)
+	}
+}
+
+// failingHistoryRepository is a history whose appends fail
+type failingHistoryRepository struct {
+	*repositories.JSONCustomerHistoryRepository
+}
+
+func (r failingHistoryRepository) AppendHistory(ctx context.Context, entries []models.CustomerHistoryEntry) error {
+	return errors.New("disk full")
+}
+
+func TestCustomerService_HistoryFailure(t *testing.T) {
+	customers, _ := repositories.ReadCustomersFile(repositories.DefaultDataFile())
+	repository := repositories.NewMemoryCustomerRepository(customers)
+	repository.History = failingHistoryRepository{repositories.NewMemoryCustomerHistoryRepository()}
+	customerService := NewCustomerService(repository)
+	existingCustomerId := uuid.MustParse("4405071c-2adc-499d-966f-3cfdfa1deedc")
+
+	if _, err := customerService.Create(t.Context(), viewmodels.CustomerCreateViewModel{Name: "Lead", Role: "Sales", Email: "lead@domain.com", Phone: "5550009061"}); err == nil {
+		t.Error("Expected Create to fail when its history cannot be written")
+	}
+	if err := customerService.Delete(t.Context(), existingCustomerId, 0); err == nil {
+		t.Error("Expected Delete to fail when its history cannot be written")
+	}
+	after, _ := customerService.GetAll(t.Context())
+	if len(after) != len(customers) {
+		t.Errorf("Expected no change to be stored without history, but got %v", after)
+	}
+	if matches, _ := customerService.Search(t.Context(), "lead@domain.com", 1); len(matches) != 0 {
+		t.Errorf("Expected the rejected customer not to be searchable, but got %v", matches)
+	}
+}
+
+func TestCustomerService_Subscribe(t *testing.T) {
+	customerService := newCustomerService(t)
+	events := []viewmodels.CustomerEventViewModel{}
+	customerService.Subscribe(func(event viewmodels.CustomerEventViewModel) {
+		events = append(events, event)
+	})
+	alice := auth.WithPrincipal(t.Context(), auth.Principal{Subject: "alice"})
+
+	created, _ := customerService.Create(alice, viewmodels.CustomerCreateViewModel{Name: "Lead", Role: "Sales", Email: "lead@domain.com", Phone: "5550008001"})
+	customerService.Patch(t.Context(), created.ID, 0, CustomerPatch{ContentType: MergePatchContentType, Document: []byte(`{"Name": "Lead Renamed"}`)})
+	customerService.Batch(t.Context(), []CustomerOperation{{Kind: OperationDelete, ID: created.ID}}, true)
+	customerService.Restore(t.Context(), created.ID, 0)
+	// A failed write publishes nothing
+	customerService.Delete(t.Context(), created.ID, 1)
+
+	types := []string{}
+	for _, event := range events {
+		types = append(types, event.Type)
+	}
+	if !slices.Equal(types, []string{EventCreated, EventUpdated, EventDeleted, EventRestored}) {
+		t.Fatalf("Expected an event per stored change, but got %v", types)
+	}
+	if created := events[0]; created.Actor != "alice" || created.Customer.Version != 1 || created.Customer.Name != "Lead" {
+		t.Errorf("Expected the created customer by alice, but got %v", created)
+	}
+	expected := []viewmodels.FieldChangeViewModel{{Field: "Name", Before: "Lead", After: "Lead Renamed"}}
+	if updated := events[1]; updated.Customer.Version != 2 || !reflect.DeepEqual(updated.Changes, expected) {
+		t.Errorf("Expected the name change at version 2, but got %v", updated)
+	}
+	if deleted := events[2]; deleted.Customer.DeletedAt == nil {
+		t.Errorf("Expected the deleted event to carry DeletedAt, but got %v", deleted)
+	}
+}
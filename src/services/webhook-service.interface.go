@@ -0,0 +1,24 @@
+package services
+
+import (
+	"context"
+
+	"congdinh.com/crm/models"
+	viewmodels "congdinh.com/crm/view-models"
+	"github.com/google/uuid"
+)
+
+// IWebhookService defines the interface for managing webhooks and reading
+// their deliveries. Create and Update report invalid input as
+// *ValidationError; Get, Update, Delete and Deliveries report an unknown
+// webhook as *WebhookNotFoundError. Deliveries and DeadLetters return the
+// newest deliveries first; a status of "" selects all of them.
+type IWebhookService interface {
+	List(ctx context.Context) ([]viewmodels.WebhookViewModel, error)
+	Get(ctx context.Context, id uuid.UUID) (viewmodels.WebhookViewModel, error)
+	Create(ctx context.Context, webhook viewmodels.WebhookEditViewModel) (viewmodels.WebhookViewModel, error)
+	Update(ctx context.Context, id uuid.UUID, webhook viewmodels.WebhookEditViewModel) (viewmodels.WebhookViewModel, error)
+	Delete(ctx context.Context, id uuid.UUID) error
+	Deliveries(ctx context.Context, id uuid.UUID, status models.DeliveryStatus, limit int) ([]viewmodels.WebhookDeliveryViewModel, error)
+	DeadLetters(ctx context.Context, limit int) ([]viewmodels.WebhookDeliveryViewModel, error)
+}
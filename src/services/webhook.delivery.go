@@ -0,0 +1,186 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"congdinh.com/crm/models"
+	"congdinh.com/crm/repositories"
+	"github.com/google/uuid"
+)
+
+// The headers of a webhook delivery besides Content-Type
+const (
+	WebhookIDHeader        = "X-Webhook-ID"
+	WebhookEventHeader     = "X-Webhook-Event"
+	WebhookTimestampHeader = "X-Webhook-Timestamp"
+	WebhookSignatureHeader = "X-Webhook-Signature"
+)
+
+// deliveryBatch bounds how many deliveries DeliverDue sends in one go
+const deliveryBatch = 100
+
+// SignWebhook returns the signature of a delivery body sent at timestamp, in
+// Unix seconds: "sha256=" and the hex HMAC-SHA256 of "<timestamp>.<body>"
+// keyed with the webhook secret
+func SignWebhook(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhook reports whether signature is the signature of body sent at
+// timestamp. Receivers should also reject old timestamps to stop replays.
+func VerifyWebhook(secret string, timestamp string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(SignWebhook(secret, timestamp, body)), []byte(signature))
+}
+
+// RunDeliveries calls DeliverDue every interval, and as soon as
+// NotifyCustomerEvent reports a stored change, until ctx is done
+func (ws *WebhookService) RunDeliveries(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-ws.wake:
+		}
+
+		// A full batch means more deliveries may be due
+		for {
+			sent, err := ws.DeliverDue(ctx)
+			if err != nil && ctx.Err() == nil {
+				log.Printf("Failed to deliver webhooks: %v", err)
+			}
+			if err != nil || sent < deliveryBatch || ctx.Err() != nil {
+				break
+			}
+		}
+	}
+}
+
+// DeliverDue makes one attempt at every delivery that is due and returns how
+// many it attempted. A delivery succeeds when the receiver answers with a 2xx
+// status; otherwise it is retried with exponential backoff until MaxAttempts
+// attempts failed, and then kept as a dead letter. The deliveries of a
+// webhook that was deactivated since they were queued are not sent but kept
+// as dead letters right away. With a History the deliveries of the changes
+// appended to it are stored first.
+func (ws *WebhookService) DeliverDue(ctx context.Context) (int, error) {
+	if ws.History != nil {
+		if err := ws.relayHistory(ctx); err != nil {
+			return 0, fmt.Errorf("failed to relay the customer history: %w", err)
+		}
+	}
+
+	due, err := ws.repository.DueDeliveries(ctx, ws.Clock.Now().UTC(), deliveryBatch)
+	if err != nil {
+		return 0, err
+	}
+
+	webhooks := map[uuid.UUID]models.Webhook{}
+	for i, delivery := range due {
+		webhook, ok := webhooks[delivery.WebhookID]
+		if !ok {
+			webhook, err = ws.repository.FindWebhook(ctx, delivery.WebhookID)
+			if errors.Is(err, repositories.ErrWebhookNotFound) {
+				// Removed since; its deliveries went with it
+				continue
+			}
+			if err != nil {
+				return i, err
+			}
+			webhooks[webhook.ID] = webhook
+		}
+		if !webhook.Active {
+			delivery.Status = models.DeliveryDead
+			log.Printf("Webhook delivery %s to %s is now a dead letter: the webhook is inactive", delivery.ID, webhook.URL)
+			if err := ws.repository.ReplaceDelivery(ctx, delivery); err != nil && !errors.Is(err, repositories.ErrDeliveryNotFound) {
+				return i + 1, err
+			}
+			continue
+		}
+
+		attempt := ws.attempt(ctx, webhook, delivery)
+		if ctx.Err() != nil {
+			// Interrupted by the shutdown, not the receiver's fault
+			return i, ctx.Err()
+		}
+		delivery.Attempts = append(delivery.Attempts, attempt)
+		switch {
+		case attempt.Error == "":
+			delivery.Status = models.DeliveryDelivered
+		case len(delivery.Attempts) >= ws.MaxAttempts:
+			delivery.Status = models.DeliveryDead
+			log.Printf("Webhook delivery %s to %s failed %d times and is now a dead letter: %s", delivery.ID, webhook.URL, len(delivery.Attempts), attempt.Error)
+		default:
+			delivery.NextAttemptAt = attempt.Timestamp.Add(ws.retryDelay(len(delivery.Attempts)))
+		}
+		if err := ws.repository.ReplaceDelivery(ctx, delivery); err != nil && !errors.Is(err, repositories.ErrDeliveryNotFound) {
+			return i + 1, err
+		}
+	}
+	return len(due), nil
+}
+
+// retryDelay is how long to wait after the given number of failed attempts
+func (ws *WebhookService) retryDelay(failures int) time.Duration {
+	delay := ws.RetryDelay
+	for range failures - 1 {
+		if delay >= ws.MaxRetryDelay {
+			break
+		}
+		delay *= 2
+	}
+	return min(delay, ws.MaxRetryDelay)
+}
+
+// attempt sends delivery to webhook once. The attempt has an Error unless
+// the receiver answered with a 2xx status.
+func (ws *WebhookService) attempt(ctx context.Context, webhook models.Webhook, delivery models.WebhookDelivery) models.WebhookAttempt {
+	start := ws.Clock.Now().UTC().Truncate(time.Microsecond)
+	attempt := models.WebhookAttempt{Timestamp: start}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", webhook.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	timestamp := strconv.FormatInt(start.Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "crm-webhooks/1.0")
+	req.Header.Set(WebhookIDHeader, delivery.ID.String())
+	req.Header.Set(WebhookEventHeader, delivery.Event)
+	req.Header.Set(WebhookTimestampHeader, timestamp)
+	req.Header.Set(WebhookSignatureHeader, SignWebhook(webhook.Secret, timestamp, delivery.Payload))
+
+	resp, err := ws.Client.Do(req)
+	attempt.Duration = ws.Clock.Now().Sub(start)
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	// Drain a little of the body so the connection can be reused
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+
+	attempt.StatusCode = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		attempt.Error = fmt.Sprintf("receiver answered %s", resp.Status)
+	}
+	return attempt
+}
@@ -0,0 +1,22 @@
+package services
+
+import (
+	"fmt"
+
+	"congdinh.com/crm/repositories"
+	"github.com/google/uuid"
+)
+
+// WebhookNotFoundError is returned when the requested webhook does not exist
+type WebhookNotFoundError struct {
+	ID uuid.UUID
+}
+
+func (e *WebhookNotFoundError) Error() string {
+	return fmt.Sprintf("webhook %s not found", e.ID)
+}
+
+// Unwrap lets errors.Is match repositories.ErrWebhookNotFound
+func (e *WebhookNotFoundError) Unwrap() error {
+	return repositories.ErrWebhookNotFound
+}
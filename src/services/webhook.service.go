@@ -0,0 +1,360 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"congdinh.com/crm/clock"
+	"congdinh.com/crm/models"
+	"congdinh.com/crm/repositories"
+	"congdinh.com/crm/validation"
+	viewmodels "congdinh.com/crm/view-models"
+	"github.com/google/uuid"
+)
+
+// The events a webhook can subscribe to
+const (
+	WebhookCustomerCreated   = "customer.created"
+	WebhookCustomerUpdated   = "customer.updated"
+	WebhookCustomerContacted = "customer.contacted"
+	WebhookCustomerDeleted   = "customer.deleted"
+	WebhookCustomerRestored  = "customer.restored"
+)
+
+// WebhookEvents lists every event a webhook can subscribe to
+var WebhookEvents = []string{WebhookCustomerCreated, WebhookCustomerUpdated, WebhookCustomerContacted, WebhookCustomerDeleted, WebhookCustomerRestored}
+
+// WebhookService manages webhooks and delivers customer events to them
+// through an outbox, so no stored change misses its deliveries. The SQL
+// backends store the deliveries in the transaction of the change (see
+// CustomerDeliveries); the file backends derive them from the history log
+// before each DeliverDue, keeping a cursor. RunDeliveries then sends them.
+type WebhookService struct {
+	repository repositories.IWebhookRepository
+	// History, when set, is the history log DeliverDue derives the
+	// deliveries from; the repository must then be an IWebhookRelayRepository
+	History repositories.ICustomerHistoryLog
+	// Clock stamps webhooks and schedules deliveries
+	Clock clock.Clock
+	// Client sends the deliveries; its timeout bounds each attempt
+	Client *http.Client
+	// MaxAttempts is how often a delivery is tried before it becomes a dead letter
+	MaxAttempts int
+	// RetryDelay is the wait after the first failed attempt; it doubles with
+	// every further failure up to MaxRetryDelay
+	RetryDelay    time.Duration
+	MaxRetryDelay time.Duration
+
+	// wake asks RunDeliveries to look for due deliveries right away
+	wake chan struct{}
+}
+
+// NewWebhookService creates a webhook service on top of the given repository
+func NewWebhookService(repository repositories.IWebhookRepository) *WebhookService {
+	return &WebhookService{
+		repository:    repository,
+		Clock:         clock.System,
+		Client:        &http.Client{Timeout: 10 * time.Second},
+		MaxAttempts:   8,
+		RetryDelay:    30 * time.Second,
+		MaxRetryDelay: time.Hour,
+		wake:          make(chan struct{}, 1),
+	}
+}
+
+func toWebhookViewModel(webhook models.Webhook) viewmodels.WebhookViewModel {
+	return viewmodels.WebhookViewModel{
+		ID:        webhook.ID,
+		URL:       webhook.URL,
+		Events:    webhook.Events,
+		Active:    webhook.Active,
+		CreatedAt: webhook.CreatedAt,
+		UpdatedAt: webhook.UpdatedAt,
+	}
+}
+
+func toWebhookDeliveryViewModel(delivery models.WebhookDelivery) viewmodels.WebhookDeliveryViewModel {
+	result := viewmodels.WebhookDeliveryViewModel{
+		ID:        delivery.ID,
+		WebhookID: delivery.WebhookID,
+		Event:     delivery.Event,
+		Status:    string(delivery.Status),
+		CreatedAt: delivery.CreatedAt,
+		Attempts:  []viewmodels.WebhookAttemptViewModel{},
+		Payload:   delivery.Payload,
+	}
+	if delivery.Status == models.DeliveryPending {
+		result.NextAttemptAt = delivery.NextAttemptAt
+	}
+	for _, attempt := range delivery.Attempts {
+		result.Attempts = append(result.Attempts, viewmodels.WebhookAttemptViewModel{
+			Timestamp:  attempt.Timestamp,
+			StatusCode: attempt.StatusCode,
+			Error:      attempt.Error,
+			DurationMs: attempt.Duration.Milliseconds(),
+		})
+	}
+	return result
+}
+
+// webhookError translates repository errors about webhook id into the typed service errors
+func webhookError(id uuid.UUID, err error) error {
+	if errors.Is(err, repositories.ErrWebhookNotFound) {
+		return &WebhookNotFoundError{ID: id}
+	}
+	return err
+}
+
+// validateWebhook checks the tags of webhook and that it subscribes to known events
+func validateWebhook(webhook viewmodels.WebhookEditViewModel) error {
+	var errs validation.Errors
+	if err := validation.Validate(webhook); err != nil && !errors.As(err, &errs) {
+		return err
+	}
+	if len(webhook.Events) == 0 || slices.ContainsFunc(webhook.Events, func(event string) bool { return !slices.Contains(WebhookEvents, event) }) {
+		errs = append(errs, validation.FieldError{Field: "Events", Reason: "must list one or more of " + strings.Join(WebhookEvents, ", ")})
+	}
+	if len(errs) > 0 {
+		return &ValidationError{Errors: errs}
+	}
+	return nil
+}
+
+// newWebhookSecret returns a random signing secret
+func newWebhookSecret() string {
+	return "whsec_" + rand.Text()
+}
+
+// List method return every webhook in creation order
+func (ws *WebhookService) List(ctx context.Context) ([]viewmodels.WebhookViewModel, error) {
+	webhooks, err := ws.repository.ListWebhooks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result := []viewmodels.WebhookViewModel{}
+	for _, webhook := range webhooks {
+		result = append(result, toWebhookViewModel(webhook))
+	}
+	return result, nil
+}
+
+// Get method return a webhook by ID, without its secret
+func (ws *WebhookService) Get(ctx context.Context, id uuid.UUID) (viewmodels.WebhookViewModel, error) {
+	webhook, err := ws.repository.FindWebhook(ctx, id)
+	if err != nil {
+		return viewmodels.WebhookViewModel{}, webhookError(id, err)
+	}
+	return toWebhookViewModel(webhook), nil
+}
+
+// Create method create a webhook. The result includes the secret, which is
+// generated when none is given and not returned again.
+func (ws *WebhookService) Create(ctx context.Context, webhook viewmodels.WebhookEditViewModel) (viewmodels.WebhookViewModel, error) {
+	if err := validateWebhook(webhook); err != nil {
+		return viewmodels.WebhookViewModel{}, err
+	}
+
+	now := ws.Clock.Now().UTC().Truncate(time.Microsecond)
+	newWebhook := models.Webhook{
+		ID:        uuid.New(),
+		URL:       webhook.URL,
+		Secret:    webhook.Secret,
+		Events:    slices.Compact(slices.Sorted(slices.Values(webhook.Events))),
+		Active:    webhook.Active == nil || *webhook.Active,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if newWebhook.Secret == "" {
+		newWebhook.Secret = newWebhookSecret()
+	}
+	if err := ws.repository.InsertWebhook(ctx, newWebhook); err != nil {
+		return viewmodels.WebhookViewModel{}, err
+	}
+
+	result := toWebhookViewModel(newWebhook)
+	result.Secret = newWebhook.Secret
+	return result, nil
+}
+
+// Update method replace the URL, events and active flag of a webhook, and
+// its secret when one is given. The result includes a changed secret.
+func (ws *WebhookService) Update(ctx context.Context, id uuid.UUID, webhook viewmodels.WebhookEditViewModel) (viewmodels.WebhookViewModel, error) {
+	if err := validateWebhook(webhook); err != nil {
+		return viewmodels.WebhookViewModel{}, err
+	}
+	current, err := ws.repository.FindWebhook(ctx, id)
+	if err != nil {
+		return viewmodels.WebhookViewModel{}, webhookError(id, err)
+	}
+
+	current.URL = webhook.URL
+	current.Events = slices.Compact(slices.Sorted(slices.Values(webhook.Events)))
+	current.Active = webhook.Active == nil || *webhook.Active
+	if webhook.Secret != "" {
+		current.Secret = webhook.Secret
+	}
+	current.UpdatedAt = ws.Clock.Now().UTC().Truncate(time.Microsecond)
+	if err := ws.repository.ReplaceWebhook(ctx, current); err != nil {
+		return viewmodels.WebhookViewModel{}, webhookError(id, err)
+	}
+
+	result := toWebhookViewModel(current)
+	result.Secret = webhook.Secret
+	return result, nil
+}
+
+// Delete method remove a webhook together with its pending and past deliveries
+func (ws *WebhookService) Delete(ctx context.Context, id uuid.UUID) error {
+	return webhookError(id, ws.repository.RemoveWebhook(ctx, id))
+}
+
+// Deliveries method return up to limit deliveries of a webhook with the
+// given status, newest first, each with its attempts
+func (ws *WebhookService) Deliveries(ctx context.Context, id uuid.UUID, status models.DeliveryStatus, limit int) ([]viewmodels.WebhookDeliveryViewModel, error) {
+	if _, err := ws.repository.FindWebhook(ctx, id); err != nil {
+		return nil, webhookError(id, err)
+	}
+	return ws.deliveries(ctx, repositories.WebhookDeliveryQuery{WebhookID: id, Status: status, Limit: limit})
+}
+
+// DeadLetters method return up to limit deliveries of any webhook that ran
+// out of attempts, newest first
+func (ws *WebhookService) DeadLetters(ctx context.Context, limit int) ([]viewmodels.WebhookDeliveryViewModel, error) {
+	return ws.deliveries(ctx, repositories.WebhookDeliveryQuery{Status: models.DeliveryDead, Limit: limit})
+}
+
+func (ws *WebhookService) deliveries(ctx context.Context, query repositories.WebhookDeliveryQuery) ([]viewmodels.WebhookDeliveryViewModel, error) {
+	deliveries, err := ws.repository.Deliveries(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	result := []viewmodels.WebhookDeliveryViewModel{}
+	for _, delivery := range deliveries {
+		result = append(result, toWebhookDeliveryViewModel(delivery))
+	}
+	return result, nil
+}
+
+// webhookEvents returns the webhook events a customer event raises: its own
+// kind, and customer.contacted for an update that marks the customer contacted
+func webhookEvents(event viewmodels.CustomerEventViewModel) []string {
+	switch event.Type {
+	case EventCreated:
+		return []string{WebhookCustomerCreated}
+	case EventDeleted:
+		return []string{WebhookCustomerDeleted}
+	case EventRestored:
+		return []string{WebhookCustomerRestored}
+	}
+	events := []string{WebhookCustomerUpdated}
+	for _, change := range event.Changes {
+		if change.Field == "Contacted" && change.After == true {
+			events = append(events, WebhookCustomerContacted)
+		}
+	}
+	return events
+}
+
+// CustomerDeliveries returns, for each history entry, a pending delivery
+// per active webhook subscribed to an event the change raises. Stored in
+// the transaction of the change, they make the webhook outbox of the SQL
+// backends; see CustomerService.Outbox.
+func (ws *WebhookService) CustomerDeliveries(ctx context.Context, entries []models.CustomerHistoryEntry) ([][]models.WebhookDelivery, error) {
+	webhooks, err := ws.repository.ListWebhooks(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	now := ws.Clock.Now().UTC().Truncate(time.Microsecond)
+	result := make([][]models.WebhookDelivery, len(entries))
+	for i, entry := range entries {
+		event := toCustomerEventViewModel(entry)
+		for _, name := range webhookEvents(event) {
+			for _, webhook := range webhooks {
+				if !webhook.Active || !slices.Contains(webhook.Events, name) {
+					continue
+				}
+				delivery := models.WebhookDelivery{
+					ID:            uuid.New(),
+					WebhookID:     webhook.ID,
+					Event:         name,
+					Status:        models.DeliveryPending,
+					CreatedAt:     now,
+					NextAttemptAt: now,
+					Attempts:      []models.WebhookAttempt{},
+				}
+				delivery.Payload, err = json.Marshal(viewmodels.WebhookPayloadViewModel{
+					ID:        delivery.ID,
+					Event:     name,
+					Timestamp: event.Timestamp,
+					Actor:     event.Actor,
+					Customer:  event.Customer,
+					Changes:   event.Changes,
+				})
+				if err != nil {
+					return nil, fmt.Errorf("failed to marshal the %s webhook payload of customer %s: %w", name, event.Customer.ID, err)
+				}
+				result[i] = append(result[i], delivery)
+			}
+		}
+	}
+	return result, nil
+}
+
+// relayBatch bounds how many history entries relayHistory reads in one go
+const relayBatch = 100
+
+// relayHistory stores the deliveries of the history entries appended since
+// the last relay, the webhook outbox of the file backends. The cursor moves
+// in the same write, so a crash in between relays the entries again rather
+// than losing them. A repository without a cursor yet, written before it
+// was kept, starts at the end of the history.
+func (ws *WebhookService) relayHistory(ctx context.Context) error {
+	repository, ok := ws.repository.(repositories.IWebhookRelayRepository)
+	if !ok {
+		return errors.New("the webhook repository cannot relay the customer history")
+	}
+	cursor, ok, err := repository.RelayCursor(ctx)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		if cursor, err = ws.History.HistoryLength(ctx); err != nil {
+			return err
+		}
+		if err := repository.RelayDeliveries(ctx, nil, cursor); err != nil {
+			return err
+		}
+	}
+
+	for {
+		entries, err := ws.History.HistorySince(ctx, cursor, relayBatch)
+		if err != nil || len(entries) == 0 {
+			return err
+		}
+		deliveries, err := ws.CustomerDeliveries(ctx, entries)
+		if err != nil {
+			return err
+		}
+		cursor += int64(len(entries))
+		if err := repository.RelayDeliveries(ctx, slices.Concat(deliveries...), cursor); err != nil {
+			return err
+		}
+	}
+}
+
+// NotifyCustomerEvent wakes RunDeliveries to send the deliveries of a stored
+// change right away. It is meant to be passed to CustomerService.Subscribe.
+func (ws *WebhookService) NotifyCustomerEvent(event viewmodels.CustomerEventViewModel) {
+	select {
+	case ws.wake <- struct{}{}:
+	default:
+	}
+}
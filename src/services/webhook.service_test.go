@@ -0,0 +1,379 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"congdinh.com/crm/clock"
+	"congdinh.com/crm/models"
+	"congdinh.com/crm/repositories"
+	viewmodels "congdinh.com/crm/view-models"
+	"github.com/google/uuid"
+)
+
+// webhookReceiver is a local endpoint that records the deliveries it gets
+// and answers with the next of its statuses, then 200
+type webhookReceiver struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	statuses []int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func newWebhookReceiver(t *testing.T, statuses ...int) *webhookReceiver {
+	t.Helper()
+
+	receiver := &webhookReceiver{statuses: statuses}
+	receiver.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		receiver.mu.Lock()
+		defer receiver.mu.Unlock()
+		receiver.requests = append(receiver.requests, r)
+		receiver.bodies = append(receiver.bodies, body)
+		status := http.StatusOK
+		if len(receiver.statuses) > 0 {
+			status, receiver.statuses = receiver.statuses[0], receiver.statuses[1:]
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(receiver.Close)
+	return receiver
+}
+
+func (r *webhookReceiver) received() ([]*http.Request, [][]byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return slices.Clone(r.requests), slices.Clone(r.bodies)
+}
+
+// newWebhookServices wires a webhook service to the history of a customer
+// service, as for the file backends
+func newWebhookServices(t *testing.T) (*CustomerService, *WebhookService) {
+	t.Helper()
+
+	customerService := newCustomerService(t)
+	webhookService := NewWebhookService(repositories.NewMemoryWebhookRepository())
+	webhookService.History = customerService.History.(repositories.ICustomerHistoryLog)
+	customerService.Subscribe(webhookService.NotifyCustomerEvent)
+	return customerService, webhookService
+}
+
+func TestWebhookService_CRUD(t *testing.T) {
+	_, webhookService := newWebhookServices(t)
+
+	created, err := webhookService.Create(t.Context(), viewmodels.WebhookEditViewModel{URL: "https://billing.example.com/hooks", Events: []string{"customer.deleted", "customer.created", "customer.created"}})
+	if err != nil {
+		t.Fatalf("Expected Create to return nil error, but got %s", err.Error())
+	}
+	if !created.Active || created.Secret == "" || !slices.Equal(created.Events, []string{"customer.created", "customer.deleted"}) {
+		t.Errorf("Expected an active webhook with a generated secret and sorted events, but got %v", created)
+	}
+
+	inactive := false
+	updated, err := webhookService.Update(t.Context(), created.ID, viewmodels.WebhookEditViewModel{URL: "https://billing.example.com/v2", Events: []string{"customer.updated"}, Active: &inactive})
+	if err != nil || updated.Active || updated.URL != "https://billing.example.com/v2" || updated.Secret != "" {
+		t.Errorf("Expected the updated webhook without its kept secret, but got %v (%v)", updated, err)
+	}
+	found, err := webhookService.Get(t.Context(), created.ID)
+	if err != nil || found.Secret != "" || !slices.Equal(found.Events, []string{"customer.updated"}) {
+		t.Errorf("Expected Get to return the webhook without its secret, but got %v (%v)", found, err)
+	}
+	if webhooks, _ := webhookService.List(t.Context()); len(webhooks) != 1 || webhooks[0].ID != created.ID {
+		t.Errorf("Expected List to return the webhook, but got %v", webhooks)
+	}
+
+	invalid := []viewmodels.WebhookEditViewModel{
+		{URL: "ftp://billing.example.com", Events: []string{"customer.created"}},
+		{URL: "https://billing.example.com", Events: []string{}},
+		{URL: "https://billing.example.com", Events: []string{"customer.purged"}},
+	}
+	for _, webhook := range invalid {
+		var validationErr *ValidationError
+		if _, err := webhookService.Create(t.Context(), webhook); !errors.As(err, &validationErr) {
+			t.Errorf("Expected Create of %v to return a ValidationError, but got %v", webhook, err)
+		}
+	}
+
+	if err := webhookService.Delete(t.Context(), created.ID); err != nil {
+		t.Fatalf("Expected Delete to return nil error, but got %s", err.Error())
+	}
+	var notFound *WebhookNotFoundError
+	if _, err := webhookService.Get(t.Context(), created.ID); !errors.As(err, &notFound) {
+		t.Errorf("Expected Get after Delete to return a WebhookNotFoundError, but got %v", err)
+	}
+	if err := webhookService.Delete(t.Context(), created.ID); !errors.As(err, &notFound) {
+		t.Errorf("Expected deleting twice to return a WebhookNotFoundError, but got %v", err)
+	}
+}
+
+func TestWebhookService_Deliver(t *testing.T) {
+	customerService, webhookService := newWebhookServices(t)
+	receiver := newWebhookReceiver(t)
+
+	marketing, _ := webhookService.Create(t.Context(), viewmodels.WebhookEditViewModel{URL: receiver.URL + "/marketing", Events: []string{"customer.contacted", "customer.deleted"}, Secret: "marketing-secret"})
+	paused := false
+	webhookService.Create(t.Context(), viewmodels.WebhookEditViewModel{URL: receiver.URL + "/paused", Events: []string{"customer.contacted"}, Active: &paused})
+
+	customer, _ := customerService.Create(t.Context(), viewmodels.CustomerCreateViewModel{Name: "Lead", Role: "Sales", Email: "lead@domain.com", Phone: "5550009001"})
+	customerService.Patch(t.Context(), customer.ID, 0, CustomerPatch{ContentType: MergePatchContentType, Document: []byte(`{"Contacted": true}`)})
+	// Marking a contacted customer as contacted again changes nothing
+	customerService.Patch(t.Context(), customer.ID, 0, CustomerPatch{ContentType: MergePatchContentType, Document: []byte(`{"Name": "Lead Renamed"}`)})
+
+	sent, err := webhookService.DeliverDue(t.Context())
+	if err != nil || sent != 1 {
+		t.Fatalf("Expected DeliverDue to send the contacted delivery, but got %d (%v)", sent, err)
+	}
+	requests, bodies := receiver.received()
+	request := requests[0]
+	if request.URL.Path != "/marketing" || request.Header.Get(WebhookEventHeader) != "customer.contacted" || request.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("Expected a customer.contacted delivery to the marketing webhook, but got %s %v", request.URL.Path, request.Header)
+	}
+	if !VerifyWebhook("marketing-secret", request.Header.Get(WebhookTimestampHeader), bodies[0], request.Header.Get(WebhookSignatureHeader)) {
+		t.Errorf("Expected the delivery to be signed with the webhook secret, but got %q", request.Header.Get(WebhookSignatureHeader))
+	}
+	if VerifyWebhook("other-secret", request.Header.Get(WebhookTimestampHeader), bodies[0], request.Header.Get(WebhookSignatureHeader)) {
+		t.Error("Expected the signature not to verify with another secret")
+	}
+	var payload viewmodels.WebhookPayloadViewModel
+	json.Unmarshal(bodies[0], &payload)
+	if payload.ID.String() != request.Header.Get(WebhookIDHeader) || payload.Customer.ID != customer.ID || !payload.Customer.Contacted || payload.Customer.Version != 2 {
+		t.Errorf("Expected the payload to carry the contacted customer, but got %v", payload)
+	}
+
+	deliveries, err := webhookService.Deliveries(t.Context(), marketing.ID, "", 0)
+	if err != nil || len(deliveries) != 1 || deliveries[0].Status != "delivered" || len(deliveries[0].Attempts) != 1 || deliveries[0].Attempts[0].StatusCode != http.StatusOK {
+		t.Errorf("Expected one delivered delivery with its attempt, but got %v (%v)", deliveries, err)
+	}
+	if sent, _ := webhookService.DeliverDue(t.Context()); sent != 0 {
+		t.Errorf("Expected a delivered delivery not to be sent again, but %d were", sent)
+	}
+}
+
+func TestWebhookService_Relay(t *testing.T) {
+	customers, _ := repositories.ReadCustomersFile(repositories.DefaultDataFile())
+	customerService := NewCustomerService(repositories.NewMemoryCustomerRepository(customers))
+	webhooks := repositories.NewMemoryWebhookRepository()
+	receiver := newWebhookReceiver(t)
+	NewWebhookService(webhooks).Create(t.Context(), viewmodels.WebhookEditViewModel{URL: receiver.URL, Events: []string{"customer.created"}})
+
+	// Nothing listens to the changes, as after a crash right after storing them
+	customerService.Create(t.Context(), viewmodels.CustomerCreateViewModel{Name: "Lead", Role: "Sales", Email: "lead@domain.com", Phone: "5550009051"})
+	customerService.Create(t.Context(), viewmodels.CustomerCreateViewModel{Name: "Other Lead", Role: "Sales", Email: "other.lead@domain.com", Phone: "5550009052"})
+
+	restarted := NewWebhookService(webhooks)
+	restarted.History = customerService.History.(repositories.ICustomerHistoryLog)
+	if sent, err := restarted.DeliverDue(t.Context()); err != nil || sent != 2 {
+		t.Fatalf("Expected the deliveries of both changes to be derived from the history, but got %d (%v)", sent, err)
+	}
+	if sent, _ := restarted.DeliverDue(t.Context()); sent != 0 {
+		t.Errorf("Expected the relayed changes not to be relayed again, but %d were sent", sent)
+	}
+	if cursor, _, _ := webhooks.RelayCursor(t.Context()); cursor != 2 {
+		t.Errorf("Expected the cursor after both entries, but got %d", cursor)
+	}
+}
+
+func TestWebhookService_Outbox(t *testing.T) {
+	repository, err := repositories.NewSQLiteCustomerRepository(t.Context(), filepath.Join(t.TempDir(), "crm.db"))
+	if err != nil {
+		t.Fatalf("Failed to open SQLite repository: %s", err.Error())
+	}
+	defer repository.Close()
+	customerService := NewCustomerService(repository)
+	webhookService := NewWebhookService(repository)
+	customerService.Outbox = webhookService.CustomerDeliveries
+	receiver := newWebhookReceiver(t)
+	webhook, _ := webhookService.Create(t.Context(), viewmodels.WebhookEditViewModel{URL: receiver.URL, Events: []string{"customer.created", "customer.updated"}})
+
+	customer, err := customerService.Create(t.Context(), viewmodels.CustomerCreateViewModel{Name: "Lead", Role: "Sales", Email: "lead@domain.com", Phone: "5550009061"})
+	if err != nil {
+		t.Fatalf("Expected Create to return nil error, but got %s", err.Error())
+	}
+	customerService.Batch(t.Context(), []CustomerOperation{
+		{Kind: OperationUpdate, ID: customer.ID, Customer: viewmodels.CustomerEditViewModel{Name: "Lead Renamed", Role: "Sales", Email: "lead@domain.com", Phone: "5550009061"}},
+		{Kind: OperationCreate, Customer: viewmodels.CustomerEditViewModel{Name: "Duplicate", Role: "Sales", Email: "lead@domain.com", Phone: "5550009062"}},
+	}, false)
+
+	// The deliveries were stored with the changes, without any subscriber
+	deliveries, err := webhookService.Deliveries(t.Context(), webhook.ID, models.DeliveryPending, 0)
+	if err != nil || len(deliveries) != 2 || deliveries[0].Event != "customer.updated" || deliveries[1].Event != "customer.created" {
+		t.Fatalf("Expected the deliveries of the stored changes only, but got %v (%v)", deliveries, err)
+	}
+	if sent, err := webhookService.DeliverDue(t.Context()); err != nil || sent != 2 {
+		t.Errorf("Expected both deliveries to be sent, but got %d (%v)", sent, err)
+	}
+}
+
+func TestWebhookService_DeactivatedWebhook(t *testing.T) {
+	repository, err := repositories.NewSQLiteCustomerRepository(t.Context(), filepath.Join(t.TempDir(), "crm.db"))
+	if err != nil {
+		t.Fatalf("Failed to open SQLite repository: %s", err.Error())
+	}
+	defer repository.Close()
+	customerService := NewCustomerService(repository)
+	webhookService := NewWebhookService(repository)
+	customerService.Outbox = webhookService.CustomerDeliveries
+	receiver := newWebhookReceiver(t)
+	webhook, _ := webhookService.Create(t.Context(), viewmodels.WebhookEditViewModel{URL: receiver.URL, Events: []string{"customer.created"}})
+	customerService.Create(t.Context(), viewmodels.CustomerCreateViewModel{Name: "Lead", Role: "Sales", Email: "lead@domain.com", Phone: "5550009071"})
+
+	// The delivery was queued before the webhook was deactivated
+	inactive := false
+	webhookService.Update(t.Context(), webhook.ID, viewmodels.WebhookEditViewModel{URL: receiver.URL, Events: []string{"customer.created"}, Active: &inactive})
+	if _, err := webhookService.DeliverDue(t.Context()); err != nil {
+		t.Fatalf("Expected DeliverDue to return nil error, but got %s", err.Error())
+	}
+	if requests, _ := receiver.received(); len(requests) != 0 {
+		t.Errorf("Expected nothing to be sent to the inactive webhook, but got %d requests", len(requests))
+	}
+	deliveries, err := webhookService.Deliveries(t.Context(), webhook.ID, "", 0)
+	if err != nil || len(deliveries) != 1 || deliveries[0].Status != "dead" || len(deliveries[0].Attempts) != 0 {
+		t.Errorf("Expected the queued delivery to be a dead letter without attempts, but got %v (%v)", deliveries, err)
+	}
+}
+
+func TestWebhookService_RelayHistoryFailure(t *testing.T) {
+	customers, _ := repositories.ReadCustomersFile(repositories.DefaultDataFile())
+	history, err := repositories.NewJSONCustomerHistoryRepository(filepath.Join(t.TempDir(), "history.jsonl"))
+	if err != nil {
+		t.Fatalf("Failed to open the history: %s", err.Error())
+	}
+	repository := repositories.NewMemoryCustomerRepository(customers)
+	repository.History = history
+	customerService := NewCustomerService(repository)
+	webhookService := NewWebhookService(repositories.NewMemoryWebhookRepository())
+	webhookService.History = history
+	receiver := newWebhookReceiver(t)
+	webhookService.Create(t.Context(), viewmodels.WebhookEditViewModel{URL: receiver.URL, Events: []string{"customer.created", "customer.updated"}})
+
+	lead, err := customerService.Create(t.Context(), viewmodels.CustomerCreateViewModel{Name: "Lead", Role: "Sales", Email: "lead@domain.com", Phone: "5550009081"})
+	if err != nil {
+		t.Fatalf("Expected Create to return nil error, but got %s", err.Error())
+	}
+
+	// Appending to the history fails from here on, so the changes are
+	// rejected instead of being stored without their deliveries
+	history.Close()
+	if _, err := customerService.Create(t.Context(), viewmodels.CustomerCreateViewModel{Name: "Other Lead", Role: "Sales", Email: "other.lead@domain.com", Phone: "5550009082"}); err == nil {
+		t.Error("Expected Create to fail when its history cannot be appended")
+	}
+	if _, err := customerService.Update(t.Context(), lead.ID, 0, viewmodels.CustomerEditViewModel{Name: "Lead Renamed", Role: "Sales", Email: "lead@domain.com", Phone: "5550009081"}); err == nil {
+		t.Error("Expected Update to fail when its history cannot be appended")
+	}
+
+	if sent, err := webhookService.DeliverDue(t.Context()); err != nil || sent != 1 {
+		t.Fatalf("Expected the delivery of the stored change only, but got %d (%v)", sent, err)
+	}
+	var payload viewmodels.WebhookPayloadViewModel
+	_, bodies := receiver.received()
+	json.Unmarshal(bodies[0], &payload)
+	if payload.Customer.ID != lead.ID || payload.Customer.Name != "Lead" {
+		t.Errorf("Expected the created lead to be delivered, but got %v", payload)
+	}
+	if stored, _ := customerService.GetById(t.Context(), lead.ID); stored.Name != "Lead" || stored.Version != 1 {
+		t.Errorf("Expected the rejected update not to be stored, but got %v", stored)
+	}
+	if all, _ := customerService.GetAll(t.Context()); len(all) != len(customers)+1 {
+		t.Errorf("Expected the rejected customer not to be stored, but got %d customers", len(all))
+	}
+}
+
+func TestWebhookService_Retries(t *testing.T) {
+	customerService, webhookService := newWebhookServices(t)
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	webhookService.Clock = clock.Func(func() time.Time { return now })
+	webhookService.MaxAttempts = 3
+	webhookService.RetryDelay = time.Minute
+	receiver := newWebhookReceiver(t, http.StatusInternalServerError, http.StatusServiceUnavailable, http.StatusBadGateway)
+
+	billing, _ := webhookService.Create(t.Context(), viewmodels.WebhookEditViewModel{URL: receiver.URL, Events: []string{"customer.created"}})
+	customerService.Create(t.Context(), viewmodels.CustomerCreateViewModel{Name: "Lead", Role: "Sales", Email: "lead@domain.com", Phone: "5550009101"})
+
+	expectedNext := []time.Duration{time.Minute, 2 * time.Minute}
+	for attempt, delay := range expectedNext {
+		if sent, err := webhookService.DeliverDue(t.Context()); sent != 1 || err != nil {
+			t.Fatalf("Expected attempt %d to be sent, but got %d (%v)", attempt+1, sent, err)
+		}
+		deliveries, _ := webhookService.Deliveries(t.Context(), billing.ID, models.DeliveryPending, 0)
+		if len(deliveries) != 1 || !deliveries[0].NextAttemptAt.Equal(now.Add(delay)) {
+			t.Fatalf("Expected the next attempt in %s, but got %v", delay, deliveries)
+		}
+		if sent, _ := webhookService.DeliverDue(t.Context()); sent != 0 {
+			t.Errorf("Expected no attempt before the backoff elapsed, but %d were sent", sent)
+		}
+		now = now.Add(delay)
+	}
+
+	webhookService.DeliverDue(t.Context())
+	deadLetters, err := webhookService.DeadLetters(t.Context(), 10)
+	if err != nil || len(deadLetters) != 1 || deadLetters[0].WebhookID != billing.ID || len(deadLetters[0].Attempts) != 3 {
+		t.Fatalf("Expected the delivery to be a dead letter after 3 attempts, but got %v (%v)", deadLetters, err)
+	}
+	statuses := []int{}
+	for _, attempt := range deadLetters[0].Attempts {
+		statuses = append(statuses, attempt.StatusCode)
+	}
+	if !slices.Equal(statuses, []int{500, 503, 502}) || deadLetters[0].Attempts[2].Error == "" || !deadLetters[0].NextAttemptAt.IsZero() {
+		t.Errorf("Expected the attempt log of the three failures, but got %v", deadLetters[0])
+	}
+
+	now = now.Add(24 * time.Hour)
+	if sent, _ := webhookService.DeliverDue(t.Context()); sent != 0 {
+		t.Errorf("Expected a dead letter not to be retried, but %d were sent", sent)
+	}
+	var notFound *WebhookNotFoundError
+	if _, err := webhookService.Deliveries(t.Context(), uuid.New(), "", 0); !errors.As(err, &notFound) {
+		t.Errorf("Expected the deliveries of an unknown webhook to return a WebhookNotFoundError, but got %v", err)
+	}
+}
+
+func TestWebhookService_RetryDelay(t *testing.T) {
+	webhookService := NewWebhookService(repositories.NewMemoryWebhookRepository())
+	webhookService.RetryDelay = 30 * time.Second
+	webhookService.MaxRetryDelay = 5 * time.Minute
+
+	expected := []time.Duration{30 * time.Second, time.Minute, 2 * time.Minute, 4 * time.Minute, 5 * time.Minute, 5 * time.Minute}
+	for i, delay := range expected {
+		if actual := webhookService.retryDelay(i + 1); actual != delay {
+			t.Errorf("Expected a delay of %s after %d failures, but got %s", delay, i+1, actual)
+		}
+	}
+}
+
+func TestWebhookService_RunDeliveries(t *testing.T) {
+	customerService, webhookService := newWebhookServices(t)
+	receiver := newWebhookReceiver(t)
+	webhookService.Create(t.Context(), viewmodels.WebhookEditViewModel{URL: receiver.URL, Events: []string{"customer.created"}})
+
+	ctx, cancel := context.WithCancel(t.Context())
+	done := make(chan struct{})
+	go func() {
+		webhookService.RunDeliveries(ctx, time.Hour)
+		close(done)
+	}()
+	customerService.Create(t.Context(), viewmodels.CustomerCreateViewModel{Name: "Lead", Role: "Sales", Email: "lead@domain.com", Phone: "5550009201"})
+
+	// The interval is an hour, so only the wake-up can deliver in time
+	deadline := time.Now().Add(5 * time.Second)
+	for requests, _ := receiver.received(); len(requests) == 0; requests, _ = receiver.received() {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the new delivery to be sent right away")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	<-done
+}
@@ -0,0 +1,119 @@
+package validation
+
+import (
+	"fmt"
+	"net/mail"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// FieldError describes why one field failed validation
+type FieldError struct {
+	Field  string `json:"field"`
+	Reason string `json:"reason"`
+}
+
+// Errors is the list of failed fields; it is returned as an error by Validate
+type Errors []FieldError
+
+func (e Errors) Error() string {
+	parts := make([]string, len(e))
+	for i, fieldError := range e {
+		parts[i] = fieldError.Field + " " + fieldError.Reason
+	}
+	return "validation failed: " + strings.Join(parts, "; ")
+}
+
+// phonePattern allows an optional leading + followed by digits and the usual separators
+var phonePattern = regexp.MustCompile(`^\+?[0-9 ().-]+$`)
+
+// rule checks one string value; param is the text after "=" in the tag
+type rule func(value string, param string) (reason string, ok bool)
+
+var rules = map[string]rule{
+	"required": func(value string, _ string) (string, bool) {
+		return "is required", strings.TrimSpace(value) != ""
+	},
+	"max": func(value string, param string) (string, bool) {
+		n, _ := strconv.Atoi(param)
+		return fmt.Sprintf("must be at most %d characters", n), utf8.RuneCountInString(value) <= n
+	},
+	"min": func(value string, param string) (string, bool) {
+		n, _ := strconv.Atoi(param)
+		return fmt.Sprintf("must be at least %d characters", n), utf8.RuneCountInString(value) >= n
+	},
+	"email": func(value string, _ string) (string, bool) {
+		// net/mail implements the RFC 5322 addr-spec; display names are not accepted
+		address, err := mail.ParseAddress(value)
+		return "must be a valid email address", err == nil && address.Address == value
+	},
+	"phone": func(value string, _ string) (string, bool) {
+		digits := 0
+		for _, r := range value {
+			if unicode.IsDigit(r) {
+				digits++
+			}
+		}
+		return "must be a phone number of 7 to 15 digits", phonePattern.MatchString(value) && digits >= 7 && digits <= 15
+	},
+	"url": func(value string, _ string) (string, bool) {
+		u, err := url.Parse(value)
+		return "must be an absolute http or https URL", err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+	},
+	"oneof": func(value string, param string) (string, bool) {
+		allowed := strings.Split(param, "|")
+		for _, option := range allowed {
+			if strings.EqualFold(value, option) {
+				return "", true
+			}
+		}
+		return "must be one of " + strings.Join(allowed, ", "), false
+	},
+}
+
+// Validate checks the string fields of the struct v against the rules in
+// their `validate` tags, e.g. `validate:"required,max=100,email"`.
+// Rules other than required are skipped for empty values.
+// It returns Errors listing every failing field, or nil.
+func Validate(v any) error {
+	value := reflect.Indirect(reflect.ValueOf(v))
+	if value.Kind() != reflect.Struct {
+		return nil
+	}
+
+	errs := Errors{}
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		tag := field.Tag.Get("validate")
+		if tag == "" || field.Type.Kind() != reflect.String {
+			continue
+		}
+
+		fieldValue := value.Field(i).String()
+		for _, spec := range strings.Split(tag, ",") {
+			name, param, _ := strings.Cut(spec, "=")
+			check, ok := rules[name]
+			if !ok {
+				panic(fmt.Sprintf("validation: unknown rule %q on %s", name, field.Name))
+			}
+			if name != "required" && fieldValue == "" {
+				continue
+			}
+			if reason, ok := check(fieldValue, param); !ok {
+				// Report only the first failing rule of each field
+				errs = append(errs, FieldError{Field: field.Name, Reason: reason})
+				break
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
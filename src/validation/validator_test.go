@@ -0,0 +1,89 @@
+package validation
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type testCustomer struct {
+	Name      string `validate:"required,min=2,max=10"`
+	Role      string `validate:"oneof=Developer|Manager"`
+	Email     string `validate:"required,email"`
+	Phone     string `validate:"phone"`
+	Website   string `validate:"url"`
+	Contacted bool
+}
+
+func TestValidate(t *testing.T) {
+	valid := testCustomer{Name: "Cong", Role: "developer", Email: "cong@example.com", Phone: "+84 (987) 654-321"}
+
+	tests := []struct {
+		name     string
+		mutate   func(c *testCustomer)
+		expected []string
+	}{
+		{"valid", func(c *testCustomer) {}, nil},
+		{"optional fields empty", func(c *testCustomer) { c.Role, c.Phone = "", "" }, nil},
+		{"required blank", func(c *testCustomer) { c.Name = "   " }, []string{"Name"}},
+		{"too short", func(c *testCustomer) { c.Name = "C" }, []string{"Name"}},
+		{"too long", func(c *testCustomer) { c.Name = strings.Repeat("n", 11) }, []string{"Name"}},
+		{"multibyte length", func(c *testCustomer) { c.Name = "Đinh Công" }, nil},
+		{"unknown role", func(c *testCustomer) { c.Role = "Pilot" }, []string{"Role"}},
+		{"email display name", func(c *testCustomer) { c.Email = "Cong <cong@example.com>" }, []string{"Email"}},
+		{"email without domain", func(c *testCustomer) { c.Email = "cong@" }, []string{"Email"}},
+		{"phone letters", func(c *testCustomer) { c.Phone = "0987-CALL-ME" }, []string{"Phone"}},
+		{"phone too short", func(c *testCustomer) { c.Phone = "12345" }, []string{"Phone"}},
+		{"url", func(c *testCustomer) { c.Website = "https://example.com/cong" }, nil},
+		{"url relative", func(c *testCustomer) { c.Website = "/cong" }, []string{"Website"}},
+		{"url other scheme", func(c *testCustomer) { c.Website = "ftp://example.com" }, []string{"Website"}},
+		{"several fields", func(c *testCustomer) { c.Name, c.Email = "", "nope" }, []string{"Name", "Email"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			customer := valid
+			test.mutate(&customer)
+
+			err := Validate(customer)
+
+			fields := []string(nil)
+			var errs Errors
+			if errors.As(err, &errs) {
+				for _, fieldError := range errs {
+					fields = append(fields, fieldError.Field)
+				}
+			} else if err != nil {
+				t.Fatalf("Expected Validate to return Errors, but got %T", err)
+			}
+			if !reflect.DeepEqual(fields, test.expected) {
+				t.Errorf("Expected failing fields %v, but got %v", test.expected, fields)
+			}
+		})
+	}
+}
+
+func TestValidate_FirstReasonPerField(t *testing.T) {
+	err := Validate(&testCustomer{Email: "cong@example.com"})
+
+	var errs Errors
+	if !errors.As(err, &errs) || len(errs) != 1 {
+		t.Fatalf("Expected one field error, but got %v", err)
+	}
+	if errs[0].Reason != "is required" {
+		t.Errorf("Expected reason %q, but got %q", "is required", errs[0].Reason)
+	}
+}
+
+func TestValidate_UnknownRulePanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected Validate to panic on an unknown rule")
+		}
+	}()
+
+	Validate(struct {
+		Name string `validate:"uppercase"`
+	}{Name: "x"})
+}
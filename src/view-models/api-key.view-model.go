@@ -0,0 +1,35 @@
+package viewmodels
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type APIKeyViewModel struct {
+	ID   uuid.UUID
+	Name string
+	// Prefix is the start of the key, to tell keys apart
+	Prefix string
+	Scopes []string
+	Roles  []string `json:",omitempty"`
+	// Key is only returned when the key is created or rotated
+	Key       string `json:",omitempty"`
+	CreatedAt time.Time
+	ExpiresAt *time.Time `json:",omitempty"`
+	RotatedAt *time.Time `json:",omitempty"`
+	// PreviousExpiresAt is when the key replaced by the last rotation stops working
+	PreviousExpiresAt *time.Time `json:",omitempty"`
+	RevokedAt         *time.Time `json:",omitempty"`
+	LastUsedAt        *time.Time `json:",omitempty"`
+}
+
+// APIKeyCreateViewModel creates an API key. Scopes are "read", "write" or
+// "admin"; Roles are roles of the access policy, and a key without any gets
+// its default roles. A missing ExpiresAt means the key does not expire.
+type APIKeyCreateViewModel struct {
+	Name      string `validate:"required,max=100"`
+	Scopes    []string
+	Roles     []string
+	ExpiresAt *time.Time
+}
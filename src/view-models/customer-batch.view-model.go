@@ -0,0 +1,28 @@
+package viewmodels
+
+import "github.com/google/uuid"
+
+type CustomerBatchViewModel struct {
+	// Mode is "atomic" (the default) or "best-effort"
+	Mode       string
+	Operations []CustomerOperationViewModel
+}
+
+type CustomerOperationViewModel struct {
+	// Op is "create", "update" or "delete"
+	Op       string
+	ID       uuid.UUID
+	Version  int64
+	Customer CustomerEditViewModel
+}
+
+type CustomerBatchResultViewModel struct {
+	Applied int
+	Results []CustomerOperationResultViewModel
+}
+
+type CustomerOperationResultViewModel struct {
+	Status   int
+	Customer *CustomerViewModel `json:",omitempty"`
+	Error    *ProblemViewModel  `json:",omitempty"`
+}
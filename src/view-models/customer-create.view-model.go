@@ -1,9 +1,9 @@
 package viewmodels
 
 type CustomerCreateViewModel struct {
-	Name      string
-	Role      string
-	Email     string
-	Phone     string
+	Name      string `validate:"required,max=100"`
+	Role      string `validate:"required,oneof=Developer|Designer|Manager|Tester|Product Owner|Sales"`
+	Email     string `validate:"required,max=254,email"`
+	Phone     string `validate:"required,max=20,phone"`
 	Contacted bool
 }
@@ -0,0 +1,14 @@
+package viewmodels
+
+import "time"
+
+// CustomerEventViewModel is a stored change of a customer as published to
+// subscribers. Type is "created", "updated", "deleted" or "restored".
+type CustomerEventViewModel struct {
+	Type      string
+	Actor     string
+	Timestamp time.Time
+	// Customer is the customer after the change; a deleted one has DeletedAt set
+	Customer CustomerViewModel
+	Changes  []FieldChangeViewModel
+}
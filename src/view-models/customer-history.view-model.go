@@ -0,0 +1,27 @@
+package viewmodels
+
+import "time"
+
+type CustomerHistoryEntryViewModel struct {
+	// Version is the version of the customer the change created
+	Version   int64
+	Operation string
+	Actor     string
+	Timestamp time.Time
+	Changes   []FieldChangeViewModel
+}
+
+// FieldChangeViewModel is the value of one field before and after a change;
+// Before is null when the customer was created
+type FieldChangeViewModel struct {
+	Field  string
+	Before any
+	After  any
+}
+
+type CustomerHistoryPageViewModel struct {
+	Items  []CustomerHistoryEntryViewModel
+	Total  int
+	Offset int
+	Limit  int
+}
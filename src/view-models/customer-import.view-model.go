@@ -0,0 +1,16 @@
+package viewmodels
+
+type CustomerImportResultViewModel struct {
+	DryRun  bool
+	Created int
+	Failed  int
+	Rows    []CustomerImportRowViewModel
+}
+
+type CustomerImportRowViewModel struct {
+	// Line is the line of the row in the file, counting the header as line 1
+	Line     int
+	Status   int
+	Customer *CustomerViewModel `json:",omitempty"`
+	Error    *ProblemViewModel  `json:",omitempty"`
+}
@@ -0,0 +1,39 @@
+package viewmodels
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// CustomerLiveMessageViewModel is a message on the live editing WebSocket of
+// a customer. The server sends "snapshot" when the connection opens,
+// "presence" when someone joins or leaves, "change" for every stored change,
+// and "ack" or "error" in reply to an edit. Clients send "update" with a
+// whole Customer or "patch" with a JSON Merge Patch, both based on Version.
+type CustomerLiveMessageViewModel struct {
+	Type string
+	// RequestID is chosen by the client and repeated in the reply to its edit
+	RequestID string `json:",omitempty"`
+	// Version is the version an edit is based on
+	Version int64                  `json:",omitempty"`
+	Edit    *CustomerEditViewModel `json:",omitempty"`
+	Patch   json.RawMessage        `json:",omitempty" swaggertype:"object"`
+	// Event is the type of a change: "updated", "deleted" or "restored"
+	Event   string                 `json:",omitempty"`
+	Actor   string                 `json:",omitempty"`
+	Changes []FieldChangeViewModel `json:",omitempty"`
+	// Customer is the current customer of a snapshot, change or ack, and of an
+	// error for a stale edit
+	Customer *CustomerViewModel `json:",omitempty"`
+	// ViewerID identifies this connection among the Viewers of a snapshot
+	ViewerID string                    `json:",omitempty"`
+	Viewers  []CustomerViewerViewModel `json:",omitempty"`
+	Problem  *ProblemViewModel         `json:",omitempty"`
+}
+
+// CustomerViewerViewModel is someone who has the customer open
+type CustomerViewerViewModel struct {
+	ID       string
+	Subject  string
+	JoinedAt time.Time
+}
@@ -0,0 +1,9 @@
+package viewmodels
+
+type CustomerPageViewModel struct {
+	Items      []CustomerViewModel
+	Total      int
+	Offset     int
+	Limit      int
+	NextCursor string
+}
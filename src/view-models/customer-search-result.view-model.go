@@ -0,0 +1,7 @@
+package viewmodels
+
+type CustomerSearchResultViewModel struct {
+	Customer   CustomerViewModel
+	Score      float64
+	Highlights map[string]string
+}
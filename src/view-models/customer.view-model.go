@@ -1,6 +1,10 @@
 package viewmodels
 
-import "github.com/google/uuid"
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
 
 type CustomerViewModel struct {
 	ID        uuid.UUID
@@ -9,4 +13,13 @@ type CustomerViewModel struct {
 	Email     string
 	Phone     string
 	Contacted bool
+	Version   int64
+	// CreatedAt, UpdatedAt, CreatedBy and UpdatedBy record when and by whom
+	// the customer was created and last changed, if known
+	CreatedAt time.Time `json:",omitzero"`
+	UpdatedAt time.Time `json:",omitzero"`
+	CreatedBy string    `json:",omitempty" xml:",omitempty"`
+	UpdatedBy string    `json:",omitempty" xml:",omitempty"`
+	// DeletedAt is set for customers in the trash
+	DeletedAt *time.Time `json:",omitempty" xml:",omitempty"`
 }
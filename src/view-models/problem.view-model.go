@@ -0,0 +1,14 @@
+package viewmodels
+
+import "congdinh.com/crm/validation"
+
+// ProblemViewModel is an RFC 7807 problem details body, served as application/problem+json
+type ProblemViewModel struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+	// Errors lists the failing fields of a validation problem
+	Errors []validation.FieldError `json:"errors,omitempty"`
+}
@@ -0,0 +1,10 @@
+package viewmodels
+
+import "time"
+
+// StreamTicketViewModel is a single-use ticket that opens one WebSocket or
+// event stream as the caller who asked for it, passed as the ticket parameter
+type StreamTicketViewModel struct {
+	Ticket    string
+	ExpiresAt time.Time
+}
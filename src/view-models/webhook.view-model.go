@@ -0,0 +1,62 @@
+package viewmodels
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type WebhookViewModel struct {
+	ID     uuid.UUID
+	URL    string
+	Events []string
+	Active bool
+	// Secret is only returned when the webhook is created or its secret changed
+	Secret    string `json:",omitempty"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// WebhookEditViewModel creates or replaces a webhook. An empty Secret is
+// generated on create and kept on update; a missing Active means true.
+type WebhookEditViewModel struct {
+	URL    string `validate:"required,max=2048,url"`
+	Events []string
+	Secret string `validate:"max=256"`
+	Active *bool
+}
+
+type WebhookDeliveryViewModel struct {
+	ID        uuid.UUID
+	WebhookID uuid.UUID
+	Event     string
+	// Status is "pending", "delivered" or "dead"
+	Status string
+	// NextAttemptAt is when a pending delivery is tried next
+	NextAttemptAt time.Time `json:",omitzero"`
+	CreatedAt     time.Time
+	Attempts      []WebhookAttemptViewModel
+	Payload       json.RawMessage `swaggertype:"object"`
+}
+
+// WebhookAttemptViewModel is one try to send a delivery. StatusCode is
+// missing when no response was received, and Error when the attempt succeeded.
+type WebhookAttemptViewModel struct {
+	Timestamp  time.Time
+	StatusCode int    `json:",omitempty"`
+	Error      string `json:",omitempty"`
+	// DurationMs is how long the receiver took to answer, in milliseconds
+	DurationMs int64
+}
+
+// WebhookPayloadViewModel is the JSON body of a delivery. ID identifies the
+// delivery, so receivers can drop retries they already processed.
+type WebhookPayloadViewModel struct {
+	ID        uuid.UUID
+	Event     string
+	Timestamp time.Time
+	Actor     string
+	Customer  CustomerViewModel
+	Changes   []FieldChangeViewModel
+}